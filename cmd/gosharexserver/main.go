@@ -5,16 +5,16 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/mmichaelb/gosharexserver/internal/gosharexserver"
 	"github.com/mmichaelb/gosharexserver/internal/gosharexserver/config"
+	"github.com/mmichaelb/gosharexserver/pkg/ratelimit"
 	"github.com/mmichaelb/gosharexserver/pkg/router"
 	"github.com/mmichaelb/gosharexserver/pkg/storage"
-	"github.com/mmichaelb/gosharexserver/pkg/storage/storages"
 	"github.com/spf13/viper"
-	"gopkg.in/mgo.v2"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
 )
 
@@ -31,6 +31,21 @@ var configFilepath = flag.String(
 func main() {
 	// parse flags
 	flag.Parse()
+	// run a subcommand instead of the server if one is given
+	switch flag.Arg(0) {
+	case "migrate":
+		runMigrate(flag.Args()[1:])
+		return
+	case "user":
+		runUser(flag.Args()[1:])
+		return
+	case "token":
+		runToken(flag.Args()[1:])
+		return
+	case "verify":
+		runVerify(flag.Args()[1:])
+		return
+	}
 	// main start process
 	log.Printf("Starting %v %v (%v/%v) by %v...\n", applicationName, version, branch, commit, author)
 	// load main configuration
@@ -41,25 +56,102 @@ func main() {
 	log.Printf("Successfully loaded %d configuration keys.\n", len(viper.AllKeys()))
 	// setup default mux router
 	muxRouter := mux.NewRouter()
-	var fileStorage storage.FileStorage
-	session := connectToMongoDB()
-	// use MongoStorage per default
-	fileStorage = &storages.MongoStorage{
-		Database:        session.DB(viper.GetString("mongodb.db")),
-		GridFSPrefix:    viper.GetString("mongodb.gridfs_prefix"),
-		GridFSChunkSize: viper.GetInt("mongodb.gridfs_chunk_size"),
-	}
+	// create the file storage selected in the configuration
+	fileStorage, releaseFileStorage := createFileStorage(viper.GetViper())
 	// initialization via interface method Initialize of the file storage instance
 	log.Println("Initializing file storage...")
 	if err := fileStorage.Initialize(); err != nil {
 		log.Fatalf("There was an error while initializing the storage: %v\n", err)
 	}
 	log.Println("Done with storage initialization! Continuing with the binding of the ShareX muxRouter...")
+	deletePolicy := router.DeletePolicy(viper.GetString("webserver.delete_policy"))
+	if !deletePolicy.Valid() {
+		log.Fatalf("Unknown delete policy %s.\n", strconv.Quote(string(deletePolicy)))
+	}
+	defaultExpiry, maximumExpiry := viper.GetDuration("webserver.default_expiry"),
+		viper.GetDuration("webserver.maximum_expiry")
+	if defaultExpiry < 0 || maximumExpiry < 0 {
+		log.Fatalln("The default and maximum expiry must not be negative.")
+	}
+	uploadSessionTimeout := viper.GetDuration("webserver.upload_session_timeout")
+	if uploadSessionTimeout <= 0 {
+		log.Fatalln("The upload session timeout must be positive.")
+	}
+	var thumbnailWidths []int
+	for _, value := range viper.GetStringSlice("webserver.thumbnail_widths") {
+		thumbnailWidth, err := strconv.Atoi(value)
+		if err != nil || thumbnailWidth <= 0 {
+			log.Fatalf("Invalid thumbnail width %s.\n", strconv.Quote(value))
+		}
+		thumbnailWidths = append(thumbnailWidths, thumbnailWidth)
+	}
+	maximumUploadSize, quota := viper.GetInt64("webserver.maximum_upload_size"), viper.GetInt64("webserver.quota")
+	if maximumUploadSize < 0 || quota < 0 {
+		log.Fatalln("The maximum upload size and the quota must not be negative.")
+	}
+	if _, ok := fileStorage.(storage.UsageStorage); quota > 0 && !ok {
+		log.Fatalln("The file storage does not support quotas.")
+	}
+	contentTypeLimits := make(map[string]int64)
+	for _, value := range viper.GetStringSlice("webserver.content_type_limits") {
+		separator := strings.LastIndex(value, "=")
+		if separator <= 0 {
+			log.Fatalf("Invalid content type limit %s.\n", strconv.Quote(value))
+		}
+		limit, err := strconv.ParseInt(strings.TrimSpace(value[separator+1:]), 10, 64)
+		if err != nil || limit < 0 {
+			log.Fatalf("Invalid content type limit %s.\n", strconv.Quote(value))
+		}
+		contentTypeLimits[strings.ToLower(strings.TrimSpace(value[:separator]))] = limit
+	}
+	rateLimits := make(map[string]router.RateLimit)
+	for _, route := range []string{router.RouteUpload, router.RouteUploadChunk, router.RouteRequest,
+		router.RouteDelete} {
+		var rateLimit router.RateLimit
+		var err error
+		if rateLimit.IP, err = ratelimit.ParseLimit(viper.GetString("rate_limits." + route + ".ip")); err != nil {
+			log.Fatalf("Invalid IP rate limit of the %s route.\n", strconv.Quote(route))
+		}
+		if rateLimit.Token, err = ratelimit.ParseLimit(viper.GetString("rate_limits." + route + ".token")); err != nil {
+			log.Fatalf("Invalid token rate limit of the %s route.\n", strconv.Quote(route))
+		}
+		rateLimits[route] = rateLimit
+	}
+	notFoundLimit, err := ratelimit.ParseLimit(viper.GetString("rate_limits.not_found"))
+	if err != nil {
+		log.Fatalln("Invalid rate limit of unknown references.")
+	}
+	banDuration := viper.GetDuration("rate_limits.ban_duration")
+	if banDuration <= 0 {
+		log.Fatalln("The ban duration must be positive.")
+	}
 	// bind ShareXRouter to previously initialized mux muxRouter
 	shareXRouter := &router.ShareXRouter{
 		Storage:                 fileStorage,
 		WhitelistedContentTypes: viper.GetStringSlice("webserver.whitelisted_content_types"),
 		AuthorizationToken:      viper.GetString("webserver.authorization_token"),
+		DeletePolicy:            deletePolicy,
+		DefaultExpiry:           defaultExpiry,
+		MaximumExpiry:           maximumExpiry,
+		CookieSecret:            []byte(viper.GetString("webserver.cookie_secret")),
+		ThumbnailWidths:         thumbnailWidths,
+		UploadSessionTimeout:    uploadSessionTimeout,
+		PasteViewer:             viper.GetBool("webserver.paste_viewer"),
+		PublicURL:               viper.GetString("webserver.public_url"),
+		MaximumUploadSize:       maximumUploadSize,
+		ContentTypeLimits:       contentTypeLimits,
+		Quota:                   quota,
+		RateLimitStore:          ratelimit.NewMemoryStore(),
+		RateLimits:              rateLimits,
+		NotFoundLimit:           notFoundLimit,
+		BanDuration:             banDuration,
+		EmbedSiteName:           viper.GetString("embed.site_name"),
+		EmbedColor:              viper.GetString("embed.color"),
+		EmbedUserAgents:         viper.GetStringSlice("embed.user_agents"),
+	}
+	// the users are stored alongside the entries if the file storage supports it
+	if userStorage, ok := unwrapFileStorage(fileStorage).(storage.UserStorage); ok {
+		shareXRouter.Users = userStorage
 	}
 	// bind ShareX server handler to existing mux muxRouter
 	shareXRouter.WrapHandler(muxRouter.PathPrefix("/").Subrouter())
@@ -70,6 +162,12 @@ func main() {
 	} else {
 		handler = muxRouter
 	}
+	// delete expired entries in the background
+	reaperInterval := viper.GetDuration("storage.reaper_interval")
+	if reaperInterval <= 0 {
+		log.Fatalln("The reaper interval must be positive.")
+	}
+	stopReaper := startReaper(fileStorage, reaperInterval)
 	webserverAddress := viper.GetString("webserver.address")
 	httpServer := http.Server{
 		Addr:    webserverAddress,
@@ -88,34 +186,17 @@ func main() {
 	sc := make(chan os.Signal, 1)
 	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt, os.Kill)
 	<-sc
-	log.Println("Shutting down ShareX server and MongoDB connection...")
+	log.Println("Shutting down ShareX server and file storage...")
 	closed = true
 	if err := httpServer.Close(); err != nil {
 		log.Printf("There was an error while closing the ShareX server, %T: %v\n", err, err)
 	}
+	stopReaper()
+	// the upload sessions can not be resumed after a restart
+	shareXRouter.AbortUploadSessions()
 	if err := fileStorage.Close(); err != nil {
 		log.Printf("There was an error while closing the ShareX file storage, %T: %v\n", err, err)
 	}
-	session.Close()
+	releaseFileStorage()
 	log.Println("Thank you for using the ShareX server. Bye!")
 }
-
-func connectToMongoDB() *mgo.Session {
-	dialInfo := parseDialInfoFromConfig()
-	session, err := mgo.DialWithInfo(dialInfo)
-	if err != nil {
-		log.Fatalf("Could not connect to MongoDB server: %v\n", err)
-	}
-	return session
-}
-
-// parseDialInfoFromConfig parses the dial information used to connect to the MongoDB server.
-func parseDialInfoFromConfig() *mgo.DialInfo {
-	return &mgo.DialInfo{
-		Addrs:    []string{viper.GetString("mongodb.address")},
-		Timeout:  viper.GetDuration("mongodb.connect_timeout"),
-		Source:   viper.GetString("mongodb.auth_db"),
-		Username: viper.GetString("mongodb.auth_user"),
-		Password: viper.GetString("mongodb.auth_passwd"),
-	}
-}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"github.com/mmichaelb/gosharexserver/internal/gosharexserver/config"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"io"
+	"log"
+	"os"
+	"strconv"
+)
+
+// runMigrate runs the migrate subcommand which copies all entries from the file storage of the source configuration
+// to the file storage of the target configuration. The call and delete references of the entries are preserved which
+// means that existing links keep working. Entries whose references are already in use by the target storage are
+// skipped so that an interrupted migration can just be run again.
+func runMigrate(arguments []string) {
+	flagSet := flag.NewFlagSet("migrate", flag.ExitOnError)
+	sourceConfigFilepath := flagSet.String("from", "./config.toml",
+		"The filepath to the configuration file of the source storage.")
+	targetConfigFilepath := flagSet.String("to", "",
+		"The filepath to the configuration file of the target storage.")
+	batchSize := flagSet.Int("batch-size", 100, "The amount of entries which are listed at once.")
+	flagSet.Parse(arguments)
+	if *targetConfigFilepath == "" || *batchSize <= 0 {
+		flagSet.Usage()
+		os.Exit(2)
+	}
+	source, releaseSource := loadMigrationStorage(*sourceConfigFilepath)
+	defer releaseSource()
+	target, releaseTarget := loadMigrationStorage(*targetConfigFilepath)
+	defer releaseTarget()
+	log.Printf("Migrating entries from %s to %s...\n", strconv.Quote(*sourceConfigFilepath),
+		strconv.Quote(*targetConfigFilepath))
+	var copied, skipped int
+	var cursor string
+	for {
+		entries, nextCursor, err := source.List(storage.ListFilter{}, cursor, *batchSize)
+		if err != nil {
+			log.Fatalf("Could not list entries of the source storage, %T: %v\n", err, err)
+		}
+		for _, entry := range entries {
+			if err = migrateEntry(source, target, entry); err == storage.ErrReferenceInUse {
+				log.Printf("Skipping entry %s because its references are already in use.\n",
+					strconv.Quote(entry.CallReference))
+				skipped++
+				continue
+			} else if err == storage.ErrEntryNotFound {
+				log.Printf("Skipping entry %s because it has expired or has been deleted in the meantime.\n",
+					strconv.Quote(entry.CallReference))
+				skipped++
+				continue
+			} else if err != nil {
+				log.Fatalf("Could not migrate entry %s, %T: %v\n", strconv.Quote(entry.CallReference), err, err)
+			}
+			copied++
+		}
+		if nextCursor == "" {
+			break
+		}
+		cursor = nextCursor
+	}
+	log.Printf("Done! Copied %d entries and skipped %d entries.\n", copied, skipped)
+}
+
+// loadMigrationStorage loads the given configuration file and creates and initializes the file storage selected in
+// it. The returned function closes the file storage.
+func loadMigrationStorage(fileName string) (storage.FileStorage, func()) {
+	storageConfig, err := config.LoadConfig(fileName)
+	if err != nil {
+		log.Fatalf("Could not load configuration from file %s, %T: %v\n", strconv.Quote(fileName), err, err)
+	}
+	fileStorage, releaseFileStorage := createFileStorage(storageConfig)
+	if err = fileStorage.Initialize(); err != nil {
+		log.Fatalf("There was an error while initializing the storage of %s: %v\n", strconv.Quote(fileName), err)
+	}
+	return fileStorage, func() {
+		if err := fileStorage.Close(); err != nil {
+			log.Printf("There was an error while closing the file storage, %T: %v\n", err, err)
+		}
+		releaseFileStorage()
+	}
+}
+
+// migrateEntry copies the data and metadata of a single entry from the source to the target storage.
+func migrateEntry(source, target storage.FileStorage, entry *storage.Entry) error {
+	sourceEntry, err := source.Request(entry.CallReference)
+	if err != nil {
+		return err
+	}
+	defer sourceEntry.Reader.Close()
+	writer, err := target.Store(&storage.Entry{
+		CallReference:      sourceEntry.CallReference,
+		DeleteReference:    sourceEntry.DeleteReference,
+		Author:             sourceEntry.Author,
+		Filename:           sourceEntry.Filename,
+		ContentType:        sourceEntry.ContentType,
+		UploadDate:         sourceEntry.UploadDate,
+		ExpirationDate:     sourceEntry.ExpirationDate,
+		MaximumDownloads:   sourceEntry.MaximumDownloads,
+		RemainingDownloads: sourceEntry.RemainingDownloads,
+		PasswordHash:       sourceEntry.PasswordHash,
+		MD5:                sourceEntry.MD5,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(writer, sourceEntry.Reader); err != nil {
+		writer.Close()
+		// remove the incomplete entry, otherwise it would be skipped when running the migration again
+		if deleteErr := target.Delete(sourceEntry.DeleteReference); deleteErr != nil {
+			log.Printf("Could not remove incomplete entry %s, %T: %v\n", strconv.Quote(sourceEntry.CallReference),
+				deleteErr, deleteErr)
+		}
+		return err
+	}
+	return writer.Close()
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"log"
+	"time"
+)
+
+// startReaper deletes the expired entries of the file storage in the given interval in the background. The returned
+// function stops the reaper and waits until a running deletion is done so that the file storage can be closed safely.
+func startReaper(fileStorage storage.FileStorage, interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	stopped := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stopped:
+				return
+			case now := <-ticker.C:
+				deleted, err := fileStorage.DeleteExpired(now)
+				if err != nil {
+					log.Printf("There was an error while deleting expired entries, %T: %v\n", err, err)
+				}
+				if deleted > 0 {
+					log.Printf("Deleted %d expired entries.\n", deleted)
+				}
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(stopped)
+		<-done
+	}
+}
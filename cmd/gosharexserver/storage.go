@@ -0,0 +1,137 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/minio/minio-go"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"github.com/mmichaelb/gosharexserver/pkg/storage/storages"
+	"github.com/spf13/viper"
+	"gopkg.in/mgo.v2"
+	"log"
+	"strconv"
+)
+
+// storage types which can be selected via the storage.type configuration value
+const (
+	storageTypeMongoDB    = "mongodb"
+	storageTypeFilesystem = "filesystem"
+	storageTypeBolt       = "bolt"
+	storageTypeSQL        = "sql"
+	storageTypeS3         = "s3"
+)
+
+// createFileStorage creates the file storage which is selected in the given configuration. The returned function has
+// to be called after closing the file storage to release additional resources (e.g. the MongoDB session).
+func createFileStorage(config *viper.Viper) (fileStorage storage.FileStorage, release func()) {
+	release = func() {}
+	referenceLengths := storages.ReferenceLengths{
+		CallReferenceLength:   config.GetInt("storage.call_reference_length"),
+		DeleteReferenceLength: config.GetInt("storage.delete_reference_length"),
+	}
+	switch storageType := config.GetString("storage.type"); storageType {
+	case storageTypeMongoDB:
+		session := connectToMongoDB(config)
+		fileStorage = &storages.MongoStorage{
+			Database:         session.DB(config.GetString("mongodb.db")),
+			GridFSPrefix:     config.GetString("mongodb.gridfs_prefix"),
+			GridFSChunkSize:  config.GetInt("mongodb.gridfs_chunk_size"),
+			ReferenceLengths: referenceLengths,
+		}
+		release = session.Close
+	case storageTypeFilesystem:
+		fileStorage = &storages.FilesystemStorage{
+			Directory:        config.GetString("filesystem.directory"),
+			ReferenceLengths: referenceLengths,
+		}
+	case storageTypeBolt:
+		fileStorage = &storages.BoltStorage{
+			Path:             config.GetString("bolt.path"),
+			OpenTimeout:      config.GetDuration("bolt.open_timeout"),
+			ChunkSize:        config.GetInt("bolt.chunk_size"),
+			ReferenceLengths: referenceLengths,
+		}
+	case storageTypeSQL:
+		db, err := sql.Open(config.GetString("sql.driver"), config.GetString("sql.dsn"))
+		if err != nil {
+			log.Fatalf("Could not open SQL database: %v\n", err)
+		}
+		fileStorage = &storages.SQLStorage{
+			DB:               db,
+			Dialect:          storages.SQLDialect(config.GetString("sql.driver")),
+			ChunkSize:        config.GetInt("sql.chunk_size"),
+			ReferenceLengths: referenceLengths,
+		}
+	case storageTypeS3:
+		client, err := minio.NewWithRegion(config.GetString("s3.endpoint"), config.GetString("s3.access_key"),
+			config.GetString("s3.secret_key"), config.GetBool("s3.secure"), config.GetString("s3.region"))
+		if err != nil {
+			log.Fatalf("Could not create S3 client: %v\n", err)
+		}
+		fileStorage = &storages.S3Storage{
+			Client:           client,
+			Bucket:           config.GetString("s3.bucket"),
+			Location:         config.GetString("s3.region"),
+			Prefix:           config.GetString("s3.prefix"),
+			PartSize:         config.GetInt("s3.part_size"),
+			ReferenceLengths: referenceLengths,
+		}
+	default:
+		log.Fatalf("Unknown storage type %s.\n", strconv.Quote(storageType))
+	}
+	if keyID := config.GetString("encryption.key_id"); keyID != "" {
+		fileStorage = &storages.EncryptedStorage{
+			Storage:   fileStorage,
+			Keys:      parseEncryptionKeys(config),
+			KeyID:     keyID,
+			ChunkSize: config.GetInt("encryption.chunk_size"),
+		}
+	}
+	return
+}
+
+// parseEncryptionKeys parses the base64 encoded keys of the encryption.keys configuration table.
+func parseEncryptionKeys(config *viper.Viper) map[string][]byte {
+	encodedKeys := config.GetStringMapString("encryption.keys")
+	keys := make(map[string][]byte, len(encodedKeys))
+	for keyID, encodedKey := range encodedKeys {
+		key, err := base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil {
+			log.Fatalf("Could not decode encryption key %s: %v\n", strconv.Quote(keyID), err)
+		}
+		keys[keyID] = key
+	}
+	return keys
+}
+
+// unwrapFileStorage returns the file storage which is wrapped by the encryption layer or the given file storage if it
+// is not encrypted. The entries and users are stored by the unwrapped file storage.
+func unwrapFileStorage(fileStorage storage.FileStorage) storage.FileStorage {
+	if encryptedStorage, ok := fileStorage.(*storages.EncryptedStorage); ok {
+		return encryptedStorage.Storage
+	}
+	return fileStorage
+}
+
+func connectToMongoDB(config *viper.Viper) *mgo.Session {
+	dialInfo := parseDialInfoFromConfig(config)
+	session, err := mgo.DialWithInfo(dialInfo)
+	if err != nil {
+		log.Fatalf("Could not connect to MongoDB server: %v\n", err)
+	}
+	return session
+}
+
+// parseDialInfoFromConfig parses the dial information used to connect to the MongoDB server.
+func parseDialInfoFromConfig(config *viper.Viper) *mgo.DialInfo {
+	return &mgo.DialInfo{
+		Addrs:    []string{config.GetString("mongodb.address")},
+		Timeout:  config.GetDuration("mongodb.connect_timeout"),
+		Source:   config.GetString("mongodb.auth_db"),
+		Username: config.GetString("mongodb.auth_user"),
+		Password: config.GetString("mongodb.auth_passwd"),
+	}
+}
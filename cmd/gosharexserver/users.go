@@ -0,0 +1,147 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/mmichaelb/gosharexserver/internal/gosharexserver/config"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"github.com/spf13/viper"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// runUser runs the user subcommand which creates, deletes and lists the users of the configured file storage.
+func runUser(arguments []string) {
+	if len(arguments) == 0 {
+		exitWithUsage("user create [-role user|admin] <name> | user delete <name> | user list")
+	}
+	userStorage, release := openUserStorage()
+	defer release()
+	flagSet := flag.NewFlagSet("user "+arguments[0], flag.ExitOnError)
+	switch arguments[0] {
+	case "create":
+		role := flagSet.String("role", string(storage.RoleUser), "The role of the new user (user or admin).")
+		flagSet.Parse(arguments[1:])
+		if flagSet.NArg() != 1 || (*role != string(storage.RoleUser) && *role != string(storage.RoleAdmin)) {
+			exitWithUsage("user create [-role user|admin] <name>")
+		}
+		if err := userStorage.CreateUser(&storage.User{
+			Name:         flagSet.Arg(0),
+			Role:         storage.Role(*role),
+			CreationDate: time.Now(),
+		}); err != nil {
+			log.Fatalf("Could not create user %s, %T: %v\n", strconv.Quote(flagSet.Arg(0)), err, err)
+		}
+		log.Printf("Created user %s with the role %s.\n", strconv.Quote(flagSet.Arg(0)), *role)
+	case "delete":
+		flagSet.Parse(arguments[1:])
+		if flagSet.NArg() != 1 {
+			exitWithUsage("user delete <name>")
+		}
+		if err := userStorage.DeleteUser(flagSet.Arg(0)); err != nil {
+			log.Fatalf("Could not delete user %s, %T: %v\n", strconv.Quote(flagSet.Arg(0)), err, err)
+		}
+		log.Printf("Deleted user %s and all of its tokens.\n", strconv.Quote(flagSet.Arg(0)))
+	case "list":
+		users, err := userStorage.ListUsers()
+		if err != nil {
+			log.Fatalf("Could not list users, %T: %v\n", err, err)
+		}
+		writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(writer, "NAME\tROLE\tCREATED")
+		for _, user := range users {
+			fmt.Fprintf(writer, "%s\t%s\t%s\n", user.Name, user.Role, user.CreationDate.Format(time.RFC3339))
+		}
+		writer.Flush()
+	default:
+		exitWithUsage("user create [-role user|admin] <name> | user delete <name> | user list")
+	}
+}
+
+// runToken runs the token subcommand which creates, lists and revokes the tokens of the users.
+func runToken(arguments []string) {
+	if len(arguments) == 0 {
+		exitWithUsage("token create [-description text] <user> | token list <user> | token revoke <id>")
+	}
+	userStorage, release := openUserStorage()
+	defer release()
+	flagSet := flag.NewFlagSet("token "+arguments[0], flag.ExitOnError)
+	switch arguments[0] {
+	case "create":
+		description := flagSet.String("description", "", "The description of the token (e.g. the device name).")
+		flagSet.Parse(arguments[1:])
+		if flagSet.NArg() != 1 {
+			exitWithUsage("token create [-description text] <user>")
+		}
+		if _, err := userStorage.RequestUser(flagSet.Arg(0)); err != nil {
+			log.Fatalf("Could not request user %s, %T: %v\n", strconv.Quote(flagSet.Arg(0)), err, err)
+		}
+		token, secret, err := storage.NewToken(flagSet.Arg(0), *description)
+		if err != nil {
+			log.Fatalf("Could not create token, %T: %v\n", err, err)
+		}
+		if err = userStorage.StoreToken(token); err != nil {
+			log.Fatalf("Could not store token, %T: %v\n", err, err)
+		}
+		log.Printf("Created token %s for user %s. Use the following value as the Authorization header, it is "+
+			"not shown again:\n", token.ID, strconv.Quote(token.Username))
+		fmt.Println(secret)
+	case "list":
+		flagSet.Parse(arguments[1:])
+		if flagSet.NArg() != 1 {
+			exitWithUsage("token list <user>")
+		}
+		tokens, err := userStorage.ListTokens(flagSet.Arg(0))
+		if err != nil {
+			log.Fatalf("Could not list tokens, %T: %v\n", err, err)
+		}
+		writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(writer, "ID\tDESCRIPTION\tCREATED")
+		for _, token := range tokens {
+			fmt.Fprintf(writer, "%s\t%s\t%s\n", token.ID, token.Description, token.CreationDate.Format(time.RFC3339))
+		}
+		writer.Flush()
+	case "revoke":
+		flagSet.Parse(arguments[1:])
+		if flagSet.NArg() != 1 {
+			exitWithUsage("token revoke <id>")
+		}
+		if err := userStorage.RevokeToken(flagSet.Arg(0)); err != nil {
+			log.Fatalf("Could not revoke token %s, %T: %v\n", strconv.Quote(flagSet.Arg(0)), err, err)
+		}
+		log.Printf("Revoked token %s.\n", flagSet.Arg(0))
+	default:
+		exitWithUsage("token create [-description text] <user> | token list <user> | token revoke <id>")
+	}
+}
+
+// openUserStorage loads the main configuration and initializes the file storage which also stores the users. The
+// returned function closes the file storage.
+func openUserStorage() (storage.UserStorage, func()) {
+	if err := config.LoadMainConfig(*configFilepath); err != nil {
+		log.Fatalf("Could not load configuration from file, %T: %v\n", err, err)
+	}
+	fileStorage, releaseFileStorage := createFileStorage(viper.GetViper())
+	if err := fileStorage.Initialize(); err != nil {
+		log.Fatalf("There was an error while initializing the storage: %v\n", err)
+	}
+	userStorage, ok := unwrapFileStorage(fileStorage).(storage.UserStorage)
+	if !ok {
+		log.Fatalf("The storage type %s does not support users.\n", strconv.Quote(viper.GetString("storage.type")))
+	}
+	return userStorage, func() {
+		if err := fileStorage.Close(); err != nil {
+			log.Printf("There was an error while closing the file storage, %T: %v\n", err, err)
+		}
+		releaseFileStorage()
+	}
+}
+
+// exitWithUsage prints the usage of a subcommand and exits the application.
+func exitWithUsage(usage string) {
+	fmt.Fprintf(os.Stderr, "Usage: %s [-config file] %s\n", os.Args[0], usage)
+	os.Exit(2)
+}
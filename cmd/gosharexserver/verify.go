@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"github.com/mmichaelb/gosharexserver/internal/gosharexserver/config"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"github.com/spf13/viper"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"strconv"
+)
+
+// runVerify runs the verify subcommand which re-reads the data of all entries of the configured file storage and
+// reports the entries whose data does not match their checksums. The SHA-256 checksum is compared with the stored
+// (possibly encrypted) data while the MD5 checksum is compared with the uploaded data. The application exits with
+// status 1 if any mismatch was found.
+func runVerify(arguments []string) {
+	flagSet := flag.NewFlagSet("verify", flag.ExitOnError)
+	batchSize := flagSet.Int("batch-size", 100, "The amount of entries which are listed at once.")
+	flagSet.Parse(arguments)
+	if *batchSize <= 0 {
+		flagSet.Usage()
+		os.Exit(2)
+	}
+	if err := config.LoadMainConfig(*configFilepath); err != nil {
+		log.Fatalf("Could not load configuration from file, %T: %v\n", err, err)
+	}
+	fileStorage, releaseFileStorage := createFileStorage(viper.GetViper())
+	if err := fileStorage.Initialize(); err != nil {
+		log.Fatalf("There was an error while initializing the storage: %v\n", err)
+	}
+	log.Println("Verifying the checksums of all entries...")
+	var verified, skipped, mismatches int
+	var cursor string
+	for {
+		entries, nextCursor, err := fileStorage.List(storage.ListFilter{}, cursor, *batchSize)
+		if err != nil {
+			log.Fatalf("Could not list entries, %T: %v\n", err, err)
+		}
+		for _, entry := range entries {
+			if entry.SHA256 == "" && entry.MD5 == "" {
+				// the entry has been stored before the checksums were introduced
+				skipped++
+				continue
+			}
+			matches, err := verifyEntry(fileStorage, entry)
+			if err == storage.ErrEntryNotFound {
+				skipped++
+				continue
+			} else if err != nil {
+				log.Printf("Could not read entry %s, %T: %v\n", strconv.Quote(entry.CallReference), err, err)
+				mismatches++
+				continue
+			}
+			if !matches {
+				log.Printf("The data of entry %s does not match its checksums.\n", strconv.Quote(entry.CallReference))
+				mismatches++
+				continue
+			}
+			verified++
+		}
+		if nextCursor == "" {
+			break
+		}
+		cursor = nextCursor
+	}
+	log.Printf("Done! Verified %d entries, skipped %d entries and found %d mismatches.\n",
+		verified, skipped, mismatches)
+	if err := fileStorage.Close(); err != nil {
+		log.Printf("There was an error while closing the file storage, %T: %v\n", err, err)
+	}
+	releaseFileStorage()
+	if mismatches > 0 {
+		os.Exit(1)
+	}
+}
+
+// verifyEntry reads the data of the given entry and returns whether it matches the checksums of the entry. Entries
+// which are encrypted at rest are read twice: the stored data is compared with the SHA-256 checksum and the decrypted
+// data is compared with the MD5 checksum.
+func verifyEntry(fileStorage storage.FileStorage, entry *storage.Entry) (bool, error) {
+	sha256Hash, md5Hash := sha256.New(), md5.New()
+	innerStorage := unwrapFileStorage(fileStorage)
+	if innerStorage == fileStorage || entry.EncryptionKeyID == "" {
+		if err := hashEntry(fileStorage, entry.CallReference, sha256Hash, md5Hash); err != nil {
+			return false, err
+		}
+	} else {
+		if err := hashEntry(innerStorage, entry.CallReference, sha256Hash); err != nil {
+			return false, err
+		}
+		if err := hashEntry(fileStorage, entry.CallReference, md5Hash); err != nil {
+			return false, err
+		}
+	}
+	return checksumMatches(entry.SHA256, sha256Hash) && checksumMatches(entry.MD5, md5Hash), nil
+}
+
+// hashEntry writes the data of the entry with the given call reference to the given hashes.
+func hashEntry(fileStorage storage.FileStorage, callReference string, hashes ...hash.Hash) error {
+	entry, err := fileStorage.Request(callReference)
+	if err != nil {
+		return err
+	}
+	defer entry.Reader.Close()
+	writers := make([]io.Writer, len(hashes))
+	for i := range hashes {
+		writers[i] = hashes[i]
+	}
+	_, err = io.Copy(io.MultiWriter(writers...), entry.Reader)
+	return err
+}
+
+// checksumMatches returns whether the calculated hash matches the hex encoded checksum. Empty checksums always match.
+func checksumMatches(checksum string, calculated hash.Hash) bool {
+	return checksum == "" || checksum == hex.EncodeToString(calculated.Sum(nil))
+}
@@ -4,38 +4,82 @@ import (
 	"github.com/spf13/viper"
 	"log"
 	"os"
+	"time"
 )
 
-func loadViper(fileName string) (err error) {
+// loadViper sets the default values and reads the given configuration file into the viper instance.
+func loadViper(config *viper.Viper, fileName string) (err error) {
 	// set configuration filepath to the provided parameter
-	viper.SetConfigFile(fileName)
+	config.SetConfigFile(fileName)
 	// add default values if the given config file does not contains specific values or do not exist
 	// default values taken from /configs/default-config.toml
 	// set webserver defaults
-	setWebserverDefaults()
+	setWebserverDefaults(config)
+	// set storage settings
+	setStorageDefaults(config)
 	// set MongoDB settings
-	setMongoDefaults()
+	setMongoDefaults(config)
 	// read config from filepath
-	return viper.ReadInConfig()
+	return config.ReadInConfig()
 }
 
-func setWebserverDefaults() {
-	viper.SetDefault("webserver.address", "localhost:10711")
+func setWebserverDefaults(config *viper.Viper) {
+	config.SetDefault("webserver.address", "localhost:10711")
 	// reverse proxy header specifies whether a reverse proxy is used and the application should parse the remote ip
-	viper.SetDefault("webserver.reverse_proxy_header", "")
+	config.SetDefault("webserver.reverse_proxy_header", "")
 	// whitelisted content types contains a list of all content types which should be displayed inline
-	viper.SetDefault("webserver.whitelisted_content_types", []string{
+	config.SetDefault("webserver.whitelisted_content_types", []string{
 		"image/png", "image/jpeg", "image/jpg", "image/gif",
 		"text/plain", "text/plain; charset=utf-8",
 		"video/mp4", "video/mpeg", "video/mpg4", "video/mpeg4", "video/flv",
 	})
 	// authorization token is set to a default value but should be changed when using the application
-	viper.SetDefault("webserver.authorization_token", "1337#Secure_Token")
+	config.SetDefault("webserver.authorization_token", "1337#Secure_Token")
+	// delete policy determines who is allowed to delete entries, possible values are "link", "owner" and "admin"
+	config.SetDefault("webserver.delete_policy", "link")
+	// default and maximum time to live of uploaded files, zero means that files do not expire/there is no maximum
+	config.SetDefault("webserver.default_expiry", time.Duration(0))
+	config.SetDefault("webserver.maximum_expiry", time.Duration(0))
+	// cookie secret signs the cookies which unlock password protected entries, a random one is used if it is empty
+	config.SetDefault("webserver.cookie_secret", "")
+	// thumbnail widths are the widths of the thumbnails which are generated for uploaded images
+	config.SetDefault("webserver.thumbnail_widths", []string{"256", "128", "512"})
+	// idle resumable upload sessions are aborted after this timeout
+	config.SetDefault("webserver.upload_session_timeout", time.Hour)
+	// paste viewer shows text entries with line numbers and syntax highlighting
+	config.SetDefault("webserver.paste_viewer", true)
+	// maximum upload size, content type limits ("type/subtype=bytes") and per-author quota in bytes, zero means that
+	// the size is not limited
+	config.SetDefault("webserver.maximum_upload_size", int64(0))
+	config.SetDefault("webserver.content_type_limits", []string{})
+	config.SetDefault("webserver.quota", int64(0))
+	// public url is the external url of the application, it is derived from the requests if it is empty
+	config.SetDefault("webserver.public_url", "")
+	// token bucket rate limits ("{requests}/{interval}") per client ip address and per authorization token of the
+	// upload, upload chunk, request and delete routes, an empty value disables the limit
+	config.SetDefault("rate_limits.upload.ip", "60/1m")
+	config.SetDefault("rate_limits.upload.token", "120/1m")
+	config.SetDefault("rate_limits.upload_chunk.ip", "600/1m")
+	config.SetDefault("rate_limits.upload_chunk.token", "")
+	config.SetDefault("rate_limits.request.ip", "600/1m")
+	config.SetDefault("rate_limits.request.token", "")
+	config.SetDefault("rate_limits.delete.ip", "60/1m")
+	config.SetDefault("rate_limits.delete.token", "60/1m")
+	// clients which look up more unknown references than allowed by the not found limit are banned
+	config.SetDefault("rate_limits.not_found", "30/1m")
+	config.SetDefault("rate_limits.ban_duration", time.Minute*15)
+	// link previews of entries are sent to the crawlers of these user agents
+	config.SetDefault("embed.site_name", "gosharexserver")
+	config.SetDefault("embed.color", "#33bbff")
+	config.SetDefault("embed.user_agents", []string{
+		"Discordbot", "Slackbot", "Slack-ImgProxy", "SkypeUriPreview", "Twitterbot", "facebookexternalhit",
+		"TelegramBot", "WhatsApp", "LinkedInBot", "Mastodon",
+	})
 }
 
-// LoadMainConfig loads the main config and stores the data into the Cfg variable.
+// LoadMainConfig loads the main config and stores the data into the global viper instance.
 func LoadMainConfig(fileName string) (err error) {
-	if err = loadViper(fileName); err != nil {
+	if err = loadViper(viper.GetViper(), fileName); err != nil {
 		if os.IsNotExist(err) {
 			log.Printf("Could not read configuration from file, %T: %v. Falling back to defaults.\n", err, err)
 			err = nil
@@ -43,3 +87,13 @@ func LoadMainConfig(fileName string) (err error) {
 	}
 	return
 }
+
+// LoadConfig loads the given config into a new viper instance which is independent of the main config. This is used
+// to e.g. load the configurations of the source and target storage when migrating entries.
+func LoadConfig(fileName string) (*viper.Viper, error) {
+	config := viper.New()
+	if err := loadViper(config, fileName); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
@@ -10,7 +10,7 @@ import (
 )
 
 func TestMainConfig(t *testing.T) {
-	err := loadViper("../../../test/test-config.toml")
+	err := loadViper(viper.GetViper(), "../../../test/test-config.toml")
 	if err != nil {
 		t.Fatalf("Could not load test config file, %T: %v", err, err)
 	}
@@ -26,9 +26,143 @@ func TestMainConfig(t *testing.T) {
 	if authorizationToken := viper.GetString("webserver.authorization_token"); authorizationToken != "123456" {
 		t.Fatalf(`Invalid value for "webserver.authorization_token": %s`, strconv.Quote(authorizationToken))
 	}
+	if deletePolicy := viper.GetString("webserver.delete_policy"); deletePolicy != "owner" {
+		t.Fatalf(`Invalid value for "webserver.delete_policy": %s`, strconv.Quote(deletePolicy))
+	}
+	if defaultExpiry := viper.GetDuration("webserver.default_expiry"); defaultExpiry != time.Hour*24 {
+		t.Fatalf(`Invalid value for "webserver.default_expiry": %s`, strconv.Quote(defaultExpiry.String()))
+	}
+	if maximumExpiry := viper.GetDuration("webserver.maximum_expiry"); maximumExpiry != time.Hour*24*30 {
+		t.Fatalf(`Invalid value for "webserver.maximum_expiry": %s`, strconv.Quote(maximumExpiry.String()))
+	}
+	if cookieSecret := viper.GetString("webserver.cookie_secret"); cookieSecret != "cookie-secret" {
+		t.Fatalf(`Invalid value for "webserver.cookie_secret": %s`, strconv.Quote(cookieSecret))
+	}
+	if thumbnailWidths := viper.GetStringSlice("webserver.thumbnail_widths"); !reflect.DeepEqual(thumbnailWidths, []string{"64", "320"}) {
+		t.Fatalf(`Invalid value for "webserver.thumbnail_widths": %s`, strconv.Quote(fmt.Sprintf("%+v", thumbnailWidths)))
+	}
+	if uploadSessionTimeout := viper.GetDuration("webserver.upload_session_timeout"); uploadSessionTimeout != time.Minute*15 {
+		t.Fatalf(`Invalid value for "webserver.upload_session_timeout": %s`, strconv.Quote(uploadSessionTimeout.String()))
+	}
+	if viper.GetBool("webserver.paste_viewer") {
+		t.Fatal(`Invalid value for "webserver.paste_viewer": true`)
+	}
+	if publicURL := viper.GetString("webserver.public_url"); publicURL != "https://example.com/files" {
+		t.Fatalf(`Invalid value for "webserver.public_url": %s`, strconv.Quote(publicURL))
+	}
+	if maximumUploadSize := viper.GetInt64("webserver.maximum_upload_size"); maximumUploadSize != 104857600 {
+		t.Fatalf(`Invalid value for "webserver.maximum_upload_size": %d`, maximumUploadSize)
+	}
+	if contentTypeLimits := viper.GetStringSlice("webserver.content_type_limits"); !reflect.DeepEqual(contentTypeLimits, []string{"image/*=10485760", "video/mp4=52428800"}) {
+		t.Fatalf(`Invalid value for "webserver.content_type_limits": %s`, strconv.Quote(fmt.Sprintf("%+v", contentTypeLimits)))
+	}
+	if quota := viper.GetInt64("webserver.quota"); quota != 1073741824 {
+		t.Fatalf(`Invalid value for "webserver.quota": %d`, quota)
+	}
+	testEmbedConfig(t)
+	testRateLimitConfig(t)
+	testStorageConfig(t)
 	testMongoConfig(t)
 }
 
+func testEmbedConfig(t *testing.T) {
+	if userAgents := viper.GetStringSlice("embed.user_agents"); !reflect.DeepEqual(userAgents, []string{"Discordbot", "Slackbot"}) {
+		t.Fatalf(`Invalid value for "embed.user_agents": %s`, strconv.Quote(fmt.Sprintf("%+v", userAgents)))
+	}
+	if siteName := viper.GetString("embed.site_name"); siteName != "Test Site" {
+		t.Fatalf(`Invalid value for "embed.site_name": %s`, strconv.Quote(siteName))
+	}
+	if color := viper.GetString("embed.color"); color != "#ff0000" {
+		t.Fatalf(`Invalid value for "embed.color": %s`, strconv.Quote(color))
+	}
+}
+
+func testRateLimitConfig(t *testing.T) {
+	for key, expected := range map[string]string{
+		"rate_limits.upload.ip":          "10/1m",
+		"rate_limits.upload.token":       "20/1m",
+		"rate_limits.upload_chunk.ip":    "200/1m",
+		"rate_limits.upload_chunk.token": "100/1m",
+		"rate_limits.request.ip":         "100/10s",
+		"rate_limits.request.token":      "50/10s",
+		"rate_limits.delete.ip":          "5/1h",
+		"rate_limits.delete.token":       "",
+		"rate_limits.not_found":          "3/1m",
+	} {
+		if value := viper.GetString(key); value != expected {
+			t.Fatalf(`Invalid value for "%s": %s`, key, strconv.Quote(value))
+		}
+	}
+	if banDuration := viper.GetDuration("rate_limits.ban_duration"); banDuration != time.Hour {
+		t.Fatalf(`Invalid value for "rate_limits.ban_duration": %s`, strconv.Quote(banDuration.String()))
+	}
+}
+
+func testStorageConfig(t *testing.T) {
+	if storageType := viper.GetString("storage.type"); storageType != "filesystem" {
+		t.Fatalf(`Invalid value for "storage.type": %s`, strconv.Quote(storageType))
+	}
+	if reaperInterval := viper.GetDuration("storage.reaper_interval"); reaperInterval != time.Second*30 {
+		t.Fatalf(`Invalid value for "storage.reaper_interval": %s`, strconv.Quote(reaperInterval.String()))
+	}
+	if callReferenceLength := viper.GetInt("storage.call_reference_length"); callReferenceLength != 10 {
+		t.Fatalf(`Invalid value for "storage.call_reference_length": %d`, callReferenceLength)
+	}
+	if deleteReferenceLength := viper.GetInt("storage.delete_reference_length"); deleteReferenceLength != 32 {
+		t.Fatalf(`Invalid value for "storage.delete_reference_length": %d`, deleteReferenceLength)
+	}
+	if directory := viper.GetString("filesystem.directory"); directory != "/var/lib/sharex" {
+		t.Fatalf(`Invalid value for "filesystem.directory": %s`, strconv.Quote(directory))
+	}
+	if path := viper.GetString("bolt.path"); path != "/var/lib/sharex.db" {
+		t.Fatalf(`Invalid value for "bolt.path": %s`, strconv.Quote(path))
+	}
+	if openTimeout := viper.GetDuration("bolt.open_timeout"); openTimeout != time.Second*10 {
+		t.Fatalf(`Invalid value for "bolt.open_timeout": %s`, strconv.Quote(openTimeout.String()))
+	}
+	if chunkSize := viper.GetInt("bolt.chunk_size"); chunkSize != 4096 {
+		t.Fatalf(`Invalid value for "bolt.chunk_size": %d`, chunkSize)
+	}
+	if driver := viper.GetString("sql.driver"); driver != "postgres" {
+		t.Fatalf(`Invalid value for "sql.driver": %s`, strconv.Quote(driver))
+	}
+	if dsn := viper.GetString("sql.dsn"); dsn != "postgres://sharex@localhost/sharex" {
+		t.Fatalf(`Invalid value for "sql.dsn": %s`, strconv.Quote(dsn))
+	}
+	if chunkSize := viper.GetInt("sql.chunk_size"); chunkSize != 8192 {
+		t.Fatalf(`Invalid value for "sql.chunk_size": %d`, chunkSize)
+	}
+	testS3Config(t)
+	testEncryptionConfig(t)
+}
+
+func testS3Config(t *testing.T) {
+	if endpoint := viper.GetString("s3.endpoint"); endpoint != "minio:9000" {
+		t.Fatalf(`Invalid value for "s3.endpoint": %s`, strconv.Quote(endpoint))
+	}
+	if accessKey := viper.GetString("s3.access_key"); accessKey != "sharex-access" {
+		t.Fatalf(`Invalid value for "s3.access_key": %s`, strconv.Quote(accessKey))
+	}
+	if secretKey := viper.GetString("s3.secret_key"); secretKey != "sharex-secret" {
+		t.Fatalf(`Invalid value for "s3.secret_key": %s`, strconv.Quote(secretKey))
+	}
+	if secure := viper.GetBool("s3.secure"); secure {
+		t.Fatal(`Invalid value for "s3.secure": true`)
+	}
+	if region := viper.GetString("s3.region"); region != "eu-central-1" {
+		t.Fatalf(`Invalid value for "s3.region": %s`, strconv.Quote(region))
+	}
+	if bucket := viper.GetString("s3.bucket"); bucket != "sharex-bucket" {
+		t.Fatalf(`Invalid value for "s3.bucket": %s`, strconv.Quote(bucket))
+	}
+	if prefix := viper.GetString("s3.prefix"); prefix != "uploads/" {
+		t.Fatalf(`Invalid value for "s3.prefix": %s`, strconv.Quote(prefix))
+	}
+	if partSize := viper.GetInt("s3.part_size"); partSize != 10485760 {
+		t.Fatalf(`Invalid value for "s3.part_size": %d`, partSize)
+	}
+}
+
 func testMongoConfig(t *testing.T) {
 	if address := viper.GetString("mongodb.address"); address != "0.0.0.0:1337" {
 		t.Fatalf(`Invalid value for "mongodb.address": %s`, strconv.Quote(address))
@@ -55,3 +189,16 @@ func testMongoConfig(t *testing.T) {
 		t.Fatalf(`Invalid value for "mongodb.grids_chunk_size": %d`, gridFSChunkSize)
 	}
 }
+
+func testEncryptionConfig(t *testing.T) {
+	if keyID := viper.GetString("encryption.key_id"); keyID != "second" {
+		t.Fatalf(`Invalid value for "encryption.key_id": %s`, strconv.Quote(keyID))
+	}
+	if chunkSize := viper.GetInt("encryption.chunk_size"); chunkSize != 1024 {
+		t.Fatalf(`Invalid value for "encryption.chunk_size": %d`, chunkSize)
+	}
+	keys := viper.GetStringMapString("encryption.keys")
+	if len(keys) != 2 || keys["first"] != "AAECAwQFBgcICQoLDA0ODw==" || keys["second"] != "ZW5jcnlwdGlvbi1rZXk=" {
+		t.Fatalf(`Invalid value for "encryption.keys": %v`, keys)
+	}
+}
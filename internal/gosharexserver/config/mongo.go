@@ -5,17 +5,17 @@ import (
 	"time"
 )
 
-func setMongoDefaults() {
+func setMongoDefaults(config *viper.Viper) {
 	// connect process
-	viper.SetDefault("mongodb.address", "localhost:27017")
-	viper.SetDefault("mongodb.connect_timeout", time.Second*4)
+	config.SetDefault("mongodb.address", "localhost:27017")
+	config.SetDefault("mongodb.connect_timeout", time.Second*4)
 	// authentication
-	viper.SetDefault("mongodb.auth_db", "")
-	viper.SetDefault("mongodb.auth_user", "")
-	viper.SetDefault("mongodb.auth_passwd", "")
+	config.SetDefault("mongodb.auth_db", "")
+	config.SetDefault("mongodb.auth_user", "")
+	config.SetDefault("mongodb.auth_passwd", "")
 	// database
-	viper.SetDefault("mongodb.db", "gosharexserver")
+	config.SetDefault("mongodb.db", "gosharexserver")
 	// GridFS
-	viper.SetDefault("mongodb.gridfs_prefix", "uploads")
-	viper.SetDefault("mongodb.gridfs_chunk_size", 255000)
+	config.SetDefault("mongodb.gridfs_prefix", "uploads")
+	config.SetDefault("mongodb.gridfs_chunk_size", 255000)
 }
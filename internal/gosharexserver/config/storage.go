@@ -0,0 +1,40 @@
+package config
+
+import (
+	"github.com/spf13/viper"
+	"time"
+)
+
+func setStorageDefaults(config *viper.Viper) {
+	// storage type which is used to store the uploaded files, possible values are "mongodb", "filesystem",
+	// "bolt", "sql" and "s3"
+	config.SetDefault("storage.type", "mongodb")
+	// interval in which expired entries are deleted from the storage
+	config.SetDefault("storage.reaper_interval", time.Minute)
+	// lengths of the references which are generated for new entries
+	config.SetDefault("storage.call_reference_length", 6)
+	config.SetDefault("storage.delete_reference_length", 16)
+	// root directory of the filesystem storage
+	config.SetDefault("filesystem.directory", "./data")
+	// database file of the Bolt storage
+	config.SetDefault("bolt.path", "./gosharexserver.db")
+	config.SetDefault("bolt.open_timeout", time.Second*4)
+	config.SetDefault("bolt.chunk_size", 255000)
+	// database connection of the SQL storage, possible drivers are "sqlite3", "mysql" and "postgres"
+	config.SetDefault("sql.driver", "sqlite3")
+	config.SetDefault("sql.dsn", "./gosharexserver.sqlite")
+	config.SetDefault("sql.chunk_size", 255000)
+	// connection and bucket of the S3 storage
+	config.SetDefault("s3.endpoint", "localhost:9000")
+	config.SetDefault("s3.access_key", "")
+	config.SetDefault("s3.secret_key", "")
+	config.SetDefault("s3.secure", true)
+	config.SetDefault("s3.region", "us-east-1")
+	config.SetDefault("s3.bucket", "gosharexserver")
+	config.SetDefault("s3.prefix", "")
+	config.SetDefault("s3.part_size", 5<<20)
+	// encryption at rest, the key ID selects the key of the keys table which encrypts new entries
+	config.SetDefault("encryption.key_id", "")
+	config.SetDefault("encryption.keys", map[string]string{})
+	config.SetDefault("encryption.chunk_size", 64<<10)
+}
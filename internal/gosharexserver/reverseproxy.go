@@ -2,7 +2,9 @@ package gosharexserver
 
 import (
 	"github.com/gorilla/mux"
+	"net"
 	"net/http"
+	"strings"
 )
 
 type reverseProxyRouter struct {
@@ -12,11 +14,29 @@ type reverseProxyRouter struct {
 
 // ServeHTTP is the implementation of the http.Handler function which modifies the request to adjust the remote address.
 func (reverseProxyRouter *reverseProxyRouter) ServeHTTP(writer http.ResponseWriter, req *http.Request) {
-	// set remote address
-	req.RemoteAddr = req.Header[reverseProxyRouter.reverseProxyHeader][0]
+	// set remote address, the address of the connection is kept if the header does not contain a valid one
+	if ip := reverseProxyRouter.forwardedIP(req); ip != nil {
+		req.RemoteAddr = ip.String()
+	}
 	reverseProxyRouter.realRouter.ServeHTTP(writer, req)
 }
 
+// forwardedIP returns the client IP address which was set by the reverse proxy or nil if the header is missing or
+// invalid. Headers like X-Forwarded-For are lists which the client can prepend arbitrary values to, only the right-most
+// value has been added by the reverse proxy and is therefore used.
+func (reverseProxyRouter *reverseProxyRouter) forwardedIP(req *http.Request) net.IP {
+	values := req.Header[http.CanonicalHeaderKey(reverseProxyRouter.reverseProxyHeader)]
+	if len(values) == 0 {
+		return nil
+	}
+	addresses := strings.Split(values[len(values)-1], ",")
+	address := strings.TrimSpace(addresses[len(addresses)-1])
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	return net.ParseIP(address)
+}
+
 // WrapRouterToReverseProxyRouter wraps the given router to a one which adjusts incoming requests fitting to the reverse
 // proxy real header ip settings.
 func WrapRouterToReverseProxyRouter(router *mux.Router, reverseProxyHeader string) http.Handler {
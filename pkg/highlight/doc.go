@@ -0,0 +1,3 @@
+// Package highlight contains the small syntax highlighter of the paste viewer. It splits source code into keywords,
+// strings, comments and numbers of the most common languages without parsing it, which is good enough for pastes.
+package highlight
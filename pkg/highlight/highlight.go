@@ -0,0 +1,263 @@
+package highlight
+
+import (
+	"path"
+	"strings"
+)
+
+// Kind is the kind of a token. It doubles as the CSS class of the token in the paste viewer.
+type Kind string
+
+const (
+	// Plain is the kind of all text which is not highlighted.
+	Plain Kind = ""
+	// Keyword is the kind of the keywords and literals (e.g. true or null) of a language.
+	Keyword Kind = "keyword"
+	// String is the kind of string and character literals.
+	String Kind = "string"
+	// Comment is the kind of line and block comments.
+	Comment Kind = "comment"
+	// Number is the kind of number literals.
+	Number Kind = "number"
+)
+
+// Token is a piece of the highlighted source code.
+type Token struct {
+	Kind Kind
+	Text string
+}
+
+// Line is a line of the highlighted source code without its line break.
+type Line []Token
+
+// Language describes the tokens of a language which are highlighted.
+type Language struct {
+	// Name is the name of the language which can be used to select it via ByName.
+	Name string
+	// Aliases are additional names of the language (e.g. "golang").
+	Aliases []string
+	// Extensions are the lower case filename extensions of the language including the dot.
+	Extensions []string
+	// Keywords are highlighted if an identifier matches one of them.
+	Keywords []string
+	// IgnoreCase makes the keywords case-insensitive (e.g. for SQL).
+	IgnoreCase bool
+	// LineComments are the prefixes of comments which end at the end of the line.
+	LineComments []string
+	// BlockComments are the start and end delimiters of comments which can span multiple lines.
+	BlockComments [][2]string
+	// Quotes are the delimiters of string literals which end at the end of the line. A backslash escapes the next byte.
+	Quotes []string
+	// BlockStrings are the start and end delimiters of string literals which can span multiple lines.
+	BlockStrings [][2]string
+	// keywords is the set of the Keywords of the predefined Languages which is built once at startup.
+	keywords map[string]bool
+}
+
+// ByName returns the language with the given name or alias or nil if there is no such language. Filename extensions
+// (with or without the dot) are accepted as well.
+func ByName(name string) *Language {
+	name = strings.ToLower(name)
+	if name == "" {
+		return nil
+	}
+	for _, language := range Languages {
+		if language.Name == name || contains(language.Aliases, name) || contains(language.Extensions, "."+name) ||
+			contains(language.Extensions, name) {
+			return language
+		}
+	}
+	return nil
+}
+
+// ByFilename returns the language of the given filename which is determined by its extension or nil if the language
+// is not known.
+func ByFilename(filename string) *Language {
+	extension := strings.ToLower(path.Ext(filename))
+	if extension == "" {
+		return nil
+	}
+	for _, language := range Languages {
+		if contains(language.Extensions, extension) {
+			return language
+		}
+	}
+	return nil
+}
+
+// Highlight splits the given source code into highlighted lines. The source code is split into plain lines if the
+// language is nil. Joining the texts of all tokens with line breaks between the lines results in the source code
+// again, except for carriage returns in front of line breaks which are removed.
+func Highlight(source string, language *Language) []Line {
+	source = strings.Replace(source, "\r\n", "\n", -1)
+	var tokens []Token
+	if language == nil {
+		tokens = []Token{{Kind: Plain, Text: source}}
+	} else {
+		tokens = language.tokenize(source)
+	}
+	lines := []Line{{}}
+	for _, token := range tokens {
+		parts := strings.Split(token.Text, "\n")
+		for i, part := range parts {
+			if i > 0 {
+				lines = append(lines, Line{})
+			}
+			if part != "" {
+				lines[len(lines)-1] = append(lines[len(lines)-1], Token{Kind: token.Kind, Text: part})
+			}
+		}
+	}
+	// a trailing line break does not start another line
+	if len(lines) > 1 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// tokenize splits the source code into tokens. Consecutive plain text is merged into a single token.
+func (language *Language) tokenize(source string) []Token {
+	keywords := language.keywords
+	if keywords == nil {
+		keywords = language.keywordSet()
+	}
+	var tokens []Token
+	add := func(kind Kind, text string) {
+		if kind == Plain && len(tokens) > 0 && tokens[len(tokens)-1].Kind == Plain {
+			tokens[len(tokens)-1].Text += text
+			return
+		}
+		tokens = append(tokens, Token{Kind: kind, Text: text})
+	}
+	for i := 0; i < len(source); {
+		rest := source[i:]
+		if length := delimited(rest, language.BlockComments); length > 0 {
+			add(Comment, rest[:length])
+			i += length
+			continue
+		}
+		if length := delimited(rest, language.BlockStrings); length > 0 {
+			add(String, rest[:length])
+			i += length
+			continue
+		}
+		if prefix := hasPrefix(rest, language.LineComments); prefix != "" {
+			length := strings.IndexByte(rest, '\n')
+			if length < 0 {
+				length = len(rest)
+			}
+			add(Comment, rest[:length])
+			i += length
+			continue
+		}
+		if quote := hasPrefix(rest, language.Quotes); quote != "" {
+			length := quoted(rest, quote)
+			add(String, rest[:length])
+			i += length
+			continue
+		}
+		character := rest[0]
+		if isDigit(character) {
+			length := 1
+			for length < len(rest) && (isIdentifier(rest[length]) || rest[length] == '.') {
+				length++
+			}
+			add(Number, rest[:length])
+			i += length
+			continue
+		}
+		if isIdentifier(character) {
+			length := 1
+			for length < len(rest) && isIdentifier(rest[length]) {
+				length++
+			}
+			identifier := rest[:length]
+			if language.IgnoreCase {
+				identifier = strings.ToLower(identifier)
+			}
+			if keywords[identifier] {
+				add(Keyword, rest[:length])
+			} else {
+				add(Plain, rest[:length])
+			}
+			i += length
+			continue
+		}
+		add(Plain, rest[:1])
+		i++
+	}
+	return tokens
+}
+
+// keywordSet returns the set of the Keywords of the language.
+func (language *Language) keywordSet() map[string]bool {
+	keywords := make(map[string]bool, len(language.Keywords))
+	for _, keyword := range language.Keywords {
+		if language.IgnoreCase {
+			keyword = strings.ToLower(keyword)
+		}
+		keywords[keyword] = true
+	}
+	return keywords
+}
+
+// delimited returns the length of the delimited text at the start of the source code or 0 if it does not start with
+// one of the start delimiters. Unterminated text reaches until the end of the source code.
+func delimited(source string, delimiters [][2]string) int {
+	for _, delimiter := range delimiters {
+		if !strings.HasPrefix(source, delimiter[0]) {
+			continue
+		}
+		end := strings.Index(source[len(delimiter[0]):], delimiter[1])
+		if end < 0 {
+			return len(source)
+		}
+		return len(delimiter[0]) + end + len(delimiter[1])
+	}
+	return 0
+}
+
+// quoted returns the length of the string literal at the start of the source code which starts with the given quote.
+// Unterminated literals end at the end of the line.
+func quoted(source, quote string) int {
+	for i := len(quote); i < len(source); i++ {
+		switch {
+		case source[i] == '\\':
+			i++
+		case source[i] == '\n':
+			return i
+		case strings.HasPrefix(source[i:], quote):
+			return i + len(quote)
+		}
+	}
+	return len(source)
+}
+
+// hasPrefix returns the first of the prefixes the source code starts with or an empty string.
+func hasPrefix(source string, prefixes []string) string {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(source, prefix) {
+			return prefix
+		}
+	}
+	return ""
+}
+
+// contains returns whether the slice contains the value.
+func contains(values []string, value string) bool {
+	for _, element := range values {
+		if element == value {
+			return true
+		}
+	}
+	return false
+}
+
+func isDigit(character byte) bool {
+	return character >= '0' && character <= '9'
+}
+
+func isIdentifier(character byte) bool {
+	return character == '_' || isDigit(character) || character >= 'a' && character <= 'z' ||
+		character >= 'A' && character <= 'Z'
+}
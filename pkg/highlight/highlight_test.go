@@ -0,0 +1,73 @@
+package highlight
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHighlight(t *testing.T) {
+	source := "package main\r\n\r\n/* block\ncomment */\nfunc main() {\n\tfmt.Println(\"a \\\" b\", 'c', 42) // done\n}\n"
+	lines := Highlight(source, ByName("go"))
+	expected := []Line{
+		{{Keyword, "package"}, {Plain, " main"}},
+		{},
+		{{Comment, "/* block"}},
+		{{Comment, "comment */"}},
+		{{Keyword, "func"}, {Plain, " main() {"}},
+		{{Plain, "\tfmt.Println("}, {String, `"a \" b"`}, {Plain, ", "}, {String, "'c'"}, {Plain, ", "},
+			{Number, "42"}, {Plain, ") "}, {Comment, "// done"}},
+		{{Plain, "}"}},
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("Highlighted lines do not match: %+v", lines)
+	}
+	// the plain text of the lines has to match the source code
+	var texts []string
+	for _, line := range Highlight(source, nil) {
+		var text string
+		for _, token := range line {
+			text += token.Text
+		}
+		texts = append(texts, text)
+	}
+	if joined := strings.Join(texts, "\n") + "\n"; joined != strings.Replace(source, "\r\n", "\n", -1) {
+		t.Fatalf("Plain lines do not match the source code: %q", joined)
+	}
+}
+
+func TestHighlightUnterminated(t *testing.T) {
+	lines := Highlight("SELECT 'open\nfrom /* never closed\nwhere", ByName("sql"))
+	expected := []Line{
+		{{Keyword, "SELECT"}, {Plain, " "}, {String, "'open"}},
+		{{Keyword, "from"}, {Plain, " "}, {Comment, "/* never closed"}},
+		{{Comment, "where"}},
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("Highlighted lines do not match: %+v", lines)
+	}
+}
+
+func TestLanguageLookup(t *testing.T) {
+	for _, test := range []struct {
+		language *Language
+		name     string
+	}{
+		{ByName("golang"), "go"},
+		{ByName("PY"), "python"},
+		{ByName(".rs"), "rust"},
+		{ByName("kts"), "kotlin"},
+		{ByFilename("ShareX_2018-06-01.JSON"), "json"},
+		{ByFilename("script.sh"), "shell"},
+	} {
+		if test.language == nil || test.language.Name != test.name {
+			t.Fatalf("Expected language %s but got %+v", test.name, test.language)
+		}
+	}
+	if language := ByName("brainfuck"); language != nil {
+		t.Fatalf("Expected no language but got %s", language.Name)
+	}
+	if language := ByFilename("README"); language != nil {
+		t.Fatalf("Expected no language but got %s", language.Name)
+	}
+}
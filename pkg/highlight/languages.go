@@ -0,0 +1,233 @@
+package highlight
+
+// the comments and quotes of the languages which inherited them from C
+var (
+	cLineComments  = []string{"//"}
+	cBlockComments = [][2]string{{"/*", "*/"}}
+	cQuotes        = []string{`"`, "'"}
+)
+
+// Languages are the languages which are known by the highlighter.
+var Languages = []*Language{
+	{
+		Name:          "go",
+		Aliases:       []string{"golang"},
+		Extensions:    []string{".go"},
+		LineComments:  cLineComments,
+		BlockComments: cBlockComments,
+		Quotes:        cQuotes,
+		BlockStrings:  [][2]string{{"`", "`"}},
+		Keywords: []string{"break", "case", "chan", "const", "continue", "default", "defer", "else", "fallthrough",
+			"for", "func", "go", "goto", "if", "import", "interface", "map", "package", "range", "return", "select",
+			"struct", "switch", "type", "var", "true", "false", "nil", "iota"},
+	},
+	{
+		Name:          "c",
+		Extensions:    []string{".c", ".h"},
+		LineComments:  cLineComments,
+		BlockComments: cBlockComments,
+		Quotes:        cQuotes,
+		Keywords: []string{"auto", "break", "case", "char", "const", "continue", "default", "do", "double", "else",
+			"enum", "extern", "float", "for", "goto", "if", "inline", "int", "long", "register", "return", "short",
+			"signed", "sizeof", "static", "struct", "switch", "typedef", "union", "unsigned", "void", "volatile",
+			"while", "NULL", "true", "false", "bool"},
+	},
+	{
+		Name:          "cpp",
+		Aliases:       []string{"c++"},
+		Extensions:    []string{".cpp", ".cc", ".cxx", ".hpp", ".hh", ".hxx"},
+		LineComments:  cLineComments,
+		BlockComments: cBlockComments,
+		Quotes:        cQuotes,
+		Keywords: []string{"auto", "bool", "break", "case", "catch", "char", "class", "const", "constexpr",
+			"continue", "default", "delete", "do", "double", "else", "enum", "explicit", "extern", "false", "float",
+			"for", "friend", "goto", "if", "inline", "int", "long", "namespace", "new", "nullptr", "operator",
+			"private", "protected", "public", "return", "short", "signed", "sizeof", "static", "struct", "switch",
+			"template", "this", "throw", "true", "try", "typedef", "typename", "union", "unsigned", "using", "virtual",
+			"void", "volatile", "while"},
+	},
+	{
+		Name:          "csharp",
+		Aliases:       []string{"c#", "cs"},
+		Extensions:    []string{".cs"},
+		LineComments:  cLineComments,
+		BlockComments: cBlockComments,
+		Quotes:        cQuotes,
+		Keywords: []string{"abstract", "as", "async", "await", "base", "bool", "break", "case", "catch", "class",
+			"const", "continue", "default", "do", "double", "else", "enum", "false", "finally", "float", "for",
+			"foreach", "if", "in", "int", "interface", "internal", "is", "long", "namespace", "new", "null", "object",
+			"out", "override", "private", "protected", "public", "readonly", "ref", "return", "sealed", "static",
+			"string", "struct", "switch", "this", "throw", "true", "try", "using", "var", "virtual", "void", "while"},
+	},
+	{
+		Name:          "java",
+		Extensions:    []string{".java"},
+		LineComments:  cLineComments,
+		BlockComments: cBlockComments,
+		Quotes:        cQuotes,
+		Keywords: []string{"abstract", "boolean", "break", "byte", "case", "catch", "char", "class", "continue",
+			"default", "do", "double", "else", "enum", "extends", "final", "finally", "float", "for", "if",
+			"implements", "import", "instanceof", "int", "interface", "long", "new", "package", "private",
+			"protected", "public", "return", "short", "static", "super", "switch", "synchronized", "this", "throw",
+			"throws", "try", "void", "volatile", "while", "true", "false", "null"},
+	},
+	{
+		Name:          "kotlin",
+		Aliases:       []string{"kt"},
+		Extensions:    []string{".kt", ".kts"},
+		LineComments:  cLineComments,
+		BlockComments: cBlockComments,
+		Quotes:        cQuotes,
+		BlockStrings:  [][2]string{{`"""`, `"""`}},
+		Keywords: []string{"as", "break", "class", "continue", "do", "else", "false", "for", "fun", "if", "import",
+			"in", "interface", "is", "null", "object", "override", "package", "private", "return", "super", "this",
+			"throw", "true", "try", "typealias", "val", "var", "when", "while"},
+	},
+	{
+		Name:          "javascript",
+		Aliases:       []string{"js", "node"},
+		Extensions:    []string{".js", ".mjs", ".cjs", ".jsx"},
+		LineComments:  cLineComments,
+		BlockComments: cBlockComments,
+		Quotes:        cQuotes,
+		BlockStrings:  [][2]string{{"`", "`"}},
+		Keywords: []string{"async", "await", "break", "case", "catch", "class", "const", "continue", "default",
+			"delete", "do", "else", "export", "extends", "false", "finally", "for", "function", "if", "import", "in",
+			"instanceof", "let", "new", "null", "return", "super", "switch", "this", "throw", "true", "try",
+			"typeof", "undefined", "var", "void", "while", "yield"},
+	},
+	{
+		Name:          "typescript",
+		Aliases:       []string{"ts"},
+		Extensions:    []string{".ts", ".tsx"},
+		LineComments:  cLineComments,
+		BlockComments: cBlockComments,
+		Quotes:        cQuotes,
+		BlockStrings:  [][2]string{{"`", "`"}},
+		Keywords: []string{"abstract", "any", "as", "async", "await", "boolean", "break", "case", "catch", "class",
+			"const", "continue", "default", "delete", "do", "else", "enum", "export", "extends", "false", "finally",
+			"for", "function", "if", "implements", "import", "in", "instanceof", "interface", "let", "new", "null",
+			"number", "private", "protected", "public", "readonly", "return", "string", "super", "switch", "this",
+			"throw", "true", "try", "type", "typeof", "undefined", "var", "void", "while"},
+	},
+	{
+		Name:          "rust",
+		Aliases:       []string{"rs"},
+		Extensions:    []string{".rs"},
+		LineComments:  cLineComments,
+		BlockComments: cBlockComments,
+		Quotes:        []string{`"`},
+		Keywords: []string{"as", "break", "const", "continue", "crate", "else", "enum", "extern", "false", "fn", "for",
+			"if", "impl", "in", "let", "loop", "match", "mod", "move", "mut", "pub", "ref", "return", "self", "Self",
+			"static", "struct", "super", "trait", "true", "type", "unsafe", "use", "where", "while"},
+	},
+	{
+		Name:          "php",
+		Extensions:    []string{".php"},
+		LineComments:  []string{"//", "#"},
+		BlockComments: cBlockComments,
+		Quotes:        cQuotes,
+		IgnoreCase:    true,
+		Keywords: []string{"abstract", "array", "as", "break", "case", "catch", "class", "const", "continue",
+			"default", "do", "echo", "else", "elseif", "extends", "false", "final", "finally", "for", "foreach",
+			"function", "if", "implements", "interface", "namespace", "new", "null", "private", "protected", "public",
+			"return", "static", "switch", "throw", "true", "try", "use", "while"},
+	},
+	{
+		Name:         "python",
+		Aliases:      []string{"py"},
+		Extensions:   []string{".py", ".pyw"},
+		LineComments: []string{"#"},
+		Quotes:       cQuotes,
+		BlockStrings: [][2]string{{`"""`, `"""`}, {"'''", "'''"}},
+		Keywords: []string{"and", "as", "assert", "async", "await", "break", "class", "continue", "def", "del",
+			"elif", "else", "except", "finally", "for", "from", "global", "if", "import", "in", "is", "lambda",
+			"nonlocal", "not", "or", "pass", "raise", "return", "try", "while", "with", "yield", "True", "False",
+			"None"},
+	},
+	{
+		Name:         "ruby",
+		Aliases:      []string{"rb"},
+		Extensions:   []string{".rb"},
+		LineComments: []string{"#"},
+		Quotes:       cQuotes,
+		Keywords: []string{"begin", "break", "case", "class", "def", "do", "else", "elsif", "end", "ensure", "false",
+			"for", "if", "in", "module", "next", "nil", "not", "or", "and", "redo", "rescue", "retry", "return",
+			"self", "super", "then", "true", "unless", "until", "when", "while", "yield"},
+	},
+	{
+		Name:          "lua",
+		Extensions:    []string{".lua"},
+		BlockComments: [][2]string{{"--[[", "]]"}},
+		LineComments:  []string{"--"},
+		Quotes:        cQuotes,
+		BlockStrings:  [][2]string{{"[[", "]]"}},
+		Keywords: []string{"and", "break", "do", "else", "elseif", "end", "false", "for", "function", "goto", "if",
+			"in", "local", "nil", "not", "or", "repeat", "return", "then", "true", "until", "while"},
+	},
+	{
+		Name:         "shell",
+		Aliases:      []string{"sh", "bash", "zsh"},
+		Extensions:   []string{".sh", ".bash", ".zsh"},
+		LineComments: []string{"#"},
+		Quotes:       cQuotes,
+		Keywords: []string{"case", "do", "done", "elif", "else", "esac", "export", "fi", "for", "function", "if", "in",
+			"local", "return", "then", "until", "while"},
+	},
+	{
+		Name:          "sql",
+		Extensions:    []string{".sql"},
+		LineComments:  []string{"--"},
+		BlockComments: cBlockComments,
+		Quotes:        cQuotes,
+		IgnoreCase:    true,
+		Keywords: []string{"add", "all", "alter", "and", "as", "asc", "between", "by", "case", "create", "delete",
+			"desc", "distinct", "drop", "else", "end", "exists", "from", "group", "having", "in", "index", "inner",
+			"insert", "into", "is", "join", "key", "left", "like", "limit", "not", "null", "on", "or", "order",
+			"outer", "primary", "references", "right", "select", "set", "table", "then", "union", "update", "values",
+			"when", "where"},
+	},
+	{
+		Name:       "json",
+		Extensions: []string{".json"},
+		Quotes:     []string{`"`},
+		Keywords:   []string{"true", "false", "null"},
+	},
+	{
+		Name:         "yaml",
+		Aliases:      []string{"yml"},
+		Extensions:   []string{".yaml", ".yml"},
+		LineComments: []string{"#"},
+		Quotes:       cQuotes,
+		Keywords:     []string{"true", "false", "null", "yes", "no"},
+	},
+	{
+		Name:         "toml",
+		Aliases:      []string{"ini"},
+		Extensions:   []string{".toml", ".ini", ".cfg", ".conf"},
+		LineComments: []string{"#", ";"},
+		Quotes:       cQuotes,
+		BlockStrings: [][2]string{{`"""`, `"""`}, {"'''", "'''"}},
+		Keywords:     []string{"true", "false"},
+	},
+	{
+		Name:          "xml",
+		Aliases:       []string{"html", "svg"},
+		Extensions:    []string{".xml", ".html", ".htm", ".svg", ".xhtml"},
+		BlockComments: [][2]string{{"<!--", "-->"}},
+		Quotes:        []string{`"`},
+	},
+	{
+		Name:          "css",
+		Extensions:    []string{".css", ".scss"},
+		BlockComments: cBlockComments,
+		Quotes:        cQuotes,
+		Keywords:      []string{"important", "inherit", "initial", "none", "auto"},
+	},
+}
+
+func init() {
+	for _, language := range Languages {
+		language.keywords = language.keywordSet()
+	}
+}
@@ -0,0 +1,4 @@
+// Package ratelimit contains the token bucket rate limiter of the ShareX router. The state of the buckets is kept in a
+// Store which is either the in-memory MemoryStore or a shared implementation if multiple instances of the application
+// run behind a load balancer.
+package ratelimit
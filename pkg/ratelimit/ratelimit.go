@@ -0,0 +1,131 @@
+package ratelimit
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sweepInterval is the interval in which the MemoryStore removes the buckets which have been refilled completely.
+const sweepInterval = time.Minute
+
+// ErrInvalidLimit is returned by the ParseLimit function if the value is not a valid limit.
+var ErrInvalidLimit = errors.New("invalid rate limit")
+
+// Limit is the configuration of a token bucket. The bucket holds up to Burst tokens and is refilled completely within
+// the Interval. Every request takes a single token. The zero value does not limit anything.
+type Limit struct {
+	// Burst is the maximum amount of requests which can be sent at once.
+	Burst int
+	// Interval is the time after which an empty bucket is full again.
+	Interval time.Duration
+}
+
+// ParseLimit parses a limit in the format "{requests}/{interval}" (e.g. "30/1m" for 30 requests per minute). An empty
+// value results in the zero Limit.
+func ParseLimit(value string) (Limit, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return Limit{}, nil
+	}
+	separator := strings.Index(value, "/")
+	if separator <= 0 {
+		return Limit{}, ErrInvalidLimit
+	}
+	burst, err := strconv.Atoi(value[:separator])
+	if err != nil || burst <= 0 {
+		return Limit{}, ErrInvalidLimit
+	}
+	interval, err := time.ParseDuration(value[separator+1:])
+	if err != nil || interval <= 0 {
+		return Limit{}, ErrInvalidLimit
+	}
+	return Limit{Burst: burst, Interval: interval}, nil
+}
+
+// Enabled returns whether the limit limits anything.
+func (limit Limit) Enabled() bool {
+	return limit.Burst > 0 && limit.Interval > 0
+}
+
+// String returns the limit in the format which is parsed by the ParseLimit function.
+func (limit Limit) String() string {
+	if !limit.Enabled() {
+		return ""
+	}
+	return strconv.Itoa(limit.Burst) + "/" + limit.Interval.String()
+}
+
+// Store is an interface which is the scheme to store the token buckets. Implementations which share the buckets between
+// multiple instances of the application (e.g. via Redis) have to take the tokens atomically.
+type Store interface {
+	// Take takes a token from the bucket with the given key at the given time. A new bucket with the given limit is
+	// created if there is none yet. It returns zero if a token has been taken, otherwise the duration after which the
+	// next token is available.
+	Take(key string, limit Limit, now time.Time) (retryAfter time.Duration, err error)
+}
+
+// MemoryStore is the Store implementation which keeps the buckets in memory. Buckets which have been refilled
+// completely are removed from time to time so that the memory usage depends on the amount of recent clients only.
+type MemoryStore struct {
+	mutex     sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// bucket is the state of a single token bucket.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	limit   Limit
+}
+
+// NewMemoryStore returns a new and empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket)}
+}
+
+// Take is the implementation of the Store.Take method.
+func (store *MemoryStore) Take(key string, limit Limit, now time.Time) (time.Duration, error) {
+	if !limit.Enabled() {
+		return 0, nil
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if now.Sub(store.lastSweep) >= sweepInterval {
+		store.sweep(now)
+	}
+	currentBucket, ok := store.buckets[key]
+	if !ok || currentBucket.limit != limit {
+		currentBucket = &bucket{tokens: float64(limit.Burst), updated: now, limit: limit}
+		store.buckets[key] = currentBucket
+	}
+	currentBucket.refill(now)
+	if currentBucket.tokens >= 1 {
+		currentBucket.tokens--
+		return 0, nil
+	}
+	return time.Duration(math.Ceil((1 - currentBucket.tokens) * float64(limit.Interval) / float64(limit.Burst))), nil
+}
+
+// sweep removes the buckets which are full at the given time.
+func (store *MemoryStore) sweep(now time.Time) {
+	for key, currentBucket := range store.buckets {
+		if currentBucket.refill(now); currentBucket.tokens >= float64(currentBucket.limit.Burst) {
+			delete(store.buckets, key)
+		}
+	}
+	store.lastSweep = now
+}
+
+// refill adds the tokens which have been refilled since the last update of the bucket.
+func (currentBucket *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(currentBucket.updated); elapsed > 0 {
+		currentBucket.tokens = math.Min(float64(currentBucket.limit.Burst), currentBucket.tokens+
+			float64(elapsed)*float64(currentBucket.limit.Burst)/float64(currentBucket.limit.Interval))
+		currentBucket.updated = now
+	}
+}
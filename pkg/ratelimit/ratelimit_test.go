@@ -0,0 +1,59 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	for value, expected := range map[string]Limit{
+		"30/1m":   {Burst: 30, Interval: time.Minute},
+		" 5/10s ": {Burst: 5, Interval: time.Second * 10},
+		"":        {},
+	} {
+		limit, err := ParseLimit(value)
+		if err != nil || limit != expected {
+			t.Fatalf("Parsed %q as %+v, expected %+v, err: %v", value, limit, expected, err)
+		}
+	}
+	for _, value := range []string{"30", "30/", "/1m", "0/1m", "-1/1m", "30/0s", "30/minute", "a/1m"} {
+		if _, err := ParseLimit(value); err != ErrInvalidLimit {
+			t.Fatalf("Invalid limit %q was not rejected, err: %v", value, err)
+		}
+	}
+	if limit := (Limit{Burst: 30, Interval: time.Minute}); limit.String() != "30/1m0s" {
+		t.Fatalf("Invalid string %q of the limit %+v", limit.String(), limit)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	limit := Limit{Burst: 2, Interval: time.Second * 10}
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if retryAfter, err := store.Take("client", limit, now); err != nil || retryAfter != 0 {
+			t.Fatalf("Request %d within the burst was limited, retry after %v, err: %v", i, retryAfter, err)
+		}
+	}
+	if retryAfter, _ := store.Take("client", limit, now); retryAfter != time.Second*5 {
+		t.Fatalf("Expected to retry after 5s, got %v", retryAfter)
+	}
+	// other keys have their own buckets
+	if retryAfter, _ := store.Take("another client", limit, now); retryAfter != 0 {
+		t.Fatalf("Request of another client was limited, retry after %v", retryAfter)
+	}
+	if retryAfter, _ := store.Take("client", limit, now.Add(time.Second*2)); retryAfter != time.Second*3 {
+		t.Fatalf("Expected to retry after 3s, got %v", retryAfter)
+	}
+	if retryAfter, _ := store.Take("client", limit, now.Add(time.Second*5)); retryAfter != 0 {
+		t.Fatalf("Request after the refill was limited, retry after %v", retryAfter)
+	}
+	if retryAfter, err := store.Take("client", Limit{}, now); err != nil || retryAfter != 0 {
+		t.Fatalf("Request without a limit was limited, retry after %v, err: %v", retryAfter, err)
+	}
+	// the buckets are full again and removed by the next sweep
+	store.Take("client", limit, now.Add(time.Minute*2))
+	if len(store.buckets) != 1 {
+		t.Fatalf("Expected a single bucket after the sweep, got %d", len(store.buckets))
+	}
+}
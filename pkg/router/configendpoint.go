@@ -0,0 +1,84 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// customUploaderTypeParameter is the query parameter which selects the kind of the generated custom uploader
+	customUploaderTypeParameter = "type"
+	customUploaderTypeFile      = "file"
+	customUploaderTypeShortener = "shortener"
+)
+
+// customUploader is the ShareX custom uploader (.sxcu) file which can be imported by the ShareX client.
+type customUploader struct {
+	Version         string            `json:"Version"`
+	Name            string            `json:"Name"`
+	DestinationType string            `json:"DestinationType"`
+	RequestMethod   string            `json:"RequestMethod"`
+	RequestURL      string            `json:"RequestURL"`
+	Headers         map[string]string `json:"Headers,omitempty"`
+	Body            string            `json:"Body"`
+	Arguments       map[string]string `json:"Arguments,omitempty"`
+	FileFormName    string            `json:"FileFormName,omitempty"`
+	URL             string            `json:"URL"`
+	DeletionURL     string            `json:"DeletionURL,omitempty"`
+}
+
+// handleCustomUploaderConfig is the endpoint which generates a ShareX custom uploader file for the token of the
+// requesting user. The uploader uploads files unless the URL shortener is selected via the type query parameter. The
+// links are built from the PublicURL and the path prefix the router is mounted at.
+func (shareXRouter *ShareXRouter) handleCustomUploaderConfig(writer http.ResponseWriter, request *http.Request) {
+	if _, ok := shareXRouter.checkAuthorization(request, writer); !ok {
+		return
+	}
+	uploader := customUploader{
+		Version:       "13.1.0",
+		RequestMethod: http.MethodPost,
+		Body:          "MultipartFormData",
+		URL:           shareXRouter.absoluteURL(request, "/$json:call_reference$"),
+	}
+	switch request.URL.Query().Get(customUploaderTypeParameter) {
+	case "", customUploaderTypeFile:
+		uploader.DestinationType = "ImageUploader, TextUploader, FileUploader"
+		uploader.RequestURL = shareXRouter.absoluteURL(request, "/upload")
+		uploader.FileFormName = multipartFormName
+	case customUploaderTypeShortener:
+		uploader.DestinationType = "URLShortener"
+		uploader.RequestURL = shareXRouter.absoluteURL(request, "/shorten")
+		uploader.Arguments = map[string]string{shortenFormName: "$input$"}
+	default:
+		http.Error(writer, fmt.Sprintf("400 the type must be %q or %q", customUploaderTypeFile,
+			customUploaderTypeShortener), http.StatusBadRequest)
+		return
+	}
+	// the uploader uses the token which has been used to request it
+	if token := request.Header.Get("Authorization"); token != "" {
+		uploader.Headers = map[string]string{"Authorization": token}
+	}
+	if shareXRouter.DeletePolicy.allowsLinks() {
+		uploader.DeletionURL = shareXRouter.absoluteURL(request, "/delete/$json:delete_reference$")
+	}
+	name := "gosharexserver"
+	if requestURL, err := url.Parse(uploader.RequestURL); err == nil && requestURL.Host != "" {
+		name = requestURL.Host
+	}
+	uploader.Name = name
+	data, err := json.MarshalIndent(uploader, "", "  ")
+	if err != nil {
+		shareXRouter.sendInternalError(writer, "creation of the custom uploader file", err)
+		return
+	}
+	writer.Header().Set(contentTypeHeader, "application/json")
+	// colons of the port are not allowed in Windows filenames
+	writer.Header().Set(dispositionHeader, fmt.Sprintf(dispositionValueFormat, "attachment",
+		strings.Replace(name, ":", "_", -1)+".sxcu"))
+	// the file contains the token of the user
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.Write(data)
+}
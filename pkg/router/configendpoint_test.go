@@ -0,0 +1,62 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// requestCustomUploader requests the custom uploader file of the given type with the global token.
+func requestCustomUploader(t *testing.T, handler http.Handler, uploaderType string) (*httptest.ResponseRecorder,
+	*customUploader) {
+	request := httptest.NewRequest(http.MethodGet, "/config.sxcu?type="+uploaderType, nil)
+	request.Header.Set("Authorization", "global")
+	recorder := serve(handler, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Custom uploader request failed with status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	uploader := &customUploader{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), uploader); err != nil {
+		t.Fatalf("Could not decode custom uploader, %T: %v", err, err)
+	}
+	return recorder, uploader
+}
+
+func TestCustomUploaderConfig(t *testing.T) {
+	shareXRouter := &ShareXRouter{AuthorizationToken: "global"}
+	handler, release := newTestRouter(t, shareXRouter, "/")
+	defer release()
+	recorder := serve(handler, httptest.NewRequest(http.MethodGet, "/config.sxcu", nil))
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("Unauthorized custom uploader request was answered with status %d", recorder.Code)
+	}
+	recorder, uploader := requestCustomUploader(t, handler, "")
+	disposition := recorder.Header().Get(dispositionHeader)
+	if disposition != `attachment; filename="example.com.sxcu"` {
+		t.Fatalf("Custom uploader was sent with the disposition %q", disposition)
+	}
+	if recorder.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("Custom uploader was sent with the Cache-Control header %q", recorder.Header().Get("Cache-Control"))
+	}
+	if uploader.Name != "example.com" || uploader.RequestMethod != http.MethodPost ||
+		uploader.RequestURL != "http://example.com/upload" || uploader.FileFormName != multipartFormName ||
+		uploader.Headers["Authorization"] != "global" || uploader.URL != "http://example.com/$json:call_reference$" ||
+		uploader.DeletionURL != "http://example.com/delete/$json:delete_reference$" {
+		t.Fatalf("Invalid file uploader %+v", uploader)
+	}
+	// the links are built from the public URL if it is set and the delete links are left out if they are disabled
+	shareXRouter.PublicURL = "https://sharex.example.org/"
+	shareXRouter.DeletePolicy = DeletePolicyOwner
+	_, uploader = requestCustomUploader(t, handler, customUploaderTypeShortener)
+	if uploader.DestinationType != "URLShortener" || uploader.RequestURL != "https://sharex.example.org/shorten" ||
+		uploader.Arguments[shortenFormName] != "$input$" || uploader.FileFormName != "" ||
+		uploader.URL != "https://sharex.example.org/$json:call_reference$" || uploader.DeletionURL != "" {
+		t.Fatalf("Invalid URL shortener %+v", uploader)
+	}
+	request := httptest.NewRequest(http.MethodGet, "/config.sxcu?type=unknown", nil)
+	request.Header.Set("Authorization", "global")
+	if code := serve(handler, request).Code; code != http.StatusBadRequest {
+		t.Fatalf("Unknown custom uploader type was answered with status %d", code)
+	}
+}
@@ -3,6 +3,7 @@ package router
 import (
 	"fmt"
 	"github.com/gorilla/mux"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
 	"net/http"
 	"strconv"
 )
@@ -11,8 +12,47 @@ const (
 	deleteReferenceVar = "deleteReference"
 )
 
+// DeletePolicy determines who is allowed to delete entries.
+type DeletePolicy string
+
+const (
+	// DeletePolicyLink allows everyone who knows the delete link of an entry to delete it. Additionally the owner of
+	// an entry and admins can delete it via the API.
+	DeletePolicyLink DeletePolicy = "link"
+	// DeletePolicyOwner disables the delete links. Only the owner of an entry and admins can delete it via the API.
+	DeletePolicyOwner DeletePolicy = "owner"
+	// DeletePolicyAdmin disables the delete links. Only admins can delete entries via the API.
+	DeletePolicyAdmin DeletePolicy = "admin"
+)
+
+// Valid returns whether the policy is one of the known delete policies.
+func (deletePolicy DeletePolicy) Valid() bool {
+	switch deletePolicy {
+	case DeletePolicyLink, DeletePolicyOwner, DeletePolicyAdmin:
+		return true
+	}
+	return false
+}
+
+// allowsLinks returns whether the delete links can be used. An empty policy defaults to the DeletePolicyLink.
+func (deletePolicy DeletePolicy) allowsLinks() bool {
+	return deletePolicy == "" || deletePolicy == DeletePolicyLink
+}
+
+// allows returns whether the user is allowed to delete the entry via the API.
+func (deletePolicy DeletePolicy) allows(user *storage.User, entry *storage.Entry) bool {
+	if user.IsAdmin() {
+		return true
+	}
+	return deletePolicy != DeletePolicyAdmin && user.Author() == entry.Author
+}
+
 // handleDelete is the endpoint which handles incoming delete requests via link.
 func (shareXRouter *ShareXRouter) handleDelete(writer http.ResponseWriter, request *http.Request) {
+	if !shareXRouter.DeletePolicy.allowsLinks() {
+		http.Error(writer, "403 delete links are disabled", http.StatusForbidden)
+		return
+	}
 	//get the delete reference
 	deleteReference, ok := mux.Vars(request)[deleteReferenceVar]
 	if !ok {
@@ -21,9 +61,49 @@ func (shareXRouter *ShareXRouter) handleDelete(writer http.ResponseWriter, reque
 	}
 	//delete the entry
 	err := shareXRouter.Storage.Delete(deleteReference)
-	if err != nil {
+	if err == storage.ErrEntryNotFound {
+		shareXRouter.referenceNotFound(writer, request)
+		return
+	} else if err != nil {
 		shareXRouter.sendInternalError(writer, fmt.Sprintf("deleting entry with call reference %v", strconv.Quote(deleteReference)), err)
 		return
 	}
 	writer.WriteHeader(http.StatusOK)
 }
+
+// handleEntryDelete is the endpoint which handles authorized delete requests via the API. Only the owner of the entry
+// and admins are allowed to delete it (see DeletePolicy).
+func (shareXRouter *ShareXRouter) handleEntryDelete(writer http.ResponseWriter, request *http.Request) {
+	user, ok := shareXRouter.checkAuthorization(request, writer)
+	if !ok {
+		return
+	}
+	callReference, ok := mux.Vars(request)[callReferenceVar]
+	if !ok {
+		http.Error(writer, "400 Bad request", http.StatusBadRequest)
+		return
+	}
+	// expired entries and entries whose downloads are exhausted can still be deleted by their owners
+	entry, err := shareXRouter.Storage.Lookup(callReference)
+	if err == storage.ErrEntryNotFound {
+		shareXRouter.referenceNotFound(writer, request)
+		return
+	} else if err != nil {
+		shareXRouter.sendInternalError(writer, fmt.Sprintf("looking up entry with call reference %v",
+			strconv.Quote(callReference)), err)
+		return
+	}
+	if !shareXRouter.DeletePolicy.allows(user, entry) {
+		http.Error(writer, "403 not allowed to delete this entry", http.StatusForbidden)
+		return
+	}
+	if err = shareXRouter.Storage.Delete(entry.DeleteReference); err == storage.ErrEntryNotFound {
+		http.NotFound(writer, request)
+		return
+	} else if err != nil {
+		shareXRouter.sendInternalError(writer, fmt.Sprintf("deleting entry with call reference %v",
+			strconv.Quote(callReference)), err)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,184 @@
+package router
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// rawPath is the path prefix of the endpoint which always sends the data of an entry relative to the root of the
+	// router
+	rawPath = "/raw/"
+)
+
+// embedTemplate is the HTML page which is sent to link preview crawlers (e.g. Discord, Slack or Microsoft Teams). It
+// contains the Open Graph and Twitter card tags which point at the raw data of the entry.
+var embedTemplate = template.Must(template.New("embed").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{.Title}}</title>
+{{if .SiteName}}<meta property="og:site_name" content="{{.SiteName}}">
+{{end}}{{if .Color}}<meta name="theme-color" content="{{.Color}}">
+{{end}}<meta property="og:title" content="{{.Title}}">
+<meta property="og:description" content="{{.Description}}">
+<meta property="og:url" content="{{.URL}}">
+{{if eq .Kind "image"}}<meta property="og:type" content="website">
+<meta property="og:image" content="{{.MediaURL}}">
+<meta property="og:image:type" content="{{.ContentType}}">
+<meta name="twitter:card" content="summary_large_image">
+<meta name="twitter:image" content="{{.MediaURL}}">
+{{else if eq .Kind "video"}}<meta property="og:type" content="video.other">
+<meta property="og:video" content="{{.MediaURL}}">
+<meta property="og:video:secure_url" content="{{.MediaURL}}">
+<meta property="og:video:type" content="{{.ContentType}}">
+<meta name="twitter:card" content="summary">
+{{else if eq .Kind "audio"}}<meta property="og:type" content="music.song">
+<meta property="og:audio" content="{{.MediaURL}}">
+<meta property="og:audio:type" content="{{.ContentType}}">
+<meta name="twitter:card" content="summary">
+{{else}}<meta property="og:type" content="website">
+<meta name="twitter:card" content="summary">
+{{end}}<meta name="twitter:title" content="{{.Title}}">
+<meta name="twitter:description" content="{{.Description}}">
+<style>
+body { font-family: sans-serif; background: #f4f4f4; display: flex; justify-content: center; padding-top: 10vh; }
+main { background: #fff; padding: 2em; border-radius: 4px; box-shadow: 0 1px 4px rgba(0, 0, 0, .2); max-width: 90vw; }
+img, video, audio { display: block; max-width: 100%; max-height: 70vh; margin-top: 1em; }
+</style>
+</head>
+<body>
+<main>
+<div><strong>{{.Title}}</strong></div>
+<div>{{.Description}}</div>
+{{if eq .Kind "image"}}<img src="{{.MediaURL}}" alt="{{.Title}}">
+{{else if eq .Kind "video"}}<video src="{{.MediaURL}}" controls></video>
+{{else if eq .Kind "audio"}}<audio src="{{.MediaURL}}" controls></audio>
+{{end}}{{if not .Locked}}<p><a href="{{.MediaURL}}">Open the file</a></p>
+{{end}}</main>
+</body>
+</html>
+`))
+
+// embed holds the values of the embedTemplate.
+type embed struct {
+	SiteName    string
+	Color       string
+	Title       string
+	Description string
+	URL         string
+	MediaURL    string
+	ContentType string
+	// Kind is the kind of media ("image", "video" or "audio") which is embedded or empty if no media is embedded.
+	Kind string
+	// Locked is true if the data of the entry must not be linked.
+	Locked bool
+}
+
+// handleRawRequest is the endpoint which sends the data of the entry like the handleRequest endpoint but never sends
+// the embed page or the paste viewer. The embed page points at it so that crawlers can fetch the data itself.
+func (shareXRouter *ShareXRouter) handleRawRequest(writer http.ResponseWriter, request *http.Request) {
+	shareXRouter.serveEntry(writer, request, false, false)
+}
+
+// handleEmbed is the endpoint which sends the embed page of an entry. Password protected entries and entries with
+// limited downloads are embedded without their data, so link previews neither reveal the data nor consume a download.
+func (shareXRouter *ShareXRouter) handleEmbed(writer http.ResponseWriter, request *http.Request) {
+	callReference, ok := mux.Vars(request)[callReferenceVar]
+	if !ok {
+		http.Error(writer, "400 the client sent a bad request", http.StatusBadRequest)
+		return
+	}
+	entry, err := shareXRouter.Storage.Request(callReference)
+	if err == storage.ErrEntryNotFound {
+		shareXRouter.referenceNotFound(writer, request)
+		return
+	} else if err != nil {
+		shareXRouter.sendInternalError(writer, fmt.Sprintf("requesting entry with call reference %v",
+			strconv.Quote(callReference)), err)
+		return
+	}
+	if entry.ContentType == redirectContentType && entry.PasswordHash == "" && entry.MaximumDownloads == 0 {
+		// crawlers follow short links to the preview of their target
+		shareXRouter.sendRedirect(writer, entry)
+		entry.Reader.Close()
+		return
+	}
+	entry.Reader.Close()
+	if entry.ContentType == encryptedContentType {
+		// the server does not know anything about the content of client-side encrypted entries
+		http.NotFound(writer, request)
+		return
+	}
+	page := embed{
+		SiteName:    shareXRouter.EmbedSiteName,
+		Color:       shareXRouter.EmbedColor,
+		Title:       entry.Filename,
+		Description: fmt.Sprintf("%s, uploaded on %s", entry.ContentType, entry.UploadDate.UTC().Format("2006-01-02")),
+		URL:         shareXRouter.absoluteURL(request, "/"+callReference),
+		MediaURL:    shareXRouter.absoluteURL(request, rawPath+callReference),
+		ContentType: entry.ContentType,
+	}
+	switch {
+	case entry.PasswordHash != "":
+		page.Locked, page.Description = true, "This file is protected by a password."
+	case entry.MaximumDownloads > 0:
+		page.Locked, page.Description = true, "This file can only be downloaded a limited number of times."
+	default:
+		page.Kind = embedKind(entry.ContentType)
+	}
+	if entry.ContentType == redirectContentType {
+		// the target of protected short links must not be revealed
+		page.Title = "Short link"
+	}
+	if page.Locked {
+		writer.Header().Set("Cache-Control", "no-store")
+	}
+	writer.Header().Set(contentTypeHeader, "text/html; charset=utf-8")
+	embedTemplate.Execute(writer, page)
+}
+
+// crawler returns whether the request has been sent by a link preview crawler whose User-Agent header contains one of
+// the EmbedUserAgents.
+func (shareXRouter *ShareXRouter) crawler(request *http.Request) bool {
+	userAgent := strings.ToLower(request.UserAgent())
+	if userAgent == "" {
+		return false
+	}
+	for _, crawlerUserAgent := range shareXRouter.EmbedUserAgents {
+		if crawlerUserAgent != "" && strings.Contains(userAgent, strings.ToLower(crawlerUserAgent)) {
+			return true
+		}
+	}
+	return false
+}
+
+// absoluteURL returns the absolute URL of the given path which is relative to the root of the router. The PublicURL is
+// used as the base URL if it is set, otherwise the base URL is derived from the request.
+func (shareXRouter *ShareXRouter) absoluteURL(request *http.Request, path string) string {
+	if shareXRouter.PublicURL != "" {
+		return strings.TrimRight(shareXRouter.PublicURL, "/") + shareXRouter.mountPrefix + path
+	}
+	scheme := "http"
+	if request.TLS != nil || strings.EqualFold(request.Header.Get("X-Forwarded-Proto"), "https") {
+		scheme = "https"
+	}
+	return scheme + "://" + request.Host + shareXRouter.mountPrefix + path
+}
+
+// embedKind returns the kind of media which is embedded for the given content type.
+func embedKind(contentType string) string {
+	for _, kind := range []string{"image", "video", "audio"} {
+		if strings.HasPrefix(strings.ToLower(contentType), kind+"/") {
+			return kind
+		}
+	}
+	return ""
+}
@@ -0,0 +1,286 @@
+package router
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"html/template"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// encryptedContentType marks entries which have been encrypted in the browser. The server only stores the
+	// ciphertext, the original filename and content type are encrypted alongside the file data.
+	encryptedContentType = "application/x-gosharexserver-encrypted"
+	// encryptedViewerPath is the path prefix of the viewer pages relative to the root of the router
+	encryptedViewerPath = "e/"
+	// encryptedPageSecurityPolicy only allows the inline scripts and styles of the pages and the decrypted blobs
+	encryptedPageSecurityPolicy = "default-src 'none'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; " +
+		"img-src blob:; media-src blob:; connect-src 'self'; form-action 'none'; base-uri 'none'"
+)
+
+// encryptedPageStyle is shared by the upload and the viewer page.
+const encryptedPageStyle = `<style>
+body { font-family: sans-serif; background: #f4f4f4; display: flex; justify-content: center; padding-top: 10vh; }
+main { background: #fff; padding: 2em; border-radius: 4px; box-shadow: 0 1px 4px rgba(0, 0, 0, .2); max-width: 90vw; }
+input, select { display: block; width: 100%; box-sizing: border-box; margin-top: 1em; padding: .5em; }
+input[type=checkbox] { display: inline; width: auto; }
+img, video { display: block; max-width: 100%; max-height: 70vh; margin-top: 1em; }
+pre { max-height: 70vh; overflow: auto; background: #f4f4f4; padding: 1em; }
+.error { color: #c00; }
+</style>`
+
+// encryptedScript contains the functions which are shared by the upload and the viewer page. The plaintext consists
+// of the length of the JSON encoded metadata (4 bytes, big endian), the metadata and the file data. The ciphertext is
+// prefixed with the random 12 byte IV and the 256 bit AES-GCM key is base64url encoded in the URL fragment.
+const encryptedScript = `function encodeKey(key) {
+	return btoa(String.fromCharCode.apply(null, new Uint8Array(key)))
+		.replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+}
+function decodeKey(encoded) {
+	var binary = atob(encoded.replace(/-/g, "+").replace(/_/g, "/"));
+	var key = new Uint8Array(binary.length);
+	for (var i = 0; i < binary.length; i++) {
+		key[i] = binary.charCodeAt(i);
+	}
+	return key;
+}
+function show(id, text) {
+	var element = document.getElementById(id);
+	element.textContent = text;
+	element.hidden = false;
+}`
+
+// encryptedUploadTemplate is the HTML page which encrypts files in the browser and uploads the ciphertext.
+var encryptedUploadTemplate = template.Must(template.New("encryptedUpload").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Encrypted upload</title>
+` + encryptedPageStyle + `
+</head>
+<body>
+<main>
+<div>The file is encrypted in your browser, the server never sees its content.</div>
+<form id="form">
+<input type="password" id="token" placeholder="Authorization token">
+<input type="file" id="file" required>
+<select id="expiry">
+<option value="">Never expires</option>
+<option value="1h">Expires after 1 hour</option>
+<option value="24h">Expires after 1 day</option>
+<option value="168h">Expires after 1 week</option>
+</select>
+<label><input type="checkbox" id="burn"> Burn after reading</label>
+<input type="submit" value="Encrypt and upload">
+</form>
+<div class="error" id="error" hidden></div>
+<div id="result" hidden>Share this link, it contains the key: <a id="link"></a></div>
+</main>
+<script>
+` + encryptedScript + `
+var contentType = {{.ContentType}};
+var tokenInput = document.getElementById("token");
+tokenInput.value = localStorage.getItem("token") || "";
+document.getElementById("form").addEventListener("submit", function (event) {
+	event.preventDefault();
+	document.getElementById("error").hidden = true;
+	var file = document.getElementById("file").files[0];
+	var metadata = new TextEncoder().encode(JSON.stringify({name: file.name, type: file.type}));
+	var header = new Uint8Array(4);
+	new DataView(header.buffer).setUint32(0, metadata.length);
+	var iv = crypto.getRandomValues(new Uint8Array(12));
+	var key;
+	crypto.subtle.generateKey({name: "AES-GCM", length: 256}, true, ["encrypt"]).then(function (generatedKey) {
+		key = generatedKey;
+		return new Response(new Blob([header, metadata, file])).arrayBuffer();
+	}).then(function (plaintext) {
+		return crypto.subtle.encrypt({name: "AES-GCM", iv: iv}, key, plaintext);
+	}).then(function (ciphertext) {
+		// the options have to be sent before the file
+		var form = new FormData();
+		form.append("expires_in", document.getElementById("expiry").value);
+		form.append("burn_after_reading", document.getElementById("burn").checked);
+		form.append("file", new Blob([iv, ciphertext], {type: contentType}), "encrypted");
+		localStorage.setItem("token", tokenInput.value);
+		return fetch("upload", {method: "POST", headers: {"Authorization": tokenInput.value}, body: form});
+	}).then(function (response) {
+		if (!response.ok) {
+			return response.text().then(function (text) {
+				throw new Error(text);
+			});
+		}
+		return Promise.all([response.json(), crypto.subtle.exportKey("raw", key)]);
+	}).then(function (values) {
+		var link = document.getElementById("link");
+		link.href = new URL("` + encryptedViewerPath + `" + values[0].call_reference + "#" + encodeKey(values[1]),
+			location.href).href;
+		link.textContent = link.href;
+		document.getElementById("result").hidden = false;
+	}).catch(function (error) {
+		show("error", "The upload failed: " + error.message);
+	});
+});
+</script>
+</body>
+</html>
+`))
+
+// encryptedViewerTemplate is the HTML page which downloads the ciphertext of an entry and decrypts it in the browser.
+var encryptedViewerTemplate = template.Must(template.New("encryptedViewer").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Encrypted file</title>
+` + encryptedPageStyle + `
+</head>
+<body>
+<main>
+<form id="unlock" hidden>
+<div>The file is protected by a password.</div>
+<input type="password" id="password" placeholder="Password" required>
+<input type="submit" value="Unlock">
+</form>
+<div class="error" id="error" hidden></div>
+<div id="content" hidden></div>
+</main>
+<script>
+` + encryptedScript + `
+var callReference = {{.CallReference}};
+var unlockForm = document.getElementById("unlock");
+function load() {
+	var key = location.hash.substring(1);
+	if (key === "") {
+		show("error", "The link does not contain the key of the file.");
+		return;
+	}
+	fetch(encodeURIComponent(callReference) + "/raw", {credentials: "same-origin"}).then(function (response) {
+		if (response.status === 401) {
+			unlockForm.hidden = false;
+			return;
+		} else if (!response.ok) {
+			throw new Error("the file could not be downloaded (" + response.status + ")");
+		}
+		return Promise.all([
+			response.arrayBuffer(),
+			crypto.subtle.importKey("raw", decodeKey(key), "AES-GCM", false, ["decrypt"])
+		]).then(function (values) {
+			var data = new Uint8Array(values[0]);
+			return crypto.subtle.decrypt({name: "AES-GCM", iv: data.subarray(0, 12)}, values[1], data.subarray(12));
+		}).then(display, function (error) {
+			throw new Error(error.message || "the file could not be decrypted, the key is wrong");
+		});
+	}).catch(function (error) {
+		show("error", "Error: " + error.message);
+	});
+}
+function display(plaintext) {
+	var length = new DataView(plaintext).getUint32(0);
+	var metadata = JSON.parse(new TextDecoder().decode(new Uint8Array(plaintext, 4, length)));
+	var data = new Uint8Array(plaintext, 4 + length);
+	var content = document.getElementById("content");
+	var type = String(metadata.type);
+	var element;
+	if (/^(image|video|audio)\//.test(type)) {
+		element = document.createElement(type.split("/")[0] === "image" ? "img" : type.split("/")[0]);
+		element.controls = true;
+		element.src = URL.createObjectURL(new Blob([data], {type: type}));
+	} else if (/^text\//.test(type)) {
+		element = document.createElement("pre");
+		element.textContent = new TextDecoder().decode(data);
+	}
+	var download = document.createElement("a");
+	download.href = URL.createObjectURL(new Blob([data], {type: "application/octet-stream"}));
+	download.download = String(metadata.name);
+	download.textContent = "Download " + metadata.name;
+	content.appendChild(download);
+	if (element) {
+		content.appendChild(element);
+	}
+	content.hidden = false;
+}
+unlockForm.addEventListener("submit", function (event) {
+	event.preventDefault();
+	var form = new URLSearchParams();
+	form.append("password", document.getElementById("password").value);
+	fetch(encodeURIComponent(callReference), {method: "POST", body: form, credentials: "same-origin"})
+		.then(function (response) {
+			if (!response.ok) {
+				show("error", "The password is wrong.");
+				return;
+			}
+			unlockForm.hidden = true;
+			document.getElementById("error").hidden = true;
+			load();
+		});
+});
+load();
+</script>
+</body>
+</html>
+`))
+
+// encryptedUpload holds the values of the encryptedUploadTemplate.
+type encryptedUpload struct {
+	ContentType string
+}
+
+// encryptedViewer holds the values of the encryptedViewerTemplate.
+type encryptedViewer struct {
+	CallReference string
+}
+
+// handleEncryptedUpload is the endpoint which sends the upload page of client-side encrypted entries. The upload
+// itself is handled by the handleUpload endpoint and is authorized like any other upload.
+func (shareXRouter *ShareXRouter) handleEncryptedUpload(writer http.ResponseWriter, request *http.Request) {
+	sendEncryptedPage(writer, encryptedUploadTemplate, encryptedUpload{
+		ContentType: encryptedContentType,
+	})
+}
+
+// handleEncryptedViewer is the endpoint which sends the viewer page of a client-side encrypted entry. The page
+// requests the ciphertext via the handleEncryptedRaw endpoint and decrypts it with the key of the URL fragment which is
+// never sent to the server.
+func (shareXRouter *ShareXRouter) handleEncryptedViewer(writer http.ResponseWriter, request *http.Request) {
+	callReference, ok := mux.Vars(request)[callReferenceVar]
+	if !ok {
+		http.Error(writer, "400 the client sent a bad request", http.StatusBadRequest)
+		return
+	}
+	entry, err := shareXRouter.Storage.Request(callReference)
+	if err == storage.ErrEntryNotFound {
+		shareXRouter.referenceNotFound(writer, request)
+		return
+	} else if err != nil {
+		shareXRouter.sendInternalError(writer, fmt.Sprintf("requesting entry with call reference %v",
+			strconv.Quote(callReference)), err)
+		return
+	}
+	entry.Reader.Close()
+	if entry.ContentType != encryptedContentType {
+		http.NotFound(writer, request)
+		return
+	}
+	sendEncryptedPage(writer, encryptedViewerTemplate, encryptedViewer{
+		CallReference: callReference,
+	})
+}
+
+// handleEncryptedRaw is the endpoint which sends the ciphertext of a client-side encrypted entry. Passwords and
+// download limits are enforced like for any other entry.
+func (shareXRouter *ShareXRouter) handleEncryptedRaw(writer http.ResponseWriter, request *http.Request) {
+	shareXRouter.serveEntry(writer, request, true, false)
+}
+
+// sendEncryptedPage sends one of the pages which encrypt or decrypt the entries in the browser.
+func sendEncryptedPage(writer http.ResponseWriter, pageTemplate *template.Template, data interface{}) {
+	writer.Header().Set(contentTypeHeader, "text/html; charset=utf-8")
+	writer.Header().Set("Content-Security-Policy", encryptedPageSecurityPolicy)
+	writer.Header().Set("Referrer-Policy", "no-referrer")
+	pageTemplate.Execute(writer, data)
+}
@@ -0,0 +1,102 @@
+package router
+
+import (
+	"encoding/json"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultListLimit = 50
+	maximumListLimit = 500
+)
+
+// handleList is the endpoint which lists the stored entries as JSON. The entries can be filtered via the author,
+// content_type, uploaded_after and uploaded_before (RFC 3339) query parameters and paginated via the cursor and limit
+// query parameters. Users who are not admins can only list their own entries.
+func (shareXRouter *ShareXRouter) handleList(writer http.ResponseWriter, request *http.Request) {
+	user, ok := shareXRouter.checkAuthorization(request, writer)
+	if !ok {
+		return
+	}
+	query := request.URL.Query()
+	filter := storage.ListFilter{
+		Author:      storage.AuthorIdentifier(query.Get("author")),
+		ContentType: query.Get("content_type"),
+	}
+	if !user.IsAdmin() {
+		filter.Author = user.Author()
+	}
+	var err error
+	if filter.UploadedAfter, err = parseTimeParameter(query.Get("uploaded_after")); err != nil {
+		http.Error(writer, "400 invalid uploaded_after parameter", http.StatusBadRequest)
+		return
+	}
+	if filter.UploadedBefore, err = parseTimeParameter(query.Get("uploaded_before")); err != nil {
+		http.Error(writer, "400 invalid uploaded_before parameter", http.StatusBadRequest)
+		return
+	}
+	limit := defaultListLimit
+	if limitParameter := query.Get("limit"); limitParameter != "" {
+		if limit, err = strconv.Atoi(limitParameter); err != nil || limit <= 0 || limit > maximumListLimit {
+			http.Error(writer, "400 invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	entries, nextCursor, err := shareXRouter.Storage.List(filter, query.Get("cursor"), limit)
+	if err == storage.ErrInvalidCursor {
+		http.Error(writer, "400 invalid cursor parameter", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		shareXRouter.sendInternalError(writer, "listing entries", err)
+		return
+	}
+	response := ListResponse{
+		Entries:    make([]ListedEntry, 0, len(entries)),
+		NextCursor: nextCursor,
+	}
+	for _, entry := range entries {
+		response.Entries = append(response.Entries, ListedEntry{
+			CallReference:   entry.CallReference,
+			DeleteReference: entry.DeleteReference,
+			Author:          string(entry.Author),
+			Filename:        entry.Filename,
+			ContentType:     entry.ContentType,
+			UploadDate:      entry.UploadDate,
+		})
+	}
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		shareXRouter.sendInternalError(writer, "creation of the json list message", err)
+		return
+	}
+	writer.Header().Set(contentTypeHeader, "application/json")
+	writer.Write(jsonResponse)
+}
+
+// parseTimeParameter parses the given RFC 3339 time. An empty value results in the zero time.
+func parseTimeParameter(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// ListResponse holds a page of listed entries.
+type ListResponse struct {
+	Entries []ListedEntry `json:"entries"`
+	// NextCursor has to be passed as the cursor parameter to request the next page. It is empty on the last page.
+	NextCursor string `json:"next_cursor"`
+}
+
+// ListedEntry holds the metadata of a single listed entry.
+type ListedEntry struct {
+	CallReference   string    `json:"call_reference"`
+	DeleteReference string    `json:"delete_reference"`
+	Author          string    `json:"author"`
+	Filename        string    `json:"filename"`
+	ContentType     string    `json:"content_type"`
+	UploadDate      time.Time `json:"upload_date"`
+}
@@ -0,0 +1,145 @@
+package router
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"golang.org/x/crypto/bcrypt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	passwordFormName     = "password"
+	unlockCookiePrefix   = "unlock_"
+	unlockCookieLifetime = time.Minute * 10
+	// bcrypt ignores all bytes after the first 72 bytes of a password
+	maximumPasswordLength = 72
+	// maximumUnlockRequestSize is the maximum size of the body of unlock requests which only contains the password
+	maximumUnlockRequestSize = 4 << 10
+)
+
+// passwordPromptTemplate is the HTML page which asks for the password of a protected entry. The form is sent to the
+// current URL which is handled by the handleUnlock endpoint.
+var passwordPromptTemplate = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Password required</title>
+<style>
+body { font-family: sans-serif; background: #f4f4f4; display: flex; justify-content: center; padding-top: 15vh; }
+form { background: #fff; padding: 2em; border-radius: 4px; box-shadow: 0 1px 4px rgba(0, 0, 0, .2); }
+input { display: block; width: 100%; box-sizing: border-box; margin-top: 1em; padding: .5em; }
+.error { color: #c00; }
+</style>
+</head>
+<body>
+<form method="post">
+<div>The file <strong>{{.Filename}}</strong> is protected by a password.</div>
+{{if .WrongPassword}}<div class="error">The password is wrong.</div>{{end}}
+<input type="password" name="password" placeholder="Password" autofocus required>
+<input type="submit" value="Unlock">
+</form>
+</body>
+</html>
+`))
+
+// passwordPrompt holds the values of the passwordPromptTemplate.
+type passwordPrompt struct {
+	Filename      string
+	WrongPassword bool
+}
+
+// handleUnlock is the endpoint which checks the password of a protected entry. If the password is correct, a short
+// lived cookie which unlocks the entry is issued and the client is redirected to the entry.
+func (shareXRouter *ShareXRouter) handleUnlock(writer http.ResponseWriter, request *http.Request) {
+	callReference, ok := mux.Vars(request)[callReferenceVar]
+	if !ok {
+		http.Error(writer, "400 the client sent a bad request", http.StatusBadRequest)
+		return
+	}
+	// requests whose body exceeds the limit are treated like requests with a wrong password
+	request.Body = http.MaxBytesReader(writer, request.Body, maximumUnlockRequestSize)
+	entry, err := shareXRouter.Storage.Request(callReference)
+	if err == storage.ErrEntryNotFound {
+		shareXRouter.referenceNotFound(writer, request)
+		return
+	} else if err != nil {
+		shareXRouter.sendInternalError(writer, fmt.Sprintf("requesting entry with call reference %v",
+			strconv.Quote(callReference)), err)
+		return
+	}
+	entry.Reader.Close()
+	if entry.PasswordHash != "" {
+		if bcrypt.CompareHashAndPassword([]byte(entry.PasswordHash),
+			[]byte(request.PostFormValue(passwordFormName))) != nil {
+			shareXRouter.sendPasswordPrompt(writer, entry, true)
+			return
+		}
+		expiry := time.Now().Add(unlockCookieLifetime)
+		http.SetCookie(writer, &http.Cookie{
+			Name:     unlockCookiePrefix + callReference,
+			Value:    fmt.Sprintf("%d.%s", expiry.Unix(), shareXRouter.unlockSignature(callReference, expiry.Unix())),
+			Expires:  expiry,
+			MaxAge:   int(unlockCookieLifetime / time.Second),
+			Secure:   request.TLS != nil,
+			HttpOnly: true,
+		})
+	}
+	// the relative location keeps working if the router is mounted behind a path prefix
+	writer.Header().Set("Location", callReference)
+	writer.WriteHeader(http.StatusSeeOther)
+}
+
+// sendPasswordPrompt sends the HTML page which asks for the password of the protected entry.
+func (shareXRouter *ShareXRouter) sendPasswordPrompt(writer http.ResponseWriter, entry *storage.Entry,
+	wrongPassword bool) {
+	writer.Header().Set(contentTypeHeader, "text/html; charset=utf-8")
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.WriteHeader(http.StatusUnauthorized)
+	passwordPromptTemplate.Execute(writer, passwordPrompt{
+		Filename:      entry.Filename,
+		WrongPassword: wrongPassword,
+	})
+}
+
+// unlocked returns whether the request contains a valid unlock cookie for the entry with the given call reference.
+func (shareXRouter *ShareXRouter) unlocked(request *http.Request, callReference string) bool {
+	cookie, err := request.Cookie(unlockCookiePrefix + callReference)
+	if err != nil {
+		return false
+	}
+	parts := strings.SplitN(cookie.Value, ".", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	expiry, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || time.Now().Unix() > expiry {
+		return false
+	}
+	return hmac.Equal([]byte(parts[1]), []byte(shareXRouter.unlockSignature(callReference, expiry)))
+}
+
+// unlockSignature returns the hex encoded HMAC of the call reference and the expiry of an unlock cookie.
+func (shareXRouter *ShareXRouter) unlockSignature(callReference string, expiry int64) string {
+	mac := hmac.New(sha256.New, shareXRouter.CookieSecret)
+	fmt.Fprintf(mac, "%s.%d", callReference, expiry)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// hashPassword returns the bcrypt hash of the given upload password.
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
@@ -0,0 +1,63 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestUnlockCookie(t *testing.T) {
+	shareXRouter := &ShareXRouter{}
+	handler, release := newTestRouter(t, shareXRouter, "/")
+	defer release()
+	response := upload(t, handler, "", "secret.txt", "text/plain", []byte("secret data"),
+		map[string]string{passwordFormName: "hunter2"})
+	if !response.PasswordProtected {
+		t.Fatalf("Upload response %+v is not marked as password protected", response)
+	}
+	// the password prompt is sent instead of the data
+	recorder := serve(handler, httptest.NewRequest(http.MethodGet, "/"+response.CallReference, nil))
+	if recorder.Code != http.StatusUnauthorized || recorder.Body.String() == "secret data" {
+		t.Fatalf("Protected entry was answered with status %d", recorder.Code)
+	}
+	recorder = serve(handler, newFormRequest(http.MethodPost, "/"+response.CallReference, "",
+		url.Values{passwordFormName: {"wrong"}}))
+	if recorder.Code != http.StatusUnauthorized || len(recorder.Result().Cookies()) != 0 {
+		t.Fatalf("Wrong password was answered with status %d and cookies %v", recorder.Code,
+			recorder.Result().Cookies())
+	}
+	recorder = serve(handler, newFormRequest(http.MethodPost, "/"+response.CallReference, "",
+		url.Values{passwordFormName: {"hunter2"}}))
+	if recorder.Code != http.StatusSeeOther || recorder.Header().Get("Location") != response.CallReference {
+		t.Fatalf("Correct password was answered with status %d and location %q", recorder.Code,
+			recorder.Header().Get("Location"))
+	}
+	cookies := recorder.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != unlockCookiePrefix+response.CallReference || !cookies[0].HttpOnly {
+		t.Fatalf("Invalid unlock cookies %v", cookies)
+	}
+	request := httptest.NewRequest(http.MethodGet, "/"+response.CallReference, nil)
+	request.AddCookie(cookies[0])
+	recorder = serve(handler, request)
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "secret data" {
+		t.Fatalf("Unlocked entry was answered with status %d and body %q", recorder.Code, recorder.Body.String())
+	}
+	if recorder.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("Unlocked entry was sent with the Cache-Control header %q", recorder.Header().Get("Cache-Control"))
+	}
+	// the cookie only unlocks the entry it has been issued for
+	otherResponse := upload(t, handler, "", "other.txt", "text/plain", []byte("other data"),
+		map[string]string{passwordFormName: "hunter2"})
+	request = httptest.NewRequest(http.MethodGet, "/"+otherResponse.CallReference, nil)
+	request.AddCookie(&http.Cookie{Name: unlockCookiePrefix + otherResponse.CallReference, Value: cookies[0].Value})
+	if recorder = serve(handler, request); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("Entry with the cookie of another entry was answered with status %d", recorder.Code)
+	}
+	// forged cookies are rejected
+	request = httptest.NewRequest(http.MethodGet, "/"+response.CallReference, nil)
+	request.AddCookie(&http.Cookie{Name: cookies[0].Name, Value: "99999999999.forged"})
+	if recorder = serve(handler, request); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("Entry with a forged cookie was answered with status %d", recorder.Code)
+	}
+}
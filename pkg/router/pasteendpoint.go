@@ -0,0 +1,136 @@
+package router
+
+import (
+	"github.com/mmichaelb/gosharexserver/pkg/highlight"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// pasteLanguageParameter is the query parameter which overrides the language of a paste
+	pasteLanguageParameter = "lang"
+	// maximumPasteSize is the maximum size of pastes in bytes which are shown in the paste viewer, larger pastes are
+	// sent as they are
+	maximumPasteSize = 1 << 20
+	// pastePageSecurityPolicy only allows the inline styles of the paste viewer
+	pastePageSecurityPolicy = "default-src 'none'; style-src 'unsafe-inline'; form-action 'none'; base-uri 'none'"
+)
+
+// pasteContentTypes are the content types besides text/* which are shown in the paste viewer.
+var pasteContentTypes = []string{
+	"application/json", "application/javascript", "application/xml", "application/x-sh", "application/x-yaml",
+	"application/toml", "application/sql",
+}
+
+// pasteTemplate is the HTML page which shows text entries with line numbers and syntax highlighting.
+var pasteTemplate = template.Must(template.New("paste").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{.Filename}}</title>
+<style>
+body { font-family: sans-serif; background: #f4f4f4; margin: 0; }
+header { background: #fff; padding: 1em 2em; box-shadow: 0 1px 4px rgba(0, 0, 0, .2); }
+header span { color: #777; margin-left: 1em; }
+table { border-collapse: collapse; margin: 1em 2em; background: #fff; font-family: monospace; font-size: 14px; }
+td { padding: 0 1em; white-space: pre; vertical-align: top; }
+td.line { text-align: right; user-select: none; border-right: 1px solid #ddd; }
+td.line a { color: #999; text-decoration: none; }
+tr:target { background: #fff8c4; }
+.keyword { color: #a626a4; font-weight: bold; }
+.string { color: #50a14f; }
+.comment { color: #a0a1a7; font-style: italic; }
+.number { color: #986801; }
+</style>
+</head>
+<body>
+<header>
+<strong>{{.Filename}}</strong>
+{{if .Language}}<span>{{.Language}}</span>{{end}}
+<span><a href="{{.RawURL}}">Raw</a></span>
+</header>
+<table>
+{{range .Lines}}<tr id="L{{.Number}}"><td class="line"><a href="#L{{.Number}}">{{.Number}}</a></td><td>
+{{- range .Tokens}}{{if .Kind}}<span class="{{.Kind}}">{{.Text}}</span>{{else}}{{.Text}}{{end}}{{end -}}
+</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// paste holds the values of the pasteTemplate.
+type paste struct {
+	Filename string
+	Language string
+	RawURL   string
+	Lines    []pasteLine
+}
+
+// pasteLine is a highlighted line of a paste.
+type pasteLine struct {
+	Number int
+	Tokens highlight.Line
+}
+
+// sendPaste sends the paste viewer of the text entry. The language is taken from the pasteLanguageParameter or is
+// inferred from the filename extension. It returns false without sending anything if the entry is too large or is not
+// valid UTF-8, the reader of the entry is rewound in this case.
+func (shareXRouter *ShareXRouter) sendPaste(writer http.ResponseWriter, request *http.Request,
+	entry *storage.Entry) bool {
+	data, err := ioutil.ReadAll(io.LimitReader(entry.Reader, maximumPasteSize+1))
+	if err != nil {
+		shareXRouter.sendInternalError(writer, "reading paste", err)
+		return true
+	}
+	if len(data) > maximumPasteSize || !utf8.Valid(data) {
+		if _, err = entry.Reader.Seek(0, io.SeekStart); err != nil {
+			shareXRouter.sendInternalError(writer, "rewinding paste", err)
+			return true
+		}
+		return false
+	}
+	language := highlight.ByName(request.URL.Query().Get(pasteLanguageParameter))
+	if language == nil {
+		language = highlight.ByFilename(entry.Filename)
+	}
+	page := paste{
+		Filename: entry.Filename,
+		// the relative location keeps working if the router is mounted behind a path prefix
+		RawURL: strings.TrimPrefix(rawPath, "/") + entry.CallReference,
+	}
+	if language != nil {
+		page.Language = language.Name
+	}
+	for i, line := range highlight.Highlight(string(data), language) {
+		page.Lines = append(page.Lines, pasteLine{Number: i + 1, Tokens: line})
+	}
+	writer.Header().Set(contentTypeHeader, "text/html; charset=utf-8")
+	writer.Header().Set("Content-Security-Policy", pastePageSecurityPolicy)
+	pasteTemplate.Execute(writer, page)
+	return true
+}
+
+// isPaste returns whether entries of the given content type are shown in the paste viewer.
+func isPaste(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mediaType, "text/") {
+		return true
+	}
+	for _, pasteContentType := range pasteContentTypes {
+		if mediaType == pasteContentType {
+			return true
+		}
+	}
+	return false
+}
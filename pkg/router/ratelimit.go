@@ -0,0 +1,131 @@
+package router
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/mmichaelb/gosharexserver/pkg/ratelimit"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// RouteUpload is the name of the rate limited routes which create entries (uploads, upload sessions and short
+	// links).
+	RouteUpload = "upload"
+	// RouteUploadChunk is the name of the rate limited route which receives the chunks of upload sessions. It is
+	// limited separately because a single upload session consists of many chunks.
+	RouteUploadChunk = "upload_chunk"
+	// RouteRequest is the name of the rate limited routes which resolve call references (the entries, their raw data,
+	// thumbnails and embed pages and the unlocking of password protected entries).
+	RouteRequest = "request"
+	// RouteDelete is the name of the rate limited routes which delete entries.
+	RouteDelete = "delete"
+	// defaultBanDuration is used if the BanDuration of the router is not set
+	defaultBanDuration = time.Minute * 15
+)
+
+// RateLimit holds the limits of a rate limited route.
+type RateLimit struct {
+	// IP limits the requests of each client IP address.
+	IP ratelimit.Limit
+	// Token limits the requests of each authorization token. Requests without a token are only limited by the IP
+	// limit.
+	Token ratelimit.Limit
+}
+
+// rateLimited wraps the handler of a route which is limited by the RateLimits of the given route name. Limited requests
+// and the requests of banned clients are rejected with "429 Too Many Requests" and a Retry-After header. Requests are
+// not limited if the RateLimitStore is not set or if it fails.
+func (shareXRouter *ShareXRouter) rateLimited(route string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		now := time.Now()
+		if banned := shareXRouter.banned(clientIP(request), now); banned > 0 {
+			sendTooManyRequests(writer, banned)
+			return
+		}
+		rateLimit, ok := shareXRouter.RateLimits[route]
+		if !ok || shareXRouter.RateLimitStore == nil {
+			handler(writer, request)
+			return
+		}
+		retryAfter, err := shareXRouter.RateLimitStore.Take(route+":ip:"+clientIP(request), rateLimit.IP, now)
+		if token := request.Header.Get("Authorization"); err == nil && retryAfter == 0 && token != "" {
+			// the tokens are hashed so that they are not kept in shared stores
+			tokenHash := sha256.Sum256([]byte(token))
+			retryAfter, err = shareXRouter.RateLimitStore.Take(route+":token:"+hex.EncodeToString(tokenHash[:]),
+				rateLimit.Token, now)
+		}
+		if err != nil {
+			log.Printf("Could not check the rate limit of the route %v, %T: %v\n", strconv.Quote(route), err, err)
+		} else if retryAfter > 0 {
+			sendTooManyRequests(writer, retryAfter)
+			return
+		}
+		handler(writer, request)
+	}
+}
+
+// referenceNotFound sends the "404 Not Found" response of a lookup of an unknown call or delete reference. Clients
+// which receive more of these responses than allowed by the NotFoundLimit are banned for the BanDuration because they
+// are most likely guessing references.
+func (shareXRouter *ShareXRouter) referenceNotFound(writer http.ResponseWriter, request *http.Request) {
+	http.NotFound(writer, request)
+	if shareXRouter.RateLimitStore == nil || !shareXRouter.NotFoundLimit.Enabled() {
+		return
+	}
+	ip, now := clientIP(request), time.Now()
+	retryAfter, err := shareXRouter.RateLimitStore.Take("not_found:ip:"+ip, shareXRouter.NotFoundLimit, now)
+	if err != nil {
+		log.Printf("Could not count the unknown references of %v, %T: %v\n", strconv.Quote(ip), err, err)
+		return
+	} else if retryAfter == 0 {
+		return
+	}
+	banDuration := shareXRouter.BanDuration
+	if banDuration <= 0 {
+		banDuration = defaultBanDuration
+	}
+	shareXRouter.bansMutex.Lock()
+	defer shareXRouter.bansMutex.Unlock()
+	// remove the expired bans so that the bans do not pile up
+	for bannedIP, expiry := range shareXRouter.bans {
+		if !now.Before(expiry) {
+			delete(shareXRouter.bans, bannedIP)
+		}
+	}
+	if _, ok := shareXRouter.bans[ip]; !ok {
+		log.Printf("Banning %v for %v because of too many requests of unknown references.\n", strconv.Quote(ip),
+			banDuration)
+		shareXRouter.bans[ip] = now.Add(banDuration)
+	}
+}
+
+// banned returns the remaining duration of the ban of the given client IP address or zero if it is not banned.
+func (shareXRouter *ShareXRouter) banned(ip string, now time.Time) time.Duration {
+	shareXRouter.bansMutex.Lock()
+	defer shareXRouter.bansMutex.Unlock()
+	if expiry, ok := shareXRouter.bans[ip]; ok && now.Before(expiry) {
+		return expiry.Sub(now)
+	}
+	return 0
+}
+
+// sendTooManyRequests sends the "429 Too Many Requests" response with the Retry-After header.
+func sendTooManyRequests(writer http.ResponseWriter, retryAfter time.Duration) {
+	writer.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(writer, "429 too many requests", http.StatusTooManyRequests)
+}
+
+// clientIP returns the IP address of the client which sent the request. The remote address has already been replaced
+// by the validated address of the reverse proxy header if the application runs behind a reverse proxy.
+func clientIP(request *http.Request) string {
+	if host, _, err := net.SplitHostPort(request.RemoteAddr); err == nil {
+		return host
+	}
+	return strings.TrimSpace(request.RemoteAddr)
+}
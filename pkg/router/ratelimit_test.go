@@ -0,0 +1,82 @@
+package router
+
+import (
+	"github.com/mmichaelb/gosharexserver/pkg/ratelimit"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimits(t *testing.T) {
+	shareXRouter := &ShareXRouter{
+		RateLimitStore: ratelimit.NewMemoryStore(),
+		RateLimits: map[string]RateLimit{
+			RouteUpload: {
+				IP:    ratelimit.Limit{Burst: 2, Interval: time.Minute},
+				Token: ratelimit.Limit{Burst: 3, Interval: time.Minute},
+			},
+		},
+	}
+	handler, release := newTestRouter(t, shareXRouter, "/")
+	defer release()
+	uploadFrom := func(remoteAddr, token string) *httptest.ResponseRecorder {
+		request := newUploadRequest(token, "a.txt", "text/plain", []byte("a"), nil)
+		request.RemoteAddr = remoteAddr
+		return serve(handler, request)
+	}
+	for i := 0; i < 2; i++ {
+		if recorder := uploadFrom("192.0.2.1:1234", "token"); recorder.Code != http.StatusOK {
+			t.Fatalf("Upload %d within the burst was answered with status %d", i, recorder.Code)
+		}
+	}
+	recorder := uploadFrom("192.0.2.1:4321", "token")
+	if recorder.Code != http.StatusTooManyRequests || recorder.Header().Get("Retry-After") != "30" {
+		t.Fatalf("Upload exceeding the IP limit was answered with status %d and Retry-After %q", recorder.Code,
+			recorder.Header().Get("Retry-After"))
+	}
+	// the token is limited independently of the IP address
+	if recorder = uploadFrom("192.0.2.2:1234", "token"); recorder.Code != http.StatusOK {
+		t.Fatalf("Upload from another IP address was answered with status %d", recorder.Code)
+	}
+	if recorder = uploadFrom("192.0.2.3:1234", "token"); recorder.Code != http.StatusTooManyRequests {
+		t.Fatalf("Upload exceeding the token limit was answered with status %d", recorder.Code)
+	}
+	if recorder = uploadFrom("192.0.2.3:1234", "another token"); recorder.Code != http.StatusOK {
+		t.Fatalf("Upload with another token was answered with status %d", recorder.Code)
+	}
+	// routes without limits are not limited
+	recorder = serve(handler, httptest.NewRequest(http.MethodGet, "/unknown", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("Request of an unlimited route was answered with status %d", recorder.Code)
+	}
+}
+
+func TestNotFoundBan(t *testing.T) {
+	shareXRouter := &ShareXRouter{
+		RateLimitStore: ratelimit.NewMemoryStore(),
+		NotFoundLimit:  ratelimit.Limit{Burst: 2, Interval: time.Minute},
+		BanDuration:    time.Minute * 2,
+	}
+	handler, release := newTestRouter(t, shareXRouter, "/")
+	defer release()
+	response := upload(t, handler, "", "a.txt", "text/plain", []byte("a"), nil)
+	// the lookup which exceeds the limit is still answered but bans the client
+	for _, target := range []string{"/unknown1", rawPath + "unknown2", "/delete/unknown3"} {
+		recorder := serve(handler, httptest.NewRequest(http.MethodGet, target, nil))
+		if recorder.Code != http.StatusNotFound {
+			t.Fatalf("Unknown reference %q was answered with status %d", target, recorder.Code)
+		}
+	}
+	recorder := serve(handler, httptest.NewRequest(http.MethodGet, "/"+response.CallReference, nil))
+	if recorder.Code != http.StatusTooManyRequests || recorder.Header().Get("Retry-After") != "120" {
+		t.Fatalf("Request of a banned client was answered with status %d and Retry-After %q", recorder.Code,
+			recorder.Header().Get("Retry-After"))
+	}
+	// other clients are not affected by the ban
+	request := httptest.NewRequest(http.MethodGet, "/"+response.CallReference, nil)
+	request.RemoteAddr = "192.0.2.2:1234"
+	if recorder = serve(handler, request); recorder.Code != http.StatusOK {
+		t.Fatalf("Request of another client was answered with status %d", recorder.Code)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
@@ -13,11 +14,23 @@ const (
 	callReferenceVar       = "callreference"
 	dispositionHeader      = "Content-Disposition"
 	dispositionValueFormat = "%v; filename=\"%v\""
+	eTagHeader             = "ETag"
 )
 
 // handleRequest is the endpoint which handles incoming file requests via link. It uses the var with the key stored in
-// callReferenceVar to resolve the database entry.
+// callReferenceVar to resolve the database entry. Link preview crawlers receive the embed page instead.
 func (shareXRouter *ShareXRouter) handleRequest(writer http.ResponseWriter, request *http.Request) {
+	if shareXRouter.crawler(request) {
+		shareXRouter.handleEmbed(writer, request)
+		return
+	}
+	shareXRouter.serveEntry(writer, request, false, true)
+}
+
+// serveEntry sends the data of the requested entry. Client-side encrypted entries are redirected to their viewer page
+// unless the raw ciphertext is requested which in turn is only served for client-side encrypted entries. Text entries
+// are shown in the paste viewer if a preview is requested and redirect entries redirect to their target.
+func (shareXRouter *ShareXRouter) serveEntry(writer http.ResponseWriter, request *http.Request, raw, preview bool) {
 	callReference, ok := mux.Vars(request)[callReferenceVar]
 	if !ok {
 		http.Error(writer, "400 the client sent a bad request", http.StatusBadRequest)
@@ -26,15 +39,77 @@ func (shareXRouter *ShareXRouter) handleRequest(writer http.ResponseWriter, requ
 	// resolve the remote entry and check if it could be found
 	entry, err := shareXRouter.Storage.Request(callReference)
 	if err == storage.ErrEntryNotFound {
-		http.NotFound(writer, request)
+		shareXRouter.referenceNotFound(writer, request)
 		return
 	} else if err != nil {
 		shareXRouter.sendInternalError(writer, fmt.Sprintf("requesting entry with call reference %v",
 			strconv.Quote(callReference)), err)
 		return
 	}
-	// make sure that the reader gets closed after sending the data
-	defer entry.Reader.Close()
+	if encrypted := entry.ContentType == encryptedContentType; encrypted != raw {
+		entry.Reader.Close()
+		if encrypted {
+			// the location contains the path prefix the router is mounted at because the entry might have been
+			// requested via the raw path, browsers keep the URL fragment which contains the key
+			writer.Header().Set("Location", shareXRouter.mountPrefix+"/"+encryptedViewerPath+callReference)
+			writer.WriteHeader(http.StatusFound)
+		} else {
+			http.NotFound(writer, request)
+		}
+		return
+	}
+	// password protected entries require an unlock cookie which is issued by the handleUnlock endpoint
+	if entry.PasswordHash != "" {
+		if !shareXRouter.unlocked(request, callReference) {
+			entry.Reader.Close()
+			if raw {
+				// the ciphertext is fetched by the viewer page which asks for the password itself
+				http.Error(writer, "401 the entry is protected by a password", http.StatusUnauthorized)
+			} else {
+				shareXRouter.sendPasswordPrompt(writer, entry, false)
+			}
+			return
+		}
+		writer.Header().Set("Cache-Control", "no-store")
+	}
+	var lastDownload bool
+	if entry.MaximumDownloads > 0 {
+		// count the download, HEAD requests do not send any data and are therefore not counted
+		if request.Method != http.MethodHead {
+			if lastDownload, err = shareXRouter.Storage.ConsumeDownload(callReference); err == storage.ErrEntryNotFound {
+				// the last download has been consumed by a concurrent request
+				entry.Reader.Close()
+				http.NotFound(writer, request)
+				return
+			} else if err != nil {
+				entry.Reader.Close()
+				shareXRouter.sendInternalError(writer, fmt.Sprintf("consuming download of entry with call reference %v",
+					strconv.Quote(callReference)), err)
+				return
+			}
+		}
+		// entries with limited downloads must not be cached by browsers or proxies
+		writer.Header().Set("Cache-Control", "no-store")
+	}
+	// make sure that the reader gets closed after sending the data and that exhausted entries are deleted afterwards
+	defer func() {
+		entry.Reader.Close()
+		if !lastDownload {
+			return
+		}
+		if err := shareXRouter.Storage.Delete(entry.DeleteReference); err != nil && err != storage.ErrEntryNotFound {
+			log.Printf("There was an error while deleting the exhausted entry %v, %T: %v\n",
+				strconv.Quote(callReference), err, err)
+		}
+	}()
+	if entry.ContentType == redirectContentType {
+		shareXRouter.sendRedirect(writer, entry)
+		return
+	}
+	if preview && shareXRouter.PasteViewer && isPaste(entry.ContentType) &&
+		shareXRouter.sendPaste(writer, request, entry) {
+		return
+	}
 	// send disposition header
 	var dispositionType string
 	for _, entryMimeType := range shareXRouter.WhitelistedContentTypes {
@@ -42,12 +117,18 @@ func (shareXRouter *ShareXRouter) handleRequest(writer http.ResponseWriter, requ
 			dispositionType = "inline"
 		}
 	}
-	if len(dispositionType) == 0 {
+	if len(dispositionType) == 0 || raw {
 		dispositionType = "attachment"
 	}
 	writer.Header().Set(dispositionHeader, fmt.Sprintf(dispositionValueFormat, dispositionType, entry.Filename))
 	// set content type header
 	writer.Header().Set(contentTypeHeader, entry.ContentType)
+	// the checksum of the stored data is a strong validator which is evaluated by http.ServeContent. Entries with
+	// limited downloads do not get one because a matching If-None-Match header would consume a download without
+	// sending any data.
+	if entry.SHA256 != "" && entry.MaximumDownloads == 0 {
+		writer.Header().Set(eTagHeader, strconv.Quote(entry.SHA256))
+	}
 	// write file data from the opened reader to the remote client
 	http.ServeContent(writer, request, "", entry.UploadDate, entry.Reader)
 }
@@ -0,0 +1,79 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEncryptedRedirect(t *testing.T) {
+	shareXRouter := &ShareXRouter{}
+	handler, release := newTestRouter(t, shareXRouter, "/files")
+	defer release()
+	request := newUploadRequest("", "secret.png", encryptedContentType, []byte("ciphertext"), nil)
+	request.URL.Path = "/files/upload"
+	recorder := serve(handler, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Upload failed with status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	response := decodeResponse(t, recorder.Body)
+	// the viewer is reached via the entry and the raw path alike
+	for _, target := range []string{"/files/", "/files" + rawPath} {
+		recorder = serve(handler, httptest.NewRequest(http.MethodGet, target+response.CallReference, nil))
+		if location := recorder.Header().Get("Location"); recorder.Code != http.StatusFound ||
+			location != "/files/"+encryptedViewerPath+response.CallReference {
+			t.Fatalf("Encrypted entry requested via %q was answered with status %d and location %q", target,
+				recorder.Code, location)
+		}
+	}
+	recorder = serve(handler, httptest.NewRequest(http.MethodGet, "/files/e/"+response.CallReference, nil))
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), response.CallReference) {
+		t.Fatalf("Viewer was answered with status %d", recorder.Code)
+	}
+	recorder = serve(handler, httptest.NewRequest(http.MethodGet, "/files/e/"+response.CallReference+"/raw", nil))
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "ciphertext" {
+		t.Fatalf("Ciphertext was answered with status %d and body %q", recorder.Code, recorder.Body.String())
+	}
+	// entries which are not encrypted have neither a viewer nor a ciphertext
+	request = newUploadRequest("", "plain.txt", "text/plain", []byte("plain"), nil)
+	request.URL.Path = "/files/upload"
+	plainResponse := decodeResponse(t, serve(handler, request).Body)
+	for _, target := range []string{"/files/e/" + plainResponse.CallReference,
+		"/files/e/" + plainResponse.CallReference + "/raw"} {
+		recorder = serve(handler, httptest.NewRequest(http.MethodGet, target, nil))
+		if recorder.Code != http.StatusNotFound {
+			t.Fatalf("Plain entry requested via %q was answered with status %d", target, recorder.Code)
+		}
+	}
+}
+
+func TestPasteViewer(t *testing.T) {
+	shareXRouter := &ShareXRouter{PasteViewer: true}
+	handler, release := newTestRouter(t, shareXRouter, "/")
+	defer release()
+	data := "package main\n\nfunc main() {}\n"
+	response := upload(t, handler, "", "main.go", "text/plain; charset=utf-8", []byte(data), nil)
+	recorder := serve(handler, httptest.NewRequest(http.MethodGet, "/"+response.CallReference, nil))
+	if recorder.Code != http.StatusOK || !strings.HasPrefix(recorder.Header().Get(contentTypeHeader), "text/html") {
+		t.Fatalf("Paste was answered with status %d and content type %q", recorder.Code,
+			recorder.Header().Get(contentTypeHeader))
+	}
+	if recorder.Header().Get("Content-Security-Policy") != pastePageSecurityPolicy ||
+		!strings.Contains(recorder.Body.String(), `href="raw/`+response.CallReference+`"`) {
+		t.Fatalf("Invalid paste viewer page %q", recorder.Body.String())
+	}
+	// the raw path sends the text itself
+	recorder = serve(handler, httptest.NewRequest(http.MethodGet, rawPath+response.CallReference, nil))
+	if recorder.Code != http.StatusOK || recorder.Body.String() != data ||
+		recorder.Header().Get(contentTypeHeader) != "text/plain; charset=utf-8" {
+		t.Fatalf("Raw paste was answered with status %d, content type %q and body %q", recorder.Code,
+			recorder.Header().Get(contentTypeHeader), recorder.Body.String())
+	}
+	// text which is not valid UTF-8 is sent as it is
+	response = upload(t, handler, "", "binary.txt", "text/plain", []byte{0xff, 0xfe}, nil)
+	recorder = serve(handler, httptest.NewRequest(http.MethodGet, "/"+response.CallReference, nil))
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "\xff\xfe" {
+		t.Fatalf("Invalid paste was answered with status %d and body %q", recorder.Code, recorder.Body.String())
+	}
+}
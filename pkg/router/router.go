@@ -1,14 +1,28 @@
 package router
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
 	"fmt"
 	"github.com/gorilla/mux"
+	"github.com/mmichaelb/gosharexserver/pkg/ratelimit"
 	"github.com/mmichaelb/gosharexserver/pkg/storage"
 	"log"
 	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
 )
 
-const contentTypeHeader = "Content-Type"
+const (
+	contentTypeHeader = "Content-Type"
+	// size of the generated cookie secret in bytes
+	cookieSecretSize = 32
+)
+
+// maximumThumbnailGenerations is the maximum amount of uploads whose thumbnails are generated at the same time.
+var maximumThumbnailGenerations = runtime.NumCPU()
 
 // ShareXRouter represents the main router which serves/accepts files.
 type ShareXRouter struct {
@@ -16,27 +30,191 @@ type ShareXRouter struct {
 	Storage storage.FileStorage
 	// WhitelistedContentTypes is a slice of content types which will be displayed embed in the browser.
 	WhitelistedContentTypes []string
-	// AuthorizationToken is the token used to authorize upload/delete requests.
+	// AuthorizationToken is the global token used to authorize upload/delete requests. Requests which use it are
+	// handled as requests of an admin. If it is empty and no user token is sent, requests are not authorized at all.
 	AuthorizationToken string
+	// Users is an optional UserStorage which resolves per-user tokens to the users. Once it contains a user, requests
+	// without a valid token are rejected even if the AuthorizationToken is empty.
+	Users storage.UserStorage
+	// DeletePolicy determines who is allowed to delete entries. It defaults to the DeletePolicyLink.
+	DeletePolicy DeletePolicy
+	// DefaultExpiry is the time to live of uploads which do not specify one. Zero means that they do not expire.
+	DefaultExpiry time.Duration
+	// MaximumExpiry is the maximum time to live of uploads. Zero means that there is no maximum.
+	MaximumExpiry time.Duration
+	// CookieSecret is the key which signs the cookies which unlock password protected entries. A random key is
+	// generated if it is empty which means that the cookies are invalidated when restarting the application.
+	CookieSecret []byte
+	// ThumbnailWidths are the widths of the thumbnails which are generated for uploaded images. The first width is sent
+	// if no width is requested. No thumbnails are generated if it is empty or if the Storage does not implement the
+	// DerivedStorage interface.
+	ThumbnailWidths []int
+	// PublicURL is the external URL of the server (e.g. "https://example.com") which is used to build absolute links
+	// to entries and the generated ShareX custom uploader files. The path prefix the mux.Router passed to WrapHandler is
+	// mounted at is appended to it. The URL is derived from the requests if it is empty.
+	PublicURL string
+	// EmbedSiteName is the site name which is shown in the link previews of entries.
+	EmbedSiteName string
+	// EmbedColor is the color (e.g. "#33bbff") which is used by some chat clients to highlight link previews.
+	EmbedColor string
+	// EmbedUserAgents are the (case-insensitive) User-Agent header fragments of the link preview crawlers which receive
+	// the embed page instead of the data of an entry. The embed page is still reachable via "/{callReference}/embed".
+	EmbedUserAgents []string
+	// PasteViewer enables the paste viewer which shows text entries with line numbers and syntax highlighting. The
+	// text itself is still sent via "/raw/{callReference}".
+	PasteViewer bool
+	// UploadSessionTimeout is the time after which upload sessions which do not receive any chunks are aborted. It
+	// defaults to one hour.
+	UploadSessionTimeout time.Duration
+	// MaximumUploadSize is the maximum size of an upload in bytes. Zero means that the size is not limited.
+	MaximumUploadSize int64
+	// ContentTypeLimits maps media types (e.g. "video/mp4") or top-level types (e.g. "video/*") to the maximum size of
+	// uploads with a matching content type in bytes.
+	ContentTypeLimits map[string]int64
+	// Quota is the maximum amount of bytes each author can store. It is only enforced if the Storage implements the
+	// UsageStorage interface. Zero means that the stored bytes are not limited.
+	Quota int64
+	// RateLimitStore stores the token buckets of the RateLimits. The requests are not rate limited if it is nil.
+	RateLimitStore ratelimit.Store
+	// RateLimits maps the names of the rate limited routes (RouteUpload, RouteUploadChunk, RouteRequest and RouteDelete)
+	// to their limits.
+	RateLimits map[string]RateLimit
+	// NotFoundLimit limits the amount of lookups of unknown call or delete references of each client IP address.
+	// Clients which exceed it are banned for the BanDuration. It requires the RateLimitStore.
+	NotFoundLimit ratelimit.Limit
+	// BanDuration is the time clients are banned for after exceeding the NotFoundLimit. It defaults to 15 minutes. The
+	// bans are only kept in memory.
+	BanDuration time.Duration
+	// internal values
+	thumbnailSlots      chan struct{}
+	mountPrefix         string
+	uploadSessions      map[string]*uploadSession
+	uploadSessionsMutex sync.Mutex
+	stopSessionReaper   func()
+	bans                map[string]time.Time
+	bansMutex           sync.Mutex
 }
 
+var (
+	// defaultAuthor is the user of requests which were authorized via the global authorization token.
+	defaultAuthor = &storage.User{
+		Name: defaultUser,
+		Role: storage.RoleAdmin,
+	}
+	// anonymousAuthor is the user of requests which did not need to be authorized at all because neither the global
+	// authorization token nor the user storage is configured.
+	anonymousAuthor = &storage.User{
+		Name: defaultUser,
+		Role: storage.RoleUser,
+	}
+)
+
 // WrapHandler wraps the endpoints to the given mux.Router. At the moment this is bound to the usage of gorilla/mux in
 // your dependency but in the future this should be generalized. //TODO
 func (shareXRouter *ShareXRouter) WrapHandler(router *mux.Router) {
+	if len(shareXRouter.CookieSecret) == 0 {
+		shareXRouter.CookieSecret = make([]byte, cookieSecretSize)
+		if _, err := rand.Read(shareXRouter.CookieSecret); err != nil {
+			panic(fmt.Sprintf("could not generate cookie secret: %v", err))
+		}
+	}
+	shareXRouter.thumbnailSlots = make(chan struct{}, maximumThumbnailGenerations)
+	shareXRouter.uploadSessions = make(map[string]*uploadSession)
+	shareXRouter.startUploadSessionReaper()
+	shareXRouter.bans = make(map[string]time.Time)
 	// register endpoints
-	router.Path("/upload").Methods(http.MethodPost).HandlerFunc(shareXRouter.handleUpload)
-	router.Path(fmt.Sprintf("/delete/{%v}", deleteReferenceVar)).HandlerFunc(shareXRouter.handleDelete)
-	router.Path(fmt.Sprintf("/{%v}", callReferenceVar)).HandlerFunc(shareXRouter.handleRequest)
+	uploadRoute := router.Path("/upload").Methods(http.MethodPost).
+		HandlerFunc(shareXRouter.rateLimited(RouteUpload, shareXRouter.handleUpload))
+	router.Path("/upload/sessions").Methods(http.MethodPost).
+		HandlerFunc(shareXRouter.rateLimited(RouteUpload, shareXRouter.handleUploadSessionCreation))
+	uploadSessionPath := fmt.Sprintf("/upload/sessions/{%v}", uploadSessionVar)
+	router.Path(uploadSessionPath).Methods(http.MethodPatch).
+		HandlerFunc(shareXRouter.rateLimited(RouteUploadChunk, shareXRouter.handleUploadSessionChunk))
+	router.Path(uploadSessionPath).Methods(http.MethodHead, http.MethodGet).
+		HandlerFunc(shareXRouter.handleUploadSessionState)
+	router.Path(uploadSessionPath).Methods(http.MethodDelete).HandlerFunc(shareXRouter.handleUploadSessionAbort)
+	router.Path("/shorten").Methods(http.MethodPost).
+		HandlerFunc(shareXRouter.rateLimited(RouteUpload, shareXRouter.handleShorten))
+	router.Path("/config.sxcu").Methods(http.MethodGet).HandlerFunc(shareXRouter.handleCustomUploaderConfig)
+	// the path template of the upload route contains the path prefix the router is mounted at
+	if pathTemplate, err := uploadRoute.GetPathTemplate(); err == nil {
+		shareXRouter.mountPrefix = strings.TrimSuffix(pathTemplate, "/upload")
+	}
+	router.Path("/api/entries").Methods(http.MethodGet).HandlerFunc(shareXRouter.handleList)
+	router.Path("/api/usage").Methods(http.MethodGet).HandlerFunc(shareXRouter.handleUsage)
+	router.Path(fmt.Sprintf("/api/entries/{%v}", callReferenceVar)).Methods(http.MethodDelete).
+		HandlerFunc(shareXRouter.rateLimited(RouteDelete, shareXRouter.handleEntryDelete))
+	router.Path(fmt.Sprintf("/delete/{%v}", deleteReferenceVar)).
+		HandlerFunc(shareXRouter.rateLimited(RouteDelete, shareXRouter.handleDelete))
+	router.Path("/e").Methods(http.MethodGet).HandlerFunc(shareXRouter.handleEncryptedUpload)
+	router.Path(fmt.Sprintf("/e/{%v}", callReferenceVar)).Methods(http.MethodPost).
+		HandlerFunc(shareXRouter.rateLimited(RouteRequest, shareXRouter.handleUnlock))
+	router.Path(fmt.Sprintf("/e/{%v}", callReferenceVar)).
+		HandlerFunc(shareXRouter.rateLimited(RouteRequest, shareXRouter.handleEncryptedViewer))
+	router.Path(fmt.Sprintf("/e/{%v}/raw", callReferenceVar)).
+		HandlerFunc(shareXRouter.rateLimited(RouteRequest, shareXRouter.handleEncryptedRaw))
+	router.Path(fmt.Sprintf("%v{%v}", rawPath, callReferenceVar)).Methods(http.MethodPost).
+		HandlerFunc(shareXRouter.rateLimited(RouteRequest, shareXRouter.handleUnlock))
+	router.Path(fmt.Sprintf("%v{%v}", rawPath, callReferenceVar)).
+		HandlerFunc(shareXRouter.rateLimited(RouteRequest, shareXRouter.handleRawRequest))
+	router.Path(fmt.Sprintf("/{%v}/thumb", callReferenceVar)).
+		HandlerFunc(shareXRouter.rateLimited(RouteRequest, shareXRouter.handleThumbnail))
+	router.Path(fmt.Sprintf("/{%v}/embed", callReferenceVar)).
+		HandlerFunc(shareXRouter.rateLimited(RouteRequest, shareXRouter.handleEmbed))
+	router.Path(fmt.Sprintf("/{%v}", callReferenceVar)).Methods(http.MethodPost).
+		HandlerFunc(shareXRouter.rateLimited(RouteRequest, shareXRouter.handleUnlock))
+	router.Path(fmt.Sprintf("/{%v}", callReferenceVar)).
+		HandlerFunc(shareXRouter.rateLimited(RouteRequest, shareXRouter.handleRequest))
 }
 
-// checkAuthorization returns whether the request is authorized or not.
-func (shareXRouter *ShareXRouter) checkAuthorization(request *http.Request, writer http.ResponseWriter) bool {
+// checkAuthorization returns the user who sent the request and whether the request is authorized or not. Requests are
+// only accepted without a valid token if the global authorization token is empty and no user has been created.
+func (shareXRouter *ShareXRouter) checkAuthorization(request *http.Request, writer http.ResponseWriter) (
+	*storage.User, bool) {
+	token := request.Header.Get("Authorization")
+	if shareXRouter.Users != nil && token != "" {
+		user, err := storage.Authenticate(shareXRouter.Users, token)
+		if err == nil {
+			return user, true
+		} else if err != storage.ErrTokenNotFound {
+			shareXRouter.sendInternalError(writer, "authenticating user token", err)
+			return nil, false
+		}
+	}
 	if shareXRouter.AuthorizationToken != "" {
-		if request.Header.Get("Authorization") != shareXRouter.AuthorizationToken {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(shareXRouter.AuthorizationToken)) != 1 {
+			http.Error(writer, "Unauthorized", http.StatusUnauthorized)
+			return nil, false
+		}
+		return defaultAuthor, true
+	}
+	if shareXRouter.Users != nil {
+		// the users are checked for every request because they are managed by the separate user command
+		hasUsers, err := shareXRouter.Users.HasUsers()
+		if err != nil {
+			shareXRouter.sendInternalError(writer, "checking for users", err)
+			return nil, false
+		} else if hasUsers {
+			// the token is either missing, unknown or revoked
 			http.Error(writer, "Unauthorized", http.StatusUnauthorized)
-			return false
+			return nil, false
 		}
 	}
+	return anonymousAuthor, true
+}
+
+// parseLimitedForm limits the body of the request to the given size and parses its url encoded or multipart form. It
+// sends an error response and returns false if the form could not be parsed.
+func parseLimitedForm(writer http.ResponseWriter, request *http.Request, maximumSize int64) bool {
+	request.Body = http.MaxBytesReader(writer, request.Body, maximumSize)
+	// the errors of url encoded forms are not returned by ParseMultipartForm, so they are parsed separately
+	if err := request.ParseForm(); err != nil {
+		http.Error(writer, "400 the client sent a bad request", http.StatusBadRequest)
+		return false
+	} else if err = request.ParseMultipartForm(maximumSize); err != nil && err != http.ErrNotMultipart {
+		http.Error(writer, "400 the client sent a bad request", http.StatusBadRequest)
+		return false
+	}
 	return true
 }
 
@@ -48,5 +226,6 @@ func (shareXRouter *ShareXRouter) sendInternalError(writer http.ResponseWriter,
 
 // Close stops and closes the ShareX router. It returns an error if something goes wrong.
 func (shareXRouter *ShareXRouter) Close() error {
+	shareXRouter.AbortUploadSessions()
 	return shareXRouter.Storage.Close()
 }
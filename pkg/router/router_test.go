@@ -0,0 +1,229 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"github.com/mmichaelb/gosharexserver/pkg/storage/storages"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestRouter initializes a filesystem storage in a temporary directory, assigns it to the given router and mounts
+// the router at the given path prefix. The returned function aborts the upload sessions and removes the storage.
+func newTestRouter(t *testing.T, shareXRouter *ShareXRouter, prefix string) (http.Handler, func()) {
+	directory, err := ioutil.TempDir("", "gosharexserver")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory, %T: %v", err, err)
+	}
+	filesystemStorage := &storages.FilesystemStorage{Directory: directory}
+	if err = filesystemStorage.Initialize(); err != nil {
+		os.RemoveAll(directory)
+		t.Fatalf("Could not initialize filesystem storage, %T: %v", err, err)
+	}
+	shareXRouter.Storage = filesystemStorage
+	muxRouter := mux.NewRouter()
+	shareXRouter.WrapHandler(muxRouter.PathPrefix(prefix).Subrouter())
+	return muxRouter, func() {
+		shareXRouter.AbortUploadSessions()
+		filesystemStorage.Close()
+		os.RemoveAll(directory)
+	}
+}
+
+// newUserToken creates a user with the given role in the user storage of the router and returns the secret of a new
+// token of the user.
+func newUserToken(t *testing.T, shareXRouter *ShareXRouter, name string, role storage.Role) string {
+	userStorage := shareXRouter.Storage.(storage.UserStorage)
+	if err := userStorage.CreateUser(&storage.User{Name: name, Role: role}); err != nil {
+		t.Fatalf("Could not create user, %T: %v", err, err)
+	}
+	token, secret, err := storage.NewToken(name, "test")
+	if err != nil {
+		t.Fatalf("Could not create token, %T: %v", err, err)
+	}
+	if err = userStorage.StoreToken(token); err != nil {
+		t.Fatalf("Could not store token, %T: %v", err, err)
+	}
+	return secret
+}
+
+// newUploadRequest returns a multipart upload request which sends the given form fields in front of the file.
+func newUploadRequest(token, filename, contentType string, data []byte, fields map[string]string) *http.Request {
+	body := &bytes.Buffer{}
+	multipartWriter := multipart.NewWriter(body)
+	for name, value := range fields {
+		multipartWriter.WriteField(name, value)
+	}
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", `form-data; name="`+multipartFormName+`"; filename="`+filename+`"`)
+	header.Set(contentTypeHeader, contentType)
+	part, _ := multipartWriter.CreatePart(header)
+	part.Write(data)
+	multipartWriter.Close()
+	request := httptest.NewRequest(http.MethodPost, "/upload", body)
+	request.Header.Set(contentTypeHeader, multipartWriter.FormDataContentType())
+	if token != "" {
+		request.Header.Set("Authorization", token)
+	}
+	return request
+}
+
+// newFormRequest returns a request with the given url encoded form.
+func newFormRequest(method, target, token string, form url.Values) *http.Request {
+	request := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+	request.Header.Set(contentTypeHeader, "application/x-www-form-urlencoded")
+	if token != "" {
+		request.Header.Set("Authorization", token)
+	}
+	return request
+}
+
+// serve sends the request to the handler and returns the recorded response.
+func serve(handler http.Handler, request *http.Request) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// upload uploads the given data and returns the upload response. The test fails if the upload is not successful.
+func upload(t *testing.T, handler http.Handler, token, filename, contentType string, data []byte,
+	fields map[string]string) *Response {
+	recorder := serve(handler, newUploadRequest(token, filename, contentType, data, fields))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Upload of %q failed with status %d: %s", filename, recorder.Code, recorder.Body.String())
+	}
+	return decodeResponse(t, recorder.Body)
+}
+
+// decodeResponse decodes the JSON upload response.
+func decodeResponse(t *testing.T, body io.Reader) *Response {
+	response := &Response{}
+	if err := json.NewDecoder(body).Decode(response); err != nil {
+		t.Fatalf("Could not decode upload response, %T: %v", err, err)
+	}
+	return response
+}
+
+func TestAuthorization(t *testing.T) {
+	shareXRouter := &ShareXRouter{AuthorizationToken: "global"}
+	handler, release := newTestRouter(t, shareXRouter, "/")
+	defer release()
+	shareXRouter.Users = shareXRouter.Storage.(storage.UserStorage)
+	userToken := newUserToken(t, shareXRouter, "alice", storage.RoleUser)
+	for _, token := range []string{"", "wrong", userToken + "x"} {
+		recorder := serve(handler, newUploadRequest(token, "a.txt", "text/plain", []byte("a"), nil))
+		if recorder.Code != http.StatusUnauthorized {
+			t.Fatalf("Upload with token %q was answered with status %d", token, recorder.Code)
+		}
+	}
+	for token, author := range map[string]storage.AuthorIdentifier{"global": defaultUser, userToken: "alice"} {
+		response := upload(t, handler, token, "a.txt", "text/plain", []byte("a"), nil)
+		entry, err := shareXRouter.Storage.Request(response.CallReference)
+		if err != nil {
+			t.Fatalf("Could not request uploaded entry, %T: %v", err, err)
+		}
+		entry.Reader.Close()
+		if entry.Author != author {
+			t.Fatalf("Expected the author %q, got %q", author, entry.Author)
+		}
+	}
+	// only user tokens are accepted if there is no global token but users exist
+	shareXRouter.AuthorizationToken = ""
+	for _, token := range []string{"", "global"} {
+		recorder := serve(handler, newUploadRequest(token, "a.txt", "text/plain", []byte("a"), nil))
+		if recorder.Code != http.StatusUnauthorized {
+			t.Fatalf("Upload with token %q was answered with status %d", token, recorder.Code)
+		}
+	}
+	upload(t, handler, userToken, "a.txt", "text/plain", []byte("a"), nil)
+	// requests are not authorized at all without a global token and without users
+	if err := shareXRouter.Users.DeleteUser("alice"); err != nil {
+		t.Fatalf("Could not delete user, %T: %v", err, err)
+	}
+	upload(t, handler, "", "a.txt", "text/plain", []byte("a"), nil)
+	shareXRouter.Users = nil
+	upload(t, handler, "", "a.txt", "text/plain", []byte("a"), nil)
+}
+
+func TestDeletePolicy(t *testing.T) {
+	shareXRouter := &ShareXRouter{AuthorizationToken: "global"}
+	handler, release := newTestRouter(t, shareXRouter, "/")
+	defer release()
+	shareXRouter.Users = shareXRouter.Storage.(storage.UserStorage)
+	aliceToken := newUserToken(t, shareXRouter, "alice", storage.RoleUser)
+	bobToken := newUserToken(t, shareXRouter, "bob", storage.RoleUser)
+	request := func(target string) int {
+		return serve(handler, httptest.NewRequest(http.MethodGet, target, nil)).Code
+	}
+	deleteEntry := func(token, callReference string) int {
+		deleteRequest := httptest.NewRequest(http.MethodDelete, "/api/entries/"+callReference, nil)
+		deleteRequest.Header.Set("Authorization", token)
+		return serve(handler, deleteRequest).Code
+	}
+	// everyone who knows the delete link can delete the entry
+	response := upload(t, handler, aliceToken, "a.txt", "text/plain", []byte("a"), nil)
+	if code := request("/delete/" + response.DeleteReference); code != http.StatusOK {
+		t.Fatalf("Delete link was answered with status %d", code)
+	}
+	if code := request("/" + response.CallReference); code != http.StatusNotFound {
+		t.Fatalf("Deleted entry was answered with status %d", code)
+	}
+	// only the owner and admins can delete entries
+	shareXRouter.DeletePolicy = DeletePolicyOwner
+	response = upload(t, handler, aliceToken, "a.txt", "text/plain", []byte("a"), nil)
+	if code := request("/delete/" + response.DeleteReference); code != http.StatusForbidden {
+		t.Fatalf("Disabled delete link was answered with status %d", code)
+	}
+	if code := deleteEntry(bobToken, response.CallReference); code != http.StatusForbidden {
+		t.Fatalf("Deletion by another user was answered with status %d", code)
+	}
+	if code := deleteEntry(aliceToken, response.CallReference); code != http.StatusNoContent {
+		t.Fatalf("Deletion by the owner was answered with status %d", code)
+	}
+	response = upload(t, handler, aliceToken, "a.txt", "text/plain", []byte("a"), nil)
+	if code := deleteEntry("global", response.CallReference); code != http.StatusNoContent {
+		t.Fatalf("Deletion by an admin was answered with status %d", code)
+	}
+	// expired entries can still be deleted by their owners
+	entry := &storage.Entry{Author: "alice", Filename: "a.txt", ContentType: "text/plain", UploadDate: time.Now(),
+		ExpirationDate: time.Now().Add(-time.Minute)}
+	writer, err := shareXRouter.Storage.Store(entry)
+	if err != nil {
+		t.Fatalf("Could not store entry, %T: %v", err, err)
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatalf("Could not close writer, %T: %v", err, err)
+	}
+	if code := request("/" + entry.CallReference); code != http.StatusNotFound {
+		t.Fatalf("Expired entry was answered with status %d", code)
+	}
+	if code := deleteEntry(bobToken, entry.CallReference); code != http.StatusForbidden {
+		t.Fatalf("Deletion of an expired entry by another user was answered with status %d", code)
+	}
+	if code := deleteEntry(aliceToken, entry.CallReference); code != http.StatusNoContent {
+		t.Fatalf("Deletion of an expired entry by the owner was answered with status %d", code)
+	}
+	if code := deleteEntry(aliceToken, entry.CallReference); code != http.StatusNotFound {
+		t.Fatalf("Deletion of a deleted entry was answered with status %d", code)
+	}
+	// only admins can delete entries
+	shareXRouter.DeletePolicy = DeletePolicyAdmin
+	response = upload(t, handler, aliceToken, "a.txt", "text/plain", []byte("a"), nil)
+	if code := deleteEntry(aliceToken, response.CallReference); code != http.StatusForbidden {
+		t.Fatalf("Deletion by the owner was answered with status %d", code)
+	}
+	if code := deleteEntry("global", response.CallReference); code != http.StatusNoContent {
+		t.Fatalf("Deletion by an admin was answered with status %d", code)
+	}
+}
@@ -0,0 +1,111 @@
+package router
+
+import (
+	"fmt"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// redirectContentType marks entries which redirect to the URL which is stored as their data
+	redirectContentType = "application/x-gosharexserver-redirect"
+	// shortenFormName is the name of the form field which contains the URL which is shortened
+	shortenFormName = "url"
+	// maximumRedirectLength is the maximum length of the URLs which can be shortened
+	maximumRedirectLength = 2048
+	// maximumShortenRequestSize is the maximum size of the body of shorten requests which contains the URL and the
+	// upload options
+	maximumShortenRequestSize = 8 << 10
+)
+
+// handleShorten is the endpoint which shortens URLs. The URL is stored as the data of a new redirect entry which gets
+// its own call and delete reference like any other upload, so expiry, download limits, passwords and the delete
+// endpoint work for short links as well.
+func (shareXRouter *ShareXRouter) handleShorten(writer http.ResponseWriter, request *http.Request) {
+	user, ok := shareXRouter.checkAuthorization(request, writer)
+	if !ok {
+		return
+	}
+	if !parseLimitedForm(writer, request, maximumShortenRequestSize) {
+		return
+	}
+	target, err := parseRedirectTarget(request.FormValue(shortenFormName))
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("400 %v", err), http.StatusBadRequest)
+		return
+	}
+	entry := &storage.Entry{
+		Author: user.Author(),
+		// the host identifies the short link in the entry list without exceeding the maximum filename length
+		Filename:    target.Host,
+		ContentType: redirectContentType,
+		UploadDate:  time.Now(),
+	}
+	if !shareXRouter.applyUploadOptions(writer, request, entry, request.FormValue) {
+		return
+	}
+	fileWriter, err := shareXRouter.Storage.Store(entry)
+	if err != nil {
+		shareXRouter.sendInternalError(writer, "storing new redirect entry", err)
+		return
+	}
+	_, checksums, err := writeFile(strings.NewReader(target.String()), fileWriter)
+	if err != nil {
+		if abortErr := storage.Abort(shareXRouter.Storage, entry, fileWriter); abortErr != nil &&
+			abortErr != storage.ErrEntryNotFound {
+			log.Printf("Could not abort the incomplete redirect entry %v, %T: %v\n", entry.ID, abortErr, abortErr)
+		}
+		shareXRouter.sendInternalError(writer, "writing target to new redirect entry", err)
+		return
+	}
+	entry.MD5 = checksums.MD5
+	if err = fileWriter.Close(); err != nil {
+		shareXRouter.sendInternalError(writer, "closing the file writer of the new redirect entry", err)
+		return
+	}
+	log.Printf("Created redirect entry %v (host %v)\n", entry.ID, target.Host)
+	shareXRouter.sendUploadResponse(writer, entry, checksums)
+}
+
+// sendRedirect redirects the client to the target of the redirect entry.
+func (shareXRouter *ShareXRouter) sendRedirect(writer http.ResponseWriter, entry *storage.Entry) {
+	data, err := ioutil.ReadAll(io.LimitReader(entry.Reader, maximumRedirectLength+1))
+	if err != nil {
+		shareXRouter.sendInternalError(writer, "reading redirect target", err)
+		return
+	}
+	// the target has been validated when shortening the URL but the data could have been replaced in the storage
+	target, err := parseRedirectTarget(string(data))
+	if err != nil {
+		shareXRouter.sendInternalError(writer, "parsing redirect target", err)
+		return
+	}
+	writer.Header().Set("Location", target.String())
+	writer.Header().Set("Referrer-Policy", "no-referrer")
+	writer.WriteHeader(http.StatusFound)
+}
+
+// parseRedirectTarget parses the URL which is shortened. Only absolute HTTP and HTTPS URLs are accepted.
+func parseRedirectTarget(value string) (*url.URL, error) {
+	if value == "" {
+		return nil, fmt.Errorf("the %v form field is missing", shortenFormName)
+	}
+	if len(value) > maximumRedirectLength {
+		return nil, fmt.Errorf("the URL must not be longer than %d bytes", maximumRedirectLength)
+	}
+	target, err := url.Parse(strings.TrimSpace(value))
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, fmt.Errorf("the URL must be an absolute HTTP or HTTPS URL")
+	}
+	if len(target.Host) > maximumMetadataLength {
+		// the host is stored as the filename of the entry
+		return nil, fmt.Errorf("the host of the URL must not be longer than %d bytes", maximumMetadataLength)
+	}
+	return target, nil
+}
@@ -0,0 +1,53 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestShorten(t *testing.T) {
+	shareXRouter := &ShareXRouter{AuthorizationToken: "global"}
+	handler, release := newTestRouter(t, shareXRouter, "/")
+	defer release()
+	target := "https://example.com/some/path?query=1"
+	recorder := serve(handler, newFormRequest(http.MethodPost, "/shorten", "", url.Values{shortenFormName: {target}}))
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("Unauthorized shorten request was answered with status %d", recorder.Code)
+	}
+	for _, invalidTarget := range []string{"", "ftp://example.com/", "/relative", "https://"} {
+		recorder = serve(handler, newFormRequest(http.MethodPost, "/shorten", "global",
+			url.Values{shortenFormName: {invalidTarget}}))
+		if recorder.Code != http.StatusBadRequest {
+			t.Fatalf("Shortening %q was answered with status %d", invalidTarget, recorder.Code)
+		}
+	}
+	recorder = serve(handler, newFormRequest(http.MethodPost, "/shorten", "global",
+		url.Values{shortenFormName: {target}}))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Shorten request failed with status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	response := decodeResponse(t, recorder.Body)
+	recorder = serve(handler, httptest.NewRequest(http.MethodGet, "/"+response.CallReference, nil))
+	if recorder.Code != http.StatusFound || recorder.Header().Get("Location") != target {
+		t.Fatalf("Short link was answered with status %d and location %q", recorder.Code,
+			recorder.Header().Get("Location"))
+	}
+	if recorder.Header().Get("Referrer-Policy") != "no-referrer" {
+		t.Fatalf("Short link was sent with the Referrer-Policy %q", recorder.Header().Get("Referrer-Policy"))
+	}
+	// uploads can not pose as short links
+	for _, contentType := range []string{redirectContentType, "Application/X-GoShareXServer-Redirect; charset=utf-8"} {
+		recorder = serve(handler, newUploadRequest("global", "redirect", contentType, []byte(target), nil))
+		if recorder.Code != http.StatusBadRequest {
+			t.Fatalf("Upload with the content type %q was answered with status %d", contentType, recorder.Code)
+		}
+		request := newFormRequest(http.MethodPost, "/upload/sessions", "global",
+			url.Values{uploadFilenameFormName: {"redirect"}, uploadContentTypeFormName: {contentType}})
+		request.Header.Set(uploadLengthHeader, "10")
+		if code := serve(handler, request).Code; code != http.StatusBadRequest {
+			t.Fatalf("Upload session with the content type %q was answered with status %d", contentType, code)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package router
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"github.com/mmichaelb/gosharexserver/pkg/thumbnail"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+const (
+	// thumbnailNamePrefix is the prefix of the names of the derived data which contains the thumbnails
+	thumbnailNamePrefix = "thumb-"
+	// thumbnailWidthParameter is the query parameter which contains the requested width of a thumbnail
+	thumbnailWidthParameter = "w"
+)
+
+// thumbnailsEnabled returns whether thumbnails are generated for the given entry. Thumbnails of entries with limited
+// downloads are never generated because they could be viewed without consuming a download.
+func (shareXRouter *ShareXRouter) thumbnailsEnabled(entry *storage.Entry) bool {
+	if _, ok := shareXRouter.Storage.(storage.DerivedStorage); !ok || len(shareXRouter.ThumbnailWidths) == 0 {
+		return false
+	}
+	return thumbnail.Supported(entry.ContentType) && entry.MaximumDownloads == 0
+}
+
+// generateThumbnails generates the thumbnails of the entry with the given call reference and stores them as derived
+// data of the entry. It is run in the background after the upload has been completed and the amount of thumbnails
+// which are generated at the same time is limited by the thumbnailSlots. The caller has to acquire a slot which is
+// released once the thumbnails have been stored.
+func (shareXRouter *ShareXRouter) generateThumbnails(callReference string) {
+	defer func() {
+		<-shareXRouter.thumbnailSlots
+	}()
+	entry, err := shareXRouter.Storage.Request(callReference)
+	if err == storage.ErrEntryNotFound {
+		// the entry has been deleted in the meantime
+		return
+	} else if err != nil {
+		log.Printf("Could not request entry %v to generate its thumbnails, %T: %v\n", strconv.Quote(callReference),
+			err, err)
+		return
+	}
+	thumbnails, err := thumbnail.Generate(entry.Reader, shareXRouter.ThumbnailWidths)
+	entry.Reader.Close()
+	if err != nil {
+		log.Printf("Could not generate thumbnails of entry %v, %T: %v\n", strconv.Quote(callReference), err, err)
+		return
+	}
+	derivedStorage := shareXRouter.Storage.(storage.DerivedStorage)
+	for width, data := range thumbnails {
+		if err = derivedStorage.StoreDerived(callReference, thumbnailName(width), data); err == storage.ErrEntryNotFound {
+			return
+		} else if err != nil {
+			log.Printf("Could not store thumbnail of entry %v, %T: %v\n", strconv.Quote(callReference), err, err)
+			return
+		}
+	}
+}
+
+// handleThumbnail is the endpoint which sends the thumbnail of an image entry. The thumbnail with the smallest width
+// which is not smaller than the requested width is sent. The first configured width is used if no width is requested.
+// Passwords are enforced like for the entry itself.
+func (shareXRouter *ShareXRouter) handleThumbnail(writer http.ResponseWriter, request *http.Request) {
+	callReference, ok := mux.Vars(request)[callReferenceVar]
+	if !ok {
+		http.Error(writer, "400 the client sent a bad request", http.StatusBadRequest)
+		return
+	}
+	width, err := shareXRouter.thumbnailWidth(request.URL.Query().Get(thumbnailWidthParameter))
+	if err != nil {
+		http.Error(writer, "400 the width must be a positive number", http.StatusBadRequest)
+		return
+	}
+	entry, err := shareXRouter.Storage.Request(callReference)
+	if err == storage.ErrEntryNotFound {
+		shareXRouter.referenceNotFound(writer, request)
+		return
+	} else if err != nil {
+		shareXRouter.sendInternalError(writer, fmt.Sprintf("requesting entry with call reference %v",
+			strconv.Quote(callReference)), err)
+		return
+	}
+	entry.Reader.Close()
+	if !shareXRouter.thumbnailsEnabled(entry) {
+		http.NotFound(writer, request)
+		return
+	}
+	if entry.PasswordHash != "" {
+		if !shareXRouter.unlocked(request, callReference) {
+			http.Error(writer, "401 the entry is protected by a password", http.StatusUnauthorized)
+			return
+		}
+		writer.Header().Set("Cache-Control", "no-store")
+	}
+	data, err := shareXRouter.Storage.(storage.DerivedStorage).RequestDerived(callReference, thumbnailName(width))
+	if err == storage.ErrEntryNotFound {
+		// the thumbnails have not been generated (yet)
+		http.NotFound(writer, request)
+		return
+	} else if err != nil {
+		shareXRouter.sendInternalError(writer, fmt.Sprintf("requesting thumbnail of entry with call reference %v",
+			strconv.Quote(callReference)), err)
+		return
+	}
+	writer.Header().Set(contentTypeHeader, http.DetectContentType(data))
+	http.ServeContent(writer, request, "", entry.UploadDate, bytes.NewReader(data))
+}
+
+// thumbnailWidth returns the configured thumbnail width which is sent for the requested width.
+func (shareXRouter *ShareXRouter) thumbnailWidth(requestedWidth string) (int, error) {
+	if len(shareXRouter.ThumbnailWidths) == 0 {
+		return 0, nil
+	}
+	if requestedWidth == "" {
+		return shareXRouter.ThumbnailWidths[0], nil
+	}
+	width, err := strconv.Atoi(requestedWidth)
+	if err != nil || width <= 0 {
+		return 0, strconv.ErrSyntax
+	}
+	widths := append([]int{}, shareXRouter.ThumbnailWidths...)
+	sort.Ints(widths)
+	for _, configuredWidth := range widths {
+		if configuredWidth >= width {
+			return configuredWidth, nil
+		}
+	}
+	return widths[len(widths)-1], nil
+}
+
+// thumbnailName returns the name of the derived data which contains the thumbnail with the given width.
+func thumbnailName(width int) string {
+	return thumbnailNamePrefix + strconv.Itoa(width)
+}
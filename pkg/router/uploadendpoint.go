@@ -1,49 +1,114 @@
 package router
 
 import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/mmichaelb/gosharexserver/pkg/storage"
 	"io"
+	"io/ioutil"
 	"log"
+	"mime"
 	"mime/multipart"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 )
 
 const (
-	maximumMemoryBytes = 1 << 20 // 1 MB maximum in memory
-	receiveBufferSize  = 1 << 20 // 1 MB maximum in memory here too
-	defaultUser        = "default user"
-	multipartFormName  = "file"
+	receiveBufferSize = 1 << 20 // 1 MB maximum in memory per upload
+	defaultUser       = "default user"
+	multipartFormName = "file"
+	expiresInHeader   = "X-Expires-In"
+	expiresInFormName = "expires_in"
+	// limits of the form fields which are sent alongside the file
+	maximumFormValueSize = 4 << 10
+	maximumFormValues    = 32
+	// maximumMetadataLength is the maximum length of the filename and the content type of an entry in bytes which fits
+	// into the columns of the SQL storage
+	maximumMetadataLength = 255
+	// download limit header and form field names
+	maximumDownloadsHeader   = "X-Max-Downloads"
+	maximumDownloadsFormName = "max_downloads"
+	burnAfterReadingHeader   = "X-Burn-After-Reading"
+	burnAfterReadingFormName = "burn_after_reading"
 )
 
-// handleUpload is the endpoint which handles new file upload requests.
+var (
+	// errInvalidExpiry is returned by the parseExpiry function if the time to live is not a positive duration.
+	errInvalidExpiry = errors.New("invalid expiry")
+	// errMissingFile is returned by the readUploadForm function if the request does not contain a file.
+	errMissingFile = fmt.Errorf("the %v form field is missing", multipartFormName)
+	// errFormFieldAfterFile is returned by the checkRemainingParts function if an upload contains form fields after the
+	// file which can not be applied anymore.
+	errFormFieldAfterFile = errors.New("the form fields have to be sent before the file")
+)
+
+// receiveBuffers pools the buffers which receive the uploaded data so that the buffers are shared by the uploads
+// instead of being allocated for each of them.
+var receiveBuffers = sync.Pool{
+	New: func() interface{} {
+		buffer := make([]byte, receiveBufferSize)
+		return &buffer
+	},
+}
+
+// handleUpload is the endpoint which handles new file upload requests. The multipart form is streamed, the file is
+// written to the storage while it is received without buffering it in memory or on the disk. Therefore the form fields
+// which contain the options of the upload have to be sent before the file.
 func (shareXRouter *ShareXRouter) handleUpload(writer http.ResponseWriter, request *http.Request) {
-	if !shareXRouter.checkAuthorization(request, writer) {
+	user, ok := shareXRouter.checkAuthorization(request, writer)
+	if !ok {
 		return
 	}
-	var err error
-	// parse multipart form file and if something goes wrong return an internal server error response code
-	if err = request.ParseMultipartForm(maximumMemoryBytes); err != nil {
-		shareXRouter.sendInternalError(writer, "parsing multiform of file upload", err)
+	if !shareXRouter.limitRequestBody(writer, request) {
+		return
+	}
+	multipartReader, err := request.MultipartReader()
+	if err != nil {
+		http.Error(writer, "400 the request is not a multipart upload", http.StatusBadRequest)
+		return
+	}
+	// read the form fields in front of the file and the header of the file
+	formValues, file, err := readUploadForm(multipartReader)
+	if limitErr, ok := err.(*limitExceededError); ok {
+		sendLimitExceeded(writer, limitErr)
+		return
+	} else if err != nil {
+		http.Error(writer, fmt.Sprintf("400 %v", err), http.StatusBadRequest)
 		return
 	}
-	var file multipart.File
-	// parse filename and mime type from multipart header
-	var multipartFileHeader *multipart.FileHeader
-	if file, multipartFileHeader, err = request.FormFile(multipartFormName); err != nil {
-		shareXRouter.sendInternalError(writer, "resolving file details of file upload", err)
+	if !checkUploadMetadata(writer, file.FileName(), file.Header.Get(contentTypeHeader)) {
 		return
 	}
 	// instantiate new entry from the given values
-	fileName := multipartFileHeader.Filename
-	mimeType := multipartFileHeader.Header.Get(contentTypeHeader)
 	entry := &storage.Entry{
-		Author:      defaultUser,
-		Filename:    fileName,
-		ContentType: mimeType,
+		Author:      user.Author(),
+		Filename:    file.FileName(),
+		ContentType: file.Header.Get(contentTypeHeader),
 		UploadDate:  time.Now(),
 	}
+	formValue := func(name string) string {
+		if value, ok := formValues[name]; ok {
+			return value
+		}
+		return request.URL.Query().Get(name)
+	}
+	if !shareXRouter.applyUploadOptions(writer, request, entry, formValue) {
+		return
+	}
+	limitErr, err := shareXRouter.uploadLimit(entry.Author, entry.ContentType)
+	if err != nil {
+		shareXRouter.sendInternalError(writer, "calculating the upload limit", err)
+		return
+	} else if limitErr != nil && limitErr.remaining == 0 {
+		sendLimitExceeded(writer, limitErr)
+		return
+	}
 	var fileWriter io.WriteCloser
 	// store entry
 	if fileWriter, err = shareXRouter.Storage.Store(entry); err != nil {
@@ -51,52 +116,265 @@ func (shareXRouter *ShareXRouter) handleUpload(writer http.ResponseWriter, reque
 		return
 	}
 	// write file data to the returned writer
-	defer func() {
-		if err := fileWriter.Close(); err != nil {
-			log.Printf("There was an error while closing the file writer, %T: %+v", err, err)
-		}
-	}()
-	total, err := writeFile(file, fileWriter)
+	total, checksums, err := writeFile(newLimitedReader(file, limitErr), fileWriter)
+	if err == nil {
+		err = checkRemainingParts(multipartReader)
+	}
 	if err != nil {
-		shareXRouter.sendInternalError(writer, "writing file data to new entry", err)
+		// the incomplete entry is removed without becoming available
+		if abortErr := storage.Abort(shareXRouter.Storage, entry, fileWriter); abortErr != nil &&
+			abortErr != storage.ErrEntryNotFound {
+			log.Printf("Could not abort the incomplete entry %v, %T: %v\n", entry.ID, abortErr, abortErr)
+		}
+		if limitErr, ok := err.(*limitExceededError); ok {
+			sendLimitExceeded(writer, limitErr)
+		} else if err == errFormFieldAfterFile {
+			http.Error(writer, fmt.Sprintf("400 %v", err), http.StatusBadRequest)
+		} else {
+			shareXRouter.sendInternalError(writer, "writing file data to new entry", err)
+		}
+		return
+	}
+	// the entry is only complete (and possibly deduplicated) once the writer has been closed
+	entry.MD5 = checksums.MD5
+	if err = fileWriter.Close(); err != nil {
+		shareXRouter.sendInternalError(writer, "closing the file writer of the new entry", err)
 		return
 	}
-	log.Printf("Created entry %v (%v bytes)\n", entry.ID, total)
-	// send json response
-	response := Response{entry.CallReference, entry.DeleteReference}
+	shareXRouter.completeUpload(writer, entry, total, checksums)
+}
+
+// completeUpload sends the response of the upload of the stored entry and generates its thumbnails.
+func (shareXRouter *ShareXRouter) completeUpload(writer http.ResponseWriter, entry *storage.Entry, total int64,
+	checksums *fileChecksums) {
+	log.Printf("Created entry %v (%v bytes, sha256 %v)\n", entry.ID, total, checksums.SHA256)
+	if !shareXRouter.sendUploadResponse(writer, entry, checksums) {
+		return
+	}
+	// the thumbnails are generated after responding so that the upload is not slowed down, they are skipped if all
+	// slots are busy so that the goroutines do not pile up
+	if shareXRouter.thumbnailsEnabled(entry) {
+		select {
+		case shareXRouter.thumbnailSlots <- struct{}{}:
+			go shareXRouter.generateThumbnails(entry.CallReference)
+		default:
+			log.Printf("Skipping the thumbnails of entry %v because all thumbnail generations are busy.\n",
+				strconv.Quote(entry.CallReference))
+		}
+	}
+}
+
+// applyUploadOptions applies the expiry, the download limit and the password of the upload request to the new entry.
+// The form values are resolved via the given function. It sends an error response and returns false if one of the
+// options is invalid.
+func (shareXRouter *ShareXRouter) applyUploadOptions(writer http.ResponseWriter, request *http.Request,
+	entry *storage.Entry, formValue func(string) string) bool {
+	// the header takes precedence over the form field
+	expiresIn := request.Header.Get(expiresInHeader)
+	if expiresIn == "" {
+		expiresIn = formValue(expiresInFormName)
+	}
+	expiry, err := shareXRouter.parseExpiry(expiresIn)
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("400 %v", err), http.StatusBadRequest)
+		return false
+	}
+	if expiry > 0 {
+		entry.ExpirationDate = entry.UploadDate.Add(expiry)
+	}
+	if entry.MaximumDownloads, err = parseMaximumDownloads(request, formValue); err != nil {
+		http.Error(writer, fmt.Sprintf("400 %v", err), http.StatusBadRequest)
+		return false
+	}
+	entry.RemainingDownloads = entry.MaximumDownloads
+	if password := formValue(passwordFormName); password != "" {
+		if len(password) > maximumPasswordLength {
+			http.Error(writer, fmt.Sprintf("400 the password must not be longer than %d bytes", maximumPasswordLength),
+				http.StatusBadRequest)
+			return false
+		}
+		if entry.PasswordHash, err = hashPassword(password); err != nil {
+			shareXRouter.sendInternalError(writer, "hashing the password of the upload", err)
+			return false
+		}
+	}
+	return true
+}
+
+// checkUploadMetadata sends an error response and returns false if the filename or the content type of an upload are
+// too long or the content type is reserved for the entries which are created by the server itself. Otherwise uploads
+// could pose as short links.
+func checkUploadMetadata(writer http.ResponseWriter, filename, contentType string) bool {
+	if len(filename) > maximumMetadataLength || len(contentType) > maximumMetadataLength {
+		http.Error(writer, fmt.Sprintf("400 the filename and the content type must not be longer than %d bytes",
+			maximumMetadataLength), http.StatusBadRequest)
+		return false
+	}
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType != redirectContentType {
+		return true
+	}
+	http.Error(writer, fmt.Sprintf("400 the content type %v is reserved", redirectContentType), http.StatusBadRequest)
+	return false
+}
+
+// sendUploadResponse sends the JSON response of a successful upload. It returns false if the response could not be
+// created.
+func (shareXRouter *ShareXRouter) sendUploadResponse(writer http.ResponseWriter, entry *storage.Entry,
+	checksums *fileChecksums) bool {
+	response := Response{
+		CallReference:   entry.CallReference,
+		DeleteReference: entry.DeleteReference,
+		SHA256:          checksums.SHA256,
+		MD5:             checksums.MD5,
+	}
+	if !entry.ExpirationDate.IsZero() {
+		response.ExpirationDate = &entry.ExpirationDate
+	}
+	response.MaximumDownloads = entry.MaximumDownloads
+	response.PasswordProtected = entry.PasswordHash != ""
 	jsonResponse, err := json.Marshal(response)
 	if err != nil {
 		shareXRouter.sendInternalError(writer, "creation of the json response message", err)
-		return
+		return false
 	}
 	// set content type header to application/json
 	writer.Header().Set("Content-Type", "application/json")
 	// write the above created json message to the client
 	writer.Write([]byte(jsonResponse))
+	return true
 }
 
-// writeFile writes the received uploaded data to the provided writer by the stored entry
-func writeFile(file multipart.File, fileWriter io.WriteCloser) (int64, error) {
-	// count total byte amount
-	var total int64
-	// do not stop iterating until no more bytes are available
+// parseExpiry parses the time to live of an upload which is either a duration (e.g. "24h") or an amount of seconds. An
+// empty value results in the default expiry of the router. A zero result means that the upload does not expire.
+func (shareXRouter *ShareXRouter) parseExpiry(value string) (time.Duration, error) {
+	if value == "" {
+		if shareXRouter.MaximumExpiry > 0 && (shareXRouter.DefaultExpiry <= 0 ||
+			shareXRouter.DefaultExpiry > shareXRouter.MaximumExpiry) {
+			return shareXRouter.MaximumExpiry, nil
+		}
+		return shareXRouter.DefaultExpiry, nil
+	}
+	var expiry time.Duration
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		expiry = time.Duration(seconds) * time.Second
+		if expiry/time.Second != time.Duration(seconds) {
+			return 0, errInvalidExpiry
+		}
+	} else if expiry, err = time.ParseDuration(value); err != nil {
+		return 0, errInvalidExpiry
+	}
+	if expiry <= 0 {
+		return 0, errInvalidExpiry
+	}
+	if shareXRouter.MaximumExpiry > 0 && expiry > shareXRouter.MaximumExpiry {
+		return 0, fmt.Errorf("expiry exceeds the maximum of %v", shareXRouter.MaximumExpiry)
+	}
+	return expiry, nil
+}
+
+// parseMaximumDownloads parses the download limit of an upload. Burn-after-reading uploads can be downloaded once. A
+// zero result means that the downloads are not limited.
+func parseMaximumDownloads(request *http.Request, formValue func(string) string) (int, error) {
+	burnAfterReading := request.Header.Get(burnAfterReadingHeader)
+	if burnAfterReading == "" {
+		burnAfterReading = formValue(burnAfterReadingFormName)
+	}
+	if burnAfterReading != "" {
+		if enabled, err := strconv.ParseBool(burnAfterReading); err != nil {
+			return 0, errors.New("invalid burn after reading flag")
+		} else if enabled {
+			return 1, nil
+		}
+	}
+	maximumDownloads := request.Header.Get(maximumDownloadsHeader)
+	if maximumDownloads == "" {
+		maximumDownloads = formValue(maximumDownloadsFormName)
+	}
+	if maximumDownloads == "" {
+		return 0, nil
+	}
+	downloads, err := strconv.Atoi(maximumDownloads)
+	if err != nil || downloads <= 0 {
+		return 0, errors.New("invalid maximum downloads")
+	}
+	return downloads, nil
+}
+
+// readUploadForm reads the form fields of the multipart upload until the part of the file is reached. The returned part
+// of the file has not been read yet.
+func readUploadForm(multipartReader *multipart.Reader) (map[string]string, *multipart.Part, error) {
+	formValues := make(map[string]string)
 	for {
-		buffer := make([]byte, receiveBufferSize)
-		bytesRead, err := file.Read(buffer)
-		total += int64(bytesRead)
-		if bytesRead == 0 {
-			break
+		part, err := multipartReader.NextPart()
+		if err == io.EOF {
+			return nil, nil, errMissingFile
 		} else if err != nil {
-			return -1, err
-		} else {
-			fileWriter.Write(buffer[:bytesRead])
+			return nil, nil, err
+		}
+		if part.FormName() == multipartFormName && part.FileName() != "" {
+			return formValues, part, nil
+		}
+		if len(formValues) >= maximumFormValues {
+			return nil, nil, errors.New("too many form fields")
+		}
+		value, err := ioutil.ReadAll(io.LimitReader(part, maximumFormValueSize+1))
+		if err != nil {
+			return nil, nil, err
+		} else if len(value) > maximumFormValueSize {
+			return nil, nil, fmt.Errorf("the form field %v is too long", strconv.Quote(part.FormName()))
 		}
+		// the first value of a form field is used like by http.Request.FormValue
+		if _, ok := formValues[part.FormName()]; !ok {
+			formValues[part.FormName()] = string(value)
+		}
+	}
+}
+
+// checkRemainingParts returns errFormFieldAfterFile if the multipart upload contains more parts after the file.
+func checkRemainingParts(multipartReader *multipart.Reader) error {
+	part, err := multipartReader.NextPart()
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	part.Close()
+	return errFormFieldAfterFile
+}
+
+// writeFile writes the received uploaded data to the provided writer by the stored entry and calculates its checksums.
+// The data is copied via a pooled buffer.
+func writeFile(file io.Reader, fileWriter io.Writer) (int64, *fileChecksums, error) {
+	sha256Hash, md5Hash := sha256.New(), md5.New()
+	buffer := receiveBuffers.Get().(*[]byte)
+	defer receiveBuffers.Put(buffer)
+	total, err := io.CopyBuffer(io.MultiWriter(fileWriter, sha256Hash, md5Hash), file, *buffer)
+	if err != nil {
+		return -1, nil, err
 	}
-	return total, nil
+	return total, &fileChecksums{
+		SHA256: hex.EncodeToString(sha256Hash.Sum(nil)),
+		MD5:    hex.EncodeToString(md5Hash.Sum(nil)),
+	}, nil
+}
+
+// fileChecksums holds the hex encoded checksums of the uploaded data.
+type fileChecksums struct {
+	SHA256 string
+	MD5    string
 }
 
 // Response holds all required data to respond to an upload.
 type Response struct {
 	CallReference   string `json:"call_reference"`
 	DeleteReference string `json:"delete_reference"`
+	// ExpirationDate is only set if the entry expires.
+	ExpirationDate *time.Time `json:"expiration_date,omitempty"`
+	// MaximumDownloads is only set if the downloads of the entry are limited.
+	MaximumDownloads int `json:"maximum_downloads,omitempty"`
+	// PasswordProtected is only set if the entry is protected by a password.
+	PasswordProtected bool `json:"password_protected,omitempty"`
+	// SHA256 and MD5 are the checksums of the received data which allow the client to verify the upload.
+	SHA256 string `json:"sha256"`
+	MD5    string `json:"md5"`
 }
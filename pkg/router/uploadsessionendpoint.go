@@ -0,0 +1,419 @@
+package router
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	uploadSessionVar = "session"
+	// upload session header and form field names
+	uploadLengthHeader        = "Upload-Length"
+	uploadOffsetHeader        = "Upload-Offset"
+	uploadFilenameFormName    = "filename"
+	uploadContentTypeFormName = "content_type"
+	// size of the random upload session ids in bytes
+	uploadSessionIDSize = 16
+	// maximumUploadSessions is the maximum amount of upload sessions which are open at the same time
+	maximumUploadSessions = 1024
+	// maximumUploadSessionsPerAuthor is the maximum amount of upload sessions of a single author which are open at the
+	// same time, every open session holds a writer of the storage (and its buffer)
+	maximumUploadSessionsPerAuthor = 16
+	// defaultUploadSessionTimeout is used if the UploadSessionTimeout of the router is not set
+	defaultUploadSessionTimeout = time.Hour
+	// maximumUploadSessionRequestSize is the maximum size of the body of upload session creation requests which only
+	// contains the filename, the content type and the upload options
+	maximumUploadSessionRequestSize = 8 << 10
+)
+
+// uploadSession is a resumable upload whose data is streamed into the writer of the entry chunk by chunk.
+type uploadSession struct {
+	entry      *storage.Entry
+	fileWriter io.WriteCloser
+	sha256Hash hash.Hash
+	md5Hash    hash.Hash
+	offset     int64
+	length     int64
+	// lastActivity is the time of the last chunk, sessions which are inactive for too long are aborted
+	lastActivity time.Time
+	// busy is set while a chunk is written so that concurrent requests of the same session are rejected
+	busy bool
+}
+
+// uploadSessionResponse is sent when creating an upload session and when requesting its state.
+type uploadSessionResponse struct {
+	ID     string `json:"id"`
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+}
+
+// handleUploadSessionCreation is the endpoint which creates a new resumable upload session. The total size of the
+// upload is sent via the Upload-Length header, the filename and the content type via the form fields. Expiry,
+// download limits and passwords are applied like for normal uploads. The storage entry is created immediately but only
+// becomes available once all chunks have been received.
+func (shareXRouter *ShareXRouter) handleUploadSessionCreation(writer http.ResponseWriter, request *http.Request) {
+	user, ok := shareXRouter.checkAuthorization(request, writer)
+	if !ok {
+		return
+	}
+	length, err := strconv.ParseInt(request.Header.Get(uploadLengthHeader), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(writer, fmt.Sprintf("400 the %v header must contain the size of the upload", uploadLengthHeader),
+			http.StatusBadRequest)
+		return
+	}
+	if !parseLimitedForm(writer, request, maximumUploadSessionRequestSize) {
+		return
+	}
+	filename := request.FormValue(uploadFilenameFormName)
+	if filename == "" {
+		http.Error(writer, fmt.Sprintf("400 the %v form field is missing", uploadFilenameFormName),
+			http.StatusBadRequest)
+		return
+	}
+	contentType := request.FormValue(uploadContentTypeFormName)
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	if !checkUploadMetadata(writer, filename, contentType) {
+		return
+	}
+	entry := &storage.Entry{
+		Author:      user.Author(),
+		Filename:    filename,
+		ContentType: contentType,
+		UploadDate:  time.Now(),
+	}
+	if !shareXRouter.applyUploadOptions(writer, request, entry, request.FormValue) {
+		return
+	}
+	// the announced length is checked against the limits so that no chunks are received in vain
+	limitErr, err := shareXRouter.uploadLimit(entry.Author, entry.ContentType)
+	if err != nil {
+		shareXRouter.sendInternalError(writer, "calculating the upload limit", err)
+		return
+	} else if limitErr != nil && length > limitErr.remaining {
+		sendLimitExceeded(writer, limitErr)
+		return
+	}
+	id, err := newUploadSessionID()
+	if err != nil {
+		shareXRouter.sendInternalError(writer, "generating upload session id", err)
+		return
+	}
+	shareXRouter.abortIdleUploadSessions(shareXRouter.uploadSessionTimeout())
+	shareXRouter.uploadSessionsMutex.Lock()
+	if len(shareXRouter.uploadSessions) >= maximumUploadSessions {
+		shareXRouter.uploadSessionsMutex.Unlock()
+		http.Error(writer, "503 too many upload sessions", http.StatusServiceUnavailable)
+		return
+	}
+	if shareXRouter.countUploadSessions(entry.Author) >= maximumUploadSessionsPerAuthor {
+		shareXRouter.uploadSessionsMutex.Unlock()
+		http.Error(writer, "429 too many open upload sessions", http.StatusTooManyRequests)
+		return
+	}
+	// reserve the session so that the limit is not exceeded by concurrent requests
+	session := &uploadSession{entry: entry, length: length, lastActivity: time.Now(), busy: true}
+	shareXRouter.uploadSessions[id] = session
+	shareXRouter.uploadSessionsMutex.Unlock()
+	if session.fileWriter, err = shareXRouter.Storage.Store(entry); err != nil {
+		shareXRouter.removeUploadSession(id)
+		shareXRouter.sendInternalError(writer, "storing new file entry of upload session", err)
+		return
+	}
+	session.sha256Hash, session.md5Hash = sha256.New(), md5.New()
+	if length == 0 {
+		// there are no chunks which would complete the upload
+		shareXRouter.completeUploadSession(writer, id, session)
+		return
+	}
+	shareXRouter.releaseUploadSession(session)
+	// the relative location keeps working if the router is mounted behind a path prefix
+	writer.Header().Set("Location", "sessions/"+id)
+	writer.Header().Set(uploadOffsetHeader, "0")
+	sendUploadSessionResponse(writer, http.StatusCreated, id, session)
+}
+
+// handleUploadSessionChunk is the endpoint which receives the next chunk of an upload session. The Upload-Offset header
+// has to match the amount of bytes which have been received so far. The data of the entry is sent once the last chunk
+// has been received. If the connection is interrupted, the bytes which have been received are kept and the client can
+// request the current offset to resume the upload.
+func (shareXRouter *ShareXRouter) handleUploadSessionChunk(writer http.ResponseWriter, request *http.Request) {
+	id, session, ok := shareXRouter.acquireUploadSession(writer, request)
+	if !ok {
+		return
+	}
+	offset, err := strconv.ParseInt(request.Header.Get(uploadOffsetHeader), 10, 64)
+	if err != nil || offset != session.offset {
+		shareXRouter.releaseUploadSession(session)
+		writer.Header().Set(uploadOffsetHeader, strconv.FormatInt(session.offset, 10))
+		http.Error(writer, "409 the offset does not match the received bytes", http.StatusConflict)
+		return
+	}
+	remaining := session.length - session.offset
+	buffer := receiveBuffers.Get().(*[]byte)
+	written, err := io.CopyBuffer(io.MultiWriter(&uploadSessionWriter{session.fileWriter}, session.sha256Hash,
+		session.md5Hash), io.LimitReader(request.Body, remaining), *buffer)
+	receiveBuffers.Put(buffer)
+	session.offset += written
+	if _, ok := err.(*storageWriteError); ok {
+		// the state of the writer is unknown after a failed write
+		shareXRouter.abortUploadSession(id, session)
+		shareXRouter.sendInternalError(writer, "writing chunk of upload session", err)
+		return
+	} else if err != nil {
+		shareXRouter.releaseUploadSession(session)
+		// the client can resume the upload from the current offset
+		log.Printf("Chunk of upload session of entry %v has been interrupted after %v bytes, %T: %v\n",
+			session.entry.ID, written, err, err)
+		writer.Header().Set(uploadOffsetHeader, strconv.FormatInt(session.offset, 10))
+		http.Error(writer, "400 the chunk could not be received completely", http.StatusBadRequest)
+		return
+	}
+	if written == remaining {
+		if n, _ := request.Body.Read(make([]byte, 1)); n > 0 {
+			shareXRouter.abortUploadSession(id, session)
+			http.Error(writer, "400 the upload exceeds its length", http.StatusBadRequest)
+			return
+		}
+		shareXRouter.completeUploadSession(writer, id, session)
+		return
+	}
+	shareXRouter.releaseUploadSession(session)
+	writer.Header().Set(uploadOffsetHeader, strconv.FormatInt(session.offset, 10))
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// handleUploadSessionState is the endpoint which sends the offset and the length of an upload session.
+func (shareXRouter *ShareXRouter) handleUploadSessionState(writer http.ResponseWriter, request *http.Request) {
+	id, session, ok := shareXRouter.acquireUploadSession(writer, request)
+	if !ok {
+		return
+	}
+	shareXRouter.releaseUploadSession(session)
+	writer.Header().Set(uploadOffsetHeader, strconv.FormatInt(session.offset, 10))
+	writer.Header().Set(uploadLengthHeader, strconv.FormatInt(session.length, 10))
+	writer.Header().Set("Cache-Control", "no-store")
+	sendUploadSessionResponse(writer, http.StatusOK, id, session)
+}
+
+// handleUploadSessionAbort is the endpoint which aborts an upload session and deletes the received data.
+func (shareXRouter *ShareXRouter) handleUploadSessionAbort(writer http.ResponseWriter, request *http.Request) {
+	id, session, ok := shareXRouter.acquireUploadSession(writer, request)
+	if !ok {
+		return
+	}
+	shareXRouter.abortUploadSession(id, session)
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// acquireUploadSession resolves the upload session of the request and marks it as busy. Only the author of the upload
+// session can use it. It sends an error response and returns false if the session can not be used.
+func (shareXRouter *ShareXRouter) acquireUploadSession(writer http.ResponseWriter, request *http.Request) (string,
+	*uploadSession, bool) {
+	user, ok := shareXRouter.checkAuthorization(request, writer)
+	if !ok {
+		return "", nil, false
+	}
+	id, ok := mux.Vars(request)[uploadSessionVar]
+	if !ok {
+		http.Error(writer, "400 the client sent a bad request", http.StatusBadRequest)
+		return "", nil, false
+	}
+	shareXRouter.uploadSessionsMutex.Lock()
+	defer shareXRouter.uploadSessionsMutex.Unlock()
+	session, ok := shareXRouter.uploadSessions[id]
+	if !ok || session.entry.Author != user.Author() {
+		http.NotFound(writer, request)
+		return "", nil, false
+	}
+	if session.busy {
+		http.Error(writer, "423 the upload session is used by another request", http.StatusLocked)
+		return "", nil, false
+	}
+	session.busy = true
+	return id, session, true
+}
+
+// releaseUploadSession marks the upload session as idle.
+func (shareXRouter *ShareXRouter) releaseUploadSession(session *uploadSession) {
+	shareXRouter.uploadSessionsMutex.Lock()
+	session.busy = false
+	session.lastActivity = time.Now()
+	shareXRouter.uploadSessionsMutex.Unlock()
+}
+
+// removeUploadSession removes the upload session with the given id.
+func (shareXRouter *ShareXRouter) removeUploadSession(id string) {
+	shareXRouter.uploadSessionsMutex.Lock()
+	delete(shareXRouter.uploadSessions, id)
+	shareXRouter.uploadSessionsMutex.Unlock()
+}
+
+// countUploadSessions returns the amount of open upload sessions of the given author. The caller has to hold the
+// uploadSessionsMutex.
+func (shareXRouter *ShareXRouter) countUploadSessions(author storage.AuthorIdentifier) (count int) {
+	for _, session := range shareXRouter.uploadSessions {
+		if session.entry.Author == author {
+			count++
+		}
+	}
+	return
+}
+
+// completeUploadSession closes the writer of the completely received upload session and sends the upload response.
+func (shareXRouter *ShareXRouter) completeUploadSession(writer http.ResponseWriter, id string,
+	session *uploadSession) {
+	shareXRouter.removeUploadSession(id)
+	checksums := &fileChecksums{
+		SHA256: hex.EncodeToString(session.sha256Hash.Sum(nil)),
+		MD5:    hex.EncodeToString(session.md5Hash.Sum(nil)),
+	}
+	session.entry.MD5 = checksums.MD5
+	if err := session.fileWriter.Close(); err != nil {
+		shareXRouter.sendInternalError(writer, "closing the file writer of the upload session", err)
+		return
+	}
+	shareXRouter.completeUpload(writer, session.entry, session.length, checksums)
+}
+
+// abortUploadSession removes the upload session and deletes the data which has been received so far. The caller has to
+// have acquired the session.
+func (shareXRouter *ShareXRouter) abortUploadSession(id string, session *uploadSession) {
+	shareXRouter.removeUploadSession(id)
+	if err := storage.Abort(shareXRouter.Storage, session.entry, session.fileWriter); err != nil &&
+		err != storage.ErrEntryNotFound {
+		log.Printf("Could not abort the entry %v of the aborted upload session, %T: %v\n", session.entry.ID, err,
+			err)
+	}
+}
+
+// abortIdleUploadSessions aborts the upload sessions which have not been used for longer than the given timeout.
+// Sessions which are used by a request are never aborted.
+func (shareXRouter *ShareXRouter) abortIdleUploadSessions(timeout time.Duration) {
+	now := time.Now()
+	expired := make(map[string]*uploadSession)
+	shareXRouter.uploadSessionsMutex.Lock()
+	for id, session := range shareXRouter.uploadSessions {
+		if !session.busy && now.Sub(session.lastActivity) > timeout {
+			session.busy = true
+			expired[id] = session
+		}
+	}
+	shareXRouter.uploadSessionsMutex.Unlock()
+	for id, session := range expired {
+		shareXRouter.abortUploadSession(id, session)
+	}
+}
+
+// startUploadSessionReaper aborts the idle upload sessions in the background. The reaper is stopped by
+// AbortUploadSessions.
+func (shareXRouter *ShareXRouter) startUploadSessionReaper() {
+	ticker := time.NewTicker(shareXRouter.uploadSessionTimeout() / 4)
+	stopped := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stopped:
+				return
+			case <-ticker.C:
+				shareXRouter.abortIdleUploadSessions(shareXRouter.uploadSessionTimeout())
+			}
+		}
+	}()
+	var once sync.Once
+	shareXRouter.stopSessionReaper = func() {
+		once.Do(func() {
+			ticker.Stop()
+			close(stopped)
+			<-done
+		})
+	}
+}
+
+// AbortUploadSessions stops the reaper of the idle upload sessions, aborts all idle upload sessions and deletes their
+// data. It should be called before closing the storage because the upload sessions are only kept in memory and can not
+// be resumed after a restart.
+func (shareXRouter *ShareXRouter) AbortUploadSessions() {
+	if shareXRouter.stopSessionReaper != nil {
+		shareXRouter.stopSessionReaper()
+	}
+	shareXRouter.abortIdleUploadSessions(-1)
+}
+
+// uploadSessionsLength returns the sum of the announced lengths of the open upload sessions of the given author. Their
+// data is not part of the usage of the author until they are complete.
+func (shareXRouter *ShareXRouter) uploadSessionsLength(author storage.AuthorIdentifier) (length int64) {
+	shareXRouter.uploadSessionsMutex.Lock()
+	defer shareXRouter.uploadSessionsMutex.Unlock()
+	for _, session := range shareXRouter.uploadSessions {
+		if session.entry.Author == author {
+			length += session.length
+		}
+	}
+	return
+}
+
+// uploadSessionTimeout returns the time after which idle upload sessions are aborted.
+func (shareXRouter *ShareXRouter) uploadSessionTimeout() time.Duration {
+	if shareXRouter.UploadSessionTimeout <= 0 {
+		return defaultUploadSessionTimeout
+	}
+	return shareXRouter.UploadSessionTimeout
+}
+
+// uploadSessionWriter wraps the errors of the writer of an upload session into storageWriteErrors so that they can be
+// distinguished from the errors of the client connection.
+type uploadSessionWriter struct {
+	io.Writer
+}
+
+func (sessionWriter *uploadSessionWriter) Write(data []byte) (int, error) {
+	written, err := sessionWriter.Writer.Write(data)
+	if err != nil {
+		err = &storageWriteError{err}
+	}
+	return written, err
+}
+
+// storageWriteError is an error which occurred while writing to the storage.
+type storageWriteError struct {
+	error
+}
+
+// sendUploadSessionResponse sends the state of the upload session as JSON.
+func sendUploadSessionResponse(writer http.ResponseWriter, status int, id string, session *uploadSession) {
+	jsonResponse, _ := json.Marshal(uploadSessionResponse{
+		ID:     id,
+		Offset: session.offset,
+		Length: session.length,
+	})
+	writer.Header().Set(contentTypeHeader, "application/json")
+	writer.WriteHeader(status)
+	writer.Write(jsonResponse)
+}
+
+// newUploadSessionID returns a new random upload session id.
+func newUploadSessionID() (string, error) {
+	id := make([]byte, uploadSessionIDSize)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
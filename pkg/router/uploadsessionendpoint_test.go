@@ -0,0 +1,142 @@
+package router
+
+import (
+	"encoding/json"
+	"github.com/mmichaelb/gosharexserver/pkg/ratelimit"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// createUploadSession creates an upload session of the given length with the given token and returns its id.
+func createUploadSession(t *testing.T, handler http.Handler, token, length string) string {
+	request := newFormRequest(http.MethodPost, "/upload/sessions", token,
+		url.Values{uploadFilenameFormName: {"session.txt"}, uploadContentTypeFormName: {"text/plain"}})
+	request.Header.Set(uploadLengthHeader, length)
+	recorder := serve(handler, request)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("Upload session creation failed with status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	response := &uploadSessionResponse{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+		t.Fatalf("Could not decode upload session response, %T: %v", err, err)
+	}
+	if recorder.Header().Get("Location") != "sessions/"+response.ID || response.Offset != 0 {
+		t.Fatalf("Invalid upload session response %+v with location %q", response,
+			recorder.Header().Get("Location"))
+	}
+	return response.ID
+}
+
+// sendChunk sends the chunk of the upload session with the given id at the given offset.
+func sendChunk(handler http.Handler, id, offset, chunk string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPatch, "/upload/sessions/"+id, strings.NewReader(chunk))
+	request.Header.Set(uploadOffsetHeader, offset)
+	return serve(handler, request)
+}
+
+// sessionRequest returns a request of the upload session with the given id and token.
+func sessionRequest(method, id, token string) *http.Request {
+	request := httptest.NewRequest(method, "/upload/sessions/"+id, nil)
+	if token != "" {
+		request.Header.Set("Authorization", token)
+	}
+	return request
+}
+
+func TestUploadSession(t *testing.T) {
+	shareXRouter := &ShareXRouter{}
+	handler, release := newTestRouter(t, shareXRouter, "/")
+	defer release()
+	id := createUploadSession(t, handler, "", "10")
+	if recorder := sendChunk(handler, id, "0", "hello"); recorder.Code != http.StatusNoContent ||
+		recorder.Header().Get(uploadOffsetHeader) != "5" {
+		t.Fatalf("First chunk was answered with status %d and offset %q", recorder.Code,
+			recorder.Header().Get(uploadOffsetHeader))
+	}
+	// chunks which do not continue at the received offset are rejected
+	if recorder := sendChunk(handler, id, "0", "hello"); recorder.Code != http.StatusConflict ||
+		recorder.Header().Get(uploadOffsetHeader) != "5" {
+		t.Fatalf("Chunk with a wrong offset was answered with status %d and offset %q", recorder.Code,
+			recorder.Header().Get(uploadOffsetHeader))
+	}
+	// the offset is requested to resume the upload
+	recorder := serve(handler, sessionRequest(http.MethodHead, id, ""))
+	if recorder.Code != http.StatusOK || recorder.Header().Get(uploadOffsetHeader) != "5" ||
+		recorder.Header().Get(uploadLengthHeader) != "10" {
+		t.Fatalf("Upload session state was answered with status %d, offset %q and length %q", recorder.Code,
+			recorder.Header().Get(uploadOffsetHeader), recorder.Header().Get(uploadLengthHeader))
+	}
+	recorder = sendChunk(handler, id, "5", "world")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Last chunk failed with status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	response := decodeResponse(t, recorder.Body)
+	recorder = serve(handler, httptest.NewRequest(http.MethodGet, rawPath+response.CallReference, nil))
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "helloworld" {
+		t.Fatalf("Uploaded entry was answered with status %d and body %q", recorder.Code, recorder.Body.String())
+	}
+	// the completed session is removed
+	if recorder = sendChunk(handler, id, "10", "!"); recorder.Code != http.StatusNotFound {
+		t.Fatalf("Chunk of a completed session was answered with status %d", recorder.Code)
+	}
+}
+
+func TestUploadSessionAbort(t *testing.T) {
+	shareXRouter := &ShareXRouter{AuthorizationToken: "global"}
+	handler, release := newTestRouter(t, shareXRouter, "/")
+	defer release()
+	shareXRouter.Users = shareXRouter.Storage.(storage.UserStorage)
+	userToken := newUserToken(t, shareXRouter, "alice", storage.RoleUser)
+	id := createUploadSession(t, handler, userToken, "10")
+	request := httptest.NewRequest(http.MethodPatch, "/upload/sessions/"+id, strings.NewReader("hello"))
+	request.Header.Set("Authorization", userToken)
+	request.Header.Set(uploadOffsetHeader, "0")
+	if code := serve(handler, request).Code; code != http.StatusNoContent {
+		t.Fatalf("Chunk was answered with status %d", code)
+	}
+	// sessions can only be used by their author
+	if code := serve(handler, sessionRequest(http.MethodDelete, id, "global")).Code; code != http.StatusNotFound {
+		t.Fatalf("Abort by another author was answered with status %d", code)
+	}
+	if code := serve(handler, sessionRequest(http.MethodDelete, id, userToken)).Code; code != http.StatusNoContent {
+		t.Fatalf("Abort was answered with status %d", code)
+	}
+	if code := serve(handler, sessionRequest(http.MethodHead, id, userToken)).Code; code != http.StatusNotFound {
+		t.Fatalf("Aborted session was answered with status %d", code)
+	}
+	if len(shareXRouter.uploadSessions) != 0 {
+		t.Fatalf("Expected no upload sessions, got %d", len(shareXRouter.uploadSessions))
+	}
+}
+
+func TestUploadSessionLimit(t *testing.T) {
+	shareXRouter := &ShareXRouter{
+		RateLimitStore: ratelimit.NewMemoryStore(),
+		RateLimits: map[string]RateLimit{
+			RouteUpload: {IP: ratelimit.Limit{Burst: maximumUploadSessionsPerAuthor + 1, Interval: time.Minute}},
+		},
+	}
+	handler, release := newTestRouter(t, shareXRouter, "/")
+	defer release()
+	var id string
+	for i := 0; i < maximumUploadSessionsPerAuthor; i++ {
+		id = createUploadSession(t, handler, "", "10")
+	}
+	request := newFormRequest(http.MethodPost, "/upload/sessions", "", url.Values{uploadFilenameFormName: {"a.txt"}})
+	request.Header.Set(uploadLengthHeader, "10")
+	if code := serve(handler, request).Code; code != http.StatusTooManyRequests {
+		t.Fatalf("Upload session exceeding the limit of the author was answered with status %d", code)
+	}
+	// the chunks are not limited by the upload limit which is exhausted now
+	for offset := 0; offset < 10; offset++ {
+		if recorder := sendChunk(handler, id, strconv.Itoa(offset), "a"); recorder.Code/100 != 2 {
+			t.Fatalf("Chunk %d was answered with status %d", offset, recorder.Code)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// limitExceededError is returned while receiving an upload which exceeds one of the size limits or the quota of its
+// author. It is sent to the client as the JSON body of the 413 response.
+type limitExceededError struct {
+	Message string `json:"error"`
+	// Limit is the exceeded limit in bytes.
+	Limit int64 `json:"limit"`
+	// Usage is the amount of bytes the author has already stored. It is only set if the quota has been exceeded.
+	Usage int64 `json:"usage,omitempty"`
+	// remaining is the amount of bytes which can still be received.
+	remaining int64
+}
+
+// Error is the implementation of the error interface method.
+func (err *limitExceededError) Error() string {
+	return err.Message
+}
+
+// UsageResponse is sent by the usage endpoint.
+type UsageResponse struct {
+	Author string `json:"author"`
+	// Usage is the amount of bytes the author has stored.
+	Usage int64 `json:"usage"`
+	// Quota is only set if the stored bytes of the authors are limited.
+	Quota int64 `json:"quota,omitempty"`
+}
+
+// handleUsage is the endpoint which sends the amount of bytes the user has stored and the quota as JSON. Admins can
+// request the usage of other authors via the author query parameter.
+func (shareXRouter *ShareXRouter) handleUsage(writer http.ResponseWriter, request *http.Request) {
+	user, ok := shareXRouter.checkAuthorization(request, writer)
+	if !ok {
+		return
+	}
+	usageStorage, ok := shareXRouter.Storage.(storage.UsageStorage)
+	if !ok {
+		http.Error(writer, "501 the storage does not support usage calculations", http.StatusNotImplemented)
+		return
+	}
+	author := user.Author()
+	if requestedAuthor := request.URL.Query().Get("author"); requestedAuthor != "" && user.IsAdmin() {
+		author = storage.AuthorIdentifier(requestedAuthor)
+	}
+	usage, err := usageStorage.Usage(author)
+	if err != nil {
+		shareXRouter.sendInternalError(writer, fmt.Sprintf("calculating the usage of %v", author), err)
+		return
+	}
+	jsonResponse, err := json.Marshal(UsageResponse{
+		Author: string(author),
+		Usage:  usage,
+		Quota:  shareXRouter.Quota,
+	})
+	if err != nil {
+		shareXRouter.sendInternalError(writer, "creation of the json usage message", err)
+		return
+	}
+	writer.Header().Set(contentTypeHeader, "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.Write(jsonResponse)
+}
+
+// limitRequestBody limits the body of the upload request to the MaximumUploadSize. Requests whose announced length
+// already exceeds it are rejected right away. It sends an error response and returns false if the request is too
+// large.
+func (shareXRouter *ShareXRouter) limitRequestBody(writer http.ResponseWriter, request *http.Request) bool {
+	if shareXRouter.MaximumUploadSize <= 0 {
+		return true
+	}
+	limitErr := shareXRouter.maximumUploadSizeError()
+	if request.ContentLength > shareXRouter.MaximumUploadSize {
+		sendLimitExceeded(writer, limitErr)
+		return false
+	}
+	request.Body = &limitedBody{
+		ReadCloser: http.MaxBytesReader(writer, request.Body, shareXRouter.MaximumUploadSize),
+		limitErr:   limitErr,
+	}
+	return true
+}
+
+// uploadLimit returns the tightest limit of an upload of the given author with the given content type or nil if the
+// upload is not limited at all. The announced lengths of the open upload sessions of the author are counted against the
+// quota. The quota is checked when the upload starts, concurrent uploads of the same author can therefore exceed it
+// slightly. It returns an error if the usage of the author could not be calculated.
+func (shareXRouter *ShareXRouter) uploadLimit(author storage.AuthorIdentifier, contentType string) (
+	*limitExceededError, error) {
+	var limitErr *limitExceededError
+	if shareXRouter.MaximumUploadSize > 0 {
+		limitErr = shareXRouter.maximumUploadSizeError()
+	}
+	if limit, ok := shareXRouter.contentTypeLimit(contentType); ok && (limitErr == nil || limit < limitErr.remaining) {
+		limitErr = &limitExceededError{
+			Message:   fmt.Sprintf("the upload exceeds the maximum size of %d bytes for its content type", limit),
+			Limit:     limit,
+			remaining: limit,
+		}
+	}
+	if shareXRouter.Quota <= 0 {
+		return limitErr, nil
+	}
+	usageStorage, ok := shareXRouter.Storage.(storage.UsageStorage)
+	if !ok {
+		return limitErr, nil
+	}
+	usage, err := usageStorage.Usage(author)
+	if err != nil {
+		return nil, err
+	}
+	usage += shareXRouter.uploadSessionsLength(author)
+	if remaining := shareXRouter.Quota - usage; limitErr == nil || remaining < limitErr.remaining {
+		if remaining < 0 {
+			remaining = 0
+		}
+		limitErr = &limitExceededError{
+			Message:   fmt.Sprintf("the upload exceeds the quota of %d bytes", shareXRouter.Quota),
+			Limit:     shareXRouter.Quota,
+			Usage:     usage,
+			remaining: remaining,
+		}
+	}
+	return limitErr, nil
+}
+
+// maximumUploadSizeError returns the error of uploads which exceed the MaximumUploadSize.
+func (shareXRouter *ShareXRouter) maximumUploadSizeError() *limitExceededError {
+	return &limitExceededError{
+		Message:   fmt.Sprintf("the upload exceeds the maximum size of %d bytes", shareXRouter.MaximumUploadSize),
+		Limit:     shareXRouter.MaximumUploadSize,
+		remaining: shareXRouter.MaximumUploadSize,
+	}
+}
+
+// contentTypeLimit returns the maximum size of uploads with the given content type. Limits of the exact media type take
+// precedence over the limits of their top-level type (e.g. "image/*").
+func (shareXRouter *ShareXRouter) contentTypeLimit(contentType string) (int64, bool) {
+	if len(shareXRouter.ContentTypeLimits) == 0 {
+		return 0, false
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(contentType))
+	}
+	if limit, ok := shareXRouter.ContentTypeLimits[mediaType]; ok {
+		return limit, true
+	}
+	if slash := strings.Index(mediaType, "/"); slash > 0 {
+		if limit, ok := shareXRouter.ContentTypeLimits[mediaType[:slash]+"/*"]; ok {
+			return limit, true
+		}
+	}
+	return 0, false
+}
+
+// sendLimitExceeded sends the given limit error as the JSON body of a 413 response.
+func sendLimitExceeded(writer http.ResponseWriter, limitErr *limitExceededError) {
+	jsonResponse, err := json.Marshal(limitErr)
+	if err != nil {
+		log.Printf("Could not create the json limit message, %T: %v\n", err, err)
+		http.Error(writer, "413 the upload is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	writer.Header().Set(contentTypeHeader, "application/json")
+	writer.WriteHeader(http.StatusRequestEntityTooLarge)
+	writer.Write(jsonResponse)
+}
+
+// limitedBody wraps the body returned by http.MaxBytesReader and replaces its error by the limit error once the
+// limit has been reached.
+type limitedBody struct {
+	io.ReadCloser
+	limitErr *limitExceededError
+	read     int64
+}
+
+// Read is the implementation of the io.Reader interface method.
+func (body *limitedBody) Read(p []byte) (n int, err error) {
+	n, err = body.ReadCloser.Read(p)
+	body.read += int64(n)
+	if err != nil && err != io.EOF && body.read >= body.limitErr.Limit {
+		err = body.limitErr
+	}
+	return
+}
+
+// limitedReader reads the data of an upload and returns the limit error as soon as the upload exceeds the remaining
+// amount of bytes of the limit.
+type limitedReader struct {
+	reader    io.Reader
+	limitErr  *limitExceededError
+	remaining int64
+}
+
+// newLimitedReader returns a reader which limits the given reader. The reader is returned unchanged if there is no
+// limit.
+func newLimitedReader(reader io.Reader, limitErr *limitExceededError) io.Reader {
+	if limitErr == nil {
+		return reader
+	}
+	return &limitedReader{reader: reader, limitErr: limitErr, remaining: limitErr.remaining}
+}
+
+// Read is the implementation of the io.Reader interface method. One more byte than allowed is requested in order to
+// notice uploads which exceed the limit.
+func (reader *limitedReader) Read(p []byte) (n int, err error) {
+	if int64(len(p)) > reader.remaining+1 {
+		p = p[:reader.remaining+1]
+	}
+	n, err = reader.reader.Read(p)
+	if int64(n) > reader.remaining {
+		n = int(reader.remaining)
+		reader.remaining = 0
+		return n, reader.limitErr
+	}
+	reader.remaining -= int64(n)
+	return n, err
+}
@@ -0,0 +1,76 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// expectLimitExceeded checks that the response is a 413 response with the given limit and usage.
+func expectLimitExceeded(t *testing.T, recorder *httptest.ResponseRecorder, limit, usage int64) {
+	if recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected status 413, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	limitErr := &limitExceededError{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), limitErr); err != nil {
+		t.Fatalf("Could not decode limit response, %T: %v", err, err)
+	}
+	if limitErr.Message == "" || limitErr.Limit != limit || limitErr.Usage != usage {
+		t.Fatalf("Expected the limit %d and the usage %d, got %+v", limit, usage, limitErr)
+	}
+}
+
+func TestUploadLimits(t *testing.T) {
+	shareXRouter := &ShareXRouter{
+		MaximumUploadSize: 1024,
+		ContentTypeLimits: map[string]int64{"image/*": 4, "image/svg+xml": 8},
+	}
+	handler, release := newTestRouter(t, shareXRouter, "/")
+	defer release()
+	// the maximum upload size limits the whole request, it is rejected before the upload is read
+	expectLimitExceeded(t, serve(handler, newUploadRequest("", "a.txt", "text/plain", make([]byte, 1024), nil)),
+		1024, 0)
+	upload(t, handler, "", "a.txt", "text/plain", make([]byte, 512), nil)
+	expectLimitExceeded(t, serve(handler, newUploadRequest("", "a.png", "image/png", make([]byte, 5), nil)), 4, 0)
+	upload(t, handler, "", "a.png", "image/png", make([]byte, 4), nil)
+	// the limits of exact media types take precedence
+	upload(t, handler, "", "a.svg", "image/svg+xml", make([]byte, 8), nil)
+	expectLimitExceeded(t, serve(handler, newUploadRequest("", "b.svg", "image/svg+xml", make([]byte, 9), nil)), 8, 0)
+	// the filename and the content type have to fit into the columns of the SQL storage
+	longValue := strings.Repeat("a", maximumMetadataLength+1)
+	for _, request := range []*http.Request{
+		newUploadRequest("", longValue, "text/plain", []byte("a"), nil),
+		newUploadRequest("", "a.txt", "text/"+longValue, []byte("a"), nil),
+	} {
+		if recorder := serve(handler, request); recorder.Code != http.StatusBadRequest {
+			t.Fatalf("Upload with a too long filename or content type was answered with status %d", recorder.Code)
+		}
+	}
+}
+
+func TestQuota(t *testing.T) {
+	shareXRouter := &ShareXRouter{Quota: 10}
+	handler, release := newTestRouter(t, shareXRouter, "/")
+	defer release()
+	upload(t, handler, "", "a.txt", "text/plain", make([]byte, 8), nil)
+	expectLimitExceeded(t, serve(handler, newUploadRequest("", "b.txt", "text/plain", make([]byte, 3), nil)), 10, 8)
+	// the announced length of upload sessions is checked against the remaining quota
+	request := newFormRequest(http.MethodPost, "/upload/sessions", "",
+		url.Values{uploadFilenameFormName: {"b.txt"}})
+	request.Header.Set(uploadLengthHeader, "3")
+	expectLimitExceeded(t, serve(handler, request), 10, 8)
+	recorder := serve(handler, httptest.NewRequest(http.MethodGet, "/api/usage", nil))
+	usage := &UsageResponse{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), usage); err != nil {
+		t.Fatalf("Could not decode usage response, %T: %v", err, err)
+	}
+	if recorder.Code != http.StatusOK || usage.Usage != 8 || usage.Quota != 10 {
+		t.Fatalf("Usage request was answered with status %d and usage %+v", recorder.Code, usage)
+	}
+	// open upload sessions count against the quota as well
+	createUploadSession(t, handler, "", "2")
+	expectLimitExceeded(t, serve(handler, newUploadRequest("", "b.txt", "text/plain", make([]byte, 1), nil)), 10, 10)
+}
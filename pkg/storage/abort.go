@@ -0,0 +1,25 @@
+package storage
+
+import "io"
+
+// AbortableWriter is an interface which is the scheme of writers returned by the FileStorage.Store method which can
+// discard the written data. FileStorage implementations can implement it so that rejected uploads never become
+// available, are never counted in the usage and never share their data with other entries.
+type AbortableWriter interface {
+	io.WriteCloser
+	// Abort discards the written data and removes the incomplete entry. The writer must not be used afterwards.
+	Abort() error
+}
+
+// Abort removes the incomplete entry which is written by the given writer. Writers which can not be aborted are closed
+// and the entry is deleted afterwards.
+func Abort(fileStorage FileStorage, entry *Entry, writer io.WriteCloser) error {
+	if abortableWriter, ok := writer.(AbortableWriter); ok {
+		return abortableWriter.Abort()
+	}
+	writer.Close()
+	if err := fileStorage.Delete(entry.DeleteReference); err != nil && err != ErrEntryNotFound {
+		return err
+	}
+	return nil
+}
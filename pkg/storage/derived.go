@@ -0,0 +1,15 @@
+package storage
+
+// DerivedStorage is an interface which is the scheme to store data which has been derived from the data of an entry
+// (e.g. thumbnails of an image). FileStorage implementations can implement it to store the derived data alongside the
+// entries.
+type DerivedStorage interface {
+	// StoreDerived stores the derived data with the given name for the entry with the given call reference. Existing
+	// data with the same name is replaced. The derived data is deleted together with the entry. ErrEntryNotFound is
+	// returned if the entry does not exist.
+	StoreDerived(callReference, name string, data []byte) error
+	// RequestDerived returns the derived data with the given name of the entry with the given call reference. It
+	// returns ErrEntryNotFound if the entry or the derived data does not exist. The expiry and the download limit of the
+	// entry are not checked, the caller has to request the entry itself first.
+	RequestDerived(callReference, name string) ([]byte, error)
+}
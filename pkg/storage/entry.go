@@ -34,6 +34,39 @@ type Entry struct {
 	ContentType string
 	// UploadDate is the unix timestamp when the file was uploaded.
 	UploadDate time.Time
+	// ExpirationDate is the time when the entry expires. Expired entries can not be requested anymore and are deleted
+	// eventually. The zero value means that the entry never expires.
+	ExpirationDate time.Time
+	// MaximumDownloads limits how often the entry can be downloaded (e.g. 1 for burn-after-reading uploads). The zero
+	// value means that the downloads are not limited.
+	MaximumDownloads int
+	// RemainingDownloads is the amount of downloads which are left if the downloads are limited. Entries whose
+	// downloads are exhausted can not be requested anymore.
+	RemainingDownloads int
+	// PasswordHash is the bcrypt hash of the password which protects the entry. Entries without a password hash can be
+	// requested by everyone who knows the call reference.
+	PasswordHash string
+	// EncryptionKeyID identifies the key which encrypted the stored data (see storages.EncryptedStorage). It is empty
+	// if the data is stored unencrypted.
+	EncryptionKeyID string
+	// SHA256 is the hex encoded SHA-256 checksum of the stored data. It is calculated by the storage while the data is
+	// written and identifies identical files which share a single reference counted blob. It is empty for entries
+	// which have been stored before the deduplication was introduced.
+	SHA256 string
+	// MD5 is the hex encoded MD5 checksum of the uploaded data for clients which do not support SHA-256. It is
+	// calculated while receiving the upload and has to be set before closing the writer of the storage. It is empty
+	// for entries which have been stored before the checksums were introduced.
+	MD5 string
 	// ReadCloseSeeker allows to read the image data while controlling the reading start process.
 	Reader ReadCloseSeeker
 }
+
+// Expired returns whether the entry has an expiration date which is not after the given time.
+func (entry *Entry) Expired(now time.Time) bool {
+	return !entry.ExpirationDate.IsZero() && !entry.ExpirationDate.After(now)
+}
+
+// DownloadsExhausted returns whether the downloads of the entry are limited and there are no downloads left.
+func (entry *Entry) DownloadsExhausted() bool {
+	return entry.MaximumDownloads > 0 && entry.RemainingDownloads <= 0
+}
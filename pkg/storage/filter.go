@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"time"
+)
+
+// ListFilter restricts the entries which are returned by the FileStorage.List method. Fields with zero values do not
+// restrict the result.
+type ListFilter struct {
+	// Author only includes entries uploaded by the given author.
+	Author AuthorIdentifier
+	// ContentType only includes entries with the given MIME-Type.
+	ContentType string
+	// UploadedAfter only includes entries uploaded after the given time.
+	UploadedAfter time.Time
+	// UploadedBefore only includes entries uploaded before the given time.
+	UploadedBefore time.Time
+}
+
+// Matches returns whether the entry matches the filter. Storages which can not query their metadata use it to filter
+// the entries manually.
+func (filter ListFilter) Matches(entry *Entry) bool {
+	if filter.Author != "" && entry.Author != filter.Author {
+		return false
+	}
+	if filter.ContentType != "" && entry.ContentType != filter.ContentType {
+		return false
+	}
+	if !filter.UploadedAfter.IsZero() && !entry.UploadDate.After(filter.UploadedAfter) {
+		return false
+	}
+	if !filter.UploadedBefore.IsZero() && !entry.UploadDate.Before(filter.UploadedBefore) {
+		return false
+	}
+	return true
+}
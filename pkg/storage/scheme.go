@@ -3,24 +3,52 @@ package storage
 import (
 	"errors"
 	"io"
+	"time"
 )
 
 // ErrEntryNotFound is returned by the FileStorage.Request method if the entry could not be found.
 var ErrEntryNotFound = errors.New("entry not found")
 
+// ErrReferenceInUse is returned by the FileStorage.Store method if a preset reference is already used by another entry.
+var ErrReferenceInUse = errors.New("reference already in use")
+
+// ErrInvalidCursor is returned by the FileStorage.List method if the cursor was not created by the storage.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
 // FileStorage is an interface which is the scheme to store and request file entries. The implementations can vary.
 type FileStorage interface {
 	// Initialize is called at the start of the application to e.g. connect to a database or create data folders. It
 	// returns an error if something goes wrong.
 	Initialize() error
-	// Store saves the provided entry and adjusts its the ID and CallReference field values. It returns a writer to
-	// write the file data or an error if something goes wrong.
+	// Store saves the provided entry and adjusts its ID, CallReference and DeleteReference field values. Preset
+	// references are kept (e.g. when migrating entries) and ErrReferenceInUse is returned if they are already used. It
+	// returns a writer to write the file data or an error if something goes wrong. The entry is available once the
+	// writer is closed which also sets the SHA256 field. Identical file data is only stored once and shared by all
+	// entries with the same checksum until the last of them is deleted. Writers which implement the AbortableWriter
+	// interface can discard the incomplete entry instead.
 	Store(entry *Entry) (io.WriteCloser, error)
 	// Request searches for an entry by the provided callReference which is the substring which is used in the uri.
-	// It returns an entry or a specific error (see above) or an unwrapped one if something goes wrong.
+	// It returns an entry or a specific error (see above) or an unwrapped one if something goes wrong. Expired entries
+	// and entries whose downloads are exhausted are handled as if they do not exist.
 	Request(callReference string) (*Entry, error)
+	// Lookup searches for an entry by the provided callReference like the Request method but also returns expired
+	// entries and entries whose downloads are exhausted, e.g. so that their owners can still delete them. The returned
+	// entry does not contain a Reader. ErrEntryNotFound is returned if the entry does not exist.
+	Lookup(callReference string) (*Entry, error)
 	// Delete deletes an entry by the given deleteReference.
 	Delete(deleteReference string) error
+	// List returns up to limit entries which match the filter in a stable order starting after the entry the cursor
+	// points to. An empty cursor starts at the beginning. The returned entries do not contain a Reader. The returned
+	// cursor continues the listing with the same filter and is empty if there are no more entries. Expired entries and
+	// entries whose downloads are exhausted are skipped.
+	List(filter ListFilter, cursor string, limit int) (entries []*Entry, nextCursor string, err error)
+	// DeleteExpired deletes all entries which have expired at the given time. It returns the amount of deleted entries.
+	DeleteExpired(now time.Time) (deleted int, err error)
+	// ConsumeDownload atomically decrements the remaining downloads of the entry with the given call reference if its
+	// downloads are limited. It returns whether this was the last download in which case the caller has to delete the
+	// entry after serving it. ErrEntryNotFound is returned if the entry does not exist, has expired or if its downloads
+	// are exhausted. Entries whose downloads are not limited are not modified.
+	ConsumeDownload(callReference string) (lastDownload bool, err error)
 	// Close shutdowns/closes the FileStorage and allows the storage to exit gracefully. It returns an error if
 	// something goes wrong.
 	Close() error
@@ -9,6 +9,7 @@ import (
 	"io"
 	"log"
 	"math/rand"
+	"sort"
 	"time"
 )
 
@@ -84,7 +85,7 @@ idCreation:
 // Request is the implementation of the storage.FileStorage.Request method.
 func (testStorage *TestStorage) Request(callReference string) (*storage.Entry, error) {
 	for entry, data := range testStorage.entries {
-		if entry.CallReference == callReference {
+		if entry.CallReference == callReference && !entry.Expired(time.Now()) && !entry.DownloadsExhausted() {
 			entryCopy := *entry
 			entryPointerCopy := &entryCopy
 			entryPointerCopy.Reader = &testReadCloseSeeker{
@@ -96,6 +97,17 @@ func (testStorage *TestStorage) Request(callReference string) (*storage.Entry, e
 	return nil, storage.ErrEntryNotFound
 }
 
+// Lookup is the implementation of the storage.FileStorage.Lookup method.
+func (testStorage *TestStorage) Lookup(callReference string) (*storage.Entry, error) {
+	for entry := range testStorage.entries {
+		if entry.CallReference == callReference {
+			entryCopy := *entry
+			return &entryCopy, nil
+		}
+	}
+	return nil, storage.ErrEntryNotFound
+}
+
 // Delete is the implementation of the storage.FileStorage.Delete method.
 func (testStorage *TestStorage) Delete(deleteReference string) error {
 	for entry, _ := range testStorage.entries {
@@ -106,6 +118,56 @@ func (testStorage *TestStorage) Delete(deleteReference string) error {
 	return nil
 }
 
+// List is the implementation of the storage.FileStorage.List method. The entries are sorted by their call reference
+// which is also used as the cursor.
+func (testStorage *TestStorage) List(filter storage.ListFilter, cursor string, limit int) (entries []*storage.Entry,
+	nextCursor string, err error) {
+	var sortedEntries []*storage.Entry
+	now := time.Now()
+	for entry := range testStorage.entries {
+		if entry.CallReference > cursor && filter.Matches(entry) && !entry.Expired(now) && !entry.DownloadsExhausted() {
+			sortedEntries = append(sortedEntries, entry)
+		}
+	}
+	sort.Slice(sortedEntries, func(i, j int) bool {
+		return sortedEntries[i].CallReference < sortedEntries[j].CallReference
+	})
+	if len(sortedEntries) > limit {
+		sortedEntries = sortedEntries[:limit]
+		nextCursor = sortedEntries[limit-1].CallReference
+	}
+	for _, entry := range sortedEntries {
+		entryCopy := *entry
+		entries = append(entries, &entryCopy)
+	}
+	return entries, nextCursor, nil
+}
+
+// DeleteExpired is the implementation of the storage.FileStorage.DeleteExpired method.
+func (testStorage *TestStorage) DeleteExpired(now time.Time) (deleted int, err error) {
+	for entry := range testStorage.entries {
+		if entry.Expired(now) {
+			delete(testStorage.entries, entry)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// ConsumeDownload is the implementation of the storage.FileStorage.ConsumeDownload method.
+func (testStorage *TestStorage) ConsumeDownload(callReference string) (lastDownload bool, err error) {
+	for entry := range testStorage.entries {
+		if entry.CallReference == callReference && !entry.Expired(time.Now()) && !entry.DownloadsExhausted() {
+			if entry.MaximumDownloads == 0 {
+				return false, nil
+			}
+			entry.RemainingDownloads--
+			return entry.RemainingDownloads == 0, nil
+		}
+	}
+	return false, storage.ErrEntryNotFound
+}
+
 // Close is the implementation of the storage.FileStorage.Close method.
 func (testStorage *TestStorage) Close() error {
 	// no connection etc. has to be closed because the data is just in the memory
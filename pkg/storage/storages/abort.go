@@ -0,0 +1,13 @@
+package storages
+
+import "github.com/mmichaelb/gosharexserver/pkg/storage"
+
+// the writers of all storages can be aborted so that rejected uploads never become available
+var (
+	_ storage.AbortableWriter = &mongoWriter{}
+	_ storage.AbortableWriter = &filesystemWriter{}
+	_ storage.AbortableWriter = &boltWriter{}
+	_ storage.AbortableWriter = &sqlWriter{}
+	_ storage.AbortableWriter = &s3Writer{}
+	_ storage.AbortableWriter = &encryptedWriter{}
+)
@@ -0,0 +1,23 @@
+package storages
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+)
+
+// checksumHash calculates the SHA-256 checksum of the file data while it is written. Entries with the same checksum
+// share a single blob which is reference counted by the storages and deleted together with the last entry using it.
+type checksumHash struct {
+	hash.Hash
+}
+
+// newChecksumHash creates a new checksumHash for the file data which is going to be written.
+func newChecksumHash() checksumHash {
+	return checksumHash{Hash: sha256.New()}
+}
+
+// String returns the hex encoded checksum of the data written so far.
+func (checksumHash checksumHash) String() string {
+	return hex.EncodeToString(checksumHash.Sum(nil))
+}
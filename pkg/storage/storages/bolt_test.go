@@ -0,0 +1,103 @@
+package storages
+
+import (
+	"bytes"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	bolt "go.etcd.io/bbolt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBoltStorage(t *testing.T) {
+	directory, err := ioutil.TempDir("", "gosharexserver")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory, %T: %v", err, err)
+	}
+	defer os.RemoveAll(directory)
+	boltStorage := &BoltStorage{
+		Path:      filepath.Join(directory, "test.db"),
+		ChunkSize: 4,
+	}
+	if err = boltStorage.Initialize(); err != nil {
+		t.Fatalf("Could not initialize bolt storage, %T: %v", err, err)
+	}
+	defer boltStorage.Close()
+	testBytes := []byte("Hello, this is a test!")
+	entry := &storage.Entry{
+		Author:      storage.AuthorIdentifier("a testing person"),
+		Filename:    "testfile.txt",
+		ContentType: "text/plain",
+		UploadDate:  time.Now().Round(time.Second),
+	}
+	writer, err := boltStorage.Store(entry)
+	if err != nil {
+		t.Fatalf("Could not store entry, %T: %v", err, err)
+	}
+	if _, err = boltStorage.Request(entry.CallReference); err != storage.ErrEntryNotFound {
+		t.Fatalf("Incomplete entry could be requested, err: %v", err)
+	}
+	// write the data in two parts which do not match the chunk size
+	if _, err = writer.Write(testBytes[:7]); err != nil {
+		t.Fatalf("Could not write entry data, %T: %v", err, err)
+	}
+	if _, err = writer.Write(testBytes[7:]); err != nil {
+		t.Fatalf("Could not write entry data, %T: %v", err, err)
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatalf("Could not close entry writer, %T: %v", err, err)
+	}
+	requestedEntry, err := boltStorage.Request(entry.CallReference)
+	if err != nil {
+		t.Fatalf("Could not request stored entry, %T: %v", err, err)
+	}
+	defer requestedEntry.Reader.Close()
+	if requestedEntry.Author != entry.Author || requestedEntry.Filename != entry.Filename ||
+		requestedEntry.ContentType != entry.ContentType || !requestedEntry.UploadDate.Equal(entry.UploadDate) {
+		t.Fatalf("Requested entry metadata %+v does not match the stored metadata %+v", requestedEntry, entry)
+	}
+	requestedBytes, err := ioutil.ReadAll(requestedEntry.Reader)
+	if err != nil {
+		t.Fatalf("Could not read requested entry data, %T: %v", err, err)
+	}
+	if !bytes.Equal(testBytes, requestedBytes) {
+		t.Fatalf("Requested data %q does not match the stored data %q", requestedBytes, testBytes)
+	}
+	// seek into the middle of a chunk and read the rest of the data
+	if _, err = requestedEntry.Reader.Seek(-9, io.SeekEnd); err != nil {
+		t.Fatalf("Could not seek requested entry data, %T: %v", err, err)
+	}
+	if requestedBytes, err = ioutil.ReadAll(requestedEntry.Reader); err != nil {
+		t.Fatalf("Could not read requested entry data, %T: %v", err, err)
+	}
+	if !bytes.Equal(testBytes[len(testBytes)-9:], requestedBytes) {
+		t.Fatalf("Requested data %q after seeking does not match the stored data", requestedBytes)
+	}
+	if err = boltStorage.Delete(entry.DeleteReference); err != nil {
+		t.Fatalf("Could not delete entry, %T: %v", err, err)
+	}
+	if _, err = boltStorage.Request(entry.CallReference); err != storage.ErrEntryNotFound {
+		t.Fatalf("Deleted entry could still be requested, err: %v", err)
+	}
+	if err = boltStorage.Delete(entry.DeleteReference); err != storage.ErrEntryNotFound {
+		t.Fatalf("Deleted entry could be deleted again, err: %v", err)
+	}
+	testPresetReferencesAndList(t, boltStorage)
+	testExpiry(t, boltStorage)
+	testDownloadLimit(t, boltStorage)
+	testConcurrentDownloads(t, boltStorage)
+	testAbort(t, boltStorage)
+	testDeduplication(t, boltStorage, func() (blobs int) {
+		boltStorage.db.View(func(tx *bolt.Tx) error {
+			blobs = tx.Bucket([]byte(blobsBucketName)).Stats().KeyN
+			return nil
+		})
+		return
+	})
+	testDerivedStorage(t, boltStorage)
+	testUsage(t, boltStorage)
+	testUserStorage(t, boltStorage)
+}
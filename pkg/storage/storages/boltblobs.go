@@ -0,0 +1,67 @@
+package storages
+
+import (
+	"encoding/json"
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltBlob is a reference counted chunks bucket which is shared by all entries with the same checksum. It is stored in
+// the blobs bucket under the checksum.
+type boltBlob struct {
+	// DataID is the id of the chunks bucket which contains the data.
+	DataID     uint64 `json:"data_id"`
+	Size       int64  `json:"size"`
+	ChunkSize  int    `json:"chunk_size"`
+	References int    `json:"references"`
+}
+
+// acquireBoltBlob adds a reference to the blob with the given checksum and returns it. The written blob is stored if
+// there is no blob with the checksum yet, otherwise its chunks are deleted.
+func acquireBoltBlob(tx *bolt.Tx, checksum string, written *boltBlob) (*boltBlob, error) {
+	blobs := tx.Bucket([]byte(blobsBucketName))
+	blob := written
+	if data := blobs.Get([]byte(checksum)); data != nil {
+		blob = &boltBlob{}
+		if err := json.Unmarshal(data, blob); err != nil {
+			return nil, err
+		}
+		if err := tx.Bucket([]byte(chunksBucketName)).DeleteBucket(boltKey(written.DataID)); err != nil {
+			return nil, err
+		}
+	}
+	blob.References++
+	data, err := json.Marshal(blob)
+	if err != nil {
+		return nil, err
+	}
+	return blob, blobs.Put([]byte(checksum), data)
+}
+
+// releaseBoltBlob removes a reference from the blob with the given checksum and deletes its chunks if it was the last
+// one.
+func releaseBoltBlob(tx *bolt.Tx, checksum string) error {
+	blobs := tx.Bucket([]byte(blobsBucketName))
+	data := blobs.Get([]byte(checksum))
+	if data == nil {
+		return nil
+	}
+	blob := &boltBlob{}
+	if err := json.Unmarshal(data, blob); err != nil {
+		return err
+	}
+	if blob.References--; blob.References > 0 {
+		data, err := json.Marshal(blob)
+		if err != nil {
+			return err
+		}
+		return blobs.Put([]byte(checksum), data)
+	}
+	if err := blobs.Delete([]byte(checksum)); err != nil {
+		return err
+	}
+	err := tx.Bucket([]byte(chunksBucketName)).DeleteBucket(boltKey(blob.DataID))
+	if err != nil && err != bolt.ErrBucketNotFound {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package storages
+
+import (
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+// StoreDerived is the implementation of the DerivedStorage.StoreDerived method. The derived data of every entry is
+// stored in a nested bucket of the derived bucket which is named after the id of the entry.
+func (boltStorage *BoltStorage) StoreDerived(callReference, name string, data []byte) error {
+	if !validReference(name) {
+		return errInvalidDerivedName
+	}
+	return boltStorage.db.Update(func(tx *bolt.Tx) error {
+		id := tx.Bucket([]byte(callReferencesBucketName)).Get([]byte(callReference))
+		if id == nil || tx.Bucket([]byte(entriesBucketName)).Get(id) == nil {
+			return storage.ErrEntryNotFound
+		}
+		bucket, err := tx.Bucket([]byte(derivedBucketName)).CreateBucketIfNotExists(id)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(name), data)
+	})
+}
+
+// RequestDerived is the implementation of the DerivedStorage.RequestDerived method.
+func (boltStorage *BoltStorage) RequestDerived(callReference, name string) (data []byte, err error) {
+	err = boltStorage.db.View(func(tx *bolt.Tx) error {
+		id := tx.Bucket([]byte(callReferencesBucketName)).Get([]byte(callReference))
+		if id == nil {
+			return storage.ErrEntryNotFound
+		}
+		bucket := tx.Bucket([]byte(derivedBucketName)).Bucket(id)
+		if bucket == nil {
+			return storage.ErrEntryNotFound
+		}
+		value := bucket.Get([]byte(name))
+		if value == nil {
+			return storage.ErrEntryNotFound
+		}
+		// copy the data because it is only valid during the transaction
+		data = append([]byte{}, value...)
+		return nil
+	})
+	return
+}
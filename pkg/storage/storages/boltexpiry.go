@@ -0,0 +1,72 @@
+package storages
+
+import (
+	"encoding/json"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	bolt "go.etcd.io/bbolt"
+	"time"
+)
+
+// DeleteExpired is the implementation of the FileStorage.DeleteExpired method. The expiries bucket is sorted by the
+// expiration date which means that only the expired entries are read.
+func (boltStorage *BoltStorage) DeleteExpired(now time.Time) (deleted int, err error) {
+	var deleteReferences []string
+	if err = boltStorage.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket([]byte(expiriesBucketName)).Cursor()
+		for key, deleteReference := cursor.First(); key != nil; key, deleteReference = cursor.Next() {
+			if expirationDate, _, ok := parseExpiryKey(string(key)); ok && expirationDate.After(now) {
+				break
+			}
+			deleteReferences = append(deleteReferences, string(deleteReference))
+		}
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	for _, deleteReference := range deleteReferences {
+		if err = boltStorage.Delete(deleteReference); err == storage.ErrEntryNotFound {
+			// the entry has been deleted in the meantime
+			continue
+		} else if err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// putBoltExpiry adds the entry with the given metadata to the expiry index if it expires.
+func putBoltExpiry(tx *bolt.Tx, metadata *entryMetadata) error {
+	if metadata.ExpirationDate.IsZero() {
+		return nil
+	}
+	return tx.Bucket([]byte(expiriesBucketName)).Put([]byte(expiryKey(metadata.ExpirationDate,
+		metadata.CallReference)), []byte(metadata.DeleteReference))
+}
+
+// deleteBoltExpiry removes the entry with the given metadata from the expiry index if it expires.
+func deleteBoltExpiry(tx *bolt.Tx, metadata *entryMetadata) error {
+	if metadata.ExpirationDate.IsZero() {
+		return nil
+	}
+	return tx.Bucket([]byte(expiriesBucketName)).Delete([]byte(expiryKey(metadata.ExpirationDate,
+		metadata.CallReference)))
+}
+
+// initializeBoltExpiries creates the expiries bucket and fills it with the stored entries if the bucket does not exist
+// yet, e.g. because the entries have been stored by an older version.
+func initializeBoltExpiries(tx *bolt.Tx) error {
+	if tx.Bucket([]byte(expiriesBucketName)) != nil {
+		return nil
+	}
+	if _, err := tx.CreateBucket([]byte(expiriesBucketName)); err != nil {
+		return err
+	}
+	return tx.Bucket([]byte(entriesBucketName)).ForEach(func(id, data []byte) error {
+		metadata := &boltMetadata{}
+		if err := json.Unmarshal(data, metadata); err != nil {
+			return err
+		}
+		return putBoltExpiry(tx, &metadata.entryMetadata)
+	})
+}
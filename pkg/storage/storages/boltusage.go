@@ -0,0 +1,68 @@
+package storages
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Usage is the implementation of the UsageStorage.Usage method. The usage is read from the counters which are updated
+// in the same transactions which complete or delete the entries.
+func (boltStorage *BoltStorage) Usage(author storage.AuthorIdentifier) (usage int64, err error) {
+	if author == "" {
+		return 0, nil
+	}
+	err = boltStorage.db.View(func(tx *bolt.Tx) error {
+		if data := tx.Bucket([]byte(usageBucketName)).Get([]byte(author)); data != nil {
+			usage = int64(binary.BigEndian.Uint64(data))
+		}
+		return nil
+	})
+	return
+}
+
+// addBoltUsage adds the given amount of bytes to the usage counter of the author. Counters which drop to zero are
+// removed. Entries without an author are not counted because Bolt does not allow empty keys.
+func addBoltUsage(tx *bolt.Tx, author string, size int64) error {
+	if author == "" {
+		return nil
+	}
+	bucket := tx.Bucket([]byte(usageBucketName))
+	var usage int64
+	if data := bucket.Get([]byte(author)); data != nil {
+		usage = int64(binary.BigEndian.Uint64(data))
+	}
+	if usage += size; usage <= 0 {
+		return bucket.Delete([]byte(author))
+	}
+	return bucket.Put([]byte(author), boltKey(uint64(usage)))
+}
+
+// initializeBoltUsage creates the usage bucket and calculates the counters from the stored entries if the bucket does
+// not exist yet, e.g. because the entries have been stored by an older version.
+func initializeBoltUsage(tx *bolt.Tx) error {
+	if tx.Bucket([]byte(usageBucketName)) != nil {
+		return nil
+	}
+	if _, err := tx.CreateBucket([]byte(usageBucketName)); err != nil {
+		return err
+	}
+	counters := make(usageCounters)
+	if err := tx.Bucket([]byte(entriesBucketName)).ForEach(func(id, data []byte) error {
+		metadata := &boltMetadata{}
+		if err := json.Unmarshal(data, metadata); err != nil {
+			return err
+		}
+		counters.add(metadata.Author, metadata.Size)
+		return nil
+	}); err != nil {
+		return err
+	}
+	for author, usage := range counters {
+		if err := addBoltUsage(tx, author, usage); err != nil {
+			return err
+		}
+	}
+	return nil
+}
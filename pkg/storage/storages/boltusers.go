@@ -0,0 +1,175 @@
+package storages
+
+import (
+	"encoding/json"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+// CreateUser is the implementation of the UserStorage.CreateUser method. The users are stored in the users bucket by
+// their name.
+func (boltStorage *BoltStorage) CreateUser(user *storage.User) error {
+	if !storage.ValidUsername(user.Name) {
+		return storage.ErrInvalidUsername
+	}
+	data, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	return boltStorage.db.Update(func(tx *bolt.Tx) error {
+		users := tx.Bucket([]byte(usersBucketName))
+		if users.Get([]byte(user.Name)) != nil {
+			return storage.ErrUserExists
+		}
+		return users.Put([]byte(user.Name), data)
+	})
+}
+
+// RequestUser is the implementation of the UserStorage.RequestUser method.
+func (boltStorage *BoltStorage) RequestUser(name string) (*storage.User, error) {
+	user := &storage.User{}
+	err := boltStorage.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(usersBucketName)).Get([]byte(name))
+		if data == nil {
+			return storage.ErrUserNotFound
+		}
+		return json.Unmarshal(data, user)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ListUsers is the implementation of the UserStorage.ListUsers method.
+func (boltStorage *BoltStorage) ListUsers() (users []*storage.User, err error) {
+	err = boltStorage.db.View(func(tx *bolt.Tx) error {
+		// the keys of the bucket are sorted by the name
+		return tx.Bucket([]byte(usersBucketName)).ForEach(func(name, data []byte) error {
+			user := &storage.User{}
+			if err := json.Unmarshal(data, user); err != nil {
+				return err
+			}
+			users = append(users, user)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// HasUsers is the implementation of the UserStorage.HasUsers method.
+func (boltStorage *BoltStorage) HasUsers() (hasUsers bool, err error) {
+	err = boltStorage.db.View(func(tx *bolt.Tx) error {
+		name, _ := tx.Bucket([]byte(usersBucketName)).Cursor().First()
+		hasUsers = name != nil
+		return nil
+	})
+	return
+}
+
+// DeleteUser is the implementation of the UserStorage.DeleteUser method.
+func (boltStorage *BoltStorage) DeleteUser(name string) error {
+	return boltStorage.db.Update(func(tx *bolt.Tx) error {
+		users := tx.Bucket([]byte(usersBucketName))
+		if users.Get([]byte(name)) == nil {
+			return storage.ErrUserNotFound
+		}
+		if err := users.Delete([]byte(name)); err != nil {
+			return err
+		}
+		return deleteBoltTokens(tx.Bucket([]byte(tokensBucketName)), func(token *storage.Token) bool {
+			return token.Username == name
+		})
+	})
+}
+
+// StoreToken is the implementation of the UserStorage.StoreToken method. The tokens are stored in the tokens bucket by
+// their hash.
+func (boltStorage *BoltStorage) StoreToken(token *storage.Token) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	return boltStorage.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(tokensBucketName)).Put([]byte(token.Hash), data)
+	})
+}
+
+// RequestToken is the implementation of the UserStorage.RequestToken method.
+func (boltStorage *BoltStorage) RequestToken(hash string) (*storage.Token, error) {
+	token := &storage.Token{}
+	err := boltStorage.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(tokensBucketName)).Get([]byte(hash))
+		if data == nil {
+			return storage.ErrTokenNotFound
+		}
+		return json.Unmarshal(data, token)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// ListTokens is the implementation of the UserStorage.ListTokens method.
+func (boltStorage *BoltStorage) ListTokens(username string) (tokens []*storage.Token, err error) {
+	err = boltStorage.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(tokensBucketName)).ForEach(func(hash, data []byte) error {
+			token := &storage.Token{}
+			if err := json.Unmarshal(data, token); err != nil {
+				return err
+			}
+			if token.Username == username {
+				tokens = append(tokens, token)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// RevokeToken is the implementation of the UserStorage.RevokeToken method.
+func (boltStorage *BoltStorage) RevokeToken(id string) error {
+	return boltStorage.db.Update(func(tx *bolt.Tx) error {
+		var revoked bool
+		if err := deleteBoltTokens(tx.Bucket([]byte(tokensBucketName)), func(token *storage.Token) bool {
+			revoked = revoked || token.ID == id
+			return token.ID == id
+		}); err != nil {
+			return err
+		}
+		if !revoked {
+			return storage.ErrTokenNotFound
+		}
+		return nil
+	})
+}
+
+// deleteBoltTokens deletes all tokens from the bucket which match the given function.
+func deleteBoltTokens(bucket *bolt.Bucket, matches func(token *storage.Token) bool) error {
+	var hashes [][]byte
+	if err := bucket.ForEach(func(hash, data []byte) error {
+		token := &storage.Token{}
+		if err := json.Unmarshal(data, token); err != nil {
+			return err
+		}
+		if matches(token) {
+			hashes = append(hashes, append([]byte{}, hash...))
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		if err := bucket.Delete(hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
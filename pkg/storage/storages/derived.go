@@ -0,0 +1,20 @@
+package storages
+
+import (
+	"errors"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+)
+
+// errInvalidDerivedName is returned by the StoreDerived methods if the name of the derived data contains characters
+// which are not allowed in references.
+var errInvalidDerivedName = errors.New("invalid name of derived data")
+
+// all storages store derived data alongside the entries
+var (
+	_ storage.DerivedStorage = &MongoStorage{}
+	_ storage.DerivedStorage = &FilesystemStorage{}
+	_ storage.DerivedStorage = &BoltStorage{}
+	_ storage.DerivedStorage = &SQLStorage{}
+	_ storage.DerivedStorage = &S3Storage{}
+	_ storage.DerivedStorage = &EncryptedStorage{}
+)
@@ -0,0 +1,353 @@
+package storages
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// default size of the plaintext chunks which are encrypted separately
+	defaultEncryptionChunkSize = 64 << 10
+	// encryptionMagic identifies the header of encrypted data
+	encryptionMagic   = "GSXE"
+	encryptionVersion = 1
+	// the header consists of the magic, the version, the chunk size and the base nonce
+	encryptionHeaderSize = len(encryptionMagic) + 1 + 4 + encryptionNonceSize
+	encryptionNonceSize  = 12
+)
+
+// errInvalidEncryptedData is returned if the stored data does not start with a valid encryption header.
+var errInvalidEncryptedData = errors.New("invalid encrypted data")
+
+// EncryptedStorage wraps another FileStorage and encrypts the file data with AES-GCM before it is stored. The data is
+// split into chunks which are encrypted separately which allows to seek in the decrypted data without decrypting it
+// completely. The ID of the used key is recorded in every entry so that old keys can still decrypt their entries
+// after switching to a new key. Entries which have been stored without encryption are returned unchanged. Every entry
+// is encrypted with a random nonce, so the deduplication of the wrapped storage is ineffective because the SHA256
+// checksums of the stored ciphertexts never match.
+type EncryptedStorage struct {
+	// Storage is the wrapped FileStorage which stores the encrypted data.
+	Storage storage.FileStorage
+	// Keys maps the key IDs to the AES keys which have to be 16, 24 or 32 bytes long.
+	Keys map[string][]byte
+	// KeyID is the ID of the key which encrypts new entries.
+	KeyID string
+	// ChunkSize is the size of a single plaintext chunk in bytes.
+	ChunkSize int
+	// internal values
+	ciphers map[string]cipher.AEAD
+}
+
+// Initialize is the implementation of the FileStorage.Initialize method. It checks the keys and initializes the
+// wrapped storage.
+func (encryptedStorage *EncryptedStorage) Initialize() error {
+	if encryptedStorage.ChunkSize <= 0 {
+		encryptedStorage.ChunkSize = defaultEncryptionChunkSize
+	}
+	encryptedStorage.ciphers = make(map[string]cipher.AEAD, len(encryptedStorage.Keys))
+	for keyID, key := range encryptedStorage.Keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return fmt.Errorf("invalid encryption key %s: %v", strconv.Quote(keyID), err)
+		}
+		if encryptedStorage.ciphers[keyID], err = cipher.NewGCM(block); err != nil {
+			return err
+		}
+	}
+	if _, ok := encryptedStorage.ciphers[encryptedStorage.KeyID]; !ok {
+		return fmt.Errorf("unknown encryption key %s", strconv.Quote(encryptedStorage.KeyID))
+	}
+	return encryptedStorage.Storage.Initialize()
+}
+
+// Store is the implementation of the FileStorage.Store method. The returned writer encrypts the data with the current
+// key.
+func (encryptedStorage *EncryptedStorage) Store(entry *storage.Entry) (io.WriteCloser, error) {
+	entry.EncryptionKeyID = encryptedStorage.KeyID
+	writer, err := encryptedStorage.Storage.Store(entry)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, encryptionHeaderSize)
+	copy(header, encryptionMagic)
+	header[len(encryptionMagic)] = encryptionVersion
+	binary.BigEndian.PutUint32(header[len(encryptionMagic)+1:], uint32(encryptedStorage.ChunkSize))
+	baseNonce := header[encryptionHeaderSize-encryptionNonceSize:]
+	if _, err = rand.Read(baseNonce); err == nil {
+		_, err = writer.Write(header)
+	}
+	if err != nil {
+		storage.Abort(encryptedStorage.Storage, entry, writer)
+		return nil, err
+	}
+	return &encryptedWriter{
+		writer:      writer,
+		fileStorage: encryptedStorage.Storage,
+		entry:       entry,
+		aead:        encryptedStorage.ciphers[encryptedStorage.KeyID],
+		baseNonce:   append([]byte{}, baseNonce...),
+		buffer:      make([]byte, 0, encryptedStorage.ChunkSize),
+	}, nil
+}
+
+// Request is the implementation of the FileStorage.Request method. The reader of the returned entry decrypts the data.
+func (encryptedStorage *EncryptedStorage) Request(callReference string) (*storage.Entry, error) {
+	entry, err := encryptedStorage.Storage.Request(callReference)
+	if err != nil || entry.EncryptionKeyID == "" {
+		return entry, err
+	}
+	aead, ok := encryptedStorage.ciphers[entry.EncryptionKeyID]
+	if !ok {
+		entry.Reader.Close()
+		return nil, fmt.Errorf("unknown encryption key %s of entry %s", strconv.Quote(entry.EncryptionKeyID),
+			strconv.Quote(callReference))
+	}
+	reader, err := newEncryptedReader(entry.Reader, aead)
+	if err != nil {
+		entry.Reader.Close()
+		return nil, err
+	}
+	entry.Reader = reader
+	return entry, nil
+}
+
+// Lookup is the implementation of the FileStorage.Lookup method.
+func (encryptedStorage *EncryptedStorage) Lookup(callReference string) (*storage.Entry, error) {
+	return encryptedStorage.Storage.Lookup(callReference)
+}
+
+// Delete is the implementation of the FileStorage.Delete method.
+func (encryptedStorage *EncryptedStorage) Delete(deleteReference string) error {
+	return encryptedStorage.Storage.Delete(deleteReference)
+}
+
+// List is the implementation of the FileStorage.List method.
+func (encryptedStorage *EncryptedStorage) List(filter storage.ListFilter, cursor string, limit int) (
+	entries []*storage.Entry, nextCursor string, err error) {
+	return encryptedStorage.Storage.List(filter, cursor, limit)
+}
+
+// DeleteExpired is the implementation of the FileStorage.DeleteExpired method.
+func (encryptedStorage *EncryptedStorage) DeleteExpired(now time.Time) (deleted int, err error) {
+	return encryptedStorage.Storage.DeleteExpired(now)
+}
+
+// ConsumeDownload is the implementation of the FileStorage.ConsumeDownload method.
+func (encryptedStorage *EncryptedStorage) ConsumeDownload(callReference string) (lastDownload bool, err error) {
+	return encryptedStorage.Storage.ConsumeDownload(callReference)
+}
+
+// Close is the implementation of the FileStorage.Close method.
+func (encryptedStorage *EncryptedStorage) Close() error {
+	return encryptedStorage.Storage.Close()
+}
+
+// chunkNonce returns the nonce of the chunk with the given index which is the base nonce XORed with the index.
+func chunkNonce(baseNonce []byte, index int64) []byte {
+	nonce := append([]byte{}, baseNonce...)
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(index))
+	for i, b := range counter {
+		nonce[len(nonce)-len(counter)+i] ^= b
+	}
+	return nonce
+}
+
+// chunkAdditionalData returns the authenticated additional data of a chunk. It marks the last chunk which prevents
+// that truncated data is decrypted successfully.
+func chunkAdditionalData(index int64, last bool) []byte {
+	additionalData := make([]byte, 9)
+	binary.BigEndian.PutUint64(additionalData, uint64(index))
+	if last {
+		additionalData[8] = 1
+	}
+	return additionalData
+}
+
+// encryptedWriter encrypts the written data chunk by chunk. A full chunk is only encrypted once more data is written
+// because the last chunk is marked differently. Closing the writer encrypts the last (possibly empty) chunk.
+type encryptedWriter struct {
+	writer      io.WriteCloser
+	fileStorage storage.FileStorage
+	entry       *storage.Entry
+	aead        cipher.AEAD
+	baseNonce   []byte
+	buffer      []byte
+	chunkIndex  int64
+}
+
+// Write is the implementation of the io.Writer interface method.
+func (writer *encryptedWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		if len(writer.buffer) == cap(writer.buffer) {
+			if err = writer.flush(false); err != nil {
+				return
+			}
+		}
+		free := cap(writer.buffer) - len(writer.buffer)
+		if free > len(p) {
+			free = len(p)
+		}
+		writer.buffer = append(writer.buffer, p[:free]...)
+		p = p[free:]
+		n += free
+	}
+	return
+}
+
+// flush encrypts the buffered data and writes it to the wrapped writer.
+func (writer *encryptedWriter) flush(last bool) error {
+	sealed := writer.aead.Seal(nil, chunkNonce(writer.baseNonce, writer.chunkIndex), writer.buffer,
+		chunkAdditionalData(writer.chunkIndex, last))
+	if _, err := writer.writer.Write(sealed); err != nil {
+		return err
+	}
+	writer.chunkIndex++
+	writer.buffer = writer.buffer[:0]
+	return nil
+}
+
+// Close is the implementation of the io.Closer interface method.
+func (writer *encryptedWriter) Close() error {
+	if err := writer.flush(true); err != nil {
+		writer.writer.Close()
+		return err
+	}
+	return writer.writer.Close()
+}
+
+// Abort is the implementation of the storage.AbortableWriter interface method. The incomplete entry is aborted by the
+// wrapped storage.
+func (writer *encryptedWriter) Abort() error {
+	return storage.Abort(writer.fileStorage, writer.entry, writer.writer)
+}
+
+// encryptedReader decrypts the chunks of the wrapped reader on demand. Only the chunk which contains the current
+// offset is held in memory.
+type encryptedReader struct {
+	reader    storage.ReadCloseSeeker
+	aead      cipher.AEAD
+	baseNonce []byte
+	chunkSize int64
+	chunks    int64
+	// size is the total plaintext size
+	size   int64
+	offset int64
+	// chunk is the decrypted chunk with the index chunkIndex
+	chunk      []byte
+	chunkIndex int64
+}
+
+// newEncryptedReader reads the encryption header of the wrapped reader and calculates the plaintext size.
+func newEncryptedReader(reader storage.ReadCloseSeeker, aead cipher.AEAD) (*encryptedReader, error) {
+	totalSize, err := reader.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = reader.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	header := make([]byte, encryptionHeaderSize)
+	if _, err = io.ReadFull(reader, header); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, errInvalidEncryptedData
+	} else if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:len(encryptionMagic)], []byte(encryptionMagic)) ||
+		header[len(encryptionMagic)] != encryptionVersion {
+		return nil, errInvalidEncryptedData
+	}
+	chunkSize := int64(binary.BigEndian.Uint32(header[len(encryptionMagic)+1:]))
+	sealedChunkSize := chunkSize + int64(aead.Overhead())
+	dataSize := totalSize - int64(encryptionHeaderSize)
+	if chunkSize <= 0 || dataSize < int64(aead.Overhead()) {
+		return nil, errInvalidEncryptedData
+	}
+	chunks := (dataSize + sealedChunkSize - 1) / sealedChunkSize
+	lastChunkSize := dataSize - (chunks-1)*sealedChunkSize
+	if lastChunkSize < int64(aead.Overhead()) {
+		return nil, errInvalidEncryptedData
+	}
+	return &encryptedReader{
+		reader:     reader,
+		aead:       aead,
+		baseNonce:  header[encryptionHeaderSize-encryptionNonceSize:],
+		chunkSize:  chunkSize,
+		chunks:     chunks,
+		size:       (chunks-1)*chunkSize + lastChunkSize - int64(aead.Overhead()),
+		chunkIndex: -1,
+	}, nil
+}
+
+// Read is the implementation of the io.Reader interface method.
+func (reader *encryptedReader) Read(p []byte) (n int, err error) {
+	if reader.offset >= reader.size {
+		return 0, io.EOF
+	}
+	index := reader.offset / reader.chunkSize
+	if index != reader.chunkIndex {
+		if err = reader.loadChunk(index); err != nil {
+			return 0, err
+		}
+	}
+	n = copy(p, reader.chunk[reader.offset-index*reader.chunkSize:])
+	reader.offset += int64(n)
+	return n, nil
+}
+
+// loadChunk reads and decrypts the chunk with the given index.
+func (reader *encryptedReader) loadChunk(index int64) error {
+	sealedChunkSize := reader.chunkSize + int64(reader.aead.Overhead())
+	if _, err := reader.reader.Seek(int64(encryptionHeaderSize)+index*sealedChunkSize, io.SeekStart); err != nil {
+		return err
+	}
+	last := index == reader.chunks-1
+	sealed := make([]byte, sealedChunkSize)
+	if last {
+		sealed = sealed[:reader.size-index*reader.chunkSize+int64(reader.aead.Overhead())]
+	}
+	if _, err := io.ReadFull(reader.reader, sealed); err != nil {
+		return err
+	}
+	chunk, err := reader.aead.Open(sealed[:0], chunkNonce(reader.baseNonce, index), sealed,
+		chunkAdditionalData(index, last))
+	if err != nil {
+		return err
+	}
+	reader.chunk = chunk
+	reader.chunkIndex = index
+	return nil
+}
+
+// Seek is the implementation of the io.Seeker interface method.
+func (reader *encryptedReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += reader.offset
+	case io.SeekEnd:
+		offset += reader.size
+	default:
+		return reader.offset, os.ErrInvalid
+	}
+	if offset < 0 {
+		return reader.offset, errNegativeOffset
+	}
+	reader.offset = offset
+	return offset, nil
+}
+
+// Close is the implementation of the io.Closer interface method.
+func (reader *encryptedReader) Close() error {
+	return reader.reader.Close()
+}
@@ -0,0 +1,150 @@
+package storages
+
+import (
+	"bytes"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestEncryptedStorage(t *testing.T) {
+	directory, err := ioutil.TempDir("", "gosharexserver")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory, %T: %v", err, err)
+	}
+	defer os.RemoveAll(directory)
+	filesystemStorage := &FilesystemStorage{Directory: directory}
+	encryptedStorage := &EncryptedStorage{
+		Storage: filesystemStorage,
+		Keys: map[string][]byte{
+			"old": bytes.Repeat([]byte{1}, 32),
+			"new": bytes.Repeat([]byte{2}, 16),
+		},
+		KeyID:     "old",
+		ChunkSize: 16,
+	}
+	if err = encryptedStorage.Initialize(); err != nil {
+		t.Fatalf("Could not initialize encrypted storage, %T: %v", err, err)
+	}
+	defer encryptedStorage.Close()
+	testBytes := make([]byte, 100)
+	rand.Read(testBytes)
+	oldEntry := storeEncryptedEntry(t, encryptedStorage, testBytes)
+	if oldEntry.EncryptionKeyID != "old" {
+		t.Fatalf("Invalid encryption key ID %q", oldEntry.EncryptionKeyID)
+	}
+	storedBytes, err := ioutil.ReadFile(filesystemStorage.blobFile(oldEntry.SHA256))
+	if err != nil {
+		t.Fatalf("Could not read stored data, %T: %v", err, err)
+	}
+	if bytes.Contains(storedBytes, testBytes[:16]) {
+		t.Fatal("Stored data is not encrypted")
+	}
+	// rotate the key, entries of the old key must stay readable
+	encryptedStorage.KeyID = "new"
+	newEntry := storeEncryptedEntry(t, encryptedStorage, testBytes[:32])
+	emptyEntry := storeEncryptedEntry(t, encryptedStorage, nil)
+	for _, test := range []struct {
+		entry *storage.Entry
+		data  []byte
+	}{{oldEntry, testBytes}, {newEntry, testBytes[:32]}, {emptyEntry, nil}} {
+		requestedEntry, err := encryptedStorage.Request(test.entry.CallReference)
+		if err != nil {
+			t.Fatalf("Could not request encrypted entry, %T: %v", err, err)
+		}
+		requestedBytes, err := ioutil.ReadAll(requestedEntry.Reader)
+		if err != nil {
+			t.Fatalf("Could not read encrypted entry, %T: %v", err, err)
+		}
+		if !bytes.Equal(requestedBytes, test.data) {
+			t.Fatalf("Decrypted data %x does not match the stored data %x", requestedBytes, test.data)
+		}
+		if size, err := requestedEntry.Reader.Seek(0, io.SeekEnd); err != nil || size != int64(len(test.data)) {
+			t.Fatalf("Invalid decrypted size %d, err: %v", size, err)
+		}
+		requestedEntry.Reader.Close()
+	}
+	// seeking into the middle of a chunk
+	requestedEntry, err := encryptedStorage.Request(oldEntry.CallReference)
+	if err != nil {
+		t.Fatalf("Could not request encrypted entry, %T: %v", err, err)
+	}
+	defer requestedEntry.Reader.Close()
+	for _, offset := range []int64{90, 3, 16, 47} {
+		if _, err = requestedEntry.Reader.Seek(offset, io.SeekStart); err != nil {
+			t.Fatalf("Could not seek to %d, %T: %v", offset, err, err)
+		}
+		part := make([]byte, 10)
+		if _, err = io.ReadFull(requestedEntry.Reader, part); err != nil {
+			t.Fatalf("Could not read at %d, %T: %v", offset, err, err)
+		}
+		if !bytes.Equal(part, testBytes[offset:offset+10]) {
+			t.Fatalf("Data at %d does not match the stored data", offset)
+		}
+	}
+	// tampered data must not be decrypted
+	storedBytes[len(storedBytes)-1] ^= 1
+	if err = ioutil.WriteFile(filesystemStorage.blobFile(oldEntry.SHA256), storedBytes, filePermissions); err != nil {
+		t.Fatalf("Could not tamper stored data, %T: %v", err, err)
+	}
+	tamperedEntry, err := encryptedStorage.Request(oldEntry.CallReference)
+	if err != nil {
+		t.Fatalf("Could not request tampered entry, %T: %v", err, err)
+	}
+	defer tamperedEntry.Reader.Close()
+	if _, err = ioutil.ReadAll(tamperedEntry.Reader); err == nil {
+		t.Fatal("Tampered data was decrypted")
+	}
+	// derived data is encrypted and bound to its entry
+	if err = encryptedStorage.StoreDerived(newEntry.CallReference, "thumb", testBytes); err != nil {
+		t.Fatalf("Could not store derived data, %T: %v", err, err)
+	}
+	if storedBytes, err = filesystemStorage.RequestDerived(newEntry.CallReference, "thumb"); err != nil ||
+		bytes.Contains(storedBytes, testBytes[:16]) {
+		t.Fatalf("Stored derived data is not encrypted, err: %v", err)
+	}
+	if derivedBytes, err := encryptedStorage.RequestDerived(newEntry.CallReference, "thumb"); err != nil ||
+		!bytes.Equal(derivedBytes, testBytes) {
+		t.Fatalf("Decrypted derived data does not match the stored data, err: %v", err)
+	}
+	if err = filesystemStorage.StoreDerived(emptyEntry.CallReference, "thumb", storedBytes); err != nil {
+		t.Fatalf("Could not copy derived data, %T: %v", err, err)
+	}
+	if _, err = encryptedStorage.RequestDerived(emptyEntry.CallReference, "thumb"); err == nil {
+		t.Fatal("Derived data of another entry was decrypted")
+	}
+	// entries of removed keys can not be decrypted
+	delete(encryptedStorage.ciphers, "new")
+	if _, err = encryptedStorage.Request(newEntry.CallReference); err == nil {
+		t.Fatal("Entry of an unknown key was decrypted")
+	}
+	testAbort(t, encryptedStorage)
+}
+
+// storeEncryptedEntry stores a new entry with the given data.
+func storeEncryptedEntry(t *testing.T, encryptedStorage *EncryptedStorage, data []byte) *storage.Entry {
+	entry := &storage.Entry{Filename: "secret.png", UploadDate: time.Now()}
+	writer, err := encryptedStorage.Store(entry)
+	if err != nil {
+		t.Fatalf("Could not store encrypted entry, %T: %v", err, err)
+	}
+	// write in small pieces to cover the chunk boundaries
+	for len(data) > 0 {
+		size := 7
+		if size > len(data) {
+			size = len(data)
+		}
+		if _, err = writer.Write(data[:size]); err != nil {
+			t.Fatalf("Could not write encrypted data, %T: %v", err, err)
+		}
+		data = data[size:]
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatalf("Could not close encrypted writer, %T: %v", err, err)
+	}
+	return entry
+}
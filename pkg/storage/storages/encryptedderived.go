@@ -0,0 +1,75 @@
+package storages
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"strconv"
+)
+
+// encryptedDerivedMagic identifies encrypted derived data. The magic is followed by the length of the key ID (1 byte),
+// the key ID, the nonce and the sealed data.
+const encryptedDerivedMagic = "GSXD"
+
+// errDerivedNotSupported is returned if the wrapped storage does not implement the storage.DerivedStorage interface.
+var errDerivedNotSupported = errors.New("the wrapped storage does not support derived data")
+
+// StoreDerived is the implementation of the DerivedStorage.StoreDerived method. The derived data is encrypted with the
+// current key as a whole. The call reference and the name are authenticated so that the encrypted data can not be
+// moved to another entry.
+func (encryptedStorage *EncryptedStorage) StoreDerived(callReference, name string, data []byte) error {
+	derivedStorage, ok := encryptedStorage.Storage.(storage.DerivedStorage)
+	if !ok {
+		return errDerivedNotSupported
+	}
+	keyID := encryptedStorage.KeyID
+	if len(keyID) > 255 {
+		return fmt.Errorf("the encryption key ID %s is too long for derived data", strconv.Quote(keyID))
+	}
+	aead := encryptedStorage.ciphers[keyID]
+	sealed := bytes.NewBufferString(encryptedDerivedMagic)
+	sealed.WriteByte(byte(len(keyID)))
+	sealed.WriteString(keyID)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed.Write(nonce)
+	return derivedStorage.StoreDerived(callReference, name,
+		aead.Seal(sealed.Bytes(), nonce, data, derivedAdditionalData(callReference, name)))
+}
+
+// RequestDerived is the implementation of the DerivedStorage.RequestDerived method. Derived data which has been stored
+// without encryption is returned unchanged.
+func (encryptedStorage *EncryptedStorage) RequestDerived(callReference, name string) ([]byte, error) {
+	derivedStorage, ok := encryptedStorage.Storage.(storage.DerivedStorage)
+	if !ok {
+		return nil, errDerivedNotSupported
+	}
+	data, err := derivedStorage.RequestDerived(callReference, name)
+	if err != nil || !bytes.HasPrefix(data, []byte(encryptedDerivedMagic)) {
+		return data, err
+	}
+	data = data[len(encryptedDerivedMagic):]
+	if len(data) == 0 || len(data) < 1+int(data[0]) {
+		return nil, errInvalidEncryptedData
+	}
+	keyID := string(data[1 : 1+data[0]])
+	data = data[1+len(keyID):]
+	aead, ok := encryptedStorage.ciphers[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown encryption key %s of derived data %s", strconv.Quote(keyID),
+			strconv.Quote(name))
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errInvalidEncryptedData
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], derivedAdditionalData(callReference, name))
+}
+
+// derivedAdditionalData returns the additional data which is authenticated together with the derived data.
+func derivedAdditionalData(callReference, name string) []byte {
+	return []byte(callReference + "/" + name)
+}
@@ -0,0 +1,19 @@
+package storages
+
+import (
+	"errors"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+)
+
+// errUsageNotSupported is returned if the wrapped storage does not implement the storage.UsageStorage interface.
+var errUsageNotSupported = errors.New("the wrapped storage does not support usage calculations")
+
+// Usage is the implementation of the UsageStorage.Usage method. The usage of the wrapped storage includes the
+// encryption overhead of the stored data.
+func (encryptedStorage *EncryptedStorage) Usage(author storage.AuthorIdentifier) (int64, error) {
+	usageStorage, ok := encryptedStorage.Storage.(storage.UsageStorage)
+	if !ok {
+		return 0, errUsageNotSupported
+	}
+	return usageStorage.Usage(author)
+}
@@ -0,0 +1,32 @@
+package storages
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// expiryKeySeparator separates the expiration date from the call reference in the keys of the expiry indexes.
+const expiryKeySeparator = "_"
+
+// expiryKey returns the key of the entry with the given expiration date and call reference in the expiry indexes of
+// the storages which can not query the metadata. The expiration date is formatted with a fixed width so that the keys
+// are sorted by the expiration date and the reaper can stop at the first entry which has not expired yet.
+func expiryKey(expirationDate time.Time, callReference string) string {
+	return fmt.Sprintf("%020d%s%s", expirationDate.UnixNano(), expiryKeySeparator, callReference)
+}
+
+// parseExpiryKey returns the expiration date and the call reference of the given expiry index key. It returns false if
+// the key is invalid.
+func parseExpiryKey(key string) (expirationDate time.Time, callReference string, ok bool) {
+	parts := strings.SplitN(key, expiryKeySeparator, 2)
+	if len(parts) != 2 {
+		return time.Time{}, "", false
+	}
+	nanoseconds, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, "", false
+	}
+	return time.Unix(0, nanoseconds), parts[1], true
+}
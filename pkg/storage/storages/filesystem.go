@@ -0,0 +1,423 @@
+package storages
+
+import (
+	"encoding/json"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// directory and file names used inside the root directory
+	entriesDirectoryName          = "entries"
+	deleteReferencesDirectoryName = "delete_references"
+	usersDirectoryName            = "users"
+	tokensDirectoryName           = "tokens"
+	blobsDirectoryName            = "blobs"
+	jsonFileSuffix                = ".json"
+	dataFileName                  = "data"
+	metadataFileName              = "metadata.json"
+	temporaryFileSuffix           = ".tmp"
+	// permissions of the created directories and files
+	directoryPermissions = 0750
+	filePermissions      = 0640
+)
+
+// FilesystemStorage is the FileStorage implementation using the local filesystem. Every entry is stored in its own
+// directory (named after the call reference) which contains a metadata sidecar file. The file data is moved into the
+// blobs directory (named after the checksum) once it has been written and is shared by all entries with the same
+// checksum. Delete references are resolved via small index files which contain the call reference of the entry.
+type FilesystemStorage struct {
+	// Directory is the root directory which contains all stored data.
+	Directory string
+	// ReferenceLengths are the lengths of the generated references.
+	ReferenceLengths
+	// internal values
+	entriesDirectory          string
+	deleteReferencesDirectory string
+	usersDirectory            string
+	tokensDirectory           string
+	blobsDirectory            string
+	expiriesDirectory         string
+	// downloadMutex serializes the updates of the remaining downloads
+	downloadMutex sync.Mutex
+	// blobMutex serializes the updates of the blob reference counts
+	blobMutex sync.Mutex
+	// usageMutex serializes the updates of the usage counters
+	usageMutex sync.Mutex
+}
+
+// filesystemWriter writes the file data of a new entry and stores the metadata sidecar file when it is closed.
+type filesystemWriter struct {
+	*os.File
+	filesystemStorage *FilesystemStorage
+	entry             *storage.Entry
+	checksum          checksumHash
+	size              int64
+}
+
+// Write is the implementation of the io.Writer interface method.
+func (writer *filesystemWriter) Write(p []byte) (n int, err error) {
+	n, err = writer.File.Write(p)
+	writer.checksum.Write(p[:n])
+	writer.size += int64(n)
+	return
+}
+
+// Close is the implementation of the io.Closer interface method. It closes the data file, moves it into the blobs
+// directory, adds the entry to the expiry index and writes the metadata file which makes the entry available. The size
+// of the data is added to the usage of the author afterwards.
+func (writer *filesystemWriter) Close() error {
+	if err := writer.File.Close(); err != nil {
+		return err
+	}
+	writer.entry.SHA256 = writer.checksum.String()
+	if err := writer.filesystemStorage.acquireBlob(writer.File.Name(), writer.entry.SHA256); err != nil {
+		return err
+	}
+	err := writer.filesystemStorage.writeExpiry(writer.entry)
+	if err == nil {
+		err = writer.filesystemStorage.writeMetadata(writer.entry)
+	}
+	if err != nil {
+		writer.filesystemStorage.releaseBlob(writer.entry.SHA256)
+		return err
+	}
+	writer.filesystemStorage.addUsage(string(writer.entry.Author), writer.size)
+	return nil
+}
+
+// Abort is the implementation of the storage.AbortableWriter interface method. The data file is removed together with
+// the incomplete entry.
+func (writer *filesystemWriter) Abort() error {
+	writer.File.Close()
+	return writer.filesystemStorage.Delete(writer.entry.DeleteReference)
+}
+
+// Initialize is the implementation of the FileStorage.Initialize method.
+func (filesystemStorage *FilesystemStorage) Initialize() (err error) {
+	if err = filesystemStorage.ReferenceLengths.validate(); err != nil {
+		return
+	}
+	filesystemStorage.entriesDirectory = filepath.Join(filesystemStorage.Directory, entriesDirectoryName)
+	filesystemStorage.deleteReferencesDirectory = filepath.Join(filesystemStorage.Directory, deleteReferencesDirectoryName)
+	filesystemStorage.usersDirectory = filepath.Join(filesystemStorage.Directory, usersDirectoryName)
+	filesystemStorage.tokensDirectory = filepath.Join(filesystemStorage.Directory, tokensDirectoryName)
+	filesystemStorage.blobsDirectory = filepath.Join(filesystemStorage.Directory, blobsDirectoryName)
+	filesystemStorage.expiriesDirectory = filepath.Join(filesystemStorage.Directory, expiriesDirectoryName)
+	// create the directories if they do not exist yet
+	for _, directory := range []string{filesystemStorage.entriesDirectory, filesystemStorage.deleteReferencesDirectory,
+		filesystemStorage.usersDirectory, filesystemStorage.tokensDirectory, filesystemStorage.blobsDirectory} {
+		if err = os.MkdirAll(directory, directoryPermissions); err != nil {
+			return
+		}
+	}
+	if err = filesystemStorage.initializeUsage(); err != nil {
+		return
+	}
+	return filesystemStorage.initializeExpiries()
+}
+
+// Store is the implementation of the FileStorage.Store method.
+func (filesystemStorage *FilesystemStorage) Store(entry *storage.Entry) (writer io.WriteCloser, err error) {
+	// keep preset references or generate new ones
+	if entry.CallReference, err = filesystemStorage.reserveCallReference(entry.CallReference); err != nil {
+		return nil, err
+	}
+	if entry.DeleteReference, err = filesystemStorage.reserveDeleteReference(entry.DeleteReference,
+		entry.CallReference); err != nil {
+		os.RemoveAll(filesystemStorage.entryDirectory(entry.CallReference))
+		return nil, err
+	}
+	// the call reference is unique and therefore used as the ID
+	entry.ID = entry.CallReference
+	dataFile, err := os.OpenFile(filepath.Join(filesystemStorage.entryDirectory(entry.CallReference), dataFileName),
+		os.O_WRONLY|os.O_CREATE|os.O_EXCL, filePermissions)
+	if err != nil {
+		return nil, err
+	}
+	return &filesystemWriter{
+		File:              dataFile,
+		filesystemStorage: filesystemStorage,
+		entry:             entry,
+		checksum:          newChecksumHash(),
+	}, nil
+}
+
+// reserveCallReference reserves the preset or a new call reference by creating the entry directory.
+func (filesystemStorage *FilesystemStorage) reserveCallReference(preset string) (string, error) {
+	for {
+		callReference := preset
+		if preset == "" {
+			callReference = randomReference(filesystemStorage.CallReferenceLength)
+		} else if !validReference(preset) {
+			return preset, errInvalidReference
+		}
+		err := os.Mkdir(filesystemStorage.entryDirectory(callReference), directoryPermissions)
+		if err == nil {
+			return callReference, nil
+		} else if !os.IsExist(err) {
+			return preset, err
+		} else if preset != "" {
+			return preset, storage.ErrReferenceInUse
+		}
+	}
+}
+
+// reserveDeleteReference reserves the preset or a new delete reference by exclusively creating the index file which
+// contains the given call reference.
+func (filesystemStorage *FilesystemStorage) reserveDeleteReference(preset, callReference string) (string, error) {
+	for {
+		deleteReference := preset
+		if preset == "" {
+			deleteReference = randomReference(filesystemStorage.DeleteReferenceLength)
+		} else if !validReference(preset) {
+			return preset, errInvalidReference
+		}
+		indexFile, err := os.OpenFile(filesystemStorage.deleteReferenceFile(deleteReference),
+			os.O_WRONLY|os.O_CREATE|os.O_EXCL, filePermissions)
+		if os.IsExist(err) {
+			if preset != "" {
+				return preset, storage.ErrReferenceInUse
+			}
+			continue
+		} else if err != nil {
+			return preset, err
+		}
+		_, err = indexFile.WriteString(callReference)
+		if closeErr := indexFile.Close(); err == nil {
+			err = closeErr
+		}
+		return deleteReference, err
+	}
+}
+
+// writeMetadata writes the metadata sidecar file of the given entry.
+func (filesystemStorage *FilesystemStorage) writeMetadata(entry *storage.Entry) error {
+	data, err := json.Marshal(newEntryMetadata(entry))
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(filepath.Join(filesystemStorage.entryDirectory(entry.CallReference), metadataFileName),
+		data)
+}
+
+// writeFileAtomically writes the data to a temporary file first and renames it afterwards so that incomplete files are
+// never read.
+func writeFileAtomically(path string, data []byte) error {
+	if err := ioutil.WriteFile(path+temporaryFileSuffix, data, filePermissions); err != nil {
+		return err
+	}
+	return os.Rename(path+temporaryFileSuffix, path)
+}
+
+// readMetadata reads the metadata sidecar file of the entry with the given call reference. It returns
+// storage.ErrEntryNotFound if the entry does not exist or has not been completely written yet.
+func (filesystemStorage *FilesystemStorage) readMetadata(callReference string) (*entryMetadata, error) {
+	if !validReference(callReference) {
+		return nil, storage.ErrEntryNotFound
+	}
+	data, err := ioutil.ReadFile(filepath.Join(filesystemStorage.entryDirectory(callReference), metadataFileName))
+	if os.IsNotExist(err) {
+		return nil, storage.ErrEntryNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	metadata := &entryMetadata{}
+	if err = json.Unmarshal(data, metadata); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+// Request is the implementation of the FileStorage.Request method.
+func (filesystemStorage *FilesystemStorage) Request(callReference string) (*storage.Entry, error) {
+	metadata, err := filesystemStorage.readMetadata(callReference)
+	if err != nil {
+		return nil, err
+	}
+	if entry := metadata.entry(); entry.Expired(time.Now()) || entry.DownloadsExhausted() {
+		return nil, storage.ErrEntryNotFound
+	}
+	dataFile, err := os.Open(filesystemStorage.dataPath(metadata))
+	if os.IsNotExist(err) {
+		// the entry has been deleted in the meantime
+		return nil, storage.ErrEntryNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	entry := metadata.entry()
+	entry.ID = entry.CallReference
+	entry.Reader = dataFile
+	return entry, nil
+}
+
+// Lookup is the implementation of the FileStorage.Lookup method.
+func (filesystemStorage *FilesystemStorage) Lookup(callReference string) (*storage.Entry, error) {
+	metadata, err := filesystemStorage.readMetadata(callReference)
+	if err != nil {
+		return nil, err
+	}
+	entry := metadata.entry()
+	entry.ID = entry.CallReference
+	return entry, nil
+}
+
+// Delete is the implementation of the FileStorage.Delete method.
+func (filesystemStorage *FilesystemStorage) Delete(deleteReference string) error {
+	if !validReference(deleteReference) {
+		return storage.ErrEntryNotFound
+	}
+	indexFile := filesystemStorage.deleteReferenceFile(deleteReference)
+	callReference, err := ioutil.ReadFile(indexFile)
+	if os.IsNotExist(err) {
+		return storage.ErrEntryNotFound
+	} else if err != nil {
+		return err
+	}
+	if !validReference(string(callReference)) {
+		return storage.ErrEntryNotFound
+	}
+	// incomplete entries do not have any metadata, still own their data file and are not part of the usage
+	var metadata *entryMetadata
+	var size int64
+	if metadata, err = filesystemStorage.readMetadata(string(callReference)); err == nil {
+		if info, err := os.Stat(filesystemStorage.dataPath(metadata)); err == nil {
+			size = info.Size()
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	} else if err != storage.ErrEntryNotFound {
+		return err
+	}
+	// remove the entry directory first so that a failure does not leave an entry which cannot be deleted anymore
+	if err = os.RemoveAll(filesystemStorage.entryDirectory(string(callReference))); err != nil {
+		return err
+	}
+	if metadata != nil {
+		if err = filesystemStorage.removeExpiry(metadata); err != nil {
+			return err
+		}
+	}
+	// only the call which removes the index file releases the blob and the usage of concurrently deleted entries
+	if err = os.Remove(indexFile); os.IsNotExist(err) {
+		return storage.ErrEntryNotFound
+	} else if err != nil || metadata == nil {
+		return err
+	}
+	filesystemStorage.addUsage(metadata.Author, -size)
+	if metadata.SHA256 == "" {
+		return nil
+	}
+	return filesystemStorage.releaseBlob(metadata.SHA256)
+}
+
+// List is the implementation of the FileStorage.List method. The entries are sorted by their call reference which is
+// also used as the cursor.
+func (filesystemStorage *FilesystemStorage) List(filter storage.ListFilter, cursor string, limit int) (
+	entries []*storage.Entry, nextCursor string, err error) {
+	if cursor != "" && !validReference(cursor) {
+		return nil, "", storage.ErrInvalidCursor
+	}
+	callReferences, err := filesystemStorage.callReferences()
+	if err != nil {
+		return nil, "", err
+	}
+	start := sort.SearchStrings(callReferences, cursor)
+	if start < len(callReferences) && callReferences[start] == cursor {
+		start++
+	}
+	now := time.Now()
+	for _, callReference := range callReferences[start:] {
+		metadata, err := filesystemStorage.readMetadata(callReference)
+		if err == storage.ErrEntryNotFound {
+			// skip entries which have not been completely written yet
+			continue
+		} else if err != nil {
+			return nil, "", err
+		}
+		entry := metadata.entry()
+		if !filter.Matches(entry) || entry.Expired(now) || entry.DownloadsExhausted() {
+			continue
+		}
+		if len(entries) == limit {
+			nextCursor = entries[limit-1].CallReference
+			break
+		}
+		entry.ID = entry.CallReference
+		entries = append(entries, entry)
+	}
+	return entries, nextCursor, nil
+}
+
+// ConsumeDownload is the implementation of the FileStorage.ConsumeDownload method. The updates of the metadata files
+// are serialized which means that the remaining downloads are only race-safe if the directory is not shared by
+// multiple processes.
+func (filesystemStorage *FilesystemStorage) ConsumeDownload(callReference string) (lastDownload bool, err error) {
+	filesystemStorage.downloadMutex.Lock()
+	defer filesystemStorage.downloadMutex.Unlock()
+	metadata, err := filesystemStorage.readMetadata(callReference)
+	if err != nil {
+		return false, err
+	}
+	entry := metadata.entry()
+	if entry.Expired(time.Now()) || entry.DownloadsExhausted() {
+		return false, storage.ErrEntryNotFound
+	}
+	if entry.MaximumDownloads == 0 {
+		return false, nil
+	}
+	entry.RemainingDownloads--
+	if err = filesystemStorage.writeMetadata(entry); os.IsNotExist(err) {
+		// the entry has been deleted in the meantime
+		return false, storage.ErrEntryNotFound
+	} else if err != nil {
+		return false, err
+	}
+	return entry.RemainingDownloads == 0, nil
+}
+
+// callReferences returns the sorted call references of all entries including the incomplete ones.
+func (filesystemStorage *FilesystemStorage) callReferences() ([]string, error) {
+	directory, err := os.Open(filesystemStorage.entriesDirectory)
+	if err != nil {
+		return nil, err
+	}
+	callReferences, err := directory.Readdirnames(-1)
+	directory.Close()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(callReferences)
+	return callReferences, nil
+}
+
+// Close is the implementation of the FileStorage.Close method.
+func (filesystemStorage *FilesystemStorage) Close() error {
+	// there are no open connections or handles which have to be closed
+	return nil
+}
+
+// entryDirectory returns the path of the directory which contains the data of the entry with the given call reference.
+func (filesystemStorage *FilesystemStorage) entryDirectory(callReference string) string {
+	return filepath.Join(filesystemStorage.entriesDirectory, callReference)
+}
+
+// dataPath returns the path of the file which contains the data of the entry with the given metadata. Entries which
+// have been stored before the deduplication still own the data file in their entry directory.
+func (filesystemStorage *FilesystemStorage) dataPath(metadata *entryMetadata) string {
+	if metadata.SHA256 != "" {
+		return filesystemStorage.blobFile(metadata.SHA256)
+	}
+	return filepath.Join(filesystemStorage.entryDirectory(metadata.CallReference), dataFileName)
+}
+
+// deleteReferenceFile returns the path of the index file of the given delete reference.
+func (filesystemStorage *FilesystemStorage) deleteReferenceFile(deleteReference string) string {
+	return filepath.Join(filesystemStorage.deleteReferencesDirectory, deleteReference)
+}
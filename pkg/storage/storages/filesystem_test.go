@@ -0,0 +1,187 @@
+package storages
+
+import (
+	"bytes"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFilesystemStorage(t *testing.T) {
+	directory, err := ioutil.TempDir("", "gosharexserver")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory, %T: %v", err, err)
+	}
+	defer os.RemoveAll(directory)
+	filesystemStorage := &FilesystemStorage{Directory: directory}
+	if err = filesystemStorage.Initialize(); err != nil {
+		t.Fatalf("Could not initialize filesystem storage, %T: %v", err, err)
+	}
+	defer filesystemStorage.Close()
+	testBytes := []byte("Hello, this is a test!")
+	entry := &storage.Entry{
+		Author:      storage.AuthorIdentifier("a testing person"),
+		Filename:    "testfile.png",
+		ContentType: "image/png",
+		UploadDate:  time.Now().Round(time.Second),
+	}
+	writer, err := filesystemStorage.Store(entry)
+	if err != nil {
+		t.Fatalf("Could not store entry, %T: %v", err, err)
+	}
+	// the entry must not be available before the writer is closed
+	if _, err = filesystemStorage.Request(entry.CallReference); err != storage.ErrEntryNotFound {
+		t.Fatalf("Incomplete entry could be requested, err: %v", err)
+	}
+	if _, err = writer.Write(testBytes); err != nil {
+		t.Fatalf("Could not write entry data, %T: %v", err, err)
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatalf("Could not close entry writer, %T: %v", err, err)
+	}
+	requestedEntry, err := filesystemStorage.Request(entry.CallReference)
+	if err != nil {
+		t.Fatalf("Could not request stored entry, %T: %v", err, err)
+	}
+	requestedBytes, err := ioutil.ReadAll(requestedEntry.Reader)
+	requestedEntry.Reader.Close()
+	if err != nil {
+		t.Fatalf("Could not read requested entry data, %T: %v", err, err)
+	}
+	if !bytes.Equal(testBytes, requestedBytes) {
+		t.Fatalf("Requested data %q does not match the stored data %q", requestedBytes, testBytes)
+	}
+	if requestedEntry.Author != entry.Author || requestedEntry.Filename != entry.Filename ||
+		requestedEntry.ContentType != entry.ContentType || !requestedEntry.UploadDate.Equal(entry.UploadDate) {
+		t.Fatalf("Requested entry metadata %+v does not match the stored metadata %+v", requestedEntry, entry)
+	}
+	// references containing path elements must not be resolved
+	if _, err = filesystemStorage.Request("../" + entriesDirectoryName); err != storage.ErrEntryNotFound {
+		t.Fatalf("Invalid call reference was not rejected, err: %v", err)
+	}
+	if err = filesystemStorage.Delete(entry.CallReference); err != storage.ErrEntryNotFound {
+		t.Fatalf("Entry could be deleted by its call reference, err: %v", err)
+	}
+	if err = filesystemStorage.Delete(entry.DeleteReference); err != nil {
+		t.Fatalf("Could not delete entry, %T: %v", err, err)
+	}
+	if _, err = filesystemStorage.Request(entry.CallReference); err != storage.ErrEntryNotFound {
+		t.Fatalf("Deleted entry could still be requested, err: %v", err)
+	}
+	testPresetReferencesAndList(t, filesystemStorage)
+	testExpiry(t, filesystemStorage)
+	testDownloadLimit(t, filesystemStorage)
+	testConcurrentDownloads(t, filesystemStorage)
+	testAbort(t, filesystemStorage)
+	testDeduplication(t, filesystemStorage, func() int {
+		blobs, err := filepath.Glob(filepath.Join(directory, blobsDirectoryName, "*"+jsonFileSuffix))
+		if err != nil {
+			t.Fatalf("Could not list blobs, %T: %v", err, err)
+		}
+		return len(blobs)
+	})
+	testDerivedStorage(t, filesystemStorage)
+	testUsage(t, filesystemStorage)
+	testUserStorage(t, filesystemStorage)
+}
+
+func TestFilesystemReferenceLengths(t *testing.T) {
+	directory, err := ioutil.TempDir("", "gosharexserver")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory, %T: %v", err, err)
+	}
+	defer os.RemoveAll(directory)
+	// single character call references would collide with the routes
+	invalidStorage := &FilesystemStorage{Directory: directory, ReferenceLengths: ReferenceLengths{CallReferenceLength: 1}}
+	if err = invalidStorage.Initialize(); err == nil {
+		t.Fatal("Invalid call reference length was not rejected")
+	}
+	filesystemStorage := &FilesystemStorage{Directory: directory, ReferenceLengths: ReferenceLengths{
+		CallReferenceLength:   12,
+		DeleteReferenceLength: 24,
+	}}
+	if err = filesystemStorage.Initialize(); err != nil {
+		t.Fatalf("Could not initialize filesystem storage, %T: %v", err, err)
+	}
+	defer filesystemStorage.Close()
+	entry := &storage.Entry{Filename: "long.txt", UploadDate: time.Now()}
+	writer, err := filesystemStorage.Store(entry)
+	if err != nil {
+		t.Fatalf("Could not store entry, %T: %v", err, err)
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatalf("Could not close entry writer, %T: %v", err, err)
+	}
+	if len(entry.CallReference) != 12 || len(entry.DeleteReference) != 24 {
+		t.Fatalf("Generated references %q and %q do not have the configured lengths", entry.CallReference,
+			entry.DeleteReference)
+	}
+}
+
+func TestFilesystemUsageInitialization(t *testing.T) {
+	directory, err := ioutil.TempDir("", "gosharexserver")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory, %T: %v", err, err)
+	}
+	defer os.RemoveAll(directory)
+	filesystemStorage := &FilesystemStorage{Directory: directory}
+	if err = filesystemStorage.Initialize(); err != nil {
+		t.Fatalf("Could not initialize filesystem storage, %T: %v", err, err)
+	}
+	author := storage.AuthorIdentifier("usage author")
+	writer, err := filesystemStorage.Store(&storage.Entry{Author: author, Filename: "usage.txt",
+		UploadDate: time.Now()})
+	if err != nil {
+		t.Fatalf("Could not store entry, %T: %v", err, err)
+	}
+	if _, err = writer.Write([]byte("usage data")); err != nil {
+		t.Fatalf("Could not write entry data, %T: %v", err, err)
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatalf("Could not close entry writer, %T: %v", err, err)
+	}
+	// the counters of entries which have been stored by older versions are calculated when initializing the storage
+	if err = os.Remove(filepath.Join(directory, usageFileName)); err != nil {
+		t.Fatalf("Could not remove the usage file, %T: %v", err, err)
+	}
+	if err = filesystemStorage.Initialize(); err != nil {
+		t.Fatalf("Could not initialize filesystem storage, %T: %v", err, err)
+	}
+	if usage, err := filesystemStorage.Usage(author); err != nil || usage != 10 {
+		t.Fatalf("Expected a usage of 10 bytes, got %d, err: %v", usage, err)
+	}
+}
+
+func TestFilesystemExpiriesInitialization(t *testing.T) {
+	directory, err := ioutil.TempDir("", "gosharexserver")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory, %T: %v", err, err)
+	}
+	defer os.RemoveAll(directory)
+	filesystemStorage := &FilesystemStorage{Directory: directory}
+	if err = filesystemStorage.Initialize(); err != nil {
+		t.Fatalf("Could not initialize filesystem storage, %T: %v", err, err)
+	}
+	now := time.Now()
+	writer, err := filesystemStorage.Store(&storage.Entry{Filename: "expired.txt", UploadDate: now,
+		ExpirationDate: now.Add(-time.Minute)})
+	if err != nil {
+		t.Fatalf("Could not store entry, %T: %v", err, err)
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatalf("Could not close entry writer, %T: %v", err, err)
+	}
+	// the index of entries which have been stored by older versions is created when initializing the storage
+	if err = os.RemoveAll(filepath.Join(directory, expiriesDirectoryName)); err != nil {
+		t.Fatalf("Could not remove the expiries directory, %T: %v", err, err)
+	}
+	if err = filesystemStorage.Initialize(); err != nil {
+		t.Fatalf("Could not initialize filesystem storage, %T: %v", err, err)
+	}
+	if deleted, err := filesystemStorage.DeleteExpired(now); err != nil || deleted != 1 {
+		t.Fatalf("Expected the expired entry to be deleted, got %d, err: %v", deleted, err)
+	}
+}
@@ -0,0 +1,88 @@
+package storages
+
+import (
+	"encoding/json"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// filesystemBlob is the content of the reference file of a blob which is stored next to the blob data file.
+type filesystemBlob struct {
+	References int `json:"references"`
+}
+
+// acquireBlob adds a reference to the blob with the given checksum. The written data file is moved into the blobs
+// directory if the blob does not exist yet and removed otherwise. The reference counts are only race-safe if the
+// directory is not shared by multiple processes.
+func (filesystemStorage *FilesystemStorage) acquireBlob(dataFile, checksum string) error {
+	filesystemStorage.blobMutex.Lock()
+	defer filesystemStorage.blobMutex.Unlock()
+	blob, err := filesystemStorage.readBlob(checksum)
+	if err != nil {
+		return err
+	}
+	if blob.References == 0 {
+		err = os.Rename(dataFile, filesystemStorage.blobFile(checksum))
+	} else {
+		err = os.Remove(dataFile)
+	}
+	if os.IsNotExist(err) {
+		// the entry has been deleted in the meantime
+		return storage.ErrEntryNotFound
+	} else if err != nil {
+		return err
+	}
+	blob.References++
+	return filesystemStorage.writeBlob(checksum, blob)
+}
+
+// releaseBlob removes a reference from the blob with the given checksum and deletes the blob if it was the last one.
+func (filesystemStorage *FilesystemStorage) releaseBlob(checksum string) error {
+	filesystemStorage.blobMutex.Lock()
+	defer filesystemStorage.blobMutex.Unlock()
+	blob, err := filesystemStorage.readBlob(checksum)
+	if err != nil {
+		return err
+	}
+	if blob.References--; blob.References > 0 {
+		return filesystemStorage.writeBlob(checksum, blob)
+	}
+	if err = os.Remove(filesystemStorage.blobFile(checksum)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err = os.Remove(filesystemStorage.blobFile(checksum) + jsonFileSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// readBlob reads the reference file of the blob with the given checksum. Blobs which do not exist have no references.
+func (filesystemStorage *FilesystemStorage) readBlob(checksum string) (*filesystemBlob, error) {
+	blob := &filesystemBlob{}
+	data, err := ioutil.ReadFile(filesystemStorage.blobFile(checksum) + jsonFileSuffix)
+	if os.IsNotExist(err) {
+		return blob, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, blob); err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
+
+// writeBlob writes the reference file of the blob with the given checksum.
+func (filesystemStorage *FilesystemStorage) writeBlob(checksum string, blob *filesystemBlob) error {
+	data, err := json.Marshal(blob)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(filesystemStorage.blobFile(checksum)+jsonFileSuffix, data)
+}
+
+// blobFile returns the path of the data file of the blob with the given checksum.
+func (filesystemStorage *FilesystemStorage) blobFile(checksum string) string {
+	return filepath.Join(filesystemStorage.blobsDirectory, checksum)
+}
@@ -0,0 +1,46 @@
+package storages
+
+import (
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// derivedFilePrefix is the prefix of the derived data files inside of the entry directories.
+const derivedFilePrefix = "derived_"
+
+// StoreDerived is the implementation of the DerivedStorage.StoreDerived method. The derived data is stored inside of
+// the entry directory and therefore removed together with it.
+func (filesystemStorage *FilesystemStorage) StoreDerived(callReference, name string, data []byte) error {
+	if !validReference(name) {
+		return errInvalidDerivedName
+	}
+	if _, err := filesystemStorage.readMetadata(callReference); err != nil {
+		return err
+	}
+	err := writeFileAtomically(filesystemStorage.derivedFile(callReference, name), data)
+	if os.IsNotExist(err) {
+		// the entry has been deleted in the meantime
+		return storage.ErrEntryNotFound
+	}
+	return err
+}
+
+// RequestDerived is the implementation of the DerivedStorage.RequestDerived method.
+func (filesystemStorage *FilesystemStorage) RequestDerived(callReference, name string) ([]byte, error) {
+	if !validReference(callReference) || !validReference(name) {
+		return nil, storage.ErrEntryNotFound
+	}
+	data, err := ioutil.ReadFile(filesystemStorage.derivedFile(callReference, name))
+	if os.IsNotExist(err) {
+		return nil, storage.ErrEntryNotFound
+	}
+	return data, err
+}
+
+// derivedFile returns the path of the derived data file with the given name of the entry with the given call
+// reference.
+func (filesystemStorage *FilesystemStorage) derivedFile(callReference, name string) string {
+	return filepath.Join(filesystemStorage.entryDirectory(callReference), derivedFilePrefix+name)
+}
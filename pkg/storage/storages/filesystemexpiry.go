@@ -0,0 +1,114 @@
+package storages
+
+import (
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// expiriesDirectoryName is the name of the directory in the root directory which contains the expiry index. Every
+// expiring entry has an index file which is named after its expiry key and contains its delete reference.
+const expiriesDirectoryName = "expiries"
+
+// DeleteExpired is the implementation of the FileStorage.DeleteExpired method. Only the names of the expiry index files
+// are read until the first entry which has not expired yet, the metadata files are not read.
+func (filesystemStorage *FilesystemStorage) DeleteExpired(now time.Time) (deleted int, err error) {
+	directory, err := os.Open(filesystemStorage.expiriesDirectory)
+	if err != nil {
+		return 0, err
+	}
+	keys, err := directory.Readdirnames(-1)
+	directory.Close()
+	if err != nil {
+		return 0, err
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		expirationDate, _, ok := parseExpiryKey(key)
+		if !ok || strings.HasSuffix(key, temporaryFileSuffix) {
+			continue
+		} else if expirationDate.After(now) {
+			break
+		}
+		indexFile := filepath.Join(filesystemStorage.expiriesDirectory, key)
+		deleteReference, err := ioutil.ReadFile(indexFile)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return deleted, err
+		}
+		if err = filesystemStorage.Delete(string(deleteReference)); err == nil {
+			deleted++
+		} else if err != storage.ErrEntryNotFound {
+			return deleted, err
+		}
+		// the index files of entries which have been deleted before they were completed are left behind
+		if err = os.Remove(indexFile); err != nil && !os.IsNotExist(err) {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// writeExpiry writes the expiry index file of the given entry if it expires.
+func (filesystemStorage *FilesystemStorage) writeExpiry(entry *storage.Entry) error {
+	if entry.ExpirationDate.IsZero() {
+		return nil
+	}
+	return writeFileAtomically(filepath.Join(filesystemStorage.expiriesDirectory,
+		expiryKey(entry.ExpirationDate, entry.CallReference)), []byte(entry.DeleteReference))
+}
+
+// removeExpiry removes the expiry index file of the entry with the given metadata if it expires.
+func (filesystemStorage *FilesystemStorage) removeExpiry(metadata *entryMetadata) error {
+	if metadata.ExpirationDate.IsZero() {
+		return nil
+	}
+	err := os.Remove(filepath.Join(filesystemStorage.expiriesDirectory,
+		expiryKey(metadata.ExpirationDate, metadata.CallReference)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// initializeExpiries creates the expiry index from the stored entries if the expiries directory does not exist yet,
+// e.g. because the entries have been stored by an older version. The index is written to a temporary directory first
+// so that an interrupted initialization is repeated.
+func (filesystemStorage *FilesystemStorage) initializeExpiries() error {
+	if _, err := os.Stat(filesystemStorage.expiriesDirectory); err == nil || !os.IsNotExist(err) {
+		return err
+	}
+	temporaryDirectory := filesystemStorage.expiriesDirectory + temporaryFileSuffix
+	if err := os.RemoveAll(temporaryDirectory); err != nil {
+		return err
+	}
+	if err := os.Mkdir(temporaryDirectory, directoryPermissions); err != nil {
+		return err
+	}
+	callReferences, err := filesystemStorage.callReferences()
+	if err != nil {
+		return err
+	}
+	for _, callReference := range callReferences {
+		metadata, err := filesystemStorage.readMetadata(callReference)
+		if err == storage.ErrEntryNotFound {
+			// skip entries which have not been completely written yet
+			continue
+		} else if err != nil {
+			return err
+		} else if metadata.ExpirationDate.IsZero() {
+			continue
+		}
+		if err = ioutil.WriteFile(filepath.Join(temporaryDirectory,
+			expiryKey(metadata.ExpirationDate, metadata.CallReference)), []byte(metadata.DeleteReference),
+			filePermissions); err != nil {
+			return err
+		}
+	}
+	return os.Rename(temporaryDirectory, filesystemStorage.expiriesDirectory)
+}
@@ -0,0 +1,100 @@
+package storages
+
+import (
+	"encoding/json"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// usageFileName is the name of the file in the root directory which contains the usage counters of all authors.
+const usageFileName = "usage.json"
+
+// Usage is the implementation of the UsageStorage.Usage method. The usage is read from the counters which are updated
+// whenever an entry is completed or deleted.
+func (filesystemStorage *FilesystemStorage) Usage(author storage.AuthorIdentifier) (int64, error) {
+	filesystemStorage.usageMutex.Lock()
+	defer filesystemStorage.usageMutex.Unlock()
+	counters, err := filesystemStorage.readUsage()
+	if err != nil {
+		return 0, err
+	}
+	return counters[string(author)], nil
+}
+
+// addUsage adds the given amount of bytes to the usage counter of the author. The counters are only race-safe if the
+// directory is not shared by multiple processes. Failures are only logged because the entry itself has already been
+// stored or deleted.
+func (filesystemStorage *FilesystemStorage) addUsage(author string, size int64) {
+	filesystemStorage.usageMutex.Lock()
+	defer filesystemStorage.usageMutex.Unlock()
+	counters, err := filesystemStorage.readUsage()
+	if err == nil {
+		counters.add(author, size)
+		err = filesystemStorage.writeUsage(counters)
+	}
+	if err != nil {
+		log.Printf("Could not update the usage of %v, %T: %v\n", strconv.Quote(author), err, err)
+	}
+}
+
+// initializeUsage calculates the usage counters from the stored entries if the usage file does not exist yet, e.g.
+// because the entries have been stored by an older version.
+func (filesystemStorage *FilesystemStorage) initializeUsage() error {
+	if _, err := os.Stat(filesystemStorage.usageFile()); err == nil || !os.IsNotExist(err) {
+		return err
+	}
+	callReferences, err := filesystemStorage.callReferences()
+	if err != nil {
+		return err
+	}
+	counters := make(usageCounters)
+	for _, callReference := range callReferences {
+		metadata, err := filesystemStorage.readMetadata(callReference)
+		if err == storage.ErrEntryNotFound {
+			// skip entries which have not been completely written yet
+			continue
+		} else if err != nil {
+			return err
+		}
+		info, err := os.Stat(filesystemStorage.dataPath(metadata))
+		if os.IsNotExist(err) {
+			// the entry has been deleted in the meantime
+			continue
+		} else if err != nil {
+			return err
+		}
+		counters.add(metadata.Author, info.Size())
+	}
+	return filesystemStorage.writeUsage(counters)
+}
+
+// readUsage reads the usage counters of all authors. The caller has to hold the usageMutex.
+func (filesystemStorage *FilesystemStorage) readUsage() (usageCounters, error) {
+	data, err := ioutil.ReadFile(filesystemStorage.usageFile())
+	if err != nil {
+		return nil, err
+	}
+	counters := make(usageCounters)
+	if err = json.Unmarshal(data, &counters); err != nil {
+		return nil, err
+	}
+	return counters, nil
+}
+
+// writeUsage writes the usage counters of all authors. The caller has to hold the usageMutex.
+func (filesystemStorage *FilesystemStorage) writeUsage(counters usageCounters) error {
+	data, err := json.Marshal(counters)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(filesystemStorage.usageFile(), data)
+}
+
+// usageFile returns the path of the file which contains the usage counters.
+func (filesystemStorage *FilesystemStorage) usageFile() string {
+	return filepath.Join(filesystemStorage.Directory, usageFileName)
+}
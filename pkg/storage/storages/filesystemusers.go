@@ -0,0 +1,207 @@
+package storages
+
+import (
+	"encoding/json"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// CreateUser is the implementation of the UserStorage.CreateUser method. Every user is stored in its own JSON file.
+func (filesystemStorage *FilesystemStorage) CreateUser(user *storage.User) error {
+	if !storage.ValidUsername(user.Name) {
+		return storage.ErrInvalidUsername
+	}
+	data, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	userFile, err := os.OpenFile(filesystemStorage.userFile(user.Name), os.O_WRONLY|os.O_CREATE|os.O_EXCL,
+		filePermissions)
+	if os.IsExist(err) {
+		return storage.ErrUserExists
+	} else if err != nil {
+		return err
+	}
+	_, err = userFile.Write(data)
+	if closeErr := userFile.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// RequestUser is the implementation of the UserStorage.RequestUser method.
+func (filesystemStorage *FilesystemStorage) RequestUser(name string) (*storage.User, error) {
+	if !storage.ValidUsername(name) {
+		return nil, storage.ErrUserNotFound
+	}
+	user := &storage.User{}
+	if err := readJSONFile(filesystemStorage.userFile(name), user); os.IsNotExist(err) {
+		return nil, storage.ErrUserNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ListUsers is the implementation of the UserStorage.ListUsers method.
+func (filesystemStorage *FilesystemStorage) ListUsers() (users []*storage.User, err error) {
+	fileNames, err := readJSONFileNames(filesystemStorage.usersDirectory)
+	if err != nil {
+		return nil, err
+	}
+	for _, fileName := range fileNames {
+		user := &storage.User{}
+		if err = readJSONFile(filepath.Join(filesystemStorage.usersDirectory, fileName), user); os.IsNotExist(err) {
+			// the user has been deleted in the meantime
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Name < users[j].Name
+	})
+	return users, nil
+}
+
+// HasUsers is the implementation of the UserStorage.HasUsers method.
+func (filesystemStorage *FilesystemStorage) HasUsers() (bool, error) {
+	fileNames, err := readJSONFileNames(filesystemStorage.usersDirectory)
+	if err != nil {
+		return false, err
+	}
+	return len(fileNames) > 0, nil
+}
+
+// DeleteUser is the implementation of the UserStorage.DeleteUser method.
+func (filesystemStorage *FilesystemStorage) DeleteUser(name string) error {
+	if !storage.ValidUsername(name) {
+		return storage.ErrUserNotFound
+	}
+	if err := os.Remove(filesystemStorage.userFile(name)); os.IsNotExist(err) {
+		return storage.ErrUserNotFound
+	} else if err != nil {
+		return err
+	}
+	tokens, err := filesystemStorage.ListTokens(name)
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		if err = os.Remove(filesystemStorage.tokenFile(token.Hash)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// StoreToken is the implementation of the UserStorage.StoreToken method. Every token is stored in its own JSON file
+// which is named after the hash of the token.
+func (filesystemStorage *FilesystemStorage) StoreToken(token *storage.Token) error {
+	if !validReference(token.Hash) {
+		return errInvalidReference
+	}
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(filesystemStorage.tokenFile(token.Hash), data)
+}
+
+// RequestToken is the implementation of the UserStorage.RequestToken method.
+func (filesystemStorage *FilesystemStorage) RequestToken(hash string) (*storage.Token, error) {
+	if !validReference(hash) {
+		return nil, storage.ErrTokenNotFound
+	}
+	token := &storage.Token{}
+	if err := readJSONFile(filesystemStorage.tokenFile(hash), token); os.IsNotExist(err) {
+		return nil, storage.ErrTokenNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// ListTokens is the implementation of the UserStorage.ListTokens method.
+func (filesystemStorage *FilesystemStorage) ListTokens(username string) (tokens []*storage.Token, err error) {
+	fileNames, err := readJSONFileNames(filesystemStorage.tokensDirectory)
+	if err != nil {
+		return nil, err
+	}
+	for _, fileName := range fileNames {
+		token := &storage.Token{}
+		if err = readJSONFile(filepath.Join(filesystemStorage.tokensDirectory, fileName), token); os.IsNotExist(err) {
+			// the token has been revoked in the meantime
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if token.Username == username {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+// RevokeToken is the implementation of the UserStorage.RevokeToken method.
+func (filesystemStorage *FilesystemStorage) RevokeToken(id string) error {
+	fileNames, err := readJSONFileNames(filesystemStorage.tokensDirectory)
+	if err != nil {
+		return err
+	}
+	for _, fileName := range fileNames {
+		token := &storage.Token{}
+		if err = readJSONFile(filepath.Join(filesystemStorage.tokensDirectory, fileName), token); os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if token.ID == id {
+			return os.Remove(filesystemStorage.tokenFile(token.Hash))
+		}
+	}
+	return storage.ErrTokenNotFound
+}
+
+// userFile returns the path of the file which contains the user with the given name.
+func (filesystemStorage *FilesystemStorage) userFile(name string) string {
+	return filepath.Join(filesystemStorage.usersDirectory, name+jsonFileSuffix)
+}
+
+// tokenFile returns the path of the file which contains the token with the given hash.
+func (filesystemStorage *FilesystemStorage) tokenFile(hash string) string {
+	return filepath.Join(filesystemStorage.tokensDirectory, hash+jsonFileSuffix)
+}
+
+// readJSONFile reads the file and unmarshals its JSON content into the given value.
+func readJSONFile(path string, value interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+// readJSONFileNames returns the names of all JSON files in the directory. Temporary files are skipped.
+func readJSONFileNames(path string) (fileNames []string, err error) {
+	directory, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	names, err := directory.Readdirnames(-1)
+	directory.Close()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if strings.HasSuffix(name, jsonFileSuffix) {
+			fileNames = append(fileNames, name)
+		}
+	}
+	return fileNames, nil
+}
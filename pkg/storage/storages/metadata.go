@@ -0,0 +1,63 @@
+package storages
+
+import (
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"time"
+)
+
+// entryMetadata is the serializable form of the storage.Entry metadata. It is used by storages which do not have their
+// own document format (e.g. the FilesystemStorage).
+type entryMetadata struct {
+	CallReference   string    `json:"call_reference"`
+	DeleteReference string    `json:"delete_reference"`
+	Author          string    `json:"author"`
+	Filename        string    `json:"filename"`
+	ContentType     string    `json:"content_type"`
+	UploadDate      time.Time `json:"upload_date"`
+	ExpirationDate  time.Time `json:"expiration_date"`
+	// the download limit fields are omitted for the majority of the entries which can be downloaded unlimited times
+	MaximumDownloads   int    `json:"maximum_downloads,omitempty"`
+	RemainingDownloads int    `json:"remaining_downloads,omitempty"`
+	PasswordHash       string `json:"password_hash,omitempty"`
+	EncryptionKeyID    string `json:"encryption_key_id,omitempty"`
+	SHA256             string `json:"sha256,omitempty"`
+	MD5                string `json:"md5,omitempty"`
+}
+
+// newEntryMetadata copies the metadata of the given entry into a new entryMetadata instance.
+func newEntryMetadata(entry *storage.Entry) *entryMetadata {
+	return &entryMetadata{
+		CallReference:      entry.CallReference,
+		DeleteReference:    entry.DeleteReference,
+		Author:             string(entry.Author),
+		Filename:           entry.Filename,
+		ContentType:        entry.ContentType,
+		UploadDate:         entry.UploadDate,
+		ExpirationDate:     entry.ExpirationDate,
+		MaximumDownloads:   entry.MaximumDownloads,
+		RemainingDownloads: entry.RemainingDownloads,
+		PasswordHash:       entry.PasswordHash,
+		EncryptionKeyID:    entry.EncryptionKeyID,
+		SHA256:             entry.SHA256,
+		MD5:                entry.MD5,
+	}
+}
+
+// entry creates a new storage.Entry from the metadata. The ID and Reader fields are left empty.
+func (metadata *entryMetadata) entry() *storage.Entry {
+	return &storage.Entry{
+		CallReference:      metadata.CallReference,
+		DeleteReference:    metadata.DeleteReference,
+		Author:             storage.AuthorIdentifier(metadata.Author),
+		Filename:           metadata.Filename,
+		ContentType:        metadata.ContentType,
+		UploadDate:         metadata.UploadDate,
+		ExpirationDate:     metadata.ExpirationDate,
+		MaximumDownloads:   metadata.MaximumDownloads,
+		RemainingDownloads: metadata.RemainingDownloads,
+		PasswordHash:       metadata.PasswordHash,
+		EncryptionKeyID:    metadata.EncryptionKeyID,
+		SHA256:             metadata.SHA256,
+		MD5:                metadata.MD5,
+	}
+}
@@ -1,36 +1,65 @@
 package storages
 
 import (
-	"bytes"
-	cryptRand "crypto/rand"
 	"fmt"
 	"github.com/kataras/iris/core/errors"
 	"github.com/mmichaelb/gosharexserver/pkg/storage"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 	"io"
-	"log"
-	"math/big"
-	mathRand "math/rand"
+	"time"
 )
 
 const (
-	// Data to generate new call/delete references
-	referenceChars        = "abcdefghijklmnopqrstuvxyzABCDEFGHIJKLMNOPQRSTUVXYZ1234567890"
-	callReferenceLength   = 6
-	deleteReferenceLength = 16
 	// MongoDB index names
-	referenceIndexName = "reference_index"
+	referenceIndexName   = "reference_index"
+	authorIndexName      = "author_index"
+	contentTypeIndexName = "content_type_index"
+	uploadDateIndexName  = "upload_date_index"
+	expirationIndexName  = "expiration_date_index"
+	sha256IndexName      = "sha256_index"
+	derivedIndexName     = "derived_index"
+	userNameIndexName    = "name_index"
+	tokenHashIndexName   = "hash_index"
+	tokenIDIndexName     = "id_index"
+	tokenUserIndexName   = "username_index"
+	// MongoDB collection names
+	usersCollectionName  = "users"
+	tokensCollectionName = "tokens"
+	// suffix of the GridFS prefix which contains the reference counted file data
+	blobsGridFSSuffix = "_blobs"
+	// suffix of the GridFS prefix which is the name of the derived data collection
+	derivedCollectionSuffix = "_derived"
 	// MongoDB key names
-	iDField              = "_id"
-	metadataField        = "metadata"
-	callReferenceField   = "call_reference"
-	deleteReferenceField = "delete_reference"
-	authorField          = "author"
-	metadataFieldScheme  = "%s.%s"
+	iDField                 = "_id"
+	metadataField           = "metadata"
+	callReferenceField      = "call_reference"
+	deleteReferenceField    = "delete_reference"
+	authorField             = "author"
+	expirationDateField     = "expiration_date"
+	maximumDownloadsField   = "maximum_downloads"
+	remainingDownloadsField = "remaining_downloads"
+	passwordHashField       = "password_hash"
+	encryptionKeyIDField    = "encryption_key_id"
+	sha256Field             = "sha256"
+	md5Field                = "md5"
+	dataIDField             = "data_id"
+	sizeField               = "size"
+	referencesField         = "references"
+	entryIDField            = "entry_id"
+	nameField               = "name"
+	dataField               = "data"
+	metadataFieldScheme     = "%s.%s"
+	// GridFS file document key names
+	filenameField    = "filename"
+	lengthField      = "length"
+	contentTypeField = "contentType"
+	uploadDateField  = "uploadDate"
 )
 
-// MongoStorage is the FileStorage implementation using MongoDB GridFS.
+// MongoStorage is the FileStorage implementation using MongoDB GridFS. The file data is stored in a separate GridFS
+// whose files are reference counted and shared by entries with identical data. The GridFS files of the entries only
+// contain the data of entries which have been stored before the deduplication.
 type MongoStorage struct {
 	// MongoDB Database
 	Database *mgo.Database
@@ -38,13 +67,24 @@ type MongoStorage struct {
 	GridFSPrefix string
 	// GridFS prefix name
 	GridFSChunkSize int
+	// ReferenceLengths are the lengths of the generated references.
+	ReferenceLengths
 	// internal values
-	gridFS *mgo.GridFS
+	gridFS  *mgo.GridFS
+	blobs   *mgo.GridFS
+	derived *mgo.Collection
+	users   *mgo.Collection
+	tokens  *mgo.Collection
 }
 
 // Initialize is the implementation of the FileStorage.Initialize method.
 func (mongoStorage *MongoStorage) Initialize() (err error) {
+	if err = mongoStorage.ReferenceLengths.validate(); err != nil {
+		return
+	}
 	mongoStorage.gridFS = mongoStorage.Database.GridFS(mongoStorage.GridFSPrefix)
+	mongoStorage.blobs = mongoStorage.Database.GridFS(mongoStorage.GridFSPrefix + blobsGridFSSuffix)
+	mongoStorage.derived = mongoStorage.Database.C(mongoStorage.GridFSPrefix + derivedCollectionSuffix)
 	// check whether db/collection exists
 	collectionNames, err := mongoStorage.Database.CollectionNames()
 	if err != nil {
@@ -71,79 +111,94 @@ collectionNameCheck:
 			}
 		}
 	}
-	return
+	// ensure the indexes which are used to filter entries in the List method
+	for _, index := range []mgo.Index{
+		{Name: authorIndexName, Key: []string{fmt.Sprintf(metadataFieldScheme, metadataField, authorField), iDField}},
+		{Name: contentTypeIndexName, Key: []string{contentTypeField, iDField}},
+		{Name: uploadDateIndexName, Key: []string{uploadDateField}},
+		{Name: expirationIndexName, Key: []string{fmt.Sprintf(metadataFieldScheme, metadataField, expirationDateField)},
+			Sparse: true},
+	} {
+		if err = fileCollection.EnsureIndex(index); err != nil {
+			return
+		}
+	}
+	if err = mongoStorage.blobs.Files.EnsureIndex(mgo.Index{
+		Name: sha256IndexName,
+		Key:  []string{fmt.Sprintf(metadataFieldScheme, metadataField, sha256Field)},
+	}); err != nil {
+		return
+	}
+	if err = mongoStorage.derived.EnsureIndex(mgo.Index{
+		Name:   derivedIndexName,
+		Key:    []string{entryIDField, nameField},
+		Unique: true,
+	}); err != nil {
+		return
+	}
+	if err = mongoStorage.initializeUsage(); err != nil {
+		return
+	}
+	return mongoStorage.initializeUsers()
 }
 
 // Store is the implementation of the FileStorage.Store method.
 func (mongoStorage *MongoStorage) Store(entry *storage.Entry) (writer io.WriteCloser, err error) {
-	// insert the file details into the collection
-	gridFile, err := mongoStorage.gridFS.Create(entry.Filename)
+	// keep preset references or generate new ones
+	if entry.CallReference, err = presetOrNewReference(entry.CallReference, mongoStorage.CallReferenceLength, func(reference string) (bool, error) {
+		return mongoStorage.checkForDuplicate(fmt.Sprintf(metadataFieldScheme, metadataField, callReferenceField), reference)
+	}); err != nil {
+		return nil, err
+	}
+	if entry.DeleteReference, err = presetOrNewReference(entry.DeleteReference, mongoStorage.DeleteReferenceLength, func(reference string) (bool, error) {
+		return mongoStorage.checkForDuplicate(fmt.Sprintf(metadataFieldScheme, metadataField, deleteReferenceField), reference)
+	}); err != nil {
+		return nil, err
+	}
+	// the data is written to a new blob, the entry document is inserted once the data has been written
+	dataFile, err := mongoStorage.blobs.Create(entry.Filename)
 	if err != nil {
 		return nil, err
 	}
-	// set values
-	entry.ID = gridFile.Id()
-	entry.CallReference = mongoStorage.newCallReference()
-	entry.DeleteReference = mongoStorage.newDeleteReference()
-	gridFile.SetChunkSize(mongoStorage.GridFSChunkSize)
-	gridFile.SetContentType(entry.ContentType)
-	gridFile.SetUploadDate(entry.UploadDate)
-	gridFile.SetMeta(bson.M{
+	dataFile.SetChunkSize(mongoStorage.GridFSChunkSize)
+	dataFile.SetContentType(entry.ContentType)
+	entry.ID = bson.NewObjectId()
+	return &mongoWriter{
+		mongoStorage: mongoStorage,
+		entry:        entry,
+		dataFile:     dataFile,
+		checksum:     newChecksumHash(),
+	}, nil
+}
+
+// newMongoMetadata returns the metadata of the GridFS file document of the given entry whose data is stored in the blob
+// with the given id and size.
+func newMongoMetadata(entry *storage.Entry, dataID interface{}, size int64) bson.M {
+	metadata := bson.M{
 		authorField:          entry.Author,
 		callReferenceField:   entry.CallReference,
 		deleteReferenceField: entry.DeleteReference,
-	})
-	return gridFile, nil
-}
-
-// method which randomly creates a new call reference
-func (mongoStorage *MongoStorage) newCallReference() (callReference string) {
-	buf := bytes.NewBuffer([]byte{})
-	randomMaximum := big.NewInt(int64(len(referenceChars)))
-	for i := 0; i < callReferenceLength; i++ {
-		var randomIndex int
-		randomIntIndex, err := cryptRand.Int(cryptRand.Reader, randomMaximum)
-		if err != nil {
-			log.Printf("Could not get create random call reference with crypto/rand. "+
-				"Falling back to (insecure) math/rand package. %T: %v\n", err, err)
-			randomIndex = mathRand.Intn(len(referenceChars))
-		} else {
-			randomIndex = int(randomIntIndex.Int64())
-		}
-		buf.WriteString(referenceChars[randomIndex : randomIndex+1])
+		sha256Field:          entry.SHA256,
+		dataIDField:          dataID,
+		sizeField:            size,
 	}
-	callReference = buf.String()
-	if duplicate, err := mongoStorage.checkForDuplicate(fmt.Sprintf(metadataFieldScheme, metadataField, callReferenceField), callReference); err != nil {
-		fmt.Printf("Could not check for duplicates: %v\n", err.Error())
-	} else if duplicate {
-		return mongoStorage.newCallReference()
+	if !entry.ExpirationDate.IsZero() {
+		metadata[expirationDateField] = entry.ExpirationDate
 	}
-	return buf.String()
-}
-
-//method which randomly creates a new delete reference
-func (mongoStorage *MongoStorage) newDeleteReference() (deleteReference string) {
-	buf := bytes.NewBuffer([]byte{})
-	randomMaximum := big.NewInt(int64(len(referenceIndexName)))
-	for i := 0; i < deleteReferenceLength; i++ {
-		var randomIndex int
-		randomIntIndex, err := cryptRand.Int(cryptRand.Reader, randomMaximum)
-		if err != nil {
-			log.Printf("Could not get create random call reference with crypto/rand. "+
-				"Falling back to (insecure) math/rand package. %T: %v\n", err, err)
-			randomIndex = mathRand.Intn(len(referenceIndexName))
-		} else {
-			randomIndex = int(randomIntIndex.Int64())
-		}
-		buf.WriteString(referenceIndexName[randomIndex : randomIndex+1])
+	if entry.MaximumDownloads > 0 {
+		metadata[maximumDownloadsField] = entry.MaximumDownloads
+		metadata[remainingDownloadsField] = entry.RemainingDownloads
+	}
+	if entry.PasswordHash != "" {
+		metadata[passwordHashField] = entry.PasswordHash
 	}
-	deleteReference = buf.String()
-	if duplicate, err := mongoStorage.checkForDuplicate(fmt.Sprintf(metadataFieldScheme, metadataField, deleteReferenceField), deleteReference); err != nil {
-		fmt.Printf("Could not check for duplicates: %v\n", err.Error())
-	} else if duplicate {
-		return mongoStorage.newDeleteReference()
+	if entry.EncryptionKeyID != "" {
+		metadata[encryptionKeyIDField] = entry.EncryptionKeyID
 	}
-	return deleteReference
+	if entry.MD5 != "" {
+		metadata[md5Field] = entry.MD5
+	}
+	return metadata
 }
 
 // checkForDuplicate returns whether the value is already present in the remote database.
@@ -158,44 +213,150 @@ func (mongoStorage *MongoStorage) checkForDuplicate(path, value string) (bool, e
 // Request is the implementation of the Storage.Request method
 func (mongoStorage *MongoStorage) Request(callReference string) (*storage.Entry, error) {
 	// read result to a simple bson map
-	result := &bson.M{}
+	result := bson.M{}
 	// find the entry by its call reference
-	if err := mongoStorage.gridFS.Find(bson.M{fmt.Sprintf(metadataFieldScheme, metadataField, callReferenceField): callReference}).One(result); err == mgo.ErrNotFound {
+	query := availableQuery(time.Now())
+	query[fmt.Sprintf(metadataFieldScheme, metadataField, callReferenceField)] = callReference
+	if err := mongoStorage.gridFS.Find(query).One(&result); err == mgo.ErrNotFound {
 		// return error that entry was not found
 		return nil, storage.ErrEntryNotFound
 	} else if err != nil {
 		// return unwrapped error because something gone horrifically wrong
 		return nil, err
 	}
-	id := storage.ID((*result)[iDField])
-	gridFile, err := mongoStorage.gridFS.OpenId(id)
+	// set all entry values except for the reader
+	entry, err := parseEntry(result)
+	if err != nil {
+		return nil, err
+	}
+	var gridFile *mgo.GridFile
+	if dataID, ok := result[metadataField].(bson.M)[dataIDField]; ok {
+		gridFile, err = mongoStorage.blobs.OpenId(dataID)
+	} else {
+		// the entry has been stored before the deduplication
+		gridFile, err = mongoStorage.gridFS.OpenId(entry.ID)
+	}
 	if err != nil {
 		// an error occurred while opening the GridFile
 		return nil, err
 	}
-	// set all entry values except for the reader
-	metadata, ok := (*result)[metadataField].(bson.M)
+	entry.Reader = gridFile
+	return entry, nil
+}
+
+// Lookup is the implementation of the Storage.Lookup method
+func (mongoStorage *MongoStorage) Lookup(callReference string) (*storage.Entry, error) {
+	result := bson.M{}
+	query := bson.M{fmt.Sprintf(metadataFieldScheme, metadataField, callReferenceField): callReference}
+	if err := mongoStorage.gridFS.Find(query).One(&result); err == mgo.ErrNotFound {
+		return nil, storage.ErrEntryNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return parseEntry(result)
+}
+
+// parseEntry parses the entry values from the given GridFS file document. The Reader field is left empty.
+func parseEntry(document bson.M) (*storage.Entry, error) {
+	metadata, ok := document[metadataField].(bson.M)
 	if !ok {
 		return nil, errors.New("could not parse metadata field from GridFS document")
 	}
-	entry := &storage.Entry{
-		ID:            id,
-		CallReference: metadata[callReferenceField].(string),
-		Author:        storage.AuthorIdentifier(metadata[authorField].(string)),
-		Filename:      gridFile.Name(),
-		ContentType:   gridFile.ContentType(),
-		UploadDate:    gridFile.UploadDate(),
-		Reader:        gridFile,
-	}
+	entry := &storage.Entry{ID: storage.ID(document[iDField])}
+	entry.CallReference, _ = metadata[callReferenceField].(string)
+	entry.DeleteReference, _ = metadata[deleteReferenceField].(string)
+	author, _ := metadata[authorField].(string)
+	entry.Author = storage.AuthorIdentifier(author)
+	entry.Filename, _ = document[filenameField].(string)
+	entry.ContentType, _ = document[contentTypeField].(string)
+	entry.UploadDate, _ = document[uploadDateField].(time.Time)
+	entry.ExpirationDate, _ = metadata[expirationDateField].(time.Time)
+	entry.MaximumDownloads, _ = metadata[maximumDownloadsField].(int)
+	entry.RemainingDownloads, _ = metadata[remainingDownloadsField].(int)
+	entry.PasswordHash, _ = metadata[passwordHashField].(string)
+	entry.EncryptionKeyID, _ = metadata[encryptionKeyIDField].(string)
+	entry.SHA256, _ = metadata[sha256Field].(string)
+	entry.MD5, _ = metadata[md5Field].(string)
 	return entry, nil
 }
 
+// notExpiredQuery returns a query which only matches entries without an expiration date or with an expiration date
+// after the given time.
+func notExpiredQuery(now time.Time) bson.M {
+	expirationDatePath := fmt.Sprintf(metadataFieldScheme, metadataField, expirationDateField)
+	return bson.M{"$or": []bson.M{
+		{expirationDatePath: bson.M{"$exists": false}},
+		{expirationDatePath: bson.M{"$gt": now}},
+	}}
+}
+
+// availableQuery returns a query which only matches entries which have not expired at the given time and whose
+// downloads are not exhausted.
+func availableQuery(now time.Time) bson.M {
+	remainingDownloadsPath := fmt.Sprintf(metadataFieldScheme, metadataField, remainingDownloadsField)
+	return bson.M{"$and": []bson.M{
+		notExpiredQuery(now),
+		{"$or": []bson.M{
+			{remainingDownloadsPath: bson.M{"$exists": false}},
+			{remainingDownloadsPath: bson.M{"$gt": 0}},
+		}},
+	}}
+}
+
+// List is the implementation of the Storage.List method. The entries are sorted by their ObjectId which is also used
+// as the cursor.
+func (mongoStorage *MongoStorage) List(filter storage.ListFilter, cursor string, limit int) (entries []*storage.Entry,
+	nextCursor string, err error) {
+	query := availableQuery(time.Now())
+	if filter.Author != "" {
+		query[fmt.Sprintf(metadataFieldScheme, metadataField, authorField)] = filter.Author
+	}
+	if filter.ContentType != "" {
+		query[contentTypeField] = filter.ContentType
+	}
+	uploadDateQuery := bson.M{}
+	if !filter.UploadedAfter.IsZero() {
+		uploadDateQuery["$gt"] = filter.UploadedAfter
+	}
+	if !filter.UploadedBefore.IsZero() {
+		uploadDateQuery["$lt"] = filter.UploadedBefore
+	}
+	if len(uploadDateQuery) > 0 {
+		query[uploadDateField] = uploadDateQuery
+	}
+	if cursor != "" {
+		if !bson.IsObjectIdHex(cursor) {
+			return nil, "", storage.ErrInvalidCursor
+		}
+		query[iDField] = bson.M{"$gt": bson.ObjectIdHex(cursor)}
+	}
+	// request one more document to find out whether there are more entries
+	var documents []bson.M
+	if err = mongoStorage.gridFS.Files.Find(query).Sort(iDField).Limit(limit + 1).All(&documents); err != nil {
+		return nil, "", err
+	}
+	if len(documents) > limit {
+		documents = documents[:limit]
+		if id, ok := documents[limit-1][iDField].(bson.ObjectId); ok {
+			nextCursor = id.Hex()
+		}
+	}
+	for _, document := range documents {
+		entry, err := parseEntry(document)
+		if err != nil {
+			return nil, "", err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nextCursor, nil
+}
+
 // Delete is the implementation of the Storage.Delete method
 func (mongoStorage *MongoStorage) Delete(deleteReference string) (err error) {
 	// initiate result instance
 	result := &bson.M{}
 	// find id and return not found if the entry could not be found
-	if err = mongoStorage.gridFS.Files.Find(bson.M{fmt.Sprintf(metadataFieldScheme, metadataField, deleteReferenceField): deleteReference}).Select(bson.M{iDField: 1}).One(result); err == mgo.ErrNotFound {
+	if err = mongoStorage.gridFS.Files.Find(bson.M{fmt.Sprintf(metadataFieldScheme, metadataField, deleteReferenceField): deleteReference}).Select(entryDeletionFields).One(result); err == mgo.ErrNotFound {
 		// return error that entry was not found
 		return storage.ErrEntryNotFound
 	} else if err != nil {
@@ -203,7 +364,7 @@ func (mongoStorage *MongoStorage) Delete(deleteReference string) (err error) {
 		return
 	}
 	// delete entry by its deleteReference
-	if err = mongoStorage.gridFS.RemoveId((*result)[iDField]); err == mgo.ErrNotFound {
+	if err = mongoStorage.removeEntry(*result); err == mgo.ErrNotFound {
 		// return error that entry was not found
 		return storage.ErrEntryNotFound
 	} else if err != nil {
@@ -213,9 +374,140 @@ func (mongoStorage *MongoStorage) Delete(deleteReference string) (err error) {
 	return nil
 }
 
+// entryDeletionFields selects the fields of the GridFS file documents which are required by the removeEntry method.
+var entryDeletionFields = bson.M{iDField: 1, fmt.Sprintf(metadataFieldScheme, metadataField, dataIDField): 1}
+
+// removeEntry removes the GridFS file of the given entry document and releases its blob. Only the call which removed
+// the document releases the blob, mgo.ErrNotFound is returned if the document has already been removed.
+func (mongoStorage *MongoStorage) removeEntry(document bson.M) error {
+	// the chunks only exist for entries which have been stored before the deduplication
+	if err := mongoStorage.gridFS.RemoveId(document[iDField]); err != nil {
+		return err
+	}
+	if _, err := mongoStorage.derived.RemoveAll(bson.M{entryIDField: document[iDField]}); err != nil {
+		return err
+	}
+	metadata, _ := document[metadataField].(bson.M)
+	if dataID, ok := metadata[dataIDField]; ok {
+		return mongoStorage.releaseBlob(dataID)
+	}
+	return nil
+}
+
+// DeleteExpired is the implementation of the Storage.DeleteExpired method
+func (mongoStorage *MongoStorage) DeleteExpired(now time.Time) (deleted int, err error) {
+	var documents []bson.M
+	if err = mongoStorage.gridFS.Files.Find(bson.M{
+		fmt.Sprintf(metadataFieldScheme, metadataField, expirationDateField): bson.M{"$lte": now},
+	}).Select(entryDeletionFields).All(&documents); err != nil {
+		return 0, err
+	}
+	for _, document := range documents {
+		if err = mongoStorage.removeEntry(document); err == mgo.ErrNotFound {
+			// the entry has been deleted in the meantime
+			continue
+		} else if err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// ConsumeDownload is the implementation of the Storage.ConsumeDownload method. The remaining downloads are decremented
+// via an atomic findAndModify command which makes concurrent downloads race-safe.
+func (mongoStorage *MongoStorage) ConsumeDownload(callReference string) (lastDownload bool, err error) {
+	callReferencePath := fmt.Sprintf(metadataFieldScheme, metadataField, callReferenceField)
+	remainingDownloadsPath := fmt.Sprintf(metadataFieldScheme, metadataField, remainingDownloadsField)
+	query := notExpiredQuery(time.Now())
+	query[callReferencePath] = callReference
+	query[remainingDownloadsPath] = bson.M{"$gt": 0}
+	result := bson.M{}
+	if _, err = mongoStorage.gridFS.Files.Find(query).Apply(mgo.Change{
+		Update:    bson.M{"$inc": bson.M{remainingDownloadsPath: -1}},
+		ReturnNew: true,
+	}, &result); err == nil {
+		entry, err := parseEntry(result)
+		if err != nil {
+			return false, err
+		}
+		return entry.RemainingDownloads == 0, nil
+	} else if err != mgo.ErrNotFound {
+		return false, err
+	}
+	// the entry does not exist, its downloads are not limited or they are exhausted
+	delete(query, remainingDownloadsPath)
+	if err = mongoStorage.gridFS.Files.Find(query).One(&result); err == mgo.ErrNotFound {
+		return false, storage.ErrEntryNotFound
+	} else if err != nil {
+		return false, err
+	}
+	entry, err := parseEntry(result)
+	if err != nil {
+		return false, err
+	}
+	if entry.MaximumDownloads > 0 {
+		return false, storage.ErrEntryNotFound
+	}
+	return false, nil
+}
+
 // Close is the implementation of the Storage.Close method
 func (mongoStorage *MongoStorage) Close() error {
 	// logout from Mongo database and revoke sent credentials
 	mongoStorage.Database.Logout()
 	return nil
 }
+
+// mongoWriter writes the file data into a new blob. Closing the writer replaces the blob by an existing one with the
+// same checksum and inserts the GridFS file document of the entry.
+type mongoWriter struct {
+	mongoStorage *MongoStorage
+	entry        *storage.Entry
+	dataFile     *mgo.GridFile
+	checksum     checksumHash
+}
+
+// Write is the implementation of the io.Writer interface method.
+func (writer *mongoWriter) Write(p []byte) (n int, err error) {
+	n, err = writer.dataFile.Write(p)
+	writer.checksum.Write(p[:n])
+	return
+}
+
+// Close is the implementation of the io.Closer interface method.
+func (writer *mongoWriter) Close() error {
+	mongoStorage := writer.mongoStorage
+	entry := writer.entry
+	entry.SHA256 = writer.checksum.String()
+	writer.dataFile.SetMeta(bson.M{sha256Field: entry.SHA256, referencesField: 0})
+	if err := writer.dataFile.Close(); err != nil {
+		return err
+	}
+	dataID, err := mongoStorage.acquireBlob(entry.SHA256, writer.dataFile.Id())
+	if err != nil {
+		return err
+	}
+	gridFile, err := mongoStorage.gridFS.Create(entry.Filename)
+	if err == nil {
+		gridFile.SetId(entry.ID)
+		gridFile.SetContentType(entry.ContentType)
+		gridFile.SetUploadDate(entry.UploadDate)
+		gridFile.SetMeta(newMongoMetadata(entry, dataID, writer.dataFile.Size()))
+		err = gridFile.Close()
+	}
+	if err != nil {
+		mongoStorage.releaseBlob(dataID)
+		return err
+	}
+	return nil
+}
+
+// Abort is the implementation of the storage.AbortableWriter interface method. The entry document is only inserted
+// when closing the writer, so only the written chunks of the data file have to be removed.
+func (writer *mongoWriter) Abort() error {
+	writer.dataFile.Abort()
+	// closing the aborted file removes its chunks, the returned error only reports the abort
+	writer.dataFile.Close()
+	return nil
+}
@@ -0,0 +1,52 @@
+package storages
+
+import (
+	"gopkg.in/mgo.v2"
+	"os"
+	"testing"
+)
+
+// mongoTestURLVariable is the environment variable which contains the URL of the MongoDB server the MongoDB storage is
+// tested against. The test is skipped if it is not set.
+const mongoTestURLVariable = "GOSHAREXSERVER_TEST_MONGODB_URL"
+
+func TestMongoStorage(t *testing.T) {
+	url := os.Getenv(mongoTestURLVariable)
+	if url == "" {
+		t.Skipf("The %s environment variable is not set", mongoTestURLVariable)
+	}
+	session, err := mgo.Dial(url)
+	if err != nil {
+		t.Fatalf("Could not connect to MongoDB, %T: %v", err, err)
+	}
+	defer session.Close()
+	// every test run uses its own database which is dropped afterwards
+	database := session.DB("gosharexserver_test_" + randomReference(8))
+	mongoStorage := &MongoStorage{
+		Database:        database,
+		GridFSPrefix:    "fs",
+		GridFSChunkSize: 4,
+	}
+	if err = mongoStorage.Initialize(); err != nil {
+		t.Fatalf("Could not initialize MongoDB storage, %T: %v", err, err)
+	}
+	defer func() {
+		database.DropDatabase()
+		mongoStorage.Close()
+	}()
+	testPresetReferencesAndList(t, mongoStorage)
+	testExpiry(t, mongoStorage)
+	testDownloadLimit(t, mongoStorage)
+	testConcurrentDownloads(t, mongoStorage)
+	testAbort(t, mongoStorage)
+	testDeduplication(t, mongoStorage, func() int {
+		blobs, err := mongoStorage.blobs.Files.Count()
+		if err != nil {
+			t.Fatalf("Could not count the blobs, %T: %v", err, err)
+		}
+		return blobs
+	})
+	testDerivedStorage(t, mongoStorage)
+	testUsage(t, mongoStorage)
+	testUserStorage(t, mongoStorage)
+}
@@ -0,0 +1,52 @@
+package storages
+
+import (
+	"fmt"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// acquireBlob adds a reference to an existing blob with the given checksum and removes the written blob. The written
+// blob is referenced instead if there is no such blob. It returns the id of the referenced blob. Blobs without any
+// references are never referenced again which makes the reference counts race-safe.
+func (mongoStorage *MongoStorage) acquireBlob(checksum string, writtenID interface{}) (interface{}, error) {
+	referencesPath := fmt.Sprintf(metadataFieldScheme, metadataField, referencesField)
+	result := bson.M{}
+	if _, err := mongoStorage.blobs.Files.Find(bson.M{
+		fmt.Sprintf(metadataFieldScheme, metadataField, sha256Field): checksum,
+		referencesPath: bson.M{"$gt": 0},
+		iDField:        bson.M{"$ne": writtenID},
+	}).Select(bson.M{iDField: 1}).Apply(mgo.Change{
+		Update: bson.M{"$inc": bson.M{referencesPath: 1}},
+	}, &result); err == nil {
+		if err = mongoStorage.blobs.RemoveId(writtenID); err != nil {
+			return nil, err
+		}
+		return result[iDField], nil
+	} else if err != mgo.ErrNotFound {
+		return nil, err
+	}
+	if err := mongoStorage.blobs.Files.UpdateId(writtenID, bson.M{"$inc": bson.M{referencesPath: 1}}); err != nil {
+		return nil, err
+	}
+	return writtenID, nil
+}
+
+// releaseBlob removes a reference from the blob with the given id and removes the blob if it was the last one.
+func (mongoStorage *MongoStorage) releaseBlob(dataID interface{}) error {
+	referencesPath := fmt.Sprintf(metadataFieldScheme, metadataField, referencesField)
+	result := bson.M{}
+	if _, err := mongoStorage.blobs.Files.FindId(dataID).Apply(mgo.Change{
+		Update:    bson.M{"$inc": bson.M{referencesPath: -1}},
+		ReturnNew: true,
+	}, &result); err == mgo.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	metadata, _ := result[metadataField].(bson.M)
+	if references, _ := metadata[referencesField].(int); references > 0 {
+		return nil
+	}
+	return mongoStorage.blobs.RemoveId(dataID)
+}
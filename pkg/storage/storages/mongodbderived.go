@@ -0,0 +1,53 @@
+package storages
+
+import (
+	"fmt"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// StoreDerived is the implementation of the DerivedStorage.StoreDerived method. The derived data is stored in a
+// separate collection and references the GridFS file document of the entry.
+func (mongoStorage *MongoStorage) StoreDerived(callReference, name string, data []byte) error {
+	if !validReference(name) {
+		return errInvalidDerivedName
+	}
+	id, err := mongoStorage.entryID(callReference)
+	if err != nil {
+		return err
+	}
+	_, err = mongoStorage.derived.Upsert(bson.M{entryIDField: id, nameField: name}, bson.M{
+		"$set": bson.M{dataField: data},
+	})
+	return err
+}
+
+// RequestDerived is the implementation of the DerivedStorage.RequestDerived method.
+func (mongoStorage *MongoStorage) RequestDerived(callReference, name string) ([]byte, error) {
+	id, err := mongoStorage.entryID(callReference)
+	if err != nil {
+		return nil, err
+	}
+	result := bson.M{}
+	if err = mongoStorage.derived.Find(bson.M{entryIDField: id, nameField: name}).One(&result); err == mgo.ErrNotFound {
+		return nil, storage.ErrEntryNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	data, _ := result[dataField].([]byte)
+	return data, nil
+}
+
+// entryID returns the id of the GridFS file document of the entry with the given call reference.
+func (mongoStorage *MongoStorage) entryID(callReference string) (interface{}, error) {
+	result := bson.M{}
+	if err := mongoStorage.gridFS.Files.Find(bson.M{
+		fmt.Sprintf(metadataFieldScheme, metadataField, callReferenceField): callReference,
+	}).Select(bson.M{iDField: 1}).One(&result); err == mgo.ErrNotFound {
+		return nil, storage.ErrEntryNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return result[iDField], nil
+}
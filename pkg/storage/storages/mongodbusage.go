@@ -0,0 +1,73 @@
+package storages
+
+import (
+	"fmt"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Usage is the implementation of the UsageStorage.Usage method. The sizes of the entries which are found via the author
+// index are summed up by the database.
+func (mongoStorage *MongoStorage) Usage(author storage.AuthorIdentifier) (int64, error) {
+	result := bson.M{}
+	err := mongoStorage.gridFS.Files.Pipe([]bson.M{
+		{"$match": bson.M{fmt.Sprintf(metadataFieldScheme, metadataField, authorField): author}},
+		{"$group": bson.M{iDField: nil, sizeField: bson.M{
+			"$sum": "$" + fmt.Sprintf(metadataFieldScheme, metadataField, sizeField),
+		}}},
+	}).One(&result)
+	if err == mgo.ErrNotFound {
+		// the author does not have any entries
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	usage, _ := mongoInt64(result[sizeField])
+	return usage, nil
+}
+
+// initializeUsage adds the sizes to the metadata of the entries which have been stored before the sizes were added, so
+// that the usage can be summed up by the database. The size is taken from the length of their blob or GridFS file.
+func (mongoStorage *MongoStorage) initializeUsage() error {
+	sizePath := fmt.Sprintf(metadataFieldScheme, metadataField, sizeField)
+	iter := mongoStorage.gridFS.Files.Find(bson.M{sizePath: bson.M{"$exists": false}}).Select(bson.M{
+		lengthField: 1,
+		fmt.Sprintf(metadataFieldScheme, metadataField, dataIDField): 1,
+	}).Iter()
+	document := bson.M{}
+	for iter.Next(&document) {
+		// entries which have been stored before the deduplication contain the data themselves
+		size, _ := mongoInt64(document[lengthField])
+		metadata, _ := document[metadataField].(bson.M)
+		if dataID, ok := metadata[dataIDField]; ok {
+			blob := bson.M{}
+			if err := mongoStorage.blobs.Files.FindId(dataID).Select(bson.M{lengthField: 1}).One(&blob); err != nil &&
+				err != mgo.ErrNotFound {
+				iter.Close()
+				return err
+			}
+			size, _ = mongoInt64(blob[lengthField])
+		}
+		if err := mongoStorage.gridFS.Files.UpdateId(document[iDField],
+			bson.M{"$set": bson.M{sizePath: size}}); err != nil && err != mgo.ErrNotFound {
+			iter.Close()
+			return err
+		}
+		document = bson.M{}
+	}
+	return iter.Close()
+}
+
+// mongoInt64 converts the given integer value of a document to an int64. The driver decodes integers either as int or
+// as int64 depending on their BSON type.
+func mongoInt64(value interface{}) (int64, bool) {
+	switch value := value.(type) {
+	case int:
+		return int64(value), true
+	case int64:
+		return value, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,124 @@
+package storages
+
+import (
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// MongoDB key names of the user and token documents
+	userNameField      = "name"
+	tokenHashField     = "hash"
+	tokenIDField       = "id"
+	tokenUsernameField = "username"
+)
+
+// initializeUsers sets up the collections of the users and tokens and ensures their indexes.
+func (mongoStorage *MongoStorage) initializeUsers() error {
+	mongoStorage.users = mongoStorage.Database.C(usersCollectionName)
+	mongoStorage.tokens = mongoStorage.Database.C(tokensCollectionName)
+	if err := mongoStorage.users.EnsureIndex(mgo.Index{
+		Name:   userNameIndexName,
+		Key:    []string{userNameField},
+		Unique: true,
+	}); err != nil {
+		return err
+	}
+	for _, index := range []mgo.Index{
+		{Name: tokenHashIndexName, Key: []string{tokenHashField}, Unique: true},
+		{Name: tokenIDIndexName, Key: []string{tokenIDField}, Unique: true},
+		{Name: tokenUserIndexName, Key: []string{tokenUsernameField}},
+	} {
+		if err := mongoStorage.tokens.EnsureIndex(index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateUser is the implementation of the UserStorage.CreateUser method.
+func (mongoStorage *MongoStorage) CreateUser(user *storage.User) error {
+	if !storage.ValidUsername(user.Name) {
+		return storage.ErrInvalidUsername
+	}
+	if err := mongoStorage.users.Insert(user); mgo.IsDup(err) {
+		return storage.ErrUserExists
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// RequestUser is the implementation of the UserStorage.RequestUser method.
+func (mongoStorage *MongoStorage) RequestUser(name string) (*storage.User, error) {
+	user := &storage.User{}
+	if err := mongoStorage.users.Find(bson.M{userNameField: name}).One(user); err == mgo.ErrNotFound {
+		return nil, storage.ErrUserNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ListUsers is the implementation of the UserStorage.ListUsers method.
+func (mongoStorage *MongoStorage) ListUsers() (users []*storage.User, err error) {
+	if err = mongoStorage.users.Find(nil).Sort(userNameField).All(&users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// HasUsers is the implementation of the UserStorage.HasUsers method.
+func (mongoStorage *MongoStorage) HasUsers() (bool, error) {
+	count, err := mongoStorage.users.Find(nil).Limit(1).Count()
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// DeleteUser is the implementation of the UserStorage.DeleteUser method.
+func (mongoStorage *MongoStorage) DeleteUser(name string) error {
+	if err := mongoStorage.users.Remove(bson.M{userNameField: name}); err == mgo.ErrNotFound {
+		return storage.ErrUserNotFound
+	} else if err != nil {
+		return err
+	}
+	_, err := mongoStorage.tokens.RemoveAll(bson.M{tokenUsernameField: name})
+	return err
+}
+
+// StoreToken is the implementation of the UserStorage.StoreToken method.
+func (mongoStorage *MongoStorage) StoreToken(token *storage.Token) error {
+	return mongoStorage.tokens.Insert(token)
+}
+
+// RequestToken is the implementation of the UserStorage.RequestToken method.
+func (mongoStorage *MongoStorage) RequestToken(hash string) (*storage.Token, error) {
+	token := &storage.Token{}
+	if err := mongoStorage.tokens.Find(bson.M{tokenHashField: hash}).One(token); err == mgo.ErrNotFound {
+		return nil, storage.ErrTokenNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// ListTokens is the implementation of the UserStorage.ListTokens method.
+func (mongoStorage *MongoStorage) ListTokens(username string) (tokens []*storage.Token, err error) {
+	if err = mongoStorage.tokens.Find(bson.M{tokenUsernameField: username}).All(&tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// RevokeToken is the implementation of the UserStorage.RevokeToken method.
+func (mongoStorage *MongoStorage) RevokeToken(id string) error {
+	if err := mongoStorage.tokens.Remove(bson.M{tokenIDField: id}); err == mgo.ErrNotFound {
+		return storage.ErrTokenNotFound
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
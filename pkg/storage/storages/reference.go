@@ -0,0 +1,124 @@
+package storages
+
+import (
+	"bytes"
+	cryptRand "crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"log"
+	"math/big"
+	mathRand "math/rand"
+)
+
+const (
+	// Data to generate new call/delete references
+	referenceChars               = "abcdefghijklmnopqrstuvxyzABCDEFGHIJKLMNOPQRSTUVXYZ1234567890"
+	defaultCallReferenceLength   = 6
+	defaultDeleteReferenceLength = 16
+	// MinimumReferenceLength is the minimum length of generated references. Single character call references would
+	// collide with the routes of the router (e.g. "/e").
+	MinimumReferenceLength = 2
+	// MaximumReferenceLength is the maximum length of generated references which keeps them usable as file names.
+	MaximumReferenceLength = 128
+)
+
+// errInvalidReference is returned by the storages if a preset reference contains invalid characters.
+var errInvalidReference = errors.New("invalid reference")
+
+// ReferenceLengths holds the lengths of the references which are generated for new entries. It is embedded by all
+// storages. Longer call references make it harder to guess the links of entries. Changing the lengths only affects new
+// entries, the references of existing entries are kept.
+type ReferenceLengths struct {
+	// CallReferenceLength is the length of the generated call references. It defaults to 6 characters.
+	CallReferenceLength int
+	// DeleteReferenceLength is the length of the generated delete references. It defaults to 16 characters.
+	DeleteReferenceLength int
+}
+
+// validate sets the default lengths and returns an error if one of the lengths is out of range.
+func (lengths *ReferenceLengths) validate() error {
+	if lengths.CallReferenceLength == 0 {
+		lengths.CallReferenceLength = defaultCallReferenceLength
+	}
+	if lengths.DeleteReferenceLength == 0 {
+		lengths.DeleteReferenceLength = defaultDeleteReferenceLength
+	}
+	for _, length := range []int{lengths.CallReferenceLength, lengths.DeleteReferenceLength} {
+		if length < MinimumReferenceLength || length > MaximumReferenceLength {
+			return fmt.Errorf("the reference length %d is not between %d and %d", length, MinimumReferenceLength,
+				MaximumReferenceLength)
+		}
+	}
+	return nil
+}
+
+// duplicateCheck returns whether the given reference is already in use by another entry.
+type duplicateCheck func(reference string) (bool, error)
+
+// newReference randomly creates a new reference with the given length. It creates new ones until the provided
+// duplicateCheck reports an unused one.
+func newReference(length int, isDuplicate duplicateCheck) string {
+	for {
+		reference := randomReference(length)
+		if duplicate, err := isDuplicate(reference); err != nil {
+			log.Printf("Could not check for duplicates: %v\n", err.Error())
+			return reference
+		} else if !duplicate {
+			return reference
+		}
+	}
+}
+
+// presetOrNewReference returns the preset reference if it is set or a new one otherwise. Preset references have to be
+// valid and unused, otherwise errInvalidReference or storage.ErrReferenceInUse is returned along with the unchanged
+// preset.
+func presetOrNewReference(preset string, length int, isDuplicate duplicateCheck) (string, error) {
+	if preset == "" {
+		return newReference(length, isDuplicate), nil
+	}
+	if !validReference(preset) {
+		return preset, errInvalidReference
+	}
+	if duplicate, err := isDuplicate(preset); err != nil {
+		return preset, err
+	} else if duplicate {
+		return preset, storage.ErrReferenceInUse
+	}
+	return preset, nil
+}
+
+// randomReference randomly creates a new reference with the given length without checking for duplicates.
+func randomReference(length int) string {
+	buf := bytes.NewBuffer([]byte{})
+	randomMaximum := big.NewInt(int64(len(referenceChars)))
+	for i := 0; i < length; i++ {
+		var randomIndex int
+		randomIntIndex, err := cryptRand.Int(cryptRand.Reader, randomMaximum)
+		if err != nil {
+			log.Printf("Could not get create random reference with crypto/rand. "+
+				"Falling back to (insecure) math/rand package. %T: %v\n", err, err)
+			randomIndex = mathRand.Intn(len(referenceChars))
+		} else {
+			randomIndex = int(randomIntIndex.Int64())
+		}
+		buf.WriteString(referenceChars[randomIndex : randomIndex+1])
+	}
+	return buf.String()
+}
+
+// validReference returns whether the given reference only consists of ASCII letters, digits, underscores and dashes.
+// This includes all generated references as well as the ones created by older versions. Storages which use references
+// as e.g. file names should check them before using them.
+func validReference(reference string) bool {
+	if reference == "" {
+		return false
+	}
+	for _, char := range reference {
+		if !(char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z' || char >= '0' && char <= '9' ||
+			char == '_' || char == '-') {
+			return false
+		}
+	}
+	return true
+}
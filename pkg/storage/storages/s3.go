@@ -0,0 +1,591 @@
+package storages
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/minio/minio-go"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// S3 object key prefixes
+	s3DataPrefix            = "data/"
+	s3BlobsPrefix           = "blobs/"
+	s3DerivedPrefix         = "derived/"
+	s3MetadataPrefix        = "metadata/"
+	s3DeleteReferencePrefix = "delete_references/"
+	s3UsersPrefix           = "users/"
+	s3TokensPrefix          = "tokens/"
+	// S3 error codes
+	s3NoSuchKeyCode = "NoSuchKey"
+	// minimum size of a multipart upload part which is allowed by S3
+	minimumS3PartSize = 5 << 20
+	metadataMimeType  = "application/json"
+	// maximum amount of keys which are listed with a single request
+	s3ListPageSize = 1000
+	// length of the random keys of the data objects, the call references can not be used because the data object may
+	// outlive its entry if other entries share it
+	s3DataKeyLength = 32
+)
+
+// S3Storage is the FileStorage implementation using a S3 compatible object storage (e.g. Amazon S3, MinIO or Ceph
+// RGW). Every entry consists of a data object which is uploaded via a multipart upload, a metadata object and a small
+// index object which resolves the delete reference. The data objects are read via ranged requests which means that
+// seeking does not download the whole object. Identical data objects are only stored once and reference counted by
+// blob objects which are named after the checksum of the data.
+type S3Storage struct {
+	// Client is the MinIO client which is connected to the S3 compatible API.
+	Client *minio.Client
+	// Bucket is the name of the bucket which contains all objects.
+	Bucket string
+	// Location is the region the bucket is created in if it does not exist yet.
+	Location string
+	// Prefix is prepended to all object keys and allows to share the bucket with other applications.
+	Prefix string
+	// PartSize is the size of a single multipart upload part in bytes. The minimum (and default) size is 5 MiB.
+	PartSize int
+	// ReferenceLengths are the lengths of the generated references.
+	ReferenceLengths
+	// internal values
+	core minio.Core
+	// downloadMutex serializes the updates of the remaining downloads
+	downloadMutex sync.Mutex
+	// blobMutex serializes the updates of the blob reference counts
+	blobMutex sync.Mutex
+	// deletions contains the delete references of the running deletions
+	deletions      map[string]bool
+	deletionsMutex sync.Mutex
+	// usageMutex serializes the updates of the usage counters
+	usageMutex sync.Mutex
+}
+
+// s3Metadata is the content of the metadata object of an entry.
+type s3Metadata struct {
+	entryMetadata
+	// Size is the size of the data object in bytes.
+	Size int64 `json:"size"`
+	// DataKey is the key of the data object without the prefix. It is empty for entries which have been stored before
+	// the deduplication and use the data object named after their call reference.
+	DataKey string `json:"data_key,omitempty"`
+}
+
+// s3Reservation is the content of the object which reserves the delete reference of an entry. The key of the data
+// object is recorded before any data is written so that the data of incomplete entries can be removed as well.
+type s3Reservation struct {
+	CallReference string `json:"call_reference"`
+	// DataKey is the key of the written data object without the prefix. It is empty if the data object is owned by a
+	// blob or the entry has been stored before the data keys were recorded.
+	DataKey string `json:"data_key,omitempty"`
+}
+
+// parseS3Reservation parses the content of a reservation object. The reservations of entries which have been stored
+// before the data keys were recorded only contain the call reference.
+func parseS3Reservation(data []byte) *s3Reservation {
+	reservation := &s3Reservation{}
+	if err := json.Unmarshal(data, reservation); err != nil || reservation.CallReference == "" {
+		return &s3Reservation{CallReference: string(data)}
+	}
+	return reservation
+}
+
+// dataKey returns the key of the data object of the entry without the prefix.
+func (metadata *s3Metadata) dataKey() string {
+	if metadata.DataKey != "" {
+		return metadata.DataKey
+	}
+	return s3DataPrefix + metadata.CallReference
+}
+
+// Initialize is the implementation of the FileStorage.Initialize method. It creates the bucket if it does not exist
+// and calculates the usage counters if they do not exist yet.
+func (s3Storage *S3Storage) Initialize() (err error) {
+	if err = s3Storage.ReferenceLengths.validate(); err != nil {
+		return
+	}
+	if s3Storage.PartSize < minimumS3PartSize {
+		s3Storage.PartSize = minimumS3PartSize
+	}
+	s3Storage.core = minio.Core{Client: s3Storage.Client}
+	exists, err := s3Storage.Client.BucketExists(s3Storage.Bucket)
+	if err != nil {
+		return
+	} else if !exists {
+		if err = s3Storage.Client.MakeBucket(s3Storage.Bucket, s3Storage.Location); err != nil {
+			return
+		}
+	}
+	if err = s3Storage.initializeUsage(); err != nil {
+		return
+	}
+	return s3Storage.initializeExpiries()
+}
+
+// Store is the implementation of the FileStorage.Store method.
+func (s3Storage *S3Storage) Store(entry *storage.Entry) (writer io.WriteCloser, err error) {
+	// keep preset references or generate new ones
+	if entry.CallReference, err = presetOrNewReference(entry.CallReference, s3Storage.CallReferenceLength,
+		s3Storage.s3DuplicateCheck(s3MetadataPrefix)); err != nil {
+		return nil, err
+	}
+	if entry.DeleteReference, err = presetOrNewReference(entry.DeleteReference, s3Storage.DeleteReferenceLength,
+		s3Storage.s3DuplicateCheck(s3DeleteReferencePrefix)); err != nil {
+		return nil, err
+	}
+	// reserve the delete reference, the entry becomes available once the metadata object is written
+	dataKey := s3DataPrefix + randomReference(s3DataKeyLength)
+	if err = s3Storage.putJSONObject(s3DeleteReferencePrefix+entry.DeleteReference, &s3Reservation{
+		CallReference: entry.CallReference,
+		DataKey:       dataKey,
+	}); err != nil {
+		return nil, err
+	}
+	entry.ID = entry.CallReference
+	return &s3Writer{
+		s3Storage: s3Storage,
+		entry:     entry,
+		key:       s3Storage.Prefix + dataKey,
+		checksum:  newChecksumHash(),
+	}, nil
+}
+
+// s3DuplicateCheck returns a duplicateCheck which checks whether an object with the reference exists.
+func (s3Storage *S3Storage) s3DuplicateCheck(keyPrefix string) duplicateCheck {
+	return func(reference string) (bool, error) {
+		_, err := s3Storage.Client.StatObject(s3Storage.Bucket, s3Storage.Prefix+keyPrefix+reference,
+			minio.StatObjectOptions{})
+		if err == nil {
+			return true, nil
+		} else if minio.ToErrorResponse(err).Code == s3NoSuchKeyCode {
+			return false, nil
+		}
+		return false, err
+	}
+}
+
+// putObject uploads the given data with a single request.
+func (s3Storage *S3Storage) putObject(key string, data []byte, contentType string) error {
+	_, err := s3Storage.core.PutObject(s3Storage.Bucket, s3Storage.Prefix+key, bytes.NewReader(data),
+		int64(len(data)), "", "", map[string]string{"Content-Type": contentType}, nil)
+	return err
+}
+
+// getObject downloads the whole object. It returns storage.ErrEntryNotFound if the object does not exist.
+func (s3Storage *S3Storage) getObject(key string) ([]byte, error) {
+	reader, _, err := s3Storage.core.GetObject(s3Storage.Bucket, s3Storage.Prefix+key, minio.GetObjectOptions{})
+	if minio.ToErrorResponse(err).Code == s3NoSuchKeyCode {
+		return nil, storage.ErrEntryNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+// Request is the implementation of the FileStorage.Request method.
+func (s3Storage *S3Storage) Request(callReference string) (*storage.Entry, error) {
+	if !validReference(callReference) {
+		return nil, storage.ErrEntryNotFound
+	}
+	data, err := s3Storage.getObject(s3MetadataPrefix + callReference)
+	if err != nil {
+		return nil, err
+	}
+	metadata := &s3Metadata{}
+	if err = json.Unmarshal(data, metadata); err != nil {
+		return nil, err
+	}
+	entry := metadata.entry()
+	if entry.Expired(time.Now()) || entry.DownloadsExhausted() {
+		return nil, storage.ErrEntryNotFound
+	}
+	entry.ID = entry.CallReference
+	entry.Reader = &s3Reader{
+		s3Storage: s3Storage,
+		key:       s3Storage.Prefix + metadata.dataKey(),
+		size:      metadata.Size,
+	}
+	return entry, nil
+}
+
+// Lookup is the implementation of the FileStorage.Lookup method.
+func (s3Storage *S3Storage) Lookup(callReference string) (*storage.Entry, error) {
+	if !validReference(callReference) {
+		return nil, storage.ErrEntryNotFound
+	}
+	metadata := &s3Metadata{}
+	if err := s3Storage.getJSONObject(s3MetadataPrefix+callReference, metadata); err != nil {
+		return nil, err
+	}
+	entry := metadata.entry()
+	entry.ID = entry.CallReference
+	return entry, nil
+}
+
+// Delete is the implementation of the FileStorage.Delete method. Concurrent deletions of the same entry within this
+// process result in storage.ErrEntryNotFound so that its blob is released only once.
+func (s3Storage *S3Storage) Delete(deleteReference string) error {
+	if !validReference(deleteReference) {
+		return storage.ErrEntryNotFound
+	}
+	if !s3Storage.startDeletion(deleteReference) {
+		return storage.ErrEntryNotFound
+	}
+	defer s3Storage.finishDeletion(deleteReference)
+	data, err := s3Storage.getObject(s3DeleteReferencePrefix + deleteReference)
+	if err != nil {
+		return err
+	}
+	reservation := parseS3Reservation(data)
+	metadataKey := s3MetadataPrefix + reservation.CallReference
+	metadata := &s3Metadata{}
+	complete := true
+	if err = s3Storage.getJSONObject(metadataKey, metadata); err == storage.ErrEntryNotFound {
+		// the upload of the entry has not been completed, the data object may have been written nevertheless
+		metadata.CallReference = reservation.CallReference
+		metadata.DataKey = reservation.DataKey
+		complete = false
+	} else if err != nil {
+		return err
+	}
+	// remove the metadata first which makes the entry unavailable immediately
+	if err = s3Storage.Client.RemoveObject(s3Storage.Bucket, s3Storage.Prefix+metadataKey); err != nil {
+		return err
+	}
+	if complete {
+		s3Storage.addUsage(metadata.Author, -metadata.Size)
+		if err = s3Storage.removeExpiry(metadata); err != nil {
+			return err
+		}
+	}
+	if err = s3Storage.removeDerived(reservation.CallReference); err != nil {
+		return err
+	}
+	if metadata.SHA256 != "" {
+		err = s3Storage.releaseBlob(metadata.SHA256)
+	} else {
+		err = s3Storage.Client.RemoveObject(s3Storage.Bucket, s3Storage.Prefix+metadata.dataKey())
+	}
+	if err != nil {
+		return err
+	}
+	return s3Storage.Client.RemoveObject(s3Storage.Bucket, s3Storage.Prefix+s3DeleteReferencePrefix+deleteReference)
+}
+
+// startDeletion marks the deletion of the entry with the given delete reference as running. It returns false if the
+// entry is already being deleted.
+func (s3Storage *S3Storage) startDeletion(deleteReference string) bool {
+	s3Storage.deletionsMutex.Lock()
+	defer s3Storage.deletionsMutex.Unlock()
+	if s3Storage.deletions[deleteReference] {
+		return false
+	}
+	if s3Storage.deletions == nil {
+		s3Storage.deletions = make(map[string]bool)
+	}
+	s3Storage.deletions[deleteReference] = true
+	return true
+}
+
+// finishDeletion marks the deletion of the entry with the given delete reference as finished.
+func (s3Storage *S3Storage) finishDeletion(deleteReference string) {
+	s3Storage.deletionsMutex.Lock()
+	defer s3Storage.deletionsMutex.Unlock()
+	delete(s3Storage.deletions, deleteReference)
+}
+
+// List is the implementation of the FileStorage.List method. The entries are sorted by their call reference which is
+// also used as the cursor. The metadata objects are filtered manually because S3 does not offer metadata queries.
+func (s3Storage *S3Storage) List(filter storage.ListFilter, cursor string, limit int) (entries []*storage.Entry,
+	nextCursor string, err error) {
+	if cursor != "" && !validReference(cursor) {
+		return nil, "", storage.ErrInvalidCursor
+	}
+	keyPrefix := s3Storage.Prefix + s3MetadataPrefix
+	startAfter := ""
+	if cursor != "" {
+		startAfter = keyPrefix + cursor
+	}
+	now := time.Now()
+	for {
+		// request one more key to find out whether there is a next page
+		result, err := s3Storage.core.ListObjectsV2(s3Storage.Bucket, keyPrefix, "", false, "", limit+1, startAfter)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, object := range result.Contents {
+			startAfter = object.Key
+			data, err := s3Storage.getObject(strings.TrimPrefix(object.Key, s3Storage.Prefix))
+			if err == storage.ErrEntryNotFound {
+				// the entry has been deleted in the meantime
+				continue
+			} else if err != nil {
+				return nil, "", err
+			}
+			metadata := &s3Metadata{}
+			if err = json.Unmarshal(data, metadata); err != nil {
+				return nil, "", err
+			}
+			entry := metadata.entry()
+			if !filter.Matches(entry) || entry.Expired(now) || entry.DownloadsExhausted() {
+				continue
+			}
+			if len(entries) == limit {
+				return entries, entries[limit-1].CallReference, nil
+			}
+			entry.ID = entry.CallReference
+			entries = append(entries, entry)
+		}
+		if !result.IsTruncated {
+			return entries, "", nil
+		}
+	}
+}
+
+// ConsumeDownload is the implementation of the FileStorage.ConsumeDownload method. S3 does not offer conditional
+// writes which means that the updates of the metadata objects are only serialized within this process.
+func (s3Storage *S3Storage) ConsumeDownload(callReference string) (lastDownload bool, err error) {
+	if !validReference(callReference) {
+		return false, storage.ErrEntryNotFound
+	}
+	s3Storage.downloadMutex.Lock()
+	defer s3Storage.downloadMutex.Unlock()
+	metadata := &s3Metadata{}
+	if err = s3Storage.getJSONObject(s3MetadataPrefix+callReference, metadata); err != nil {
+		return false, err
+	}
+	if entry := metadata.entry(); entry.Expired(time.Now()) || entry.DownloadsExhausted() {
+		return false, storage.ErrEntryNotFound
+	}
+	if metadata.MaximumDownloads == 0 {
+		return false, nil
+	}
+	metadata.RemainingDownloads--
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return false, err
+	}
+	if err = s3Storage.putObject(s3MetadataPrefix+callReference, data, metadataMimeType); err != nil {
+		return false, err
+	}
+	return metadata.RemainingDownloads == 0, nil
+}
+
+// Close is the implementation of the FileStorage.Close method.
+func (s3Storage *S3Storage) Close() error {
+	// the MinIO client does not hold any connections which have to be closed
+	return nil
+}
+
+// s3Writer buffers the written data and uploads it as parts of a multipart upload. Small files which fit into a
+// single part are uploaded with a single request. Closing the writer completes the upload and writes the metadata.
+type s3Writer struct {
+	s3Storage *S3Storage
+	entry     *storage.Entry
+	key       string
+	buffer    []byte
+	uploadID  string
+	parts     []minio.CompletePart
+	size      int64
+	checksum  checksumHash
+	// err is the error of a failed upload, the entry is not stored in this case
+	err error
+}
+
+// Write is the implementation of the io.Writer interface method.
+func (writer *s3Writer) Write(p []byte) (n int, err error) {
+	if writer.err != nil {
+		return 0, writer.err
+	}
+	partSize := writer.s3Storage.PartSize
+	for len(p) > 0 {
+		free := partSize - len(writer.buffer)
+		if free > len(p) {
+			free = len(p)
+		}
+		// the buffer grows with the written data so that writers which are kept open (e.g. by upload sessions) do not
+		// hold a whole part before they receive the data
+		writer.buffer = append(writer.buffer, p[:free]...)
+		writer.checksum.Write(p[:free])
+		p = p[free:]
+		n += free
+		if len(writer.buffer) == partSize {
+			if err = writer.uploadPart(); err != nil {
+				writer.abort()
+				writer.err = err
+				return
+			}
+		}
+	}
+	return
+}
+
+// uploadPart uploads the buffered data as the next part and initiates the multipart upload if necessary.
+func (writer *s3Writer) uploadPart() (err error) {
+	s3Storage := writer.s3Storage
+	if writer.uploadID == "" {
+		if writer.uploadID, err = s3Storage.core.NewMultipartUpload(s3Storage.Bucket, writer.key, minio.PutObjectOptions{
+			ContentType: writer.entry.ContentType,
+		}); err != nil {
+			return
+		}
+	}
+	partNumber := len(writer.parts) + 1
+	part, err := s3Storage.core.PutObjectPart(s3Storage.Bucket, writer.key, writer.uploadID, partNumber,
+		bytes.NewReader(writer.buffer), int64(len(writer.buffer)), "", "", nil)
+	if err != nil {
+		return
+	}
+	writer.parts = append(writer.parts, minio.CompletePart{PartNumber: partNumber, ETag: part.ETag})
+	writer.size += int64(len(writer.buffer))
+	writer.buffer = writer.buffer[:0]
+	return nil
+}
+
+// abort aborts the running multipart upload so that the uploaded parts do not take up space.
+func (writer *s3Writer) abort() {
+	if writer.uploadID == "" {
+		return
+	}
+	if err := writer.s3Storage.core.AbortMultipartUpload(writer.s3Storage.Bucket, writer.key, writer.uploadID); err != nil {
+		log.Printf("Could not abort multipart upload of %v, %T: %v\n", writer.key, err, err)
+	}
+	writer.uploadID = ""
+}
+
+// Close is the implementation of the io.Closer interface method. It uploads the remaining data, the expiry index
+// object and the metadata and updates the usage of the author.
+func (writer *s3Writer) Close() (err error) {
+	if writer.err != nil {
+		return writer.err
+	}
+	s3Storage := writer.s3Storage
+	if writer.uploadID == "" {
+		// the data fits into a single part, no multipart upload is necessary
+		if _, err = s3Storage.core.PutObject(s3Storage.Bucket, writer.key, bytes.NewReader(writer.buffer),
+			int64(len(writer.buffer)), "", "", map[string]string{"Content-Type": writer.entry.ContentType}, nil); err != nil {
+			return
+		}
+		writer.size = int64(len(writer.buffer))
+	} else {
+		if len(writer.buffer) > 0 {
+			if err = writer.uploadPart(); err != nil {
+				writer.abort()
+				return
+			}
+		}
+		if _, err = s3Storage.core.CompleteMultipartUpload(s3Storage.Bucket, writer.key, writer.uploadID,
+			writer.parts); err != nil {
+			writer.abort()
+			return
+		}
+	}
+	writer.entry.SHA256 = writer.checksum.String()
+	blob, err := s3Storage.acquireBlob(writer.entry.SHA256, &s3Blob{
+		Key:  strings.TrimPrefix(writer.key, s3Storage.Prefix),
+		Size: writer.size,
+	})
+	if err != nil {
+		return
+	}
+	data, err := json.Marshal(&s3Metadata{
+		entryMetadata: *newEntryMetadata(writer.entry),
+		Size:          blob.Size,
+		DataKey:       blob.Key,
+	})
+	if err == nil {
+		err = s3Storage.putExpiry(writer.entry)
+	}
+	if err == nil {
+		err = s3Storage.putObject(s3MetadataPrefix+writer.entry.CallReference, data, metadataMimeType)
+	}
+	if err != nil {
+		if blob.Key == strings.TrimPrefix(writer.key, s3Storage.Prefix) {
+			// the blob owns the written data object now which must not be removed by the deletion of this entry, the
+			// reference is kept if the reservation can not be updated because other entries may share the object
+			if s3Storage.putObject(s3DeleteReferencePrefix+writer.entry.DeleteReference,
+				[]byte(writer.entry.CallReference), "text/plain") != nil {
+				return
+			}
+		}
+		s3Storage.releaseBlob(writer.entry.SHA256)
+		return
+	}
+	s3Storage.addUsage(string(writer.entry.Author), blob.Size)
+	return
+}
+
+// Abort is the implementation of the storage.AbortableWriter interface method. The running multipart upload is aborted
+// and the incomplete entry is deleted.
+func (writer *s3Writer) Abort() error {
+	writer.abort()
+	return writer.s3Storage.Delete(writer.entry.DeleteReference)
+}
+
+// s3Reader reads the data object of an entry. The object is requested lazily starting at the current offset which
+// means that seeking only results in a new ranged request instead of downloading the whole object.
+type s3Reader struct {
+	s3Storage *S3Storage
+	key       string
+	size      int64
+	offset    int64
+	body      io.ReadCloser
+}
+
+// Read is the implementation of the io.Reader interface method.
+func (reader *s3Reader) Read(p []byte) (n int, err error) {
+	if reader.offset >= reader.size {
+		return 0, io.EOF
+	}
+	if reader.body == nil {
+		options := minio.GetObjectOptions{}
+		if reader.offset > 0 {
+			if err = options.SetRange(reader.offset, 0); err != nil {
+				return
+			}
+		}
+		if reader.body, _, err = reader.s3Storage.core.GetObject(reader.s3Storage.Bucket, reader.key, options); err != nil {
+			return
+		}
+	}
+	n, err = reader.body.Read(p)
+	reader.offset += int64(n)
+	return
+}
+
+// Seek is the implementation of the io.Seeker interface method.
+func (reader *s3Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += reader.offset
+	case io.SeekEnd:
+		offset += reader.size
+	default:
+		return reader.offset, os.ErrInvalid
+	}
+	if offset < 0 {
+		return reader.offset, errNegativeOffset
+	}
+	if offset != reader.offset {
+		// the current response does not start at the new offset anymore
+		reader.Close()
+		reader.offset = offset
+	}
+	return offset, nil
+}
+
+// Close is the implementation of the io.Closer interface method.
+func (reader *s3Reader) Close() (err error) {
+	if reader.body != nil {
+		err = reader.body.Close()
+		reader.body = nil
+	}
+	return
+}
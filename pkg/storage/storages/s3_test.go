@@ -0,0 +1,297 @@
+package storages
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"github.com/minio/minio-go"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is a minimal in-memory implementation of the S3 API subset which is used by the S3Storage.
+type fakeS3 struct {
+	sync.Mutex
+	buckets map[string]map[string][]byte
+	uploads map[string]map[int][]byte
+	// rangeRequests counts the received requests which contained a Range header
+	rangeRequests int
+	// failingPrefix makes all requests of object keys with this prefix fail if it is set
+	failingPrefix string
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{
+		buckets: make(map[string]map[string][]byte),
+		uploads: make(map[string]map[int][]byte),
+	}
+}
+
+// ServeHTTP is the implementation of the http.Handler function. It uses path style bucket addressing.
+func (fake *fakeS3) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	fake.Lock()
+	defer fake.Unlock()
+	path := strings.SplitN(strings.TrimPrefix(request.URL.Path, "/"), "/", 2)
+	query := request.URL.Query()
+	bucket, exists := fake.buckets[path[0]]
+	if len(path) == 1 || path[1] == "" {
+		switch {
+		case request.Method == http.MethodPut:
+			fake.buckets[path[0]] = make(map[string][]byte)
+		case !exists:
+			fake.sendError(writer, http.StatusNotFound, "NoSuchBucket")
+		case request.Method == http.MethodGet && query.Get("list-type") == "2":
+			fake.listObjects(writer, bucket, query)
+		}
+		return
+	}
+	key := path[1]
+	if fake.failingPrefix != "" && strings.HasPrefix(key, fake.failingPrefix) {
+		fake.sendError(writer, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	data, _ := ioutil.ReadAll(request.Body)
+	if request.Header.Get("X-Amz-Content-Sha256") == "STREAMING-AWS4-HMAC-SHA256-PAYLOAD" {
+		data = decodeAWSChunked(data)
+	}
+	switch {
+	case request.Method == http.MethodPost && query.Get("uploadId") == "" && query["uploads"] != nil:
+		uploadID := fmt.Sprintf("upload-%d", len(fake.uploads)+1)
+		fake.uploads[uploadID] = make(map[int][]byte)
+		fmt.Fprintf(writer, "<InitiateMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><UploadId>%s</UploadId>"+
+			"</InitiateMultipartUploadResult>", path[0], key, uploadID)
+	case request.Method == http.MethodPut && query.Get("uploadId") != "":
+		var partNumber int
+		fmt.Sscan(query.Get("partNumber"), &partNumber)
+		fake.uploads[query.Get("uploadId")][partNumber] = data
+		writer.Header().Set("ETag", fmt.Sprintf(`"part-%d"`, partNumber))
+	case request.Method == http.MethodPost && query.Get("uploadId") != "":
+		parts := fake.uploads[query.Get("uploadId")]
+		var partNumbers []int
+		for partNumber := range parts {
+			partNumbers = append(partNumbers, partNumber)
+		}
+		sort.Ints(partNumbers)
+		var object []byte
+		for _, partNumber := range partNumbers {
+			object = append(object, parts[partNumber]...)
+		}
+		bucket[key] = object
+		delete(fake.uploads, query.Get("uploadId"))
+		fmt.Fprintf(writer, "<CompleteMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><ETag>\"object\"</ETag>"+
+			"</CompleteMultipartUploadResult>", path[0], key)
+	case request.Method == http.MethodDelete && query.Get("uploadId") != "":
+		delete(fake.uploads, query.Get("uploadId"))
+		writer.WriteHeader(http.StatusNoContent)
+	case request.Method == http.MethodPut:
+		bucket[key] = data
+		writer.Header().Set("ETag", `"object"`)
+	case request.Method == http.MethodDelete:
+		delete(bucket, key)
+		writer.WriteHeader(http.StatusNoContent)
+	default:
+		object, ok := bucket[key]
+		if !ok {
+			fake.sendError(writer, http.StatusNotFound, s3NoSuchKeyCode)
+			return
+		}
+		if request.Header.Get("Range") != "" {
+			fake.rangeRequests++
+		}
+		writer.Header().Set("ETag", `"object"`)
+		http.ServeContent(writer, request, "", time.Now(), bytes.NewReader(object))
+	}
+}
+
+// listObjects sends the sorted keys of the bucket which match the prefix and start-after parameters in the
+// ListObjectsV2 format. The continuation token is the last key of the previous page.
+func (fake *fakeS3) listObjects(writer http.ResponseWriter, bucket map[string][]byte, query url.Values) {
+	startAfter := query.Get("start-after")
+	if continuationToken := query.Get("continuation-token"); continuationToken != "" {
+		startAfter = continuationToken
+	}
+	var keys []string
+	for key := range bucket {
+		if strings.HasPrefix(key, query.Get("prefix")) && key > startAfter {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	var maxKeys int
+	fmt.Sscan(query.Get("max-keys"), &maxKeys)
+	truncated := len(keys) > maxKeys
+	if truncated {
+		keys = keys[:maxKeys]
+	}
+	fmt.Fprintf(writer, "<ListBucketResult><KeyCount>%d</KeyCount><IsTruncated>%t</IsTruncated>", len(keys),
+		truncated)
+	if truncated {
+		fmt.Fprintf(writer, "<NextContinuationToken>%s</NextContinuationToken>", keys[len(keys)-1])
+	}
+	for _, key := range keys {
+		fmt.Fprintf(writer, "<Contents><Key>%s</Key><Size>%d</Size></Contents>", key, len(bucket[key]))
+	}
+	fmt.Fprint(writer, "</ListBucketResult>")
+}
+
+// decodeAWSChunked removes the chunk headers of a body which was sent with a streaming signature. The signatures
+// themselves are not verified.
+func decodeAWSChunked(body []byte) []byte {
+	var data []byte
+	for len(body) > 0 {
+		headerEnd := bytes.Index(body, []byte("\r\n"))
+		if headerEnd < 0 {
+			break
+		}
+		var chunkSize int
+		fmt.Sscanf(string(body[:headerEnd]), "%x;", &chunkSize)
+		body = body[headerEnd+2:]
+		if chunkSize == 0 || len(body) < chunkSize {
+			break
+		}
+		data = append(data, body[:chunkSize]...)
+		body = body[chunkSize+2:]
+	}
+	return data
+}
+
+// sendError sends an error response in the S3 format.
+func (fake *fakeS3) sendError(writer http.ResponseWriter, status int, code string) {
+	writer.Header().Set("Content-Type", "application/xml")
+	writer.WriteHeader(status)
+	xml.NewEncoder(writer).Encode(minio.ErrorResponse{Code: code, Message: code})
+}
+
+func TestS3Storage(t *testing.T) {
+	fake := newFakeS3()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	client, err := minio.NewWithRegion(strings.TrimPrefix(server.URL, "http://"), "access-key", "secret-key",
+		false, "us-east-1")
+	if err != nil {
+		t.Fatalf("Could not create MinIO client, %T: %v", err, err)
+	}
+	s3Storage := &S3Storage{
+		Client:   client,
+		Bucket:   "gosharexserver",
+		Location: "us-east-1",
+		Prefix:   "uploads/",
+	}
+	if err = s3Storage.Initialize(); err != nil {
+		t.Fatalf("Could not initialize S3 storage, %T: %v", err, err)
+	}
+	defer s3Storage.Close()
+	// the data is larger than a single part to test the multipart upload
+	testBytes := bytes.Repeat([]byte("Hello, this is a test!"), minimumS3PartSize/10)
+	entry := &storage.Entry{
+		Author:      storage.AuthorIdentifier("a testing person"),
+		Filename:    "testfile.txt",
+		ContentType: "text/plain",
+		UploadDate:  time.Now().Round(time.Second),
+	}
+	writer, err := s3Storage.Store(entry)
+	if err != nil {
+		t.Fatalf("Could not store entry, %T: %v", err, err)
+	}
+	if _, err = s3Storage.Request(entry.CallReference); err != storage.ErrEntryNotFound {
+		t.Fatalf("Incomplete entry could be requested, err: %v", err)
+	}
+	if _, err = writer.Write(testBytes); err != nil {
+		t.Fatalf("Could not write entry data, %T: %v", err, err)
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatalf("Could not close entry writer, %T: %v", err, err)
+	}
+	requestedEntry, err := s3Storage.Request(entry.CallReference)
+	if err != nil {
+		t.Fatalf("Could not request stored entry, %T: %v", err, err)
+	}
+	defer requestedEntry.Reader.Close()
+	if requestedEntry.Author != entry.Author || requestedEntry.Filename != entry.Filename ||
+		requestedEntry.ContentType != entry.ContentType || !requestedEntry.UploadDate.Equal(entry.UploadDate) {
+		t.Fatalf("Requested entry metadata %+v does not match the stored metadata %+v", requestedEntry, entry)
+	}
+	// seeking must result in a ranged request
+	if _, err = requestedEntry.Reader.Seek(-9, io.SeekEnd); err != nil {
+		t.Fatalf("Could not seek requested entry data, %T: %v", err, err)
+	}
+	requestedBytes, err := ioutil.ReadAll(requestedEntry.Reader)
+	if err != nil {
+		t.Fatalf("Could not read requested entry data, %T: %v", err, err)
+	}
+	if !bytes.Equal(testBytes[len(testBytes)-9:], requestedBytes) {
+		t.Fatalf("Requested data %q after seeking does not match the stored data", requestedBytes)
+	}
+	if fake.rangeRequests == 0 {
+		t.Fatal("Seeking the requested entry did not result in a ranged request")
+	}
+	if _, err = requestedEntry.Reader.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("Could not seek requested entry data, %T: %v", err, err)
+	}
+	if requestedBytes, err = ioutil.ReadAll(requestedEntry.Reader); err != nil {
+		t.Fatalf("Could not read requested entry data, %T: %v", err, err)
+	}
+	if !bytes.Equal(testBytes, requestedBytes) {
+		t.Fatal("Requested data does not match the stored data")
+	}
+	if err = s3Storage.Delete(entry.DeleteReference); err != nil {
+		t.Fatalf("Could not delete entry, %T: %v", err, err)
+	}
+	if _, err = s3Storage.Request(entry.CallReference); err != storage.ErrEntryNotFound {
+		t.Fatalf("Deleted entry could still be requested, err: %v", err)
+	}
+	// only the usage counters and the marker of the expiry index are kept
+	if objects := len(fake.buckets[s3Storage.Bucket]); objects != 2 {
+		t.Fatalf("%d objects of the deleted entry were not removed", objects-2)
+	}
+	// the data object of an upload which failed after the data had been written is removed as well
+	if writer, err = s3Storage.Store(entry); err != nil {
+		t.Fatalf("Could not store entry, %T: %v", err, err)
+	}
+	if _, err = writer.Write([]byte("failing upload")); err != nil {
+		t.Fatalf("Could not write entry data, %T: %v", err, err)
+	}
+	fake.Lock()
+	fake.failingPrefix = s3Storage.Prefix + s3BlobsPrefix
+	fake.Unlock()
+	err = writer.Close()
+	fake.Lock()
+	fake.failingPrefix = ""
+	fake.Unlock()
+	if err == nil {
+		t.Fatal("Entry writer could be closed although the blob could not be written")
+	}
+	if err = s3Storage.Delete(entry.DeleteReference); err != nil {
+		t.Fatalf("Could not delete incomplete entry, %T: %v", err, err)
+	}
+	if objects := len(fake.buckets[s3Storage.Bucket]); objects != 2 {
+		t.Fatalf("%d objects of the incomplete entry were not removed", objects-2)
+	}
+	testPresetReferencesAndList(t, s3Storage)
+	testExpiry(t, s3Storage)
+	testDownloadLimit(t, s3Storage)
+	testConcurrentDownloads(t, s3Storage)
+	testAbort(t, s3Storage)
+	testDeduplication(t, s3Storage, func() (blobs int) {
+		fake.Lock()
+		defer fake.Unlock()
+		for key := range fake.buckets[s3Storage.Bucket] {
+			if strings.HasPrefix(key, s3Storage.Prefix+s3BlobsPrefix) {
+				blobs++
+			}
+		}
+		return
+	})
+	testDerivedStorage(t, s3Storage)
+	testUsage(t, s3Storage)
+	testUserStorage(t, s3Storage)
+}
@@ -0,0 +1,90 @@
+package storages
+
+import (
+	"encoding/json"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+)
+
+// s3Blob is the content of the blob object which references the data object of identical entries.
+type s3Blob struct {
+	// Key is the key of the data object without the prefix.
+	Key string `json:"key"`
+	// Size is the size of the data object in bytes.
+	Size int64 `json:"size"`
+	// References is the amount of entries which use the data object.
+	References int `json:"references"`
+}
+
+// acquireBlob adds a reference to the blob with the given checksum. The written data object becomes the data object of
+// the blob if the blob does not exist yet and is removed otherwise. The reference counts are only race-safe if the
+// bucket is not shared by multiple processes.
+func (s3Storage *S3Storage) acquireBlob(checksum string, written *s3Blob) (*s3Blob, error) {
+	blob, err := s3Storage.updateBlob(checksum, func(blob *s3Blob) *s3Blob {
+		if blob == nil {
+			blob = written
+		}
+		blob.References++
+		return blob
+	})
+	if err != nil {
+		return nil, err
+	}
+	if blob.Key != written.Key {
+		// the data object is not needed because the blob already has one
+		if err = s3Storage.Client.RemoveObject(s3Storage.Bucket, s3Storage.Prefix+written.Key); err != nil {
+			return nil, err
+		}
+	}
+	return blob, nil
+}
+
+// releaseBlob removes a reference from the blob with the given checksum and removes the data object if it was the last
+// one.
+func (s3Storage *S3Storage) releaseBlob(checksum string) error {
+	blob, err := s3Storage.updateBlob(checksum, func(blob *s3Blob) *s3Blob {
+		if blob != nil {
+			blob.References--
+		}
+		return blob
+	})
+	if err != nil || blob == nil || blob.References > 0 {
+		return err
+	}
+	return s3Storage.Client.RemoveObject(s3Storage.Bucket, s3Storage.Prefix+blob.Key)
+}
+
+// updateBlob updates the reference count of the blob with the given checksum while holding the blobMutex. The update
+// function receives nil if the blob does not exist and may return nil to leave it untouched. The blob object is
+// removed once no entry references it anymore, removing its data object is left to the caller.
+func (s3Storage *S3Storage) updateBlob(checksum string, update func(blob *s3Blob) *s3Blob) (*s3Blob, error) {
+	s3Storage.blobMutex.Lock()
+	defer s3Storage.blobMutex.Unlock()
+	blob := &s3Blob{}
+	if err := s3Storage.getJSONObject(s3BlobsPrefix+checksum, blob); err == storage.ErrEntryNotFound {
+		blob = nil
+	} else if err != nil {
+		return nil, err
+	}
+	if blob = update(blob); blob == nil {
+		return nil, nil
+	}
+	var err error
+	if blob.References > 0 {
+		err = s3Storage.putBlob(checksum, blob)
+	} else {
+		err = s3Storage.Client.RemoveObject(s3Storage.Bucket, s3Storage.Prefix+s3BlobsPrefix+checksum)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
+
+// putBlob writes the blob object of the blob with the given checksum.
+func (s3Storage *S3Storage) putBlob(checksum string, blob *s3Blob) error {
+	data, err := json.Marshal(blob)
+	if err != nil {
+		return err
+	}
+	return s3Storage.putObject(s3BlobsPrefix+checksum, data, metadataMimeType)
+}
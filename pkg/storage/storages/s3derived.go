@@ -0,0 +1,51 @@
+package storages
+
+import (
+	"github.com/minio/minio-go"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+)
+
+// StoreDerived is the implementation of the DerivedStorage.StoreDerived method. The derived data of every entry is
+// stored in objects below a key prefix which contains the call reference of the entry.
+func (s3Storage *S3Storage) StoreDerived(callReference, name string, data []byte) error {
+	if !validReference(name) {
+		return errInvalidDerivedName
+	}
+	if !validReference(callReference) {
+		return storage.ErrEntryNotFound
+	}
+	if _, err := s3Storage.Client.StatObject(s3Storage.Bucket, s3Storage.Prefix+s3MetadataPrefix+callReference,
+		minio.StatObjectOptions{}); minio.ToErrorResponse(err).Code == s3NoSuchKeyCode {
+		return storage.ErrEntryNotFound
+	} else if err != nil {
+		return err
+	}
+	return s3Storage.putObject(s3DerivedKey(callReference, name), data, "application/octet-stream")
+}
+
+// RequestDerived is the implementation of the DerivedStorage.RequestDerived method.
+func (s3Storage *S3Storage) RequestDerived(callReference, name string) ([]byte, error) {
+	if !validReference(callReference) || !validReference(name) {
+		return nil, storage.ErrEntryNotFound
+	}
+	return s3Storage.getObject(s3DerivedKey(callReference, name))
+}
+
+// removeDerived removes all derived data objects of the entry with the given call reference.
+func (s3Storage *S3Storage) removeDerived(callReference string) error {
+	keys, err := s3Storage.listObjectKeys(s3DerivedKey(callReference, ""))
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err = s3Storage.Client.RemoveObject(s3Storage.Bucket, s3Storage.Prefix+key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// s3DerivedKey returns the key of the derived data object with the given name without the prefix.
+func s3DerivedKey(callReference, name string) string {
+	return s3DerivedPrefix + callReference + "/" + name
+}
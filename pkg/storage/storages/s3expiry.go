@@ -0,0 +1,101 @@
+package storages
+
+import (
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"strings"
+	"time"
+)
+
+const (
+	// s3ExpiriesPrefix is the key prefix of the expiry index. Every expiring entry has an index object which is named
+	// after its expiry key and contains its delete reference.
+	s3ExpiriesPrefix = "expiries/"
+	// s3ExpiriesInitializedKey is the key of the object which marks that the expiry index has been created.
+	s3ExpiriesInitializedKey = "expiries_initialized"
+)
+
+// DeleteExpired is the implementation of the FileStorage.DeleteExpired method. The index objects are listed in the
+// order of the expiration dates until the first entry which has not expired yet, the metadata objects are not
+// downloaded.
+func (s3Storage *S3Storage) DeleteExpired(now time.Time) (deleted int, err error) {
+	keyPrefix := s3Storage.Prefix + s3ExpiriesPrefix
+	var continuationToken string
+	for {
+		result, err := s3Storage.core.ListObjectsV2(s3Storage.Bucket, keyPrefix, continuationToken, false, "",
+			s3ListPageSize, "")
+		if err != nil {
+			return deleted, err
+		}
+		for _, object := range result.Contents {
+			expirationDate, _, ok := parseExpiryKey(strings.TrimPrefix(object.Key, keyPrefix))
+			if !ok {
+				continue
+			} else if expirationDate.After(now) {
+				return deleted, nil
+			}
+			key := strings.TrimPrefix(object.Key, s3Storage.Prefix)
+			deleteReference, err := s3Storage.getObject(key)
+			if err == storage.ErrEntryNotFound {
+				continue
+			} else if err != nil {
+				return deleted, err
+			}
+			if err = s3Storage.Delete(string(deleteReference)); err == nil {
+				deleted++
+			} else if err != storage.ErrEntryNotFound {
+				return deleted, err
+			}
+			// the index objects of entries which have been deleted before they were completed are left behind
+			if err = s3Storage.Client.RemoveObject(s3Storage.Bucket, object.Key); err != nil {
+				return deleted, err
+			}
+		}
+		if !result.IsTruncated {
+			return deleted, nil
+		}
+		continuationToken = result.NextContinuationToken
+	}
+}
+
+// putExpiry writes the expiry index object of the given entry if it expires.
+func (s3Storage *S3Storage) putExpiry(entry *storage.Entry) error {
+	if entry.ExpirationDate.IsZero() {
+		return nil
+	}
+	return s3Storage.putObject(s3ExpiriesPrefix+expiryKey(entry.ExpirationDate, entry.CallReference),
+		[]byte(entry.DeleteReference), "text/plain")
+}
+
+// removeExpiry removes the expiry index object of the entry with the given metadata if it expires.
+func (s3Storage *S3Storage) removeExpiry(metadata *s3Metadata) error {
+	if metadata.ExpirationDate.IsZero() {
+		return nil
+	}
+	return s3Storage.Client.RemoveObject(s3Storage.Bucket, s3Storage.Prefix+s3ExpiriesPrefix+
+		expiryKey(metadata.ExpirationDate, metadata.CallReference))
+}
+
+// initializeExpiries creates the expiry index from the stored entries if it has not been created yet, e.g. because the
+// entries have been stored by an older version.
+func (s3Storage *S3Storage) initializeExpiries() error {
+	if _, err := s3Storage.getObject(s3ExpiriesInitializedKey); err != storage.ErrEntryNotFound {
+		return err
+	}
+	keys, err := s3Storage.listObjectKeys(s3MetadataPrefix)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		metadata := &s3Metadata{}
+		if err = s3Storage.getJSONObject(key, metadata); err == storage.ErrEntryNotFound {
+			// the entry has been deleted in the meantime
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err = s3Storage.putExpiry(metadata.entry()); err != nil {
+			return err
+		}
+	}
+	return s3Storage.putObject(s3ExpiriesInitializedKey, nil, "text/plain")
+}
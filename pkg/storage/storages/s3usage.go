@@ -0,0 +1,63 @@
+package storages
+
+import (
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"log"
+	"strconv"
+)
+
+// s3UsageKey is the key of the object which contains the usage counters of all authors.
+const s3UsageKey = "usage.json"
+
+// Usage is the implementation of the UsageStorage.Usage method. The usage is read from the counters which are updated
+// whenever an entry is completed or deleted because S3 does not offer metadata queries.
+func (s3Storage *S3Storage) Usage(author storage.AuthorIdentifier) (int64, error) {
+	s3Storage.usageMutex.Lock()
+	defer s3Storage.usageMutex.Unlock()
+	counters := make(usageCounters)
+	if err := s3Storage.getJSONObject(s3UsageKey, &counters); err != nil {
+		return 0, err
+	}
+	return counters[string(author)], nil
+}
+
+// addUsage adds the given amount of bytes to the usage counter of the author. The counters are only race-safe if the
+// bucket is not shared by multiple processes. Failures are only logged because the entry itself has already been
+// stored or deleted.
+func (s3Storage *S3Storage) addUsage(author string, size int64) {
+	s3Storage.usageMutex.Lock()
+	defer s3Storage.usageMutex.Unlock()
+	counters := make(usageCounters)
+	err := s3Storage.getJSONObject(s3UsageKey, &counters)
+	if err == nil {
+		counters.add(author, size)
+		err = s3Storage.putJSONObject(s3UsageKey, counters)
+	}
+	if err != nil {
+		log.Printf("Could not update the usage of %v, %T: %v\n", strconv.Quote(author), err, err)
+	}
+}
+
+// initializeUsage calculates the usage counters from the metadata objects if the usage object does not exist yet, e.g.
+// because the entries have been stored by an older version.
+func (s3Storage *S3Storage) initializeUsage() error {
+	counters := make(usageCounters)
+	if err := s3Storage.getJSONObject(s3UsageKey, &counters); err != storage.ErrEntryNotFound {
+		return err
+	}
+	keys, err := s3Storage.listObjectKeys(s3MetadataPrefix)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		metadata := &s3Metadata{}
+		if err = s3Storage.getJSONObject(key, metadata); err == storage.ErrEntryNotFound {
+			// the entry has been deleted in the meantime
+			continue
+		} else if err != nil {
+			return err
+		}
+		counters.add(metadata.Author, metadata.Size)
+	}
+	return s3Storage.putJSONObject(s3UsageKey, counters)
+}
@@ -0,0 +1,191 @@
+package storages
+
+import (
+	"encoding/json"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"strings"
+)
+
+// CreateUser is the implementation of the UserStorage.CreateUser method. Every user is stored in its own JSON object.
+func (s3Storage *S3Storage) CreateUser(user *storage.User) error {
+	if !storage.ValidUsername(user.Name) {
+		return storage.ErrInvalidUsername
+	}
+	if exists, err := s3Storage.s3DuplicateCheck(s3UsersPrefix)(user.Name); err != nil {
+		return err
+	} else if exists {
+		return storage.ErrUserExists
+	}
+	data, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	return s3Storage.putObject(s3UsersPrefix+user.Name, data, metadataMimeType)
+}
+
+// RequestUser is the implementation of the UserStorage.RequestUser method.
+func (s3Storage *S3Storage) RequestUser(name string) (*storage.User, error) {
+	if !storage.ValidUsername(name) {
+		return nil, storage.ErrUserNotFound
+	}
+	user := &storage.User{}
+	if err := s3Storage.getJSONObject(s3UsersPrefix+name, user); err == storage.ErrEntryNotFound {
+		return nil, storage.ErrUserNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ListUsers is the implementation of the UserStorage.ListUsers method.
+func (s3Storage *S3Storage) ListUsers() (users []*storage.User, err error) {
+	keys, err := s3Storage.listObjectKeys(s3UsersPrefix)
+	if err != nil {
+		return nil, err
+	}
+	// the keys are sorted by the name
+	for _, key := range keys {
+		user := &storage.User{}
+		if err = s3Storage.getJSONObject(key, user); err == storage.ErrEntryNotFound {
+			// the user has been deleted in the meantime
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// HasUsers is the implementation of the UserStorage.HasUsers method. Only the first key of the users prefix is listed.
+func (s3Storage *S3Storage) HasUsers() (bool, error) {
+	result, err := s3Storage.core.ListObjectsV2(s3Storage.Bucket, s3Storage.Prefix+s3UsersPrefix, "", false, "", 1, "")
+	if err != nil {
+		return false, err
+	}
+	return len(result.Contents) > 0, nil
+}
+
+// DeleteUser is the implementation of the UserStorage.DeleteUser method.
+func (s3Storage *S3Storage) DeleteUser(name string) error {
+	if _, err := s3Storage.RequestUser(name); err != nil {
+		return err
+	}
+	tokens, err := s3Storage.ListTokens(name)
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		if err = s3Storage.Client.RemoveObject(s3Storage.Bucket, s3Storage.Prefix+s3TokensPrefix+token.Hash); err != nil {
+			return err
+		}
+	}
+	return s3Storage.Client.RemoveObject(s3Storage.Bucket, s3Storage.Prefix+s3UsersPrefix+name)
+}
+
+// StoreToken is the implementation of the UserStorage.StoreToken method. Every token is stored in its own JSON object
+// which is named after the hash of the token.
+func (s3Storage *S3Storage) StoreToken(token *storage.Token) error {
+	if !validReference(token.Hash) {
+		return errInvalidReference
+	}
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	return s3Storage.putObject(s3TokensPrefix+token.Hash, data, metadataMimeType)
+}
+
+// RequestToken is the implementation of the UserStorage.RequestToken method.
+func (s3Storage *S3Storage) RequestToken(hash string) (*storage.Token, error) {
+	if !validReference(hash) {
+		return nil, storage.ErrTokenNotFound
+	}
+	token := &storage.Token{}
+	if err := s3Storage.getJSONObject(s3TokensPrefix+hash, token); err == storage.ErrEntryNotFound {
+		return nil, storage.ErrTokenNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// ListTokens is the implementation of the UserStorage.ListTokens method.
+func (s3Storage *S3Storage) ListTokens(username string) (tokens []*storage.Token, err error) {
+	keys, err := s3Storage.listObjectKeys(s3TokensPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		token := &storage.Token{}
+		if err = s3Storage.getJSONObject(key, token); err == storage.ErrEntryNotFound {
+			// the token has been revoked in the meantime
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if token.Username == username {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+// RevokeToken is the implementation of the UserStorage.RevokeToken method.
+func (s3Storage *S3Storage) RevokeToken(id string) error {
+	keys, err := s3Storage.listObjectKeys(s3TokensPrefix)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		token := &storage.Token{}
+		if err = s3Storage.getJSONObject(key, token); err == storage.ErrEntryNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if token.ID == id {
+			return s3Storage.Client.RemoveObject(s3Storage.Bucket, s3Storage.Prefix+key)
+		}
+	}
+	return storage.ErrTokenNotFound
+}
+
+// getJSONObject downloads the object and unmarshals its JSON content into the given value. It returns
+// storage.ErrEntryNotFound if the object does not exist.
+func (s3Storage *S3Storage) getJSONObject(key string, value interface{}) error {
+	data, err := s3Storage.getObject(key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+// putJSONObject marshals the given value and uploads it as a JSON object.
+func (s3Storage *S3Storage) putJSONObject(key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return s3Storage.putObject(key, data, metadataMimeType)
+}
+
+// listObjectKeys returns the sorted keys of all objects with the given key prefix. The storage prefix is removed from
+// the returned keys.
+func (s3Storage *S3Storage) listObjectKeys(keyPrefix string) (keys []string, err error) {
+	var continuationToken string
+	for {
+		result, err := s3Storage.core.ListObjectsV2(s3Storage.Bucket, s3Storage.Prefix+keyPrefix, continuationToken,
+			false, "", s3ListPageSize, "")
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range result.Contents {
+			keys = append(keys, strings.TrimPrefix(object.Key, s3Storage.Prefix))
+		}
+		if !result.IsTruncated {
+			return keys, nil
+		}
+		continuationToken = result.NextContinuationToken
+	}
+}
@@ -0,0 +1,728 @@
+package storages
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// SQL dialects which are supported by the SQLStorage. The values match the names of the common Go drivers.
+	SQLiteDialect   SQLDialect = "sqlite3"
+	MySQLDialect    SQLDialect = "mysql"
+	PostgresDialect SQLDialect = "postgres"
+	// default values of the SQLStorage
+	defaultSQLChunkSize = 255000
+	// maximumSQLInsertAttempts is the maximum amount of attempts to insert a new entry whose references collide with
+	// the ones of concurrently stored entries
+	maximumSQLInsertAttempts = 3
+)
+
+// SQLDialect identifies the SQL dialect of the database used by the SQLStorage.
+type SQLDialect string
+
+// rebind replaces the question mark placeholders of the query with the placeholders used by the dialect.
+func (dialect SQLDialect) rebind(query string) string {
+	if dialect != PostgresDialect {
+		return query
+	}
+	buf := bytes.NewBuffer([]byte{})
+	var placeholder int
+	for _, char := range query {
+		if char == '?' {
+			placeholder++
+			buf.WriteString("$" + strconv.Itoa(placeholder))
+		} else {
+			buf.WriteRune(char)
+		}
+	}
+	return buf.String()
+}
+
+// types returns the column types used for the auto incremented primary key and binary data.
+func (dialect SQLDialect) types() (primaryKey string, blob string) {
+	switch dialect {
+	case MySQLDialect:
+		return "BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY", "LONGBLOB"
+	case PostgresDialect:
+		return "BIGSERIAL PRIMARY KEY", "BYTEA"
+	default:
+		return "INTEGER PRIMARY KEY AUTOINCREMENT", "BLOB"
+	}
+}
+
+// sqlMigration is a single step of the database schema. The statements are created for the given dialect.
+type sqlMigration func(dialect SQLDialect) []string
+
+// sqlMigrations contains all schema migrations. The version of a migration is its index plus one. New migrations have
+// to be appended, existing ones must never be changed.
+var sqlMigrations = []sqlMigration{
+	// version 1: entries with reference indexes and chunked file data
+	func(dialect SQLDialect) []string {
+		primaryKey, blob := dialect.types()
+		return []string{
+			`CREATE TABLE entries (
+				id ` + primaryKey + `,
+				call_reference VARCHAR(64) NOT NULL,
+				delete_reference VARCHAR(64) NOT NULL,
+				author VARCHAR(255) NOT NULL,
+				filename VARCHAR(255) NOT NULL,
+				content_type VARCHAR(255) NOT NULL,
+				upload_date BIGINT NOT NULL,
+				size BIGINT NOT NULL DEFAULT 0,
+				chunk_size INTEGER NOT NULL DEFAULT 0,
+				complete SMALLINT NOT NULL DEFAULT 0
+			)`,
+			`CREATE UNIQUE INDEX entries_call_reference ON entries (call_reference)`,
+			`CREATE UNIQUE INDEX entries_delete_reference ON entries (delete_reference)`,
+			`CREATE TABLE chunks (
+				entry_id BIGINT NOT NULL,
+				chunk_index INTEGER NOT NULL,
+				data ` + blob + ` NOT NULL,
+				PRIMARY KEY (entry_id, chunk_index)
+			)`,
+		}
+	},
+	// version 2: indexes used to filter the listed entries
+	func(dialect SQLDialect) []string {
+		return []string{
+			`CREATE INDEX entries_author ON entries (author, id)`,
+			`CREATE INDEX entries_content_type ON entries (content_type, id)`,
+			`CREATE INDEX entries_upload_date ON entries (upload_date)`,
+		}
+	},
+	// version 3: users and their tokens
+	func(dialect SQLDialect) []string {
+		return []string{
+			`CREATE TABLE users (
+				name VARCHAR(64) NOT NULL PRIMARY KEY,
+				role VARCHAR(32) NOT NULL,
+				creation_date BIGINT NOT NULL
+			)`,
+			`CREATE TABLE tokens (
+				hash VARCHAR(64) NOT NULL PRIMARY KEY,
+				id VARCHAR(16) NOT NULL,
+				username VARCHAR(64) NOT NULL,
+				description VARCHAR(255) NOT NULL,
+				creation_date BIGINT NOT NULL
+			)`,
+			`CREATE UNIQUE INDEX tokens_id ON tokens (id)`,
+			`CREATE INDEX tokens_username ON tokens (username)`,
+		}
+	},
+	// version 4: expiration date of the entries, zero means that the entry never expires
+	func(dialect SQLDialect) []string {
+		return []string{
+			`ALTER TABLE entries ADD COLUMN expiration_date BIGINT NOT NULL DEFAULT 0`,
+			`CREATE INDEX entries_expiration_date ON entries (expiration_date)`,
+		}
+	},
+	// version 5: download limits of the entries, a maximum of zero means that the downloads are not limited
+	func(dialect SQLDialect) []string {
+		return []string{
+			`ALTER TABLE entries ADD COLUMN maximum_downloads INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE entries ADD COLUMN remaining_downloads INTEGER NOT NULL DEFAULT 0`,
+		}
+	},
+	// version 6: bcrypt hash of the password which protects the entry, empty if the entry is public
+	func(dialect SQLDialect) []string {
+		return []string{
+			`ALTER TABLE entries ADD COLUMN password_hash VARCHAR(255) NOT NULL DEFAULT ''`,
+		}
+	},
+	// version 7: ID of the key which encrypted the data of the entry, empty if the data is not encrypted
+	func(dialect SQLDialect) []string {
+		return []string{
+			`ALTER TABLE entries ADD COLUMN encryption_key_id VARCHAR(255) NOT NULL DEFAULT ''`,
+		}
+	},
+	// version 8: reference counted blobs which contain the chunks of identical files, the data id of the entries
+	// references the chunks and is zero for older entries which own the chunks of their own id
+	func(dialect SQLDialect) []string {
+		return []string{
+			`ALTER TABLE entries ADD COLUMN sha256 VARCHAR(64) NOT NULL DEFAULT ''`,
+			`ALTER TABLE entries ADD COLUMN data_id BIGINT NOT NULL DEFAULT 0`,
+			`CREATE TABLE blobs (
+				data_id BIGINT NOT NULL PRIMARY KEY,
+				sha256 VARCHAR(64) NOT NULL,
+				size BIGINT NOT NULL,
+				chunk_size INTEGER NOT NULL,
+				reference_count INTEGER NOT NULL
+			)`,
+			`CREATE INDEX blobs_sha256 ON blobs (sha256)`,
+		}
+	},
+	// version 9: MD5 checksum of the uploaded data
+	func(dialect SQLDialect) []string {
+		return []string{
+			`ALTER TABLE entries ADD COLUMN md5 VARCHAR(32) NOT NULL DEFAULT ''`,
+		}
+	},
+	// version 10: data which has been derived from the data of the entries (e.g. thumbnails)
+	func(dialect SQLDialect) []string {
+		_, blob := dialect.types()
+		return []string{
+			`CREATE TABLE derived (
+				entry_id BIGINT NOT NULL,
+				name VARCHAR(255) NOT NULL,
+				data ` + blob + ` NOT NULL,
+				PRIMARY KEY (entry_id, name)
+			)`,
+		}
+	},
+}
+
+// nullableUnixNano returns the unix nanoseconds of the given time or zero if it is the zero time.
+func nullableUnixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// nullableTime returns the time of the given unix nanoseconds or the zero time if they are zero.
+func nullableTime(unixNano int64) time.Time {
+	if unixNano == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, unixNano)
+}
+
+// SQLStorage is the FileStorage implementation using a SQL database via the database/sql package. The entry metadata
+// is stored in the entries table and the file data is split into BLOB rows of the chunks table. The chunks of identical
+// files are only stored once and reference counted in the blobs table. The schema is created and migrated when
+// initializing the storage.
+type SQLStorage struct {
+	// DB is the opened database handle. The matching driver has to be imported by the application.
+	DB *sql.DB
+	// Dialect is the SQL dialect of the database.
+	Dialect SQLDialect
+	// ChunkSize is the maximum size of a single chunk of file data in bytes.
+	ChunkSize int
+	// ReferenceLengths are the lengths of the generated references.
+	ReferenceLengths
+}
+
+// Initialize is the implementation of the FileStorage.Initialize method. It runs all pending schema migrations.
+func (sqlStorage *SQLStorage) Initialize() (err error) {
+	if err = sqlStorage.ReferenceLengths.validate(); err != nil {
+		return
+	}
+	if sqlStorage.ChunkSize <= 0 {
+		sqlStorage.ChunkSize = defaultSQLChunkSize
+	}
+	switch sqlStorage.Dialect {
+	case SQLiteDialect, MySQLDialect, PostgresDialect:
+	default:
+		return fmt.Errorf("unsupported SQL dialect %s", strconv.Quote(string(sqlStorage.Dialect)))
+	}
+	if _, err = sqlStorage.DB.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER NOT NULL PRIMARY KEY)`); err != nil {
+		return
+	}
+	var version int
+	if err = sqlStorage.DB.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return
+	}
+	for ; version < len(sqlMigrations); version++ {
+		if err = sqlStorage.migrate(version+1, sqlMigrations[version]); err != nil {
+			return fmt.Errorf("schema migration to version %d failed: %v", version+1, err)
+		}
+	}
+	return nil
+}
+
+// migrate runs the statements of the given migration and records the new schema version in one transaction.
+func (sqlStorage *SQLStorage) migrate(version int, migration sqlMigration) error {
+	tx, err := sqlStorage.DB.Begin()
+	if err != nil {
+		return err
+	}
+	for _, statement := range migration(sqlStorage.Dialect) {
+		if _, err = tx.Exec(statement); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if _, err = tx.Exec(sqlStorage.Dialect.rebind(`INSERT INTO schema_migrations (version) VALUES (?)`), version); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// exec executes the query after replacing the placeholders according to the dialect.
+func (sqlStorage *SQLStorage) exec(query string, args ...interface{}) (sql.Result, error) {
+	return sqlStorage.DB.Exec(sqlStorage.Dialect.rebind(query), args...)
+}
+
+// queryRow executes the query after replacing the placeholders according to the dialect.
+func (sqlStorage *SQLStorage) queryRow(query string, args ...interface{}) *sql.Row {
+	return sqlStorage.DB.QueryRow(sqlStorage.Dialect.rebind(query), args...)
+}
+
+// sqlDuplicateCheck returns a duplicateCheck which checks whether the reference is already present in the column.
+func (sqlStorage *SQLStorage) sqlDuplicateCheck(column string) duplicateCheck {
+	return func(reference string) (bool, error) {
+		var count int
+		if err := sqlStorage.queryRow(`SELECT COUNT(*) FROM entries WHERE `+column+` = ?`, reference).Scan(&count); err != nil {
+			return false, err
+		}
+		return count > 0, nil
+	}
+}
+
+// referencesInUse returns whether one of the references of the given entry is used by another entry.
+func (sqlStorage *SQLStorage) referencesInUse(entry *storage.Entry) bool {
+	for column, reference := range map[string]string{
+		"call_reference":   entry.CallReference,
+		"delete_reference": entry.DeleteReference,
+	} {
+		if duplicate, err := sqlStorage.sqlDuplicateCheck(column)(reference); err == nil && duplicate {
+			return true
+		}
+	}
+	return false
+}
+
+// Store is the implementation of the FileStorage.Store method.
+func (sqlStorage *SQLStorage) Store(entry *storage.Entry) (writer io.WriteCloser, err error) {
+	callReference, deleteReference := entry.CallReference, entry.DeleteReference
+	for attempt := 1; ; attempt++ {
+		// keep preset references or generate new ones
+		if entry.CallReference, err = presetOrNewReference(callReference, sqlStorage.CallReferenceLength,
+			sqlStorage.sqlDuplicateCheck("call_reference")); err != nil {
+			return nil, err
+		}
+		if entry.DeleteReference, err = presetOrNewReference(deleteReference, sqlStorage.DeleteReferenceLength,
+			sqlStorage.sqlDuplicateCheck("delete_reference")); err != nil {
+			return nil, err
+		}
+		// reserve the references, the entry becomes available once the writer is closed
+		if _, err = sqlStorage.exec(`INSERT INTO entries (call_reference, delete_reference, author, filename,
+			content_type, upload_date, expiration_date, maximum_downloads, remaining_downloads, password_hash,
+			encryption_key_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, entry.CallReference, entry.DeleteReference,
+			string(entry.Author), entry.Filename, entry.ContentType, entry.UploadDate.UnixNano(),
+			nullableUnixNano(entry.ExpirationDate), entry.MaximumDownloads, entry.RemainingDownloads,
+			entry.PasswordHash, entry.EncryptionKeyID); err == nil {
+			break
+		}
+		// the unique indexes reject references which have been taken by a concurrent upload after the duplicate
+		// checks, new references are generated (and preset ones are reported as in use) by the next attempt
+		if attempt == maximumSQLInsertAttempts || !sqlStorage.referencesInUse(entry) {
+			return nil, err
+		}
+	}
+	var id int64
+	if err = sqlStorage.queryRow(`SELECT id FROM entries WHERE call_reference = ?`, entry.CallReference).Scan(&id); err != nil {
+		return nil, err
+	}
+	entry.ID = id
+	return &sqlWriter{
+		sqlStorage: sqlStorage,
+		entry:      entry,
+		id:         id,
+		buffer:     make([]byte, 0, sqlStorage.ChunkSize),
+		checksum:   newChecksumHash(),
+	}, nil
+}
+
+// sqlEntryColumns are the selected columns which are scanned by the scanEntry method.
+const sqlEntryColumns = `id, call_reference, delete_reference, author, filename, content_type, upload_date,
+	expiration_date, maximum_downloads, remaining_downloads, password_hash, encryption_key_id, sha256, md5, data_id,
+	size, chunk_size`
+
+// sqlNotExpired is the condition which excludes expired entries. It expects the current time as its argument.
+const sqlNotExpired = `(expiration_date = 0 OR expiration_date > ?)`
+
+// sqlAvailable is the condition which excludes expired entries and entries whose downloads are exhausted. It expects
+// the current time as its argument.
+const sqlAvailable = sqlNotExpired + ` AND (maximum_downloads = 0 OR remaining_downloads > 0)`
+
+// sqlScanner is implemented by sql.Row and sql.Rows.
+type sqlScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanEntry scans the sqlEntryColumns of a row and returns the corresponding entry and a reader of its data.
+func (sqlStorage *SQLStorage) scanEntry(scanner sqlScanner) (*storage.Entry, *sqlReader, error) {
+	var id, uploadDate, expirationDate, dataID, size int64
+	var chunkSize int
+	var author string
+	entry := &storage.Entry{}
+	if err := scanner.Scan(&id, &entry.CallReference, &entry.DeleteReference, &author, &entry.Filename,
+		&entry.ContentType, &uploadDate, &expirationDate, &entry.MaximumDownloads, &entry.RemainingDownloads,
+		&entry.PasswordHash, &entry.EncryptionKeyID, &entry.SHA256, &entry.MD5, &dataID, &size, &chunkSize); err != nil {
+		return nil, nil, err
+	}
+	if dataID == 0 {
+		dataID = id
+	}
+	entry.ID = id
+	entry.Author = storage.AuthorIdentifier(author)
+	entry.UploadDate = time.Unix(0, uploadDate)
+	entry.ExpirationDate = nullableTime(expirationDate)
+	return entry, &sqlReader{
+		sqlStorage: sqlStorage,
+		id:         dataID,
+		size:       size,
+		chunkSize:  int64(chunkSize),
+		chunkIndex: -1,
+	}, nil
+}
+
+// Request is the implementation of the FileStorage.Request method.
+func (sqlStorage *SQLStorage) Request(callReference string) (*storage.Entry, error) {
+	entry, reader, err := sqlStorage.scanEntry(sqlStorage.queryRow(`SELECT `+sqlEntryColumns+` FROM entries
+		WHERE call_reference = ? AND complete = 1 AND `+sqlAvailable, callReference, time.Now().UnixNano()))
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrEntryNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	entry.Reader = reader
+	return entry, nil
+}
+
+// Lookup is the implementation of the FileStorage.Lookup method.
+func (sqlStorage *SQLStorage) Lookup(callReference string) (*storage.Entry, error) {
+	entry, _, err := sqlStorage.scanEntry(sqlStorage.queryRow(`SELECT `+sqlEntryColumns+` FROM entries
+		WHERE call_reference = ? AND complete = 1`, callReference))
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrEntryNotFound
+	}
+	return entry, err
+}
+
+// List is the implementation of the FileStorage.List method. The entries are sorted by their numeric ID which is also
+// used as the cursor.
+func (sqlStorage *SQLStorage) List(filter storage.ListFilter, cursor string, limit int) (entries []*storage.Entry,
+	nextCursor string, err error) {
+	var start int64
+	if cursor != "" {
+		if start, err = strconv.ParseInt(cursor, 10, 64); err != nil {
+			return nil, "", storage.ErrInvalidCursor
+		}
+	}
+	query := `SELECT ` + sqlEntryColumns + ` FROM entries WHERE complete = 1 AND id > ? AND ` + sqlAvailable
+	args := []interface{}{start, time.Now().UnixNano()}
+	if filter.Author != "" {
+		query += ` AND author = ?`
+		args = append(args, string(filter.Author))
+	}
+	if filter.ContentType != "" {
+		query += ` AND content_type = ?`
+		args = append(args, filter.ContentType)
+	}
+	if !filter.UploadedAfter.IsZero() {
+		query += ` AND upload_date > ?`
+		args = append(args, filter.UploadedAfter.UnixNano())
+	}
+	if !filter.UploadedBefore.IsZero() {
+		query += ` AND upload_date < ?`
+		args = append(args, filter.UploadedBefore.UnixNano())
+	}
+	// request one more row to find out whether there is a next page
+	rows, err := sqlStorage.DB.Query(sqlStorage.Dialect.rebind(query+` ORDER BY id LIMIT ?`), append(args, limit+1)...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if len(entries) == limit {
+			nextCursor = strconv.FormatInt(entries[limit-1].ID.(int64), 10)
+			break
+		}
+		entry, _, err := sqlStorage.scanEntry(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		entries = append(entries, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, "", err
+	}
+	return entries, nextCursor, nil
+}
+
+// Delete is the implementation of the FileStorage.Delete method.
+func (sqlStorage *SQLStorage) Delete(deleteReference string) error {
+	tx, err := sqlStorage.DB.Begin()
+	if err != nil {
+		return err
+	}
+	var id, dataID int64
+	if err = tx.QueryRow(sqlStorage.Dialect.rebind(`SELECT id, data_id FROM entries WHERE delete_reference = ?`),
+		deleteReference).Scan(&id, &dataID); err == sql.ErrNoRows {
+		tx.Rollback()
+		return storage.ErrEntryNotFound
+	} else if err != nil {
+		tx.Rollback()
+		return err
+	}
+	// only the transaction which deletes the row releases the data of concurrently deleted entries
+	result, err := tx.Exec(sqlStorage.Dialect.rebind(`DELETE FROM entries WHERE id = ?`), id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		tx.Rollback()
+		if err != nil {
+			return err
+		}
+		return storage.ErrEntryNotFound
+	}
+	if _, err = tx.Exec(sqlStorage.Dialect.rebind(`DELETE FROM derived WHERE entry_id = ?`), id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if dataID != 0 {
+		err = sqlStorage.releaseBlob(tx, dataID)
+	} else {
+		// incomplete entries and entries which have been stored before the deduplication own their chunks
+		_, err = tx.Exec(sqlStorage.Dialect.rebind(`DELETE FROM chunks WHERE entry_id = ?`), id)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteExpired is the implementation of the FileStorage.DeleteExpired method.
+func (sqlStorage *SQLStorage) DeleteExpired(now time.Time) (deleted int, err error) {
+	rows, err := sqlStorage.DB.Query(sqlStorage.Dialect.rebind(`SELECT delete_reference FROM entries
+		WHERE expiration_date <> 0 AND expiration_date <= ?`), now.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	var deleteReferences []string
+	for rows.Next() {
+		var deleteReference string
+		if err = rows.Scan(&deleteReference); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		deleteReferences = append(deleteReferences, deleteReference)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	for _, deleteReference := range deleteReferences {
+		if err = sqlStorage.Delete(deleteReference); err == storage.ErrEntryNotFound {
+			// the entry has been deleted in the meantime
+			continue
+		} else if err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// ConsumeDownload is the implementation of the FileStorage.ConsumeDownload method. The conditional update locks the
+// row until the transaction is committed which makes concurrent downloads race-safe.
+func (sqlStorage *SQLStorage) ConsumeDownload(callReference string) (lastDownload bool, err error) {
+	now := time.Now().UnixNano()
+	tx, err := sqlStorage.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	result, err := tx.Exec(sqlStorage.Dialect.rebind(`UPDATE entries SET remaining_downloads = remaining_downloads - 1
+		WHERE call_reference = ? AND complete = 1 AND maximum_downloads > 0 AND `+sqlAvailable), callReference, now)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	var maximumDownloads, remainingDownloads int
+	if err = tx.QueryRow(sqlStorage.Dialect.rebind(`SELECT maximum_downloads, remaining_downloads FROM entries
+		WHERE call_reference = ? AND complete = 1 AND `+sqlNotExpired), callReference, now).Scan(&maximumDownloads,
+		&remainingDownloads); err == sql.ErrNoRows {
+		tx.Rollback()
+		return false, storage.ErrEntryNotFound
+	} else if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	if affected == 0 {
+		if maximumDownloads > 0 {
+			// the downloads are exhausted
+			return false, storage.ErrEntryNotFound
+		}
+		return false, nil
+	}
+	return remainingDownloads == 0, nil
+}
+
+// Close is the implementation of the FileStorage.Close method.
+func (sqlStorage *SQLStorage) Close() error {
+	return sqlStorage.DB.Close()
+}
+
+// sqlWriter buffers the written data and stores it as chunk rows. Closing the writer stores the metadata of the entry.
+type sqlWriter struct {
+	sqlStorage *SQLStorage
+	entry      *storage.Entry
+	id         int64
+	buffer     []byte
+	chunkIndex int
+	size       int64
+	checksum   checksumHash
+}
+
+// Write is the implementation of the io.Writer interface method.
+func (writer *sqlWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		free := cap(writer.buffer) - len(writer.buffer)
+		if free > len(p) {
+			free = len(p)
+		}
+		writer.buffer = append(writer.buffer, p[:free]...)
+		writer.checksum.Write(p[:free])
+		p = p[free:]
+		n += free
+		if len(writer.buffer) == cap(writer.buffer) {
+			if err = writer.flush(); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// flush stores the buffered data as the next chunk row.
+func (writer *sqlWriter) flush() error {
+	if len(writer.buffer) == 0 {
+		return nil
+	}
+	if _, err := writer.sqlStorage.exec(`INSERT INTO chunks (entry_id, chunk_index, data) VALUES (?, ?, ?)`,
+		writer.id, writer.chunkIndex, writer.buffer); err != nil {
+		return err
+	}
+	writer.chunkIndex++
+	writer.size += int64(len(writer.buffer))
+	writer.buffer = writer.buffer[:0]
+	return nil
+}
+
+// Close is the implementation of the io.Closer interface method. It stores the remaining data and the metadata. The
+// chunks are replaced by the ones of an existing blob with the same checksum.
+func (writer *sqlWriter) Close() error {
+	if err := writer.flush(); err != nil {
+		return err
+	}
+	sqlStorage := writer.sqlStorage
+	entry := writer.entry
+	entry.SHA256 = writer.checksum.String()
+	tx, err := sqlStorage.DB.Begin()
+	if err != nil {
+		return err
+	}
+	blob, err := sqlStorage.acquireBlob(tx, entry.SHA256, &sqlBlob{
+		dataID:    writer.id,
+		size:      writer.size,
+		chunkSize: cap(writer.buffer),
+	})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	result, err := tx.Exec(sqlStorage.Dialect.rebind(`UPDATE entries SET author = ?, filename = ?, content_type = ?,
+		upload_date = ?, expiration_date = ?, maximum_downloads = ?, remaining_downloads = ?, password_hash = ?,
+		encryption_key_id = ?, sha256 = ?, md5 = ?, data_id = ?, size = ?, chunk_size = ?, complete = 1 WHERE id = ?`),
+		string(entry.Author), entry.Filename, entry.ContentType, entry.UploadDate.UnixNano(),
+		nullableUnixNano(entry.ExpirationDate), entry.MaximumDownloads, entry.RemainingDownloads, entry.PasswordHash,
+		entry.EncryptionKeyID, entry.SHA256, entry.MD5, blob.dataID, blob.size, blob.chunkSize, writer.id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		// the entry has been deleted in the meantime
+		tx.Rollback()
+		sqlStorage.exec(`DELETE FROM chunks WHERE entry_id = ?`, writer.id)
+		return storage.ErrEntryNotFound
+	}
+	return tx.Commit()
+}
+
+// Abort is the implementation of the storage.AbortableWriter interface method. The buffered data is discarded and the
+// written chunks are deleted together with the incomplete entry.
+func (writer *sqlWriter) Abort() error {
+	return writer.sqlStorage.Delete(writer.entry.DeleteReference)
+}
+
+// sqlReader reads the chunked file data of an entry. The current chunk is cached to avoid querying it for every
+// Read call.
+type sqlReader struct {
+	sqlStorage *SQLStorage
+	id         int64
+	size       int64
+	chunkSize  int64
+	offset     int64
+	chunkIndex int64
+	chunk      []byte
+}
+
+// Read is the implementation of the io.Reader interface method.
+func (reader *sqlReader) Read(p []byte) (n int, err error) {
+	if reader.offset >= reader.size {
+		return 0, io.EOF
+	}
+	chunkIndex := reader.offset / reader.chunkSize
+	if chunkIndex != reader.chunkIndex {
+		if err = reader.sqlStorage.queryRow(`SELECT data FROM chunks WHERE entry_id = ? AND chunk_index = ?`,
+			reader.id, chunkIndex).Scan(&reader.chunk); err == sql.ErrNoRows {
+			return 0, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return 0, err
+		}
+		reader.chunkIndex = chunkIndex
+	}
+	chunkOffset := reader.offset % reader.chunkSize
+	if int64(len(reader.chunk)) <= chunkOffset {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n = copy(p, reader.chunk[chunkOffset:])
+	reader.offset += int64(n)
+	return
+}
+
+// Seek is the implementation of the io.Seeker interface method.
+func (reader *sqlReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += reader.offset
+	case io.SeekEnd:
+		offset += reader.size
+	default:
+		return reader.offset, os.ErrInvalid
+	}
+	if offset < 0 {
+		return reader.offset, errNegativeOffset
+	}
+	reader.offset = offset
+	return offset, nil
+}
+
+// Close is the implementation of the io.Closer interface method.
+func (reader *sqlReader) Close() error {
+	// every chunk is queried separately which means that nothing has to be closed
+	reader.chunk = nil
+	return nil
+}
@@ -0,0 +1,108 @@
+package storages
+
+import (
+	"bytes"
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSQLStorage(t *testing.T) {
+	directory, err := ioutil.TempDir("", "gosharexserver")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory, %T: %v", err, err)
+	}
+	defer os.RemoveAll(directory)
+	db, err := sql.Open(string(SQLiteDialect), filepath.Join(directory, "test.sqlite"))
+	if err != nil {
+		t.Fatalf("Could not open SQLite database, %T: %v", err, err)
+	}
+	sqlStorage := &SQLStorage{
+		DB:        db,
+		Dialect:   SQLiteDialect,
+		ChunkSize: 4,
+	}
+	if err = sqlStorage.Initialize(); err != nil {
+		t.Fatalf("Could not initialize SQL storage, %T: %v", err, err)
+	}
+	defer sqlStorage.Close()
+	// running the migrations a second time must not fail
+	if err = sqlStorage.Initialize(); err != nil {
+		t.Fatalf("Could not initialize already migrated SQL storage, %T: %v", err, err)
+	}
+	testBytes := []byte("Hello, this is a test!")
+	entry := &storage.Entry{
+		Author:      storage.AuthorIdentifier("a testing person"),
+		Filename:    "testfile.txt",
+		ContentType: "text/plain",
+		UploadDate:  time.Now(),
+	}
+	writer, err := sqlStorage.Store(entry)
+	if err != nil {
+		t.Fatalf("Could not store entry, %T: %v", err, err)
+	}
+	if _, err = sqlStorage.Request(entry.CallReference); err != storage.ErrEntryNotFound {
+		t.Fatalf("Incomplete entry could be requested, err: %v", err)
+	}
+	if _, err = writer.Write(testBytes); err != nil {
+		t.Fatalf("Could not write entry data, %T: %v", err, err)
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatalf("Could not close entry writer, %T: %v", err, err)
+	}
+	requestedEntry, err := sqlStorage.Request(entry.CallReference)
+	if err != nil {
+		t.Fatalf("Could not request stored entry, %T: %v", err, err)
+	}
+	defer requestedEntry.Reader.Close()
+	if requestedEntry.Author != entry.Author || requestedEntry.Filename != entry.Filename ||
+		requestedEntry.ContentType != entry.ContentType || !requestedEntry.UploadDate.Equal(entry.UploadDate) {
+		t.Fatalf("Requested entry metadata %+v does not match the stored metadata %+v", requestedEntry, entry)
+	}
+	requestedBytes, err := ioutil.ReadAll(requestedEntry.Reader)
+	if err != nil {
+		t.Fatalf("Could not read requested entry data, %T: %v", err, err)
+	}
+	if !bytes.Equal(testBytes, requestedBytes) {
+		t.Fatalf("Requested data %q does not match the stored data %q", requestedBytes, testBytes)
+	}
+	if _, err = requestedEntry.Reader.Seek(5, io.SeekStart); err != nil {
+		t.Fatalf("Could not seek requested entry data, %T: %v", err, err)
+	}
+	if requestedBytes, err = ioutil.ReadAll(requestedEntry.Reader); err != nil {
+		t.Fatalf("Could not read requested entry data, %T: %v", err, err)
+	}
+	if !bytes.Equal(testBytes[5:], requestedBytes) {
+		t.Fatalf("Requested data %q after seeking does not match the stored data", requestedBytes)
+	}
+	if err = sqlStorage.Delete(entry.DeleteReference); err != nil {
+		t.Fatalf("Could not delete entry, %T: %v", err, err)
+	}
+	if _, err = sqlStorage.Request(entry.CallReference); err != storage.ErrEntryNotFound {
+		t.Fatalf("Deleted entry could still be requested, err: %v", err)
+	}
+	var chunks int
+	if err = db.QueryRow(`SELECT COUNT(*) FROM chunks`).Scan(&chunks); err != nil || chunks != 0 {
+		t.Fatalf("Chunks of the deleted entry were not removed (%d chunks left), err: %v", chunks, err)
+	}
+	testPresetReferencesAndList(t, sqlStorage)
+	testExpiry(t, sqlStorage)
+	testDownloadLimit(t, sqlStorage)
+	testConcurrentDownloads(t, sqlStorage)
+	testAbort(t, sqlStorage)
+	testDeduplication(t, sqlStorage, func() (blobs int) {
+		if err := db.QueryRow(`SELECT COUNT(*) FROM blobs`).Scan(&blobs); err != nil {
+			t.Fatalf("Could not count blobs, %T: %v", err, err)
+		}
+		return
+	})
+	testDerivedStorage(t, sqlStorage)
+	testUsage(t, sqlStorage)
+	testUserStorage(t, sqlStorage)
+}
@@ -0,0 +1,66 @@
+package storages
+
+import (
+	"database/sql"
+)
+
+// sqlBlob is a row of the blobs table. The data id is the id of the entry which has written the chunks.
+type sqlBlob struct {
+	dataID    int64
+	size      int64
+	chunkSize int
+}
+
+// acquireBlob adds a reference to an existing blob with the given checksum and deletes the written chunks. The written
+// chunks become a new blob if there is no such blob. Concurrent uploads of the same data may create multiple blobs with
+// the same checksum which only costs storage space.
+func (sqlStorage *SQLStorage) acquireBlob(tx *sql.Tx, checksum string, written *sqlBlob) (*sqlBlob, error) {
+	blob := &sqlBlob{}
+	err := tx.QueryRow(sqlStorage.Dialect.rebind(`SELECT data_id, size, chunk_size FROM blobs
+		WHERE sha256 = ? AND reference_count > 0 ORDER BY data_id LIMIT 1`), checksum).Scan(&blob.dataID, &blob.size,
+		&blob.chunkSize)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if err == nil {
+		// the blob may have been released in the meantime
+		result, err := tx.Exec(sqlStorage.Dialect.rebind(`UPDATE blobs SET reference_count = reference_count + 1
+			WHERE data_id = ? AND reference_count > 0`), blob.dataID)
+		if err != nil {
+			return nil, err
+		}
+		if affected, err := result.RowsAffected(); err != nil {
+			return nil, err
+		} else if affected == 1 {
+			if _, err = tx.Exec(sqlStorage.Dialect.rebind(`DELETE FROM chunks WHERE entry_id = ?`),
+				written.dataID); err != nil {
+				return nil, err
+			}
+			return blob, nil
+		}
+	}
+	if _, err = tx.Exec(sqlStorage.Dialect.rebind(`INSERT INTO blobs (data_id, sha256, size, chunk_size,
+		reference_count) VALUES (?, ?, ?, ?, 1)`), written.dataID, checksum, written.size,
+		written.chunkSize); err != nil {
+		return nil, err
+	}
+	return written, nil
+}
+
+// releaseBlob removes a reference from the blob with the given data id and deletes its chunks if it was the last one.
+func (sqlStorage *SQLStorage) releaseBlob(tx *sql.Tx, dataID int64) error {
+	if _, err := tx.Exec(sqlStorage.Dialect.rebind(`UPDATE blobs SET reference_count = reference_count - 1
+		WHERE data_id = ?`), dataID); err != nil {
+		return err
+	}
+	result, err := tx.Exec(sqlStorage.Dialect.rebind(`DELETE FROM blobs WHERE data_id = ? AND reference_count <= 0`),
+		dataID)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return err
+	}
+	_, err = tx.Exec(sqlStorage.Dialect.rebind(`DELETE FROM chunks WHERE entry_id = ?`), dataID)
+	return err
+}
@@ -0,0 +1,47 @@
+package storages
+
+import (
+	"database/sql"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+)
+
+// StoreDerived is the implementation of the DerivedStorage.StoreDerived method. The derived data is stored in the
+// derived table.
+func (sqlStorage *SQLStorage) StoreDerived(callReference, name string, data []byte) error {
+	if !validReference(name) {
+		return errInvalidDerivedName
+	}
+	tx, err := sqlStorage.DB.Begin()
+	if err != nil {
+		return err
+	}
+	var id int64
+	if err = tx.QueryRow(sqlStorage.Dialect.rebind(`SELECT id FROM entries WHERE call_reference = ? AND complete = 1`),
+		callReference).Scan(&id); err == sql.ErrNoRows {
+		tx.Rollback()
+		return storage.ErrEntryNotFound
+	} else if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err = tx.Exec(sqlStorage.Dialect.rebind(`DELETE FROM derived WHERE entry_id = ? AND name = ?`), id,
+		name); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err = tx.Exec(sqlStorage.Dialect.rebind(`INSERT INTO derived (entry_id, name, data) VALUES (?, ?, ?)`), id,
+		name, data); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// RequestDerived is the implementation of the DerivedStorage.RequestDerived method.
+func (sqlStorage *SQLStorage) RequestDerived(callReference, name string) (data []byte, err error) {
+	if err = sqlStorage.queryRow(`SELECT derived.data FROM derived INNER JOIN entries ON derived.entry_id = entries.id
+		WHERE entries.call_reference = ? AND derived.name = ?`, callReference, name).Scan(&data); err == sql.ErrNoRows {
+		return nil, storage.ErrEntryNotFound
+	}
+	return
+}
@@ -0,0 +1,10 @@
+package storages
+
+import "github.com/mmichaelb/gosharexserver/pkg/storage"
+
+// Usage is the implementation of the UsageStorage.Usage method.
+func (sqlStorage *SQLStorage) Usage(author storage.AuthorIdentifier) (usage int64, err error) {
+	err = sqlStorage.queryRow(`SELECT COALESCE(SUM(size), 0) FROM entries WHERE author = ? AND complete = 1`,
+		string(author)).Scan(&usage)
+	return
+}
@@ -0,0 +1,158 @@
+package storages
+
+import (
+	"database/sql"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"time"
+)
+
+// CreateUser is the implementation of the UserStorage.CreateUser method.
+func (sqlStorage *SQLStorage) CreateUser(user *storage.User) error {
+	if !storage.ValidUsername(user.Name) {
+		return storage.ErrInvalidUsername
+	}
+	if _, err := sqlStorage.RequestUser(user.Name); err == nil {
+		return storage.ErrUserExists
+	} else if err != storage.ErrUserNotFound {
+		return err
+	}
+	_, err := sqlStorage.exec(`INSERT INTO users (name, role, creation_date) VALUES (?, ?, ?)`, user.Name,
+		string(user.Role), user.CreationDate.UnixNano())
+	return err
+}
+
+// RequestUser is the implementation of the UserStorage.RequestUser method.
+func (sqlStorage *SQLStorage) RequestUser(name string) (*storage.User, error) {
+	user, err := scanUser(sqlStorage.queryRow(`SELECT name, role, creation_date FROM users WHERE name = ?`, name))
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrUserNotFound
+	}
+	return user, err
+}
+
+// ListUsers is the implementation of the UserStorage.ListUsers method.
+func (sqlStorage *SQLStorage) ListUsers() (users []*storage.User, err error) {
+	rows, err := sqlStorage.DB.Query(`SELECT name, role, creation_date FROM users ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// HasUsers is the implementation of the UserStorage.HasUsers method.
+func (sqlStorage *SQLStorage) HasUsers() (bool, error) {
+	var exists int
+	err := sqlStorage.DB.QueryRow(`SELECT 1 FROM users LIMIT 1`).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// scanUser scans the name, role and creation_date columns of a row.
+func scanUser(scanner sqlScanner) (*storage.User, error) {
+	var role string
+	var creationDate int64
+	user := &storage.User{}
+	if err := scanner.Scan(&user.Name, &role, &creationDate); err != nil {
+		return nil, err
+	}
+	user.Role = storage.Role(role)
+	user.CreationDate = time.Unix(0, creationDate)
+	return user, nil
+}
+
+// DeleteUser is the implementation of the UserStorage.DeleteUser method.
+func (sqlStorage *SQLStorage) DeleteUser(name string) error {
+	tx, err := sqlStorage.DB.Begin()
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec(sqlStorage.Dialect.rebind(`DELETE FROM users WHERE name = ?`), name)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if deleted, err := result.RowsAffected(); err != nil {
+		tx.Rollback()
+		return err
+	} else if deleted == 0 {
+		tx.Rollback()
+		return storage.ErrUserNotFound
+	}
+	if _, err = tx.Exec(sqlStorage.Dialect.rebind(`DELETE FROM tokens WHERE username = ?`), name); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// StoreToken is the implementation of the UserStorage.StoreToken method.
+func (sqlStorage *SQLStorage) StoreToken(token *storage.Token) error {
+	_, err := sqlStorage.exec(`INSERT INTO tokens (hash, id, username, description, creation_date)
+		VALUES (?, ?, ?, ?, ?)`, token.Hash, token.ID, token.Username, token.Description, token.CreationDate.UnixNano())
+	return err
+}
+
+// RequestToken is the implementation of the UserStorage.RequestToken method.
+func (sqlStorage *SQLStorage) RequestToken(hash string) (*storage.Token, error) {
+	token, err := scanToken(sqlStorage.queryRow(`SELECT hash, id, username, description, creation_date FROM tokens
+		WHERE hash = ?`, hash))
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrTokenNotFound
+	}
+	return token, err
+}
+
+// ListTokens is the implementation of the UserStorage.ListTokens method.
+func (sqlStorage *SQLStorage) ListTokens(username string) (tokens []*storage.Token, err error) {
+	rows, err := sqlStorage.DB.Query(sqlStorage.Dialect.rebind(`SELECT hash, id, username, description, creation_date
+		FROM tokens WHERE username = ? ORDER BY creation_date`), username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		token, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// scanToken scans the hash, id, username, description and creation_date columns of a row.
+func scanToken(scanner sqlScanner) (*storage.Token, error) {
+	var creationDate int64
+	token := &storage.Token{}
+	if err := scanner.Scan(&token.Hash, &token.ID, &token.Username, &token.Description, &creationDate); err != nil {
+		return nil, err
+	}
+	token.CreationDate = time.Unix(0, creationDate)
+	return token, nil
+}
+
+// RevokeToken is the implementation of the UserStorage.RevokeToken method.
+func (sqlStorage *SQLStorage) RevokeToken(id string) error {
+	result, err := sqlStorage.exec(`DELETE FROM tokens WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if revoked, err := result.RowsAffected(); err != nil {
+		return err
+	} else if revoked == 0 {
+		return storage.ErrTokenNotFound
+	}
+	return nil
+}
@@ -0,0 +1,530 @@
+package storages
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/mmichaelb/gosharexserver/pkg/storage"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// testPresetReferencesAndList stores entries with preset references and pages through them with the List method. The
+// given storage has to be empty.
+func testPresetReferencesAndList(t *testing.T, fileStorage storage.FileStorage) {
+	presetReferences := []string{"entry1", "entry2", "entry3"}
+	uploadDate := time.Now().Round(time.Second)
+	for i, presetReference := range presetReferences {
+		entry := &storage.Entry{
+			CallReference:   presetReference,
+			DeleteReference: presetReference + "-delete",
+			Author:          storage.AuthorIdentifier("a testing person"),
+			Filename:        presetReference + ".txt",
+			ContentType:     "text/plain",
+			UploadDate:      uploadDate.Add(time.Duration(i) * time.Hour),
+		}
+		if i == 1 {
+			entry.Author = storage.AuthorIdentifier("another testing person")
+			entry.ContentType = "image/png"
+			entry.PasswordHash = "$2a$10$hash"
+		}
+		writer, err := fileStorage.Store(entry)
+		if err != nil {
+			t.Fatalf("Could not store entry with preset references, %T: %v", err, err)
+		}
+		if entry.CallReference != presetReference || entry.DeleteReference != presetReference+"-delete" {
+			t.Fatalf("Preset references were not kept, got %q and %q", entry.CallReference, entry.DeleteReference)
+		}
+		if err = writer.Close(); err != nil {
+			t.Fatalf("Could not close entry writer, %T: %v", err, err)
+		}
+	}
+	if _, err := fileStorage.Store(&storage.Entry{CallReference: presetReferences[0]}); err != storage.ErrReferenceInUse {
+		t.Fatalf("Used call reference was not rejected, err: %v", err)
+	}
+	if _, err := fileStorage.Store(&storage.Entry{CallReference: "../entry"}); err == nil {
+		t.Fatal("Invalid call reference was not rejected")
+	}
+	entries, nextCursor, err := fileStorage.List(storage.ListFilter{}, "", 2)
+	if err != nil {
+		t.Fatalf("Could not list entries, %T: %v", err, err)
+	}
+	if len(entries) != 2 || nextCursor == "" {
+		t.Fatalf("Invalid first page of %d entries with cursor %q", len(entries), nextCursor)
+	}
+	nextEntries, nextCursor, err := fileStorage.List(storage.ListFilter{}, nextCursor, 2)
+	if err != nil {
+		t.Fatalf("Could not list entries, %T: %v", err, err)
+	}
+	if len(nextEntries) != 1 || nextCursor != "" {
+		t.Fatalf("Invalid second page of %d entries with cursor %q", len(nextEntries), nextCursor)
+	}
+	for i, entry := range append(entries, nextEntries...) {
+		if entry.CallReference != presetReferences[i] || entry.Filename != presetReferences[i]+".txt" ||
+			(entry.PasswordHash != "") != (i == 1) {
+			t.Fatalf("Listed entry %+v does not match the stored entry %q", entry, presetReferences[i])
+		}
+	}
+	if _, _, err = fileStorage.List(storage.ListFilter{}, "../invalid", 2); err != storage.ErrInvalidCursor {
+		t.Fatalf("Invalid cursor was not rejected, err: %v", err)
+	}
+	// every filter only matches a single entry
+	for _, filter := range []storage.ListFilter{
+		{Author: storage.AuthorIdentifier("another testing person")},
+		{ContentType: "image/png"},
+		{UploadedAfter: uploadDate, UploadedBefore: uploadDate.Add(time.Hour * 2)},
+	} {
+		if entries, nextCursor, err = fileStorage.List(filter, "", 1); err != nil {
+			t.Fatalf("Could not list filtered entries, %T: %v", err, err)
+		}
+		if len(entries) != 1 || entries[0].CallReference != presetReferences[1] || nextCursor != "" {
+			t.Fatalf("Filter %+v returned %d entries with cursor %q", filter, len(entries), nextCursor)
+		}
+	}
+}
+
+// testExpiry stores an expired entry and an entry which expires later and checks that only the expired one is hidden
+// and deleted. It has to be called after testPresetReferencesAndList.
+func testExpiry(t *testing.T, fileStorage storage.FileStorage) {
+	now := time.Now().Round(time.Second)
+	for _, entry := range []*storage.Entry{
+		{CallReference: "expired", ExpirationDate: now.Add(-time.Minute)},
+		{CallReference: "expiring", ExpirationDate: now.Add(time.Hour)},
+	} {
+		entry.Filename = entry.CallReference + ".txt"
+		entry.UploadDate = now
+		writer, err := fileStorage.Store(entry)
+		if err != nil {
+			t.Fatalf("Could not store entry with expiration date, %T: %v", err, err)
+		}
+		if err = writer.Close(); err != nil {
+			t.Fatalf("Could not close entry writer, %T: %v", err, err)
+		}
+	}
+	if _, err := fileStorage.Request("expired"); err != storage.ErrEntryNotFound {
+		t.Fatalf("Expired entry was not hidden, err: %v", err)
+	}
+	if entry, err := fileStorage.Lookup("expired"); err != nil {
+		t.Fatalf("Could not look up expired entry, %T: %v", err, err)
+	} else if entry.CallReference != "expired" || entry.Reader != nil {
+		t.Fatalf("Looked up entry does not match, got %+v", entry)
+	}
+	if _, err := fileStorage.Lookup("missing"); err != storage.ErrEntryNotFound {
+		t.Fatalf("Missing entry was found, err: %v", err)
+	}
+	entry, err := fileStorage.Request("expiring")
+	if err != nil {
+		t.Fatalf("Could not request expiring entry, %T: %v", err, err)
+	}
+	entry.Reader.Close()
+	if !entry.ExpirationDate.Equal(now.Add(time.Hour)) {
+		t.Fatalf("Expiration date was not kept, got %v", entry.ExpirationDate)
+	}
+	entries, _, err := fileStorage.List(storage.ListFilter{}, "", 10)
+	if err != nil {
+		t.Fatalf("Could not list entries, %T: %v", err, err)
+	}
+	for _, entry := range entries {
+		if entry.CallReference == "expired" {
+			t.Fatal("Expired entry was listed")
+		}
+	}
+	if deleted, err := fileStorage.DeleteExpired(now); err != nil || deleted != 1 {
+		t.Fatalf("Expected a single expired entry to be deleted, got %d, err: %v", deleted, err)
+	}
+	if deleted, err := fileStorage.DeleteExpired(now.Add(2 * time.Hour)); err != nil || deleted != 1 {
+		t.Fatalf("Expected the expiring entry to be deleted, got %d, err: %v", deleted, err)
+	}
+	if _, err = fileStorage.Request("expiring"); err != storage.ErrEntryNotFound {
+		t.Fatalf("Deleted entry is still available, err: %v", err)
+	}
+}
+
+// testDownloadLimit stores an entry which can be downloaded twice and consumes its downloads.
+func testDownloadLimit(t *testing.T, fileStorage storage.FileStorage) {
+	entry := &storage.Entry{
+		CallReference:      "limited",
+		Filename:           "limited.txt",
+		UploadDate:         time.Now(),
+		MaximumDownloads:   2,
+		RemainingDownloads: 2,
+	}
+	writer, err := fileStorage.Store(entry)
+	if err != nil {
+		t.Fatalf("Could not store entry with download limit, %T: %v", err, err)
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatalf("Could not close entry writer, %T: %v", err, err)
+	}
+	for i, expectedLastDownload := range []bool{false, true} {
+		lastDownload, err := fileStorage.ConsumeDownload(entry.CallReference)
+		if err != nil {
+			t.Fatalf("Could not consume download %d, %T: %v", i+1, err, err)
+		}
+		if lastDownload != expectedLastDownload {
+			t.Fatalf("Download %d returned last download %v", i+1, lastDownload)
+		}
+	}
+	if _, err = fileStorage.ConsumeDownload(entry.CallReference); err != storage.ErrEntryNotFound {
+		t.Fatalf("Exhausted downloads were consumed, err: %v", err)
+	}
+	if _, err = fileStorage.Request(entry.CallReference); err != storage.ErrEntryNotFound {
+		t.Fatalf("Entry with exhausted downloads was not hidden, err: %v", err)
+	}
+	if lookedUpEntry, err := fileStorage.Lookup(entry.CallReference); err != nil {
+		t.Fatalf("Could not look up entry with exhausted downloads, %T: %v", err, err)
+	} else if lookedUpEntry.DeleteReference != entry.DeleteReference || lookedUpEntry.RemainingDownloads != 0 {
+		t.Fatalf("Looked up entry does not match, got %+v", lookedUpEntry)
+	}
+	if err = fileStorage.Delete(entry.DeleteReference); err != nil {
+		t.Fatalf("Could not delete entry with exhausted downloads, %T: %v", err, err)
+	}
+	// entries without a download limit are not modified
+	if lastDownload, err := fileStorage.ConsumeDownload("entry1"); err != nil || lastDownload {
+		t.Fatalf("Unlimited entry returned last download %v, err: %v", lastDownload, err)
+	}
+	if _, err = fileStorage.ConsumeDownload("missing"); err != storage.ErrEntryNotFound {
+		t.Fatalf("Missing entry was not reported, err: %v", err)
+	}
+}
+
+// testConcurrentDownloads consumes the downloads of an entry with a download limit from concurrent goroutines. Exactly
+// the allowed amount of downloads has to succeed and exactly one of them has to be the last download.
+func testConcurrentDownloads(t *testing.T, fileStorage storage.FileStorage) {
+	const maximumDownloads, downloads = 5, 20
+	entry := &storage.Entry{
+		Filename:           "concurrent.txt",
+		UploadDate:         time.Now(),
+		MaximumDownloads:   maximumDownloads,
+		RemainingDownloads: maximumDownloads,
+	}
+	writer, err := fileStorage.Store(entry)
+	if err != nil {
+		t.Fatalf("Could not store entry with download limit, %T: %v", err, err)
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatalf("Could not close entry writer, %T: %v", err, err)
+	}
+	type result struct {
+		lastDownload bool
+		err          error
+	}
+	results := make(chan result, downloads)
+	start := make(chan struct{})
+	for i := 0; i < downloads; i++ {
+		go func() {
+			<-start
+			lastDownload, err := fileStorage.ConsumeDownload(entry.CallReference)
+			results <- result{lastDownload, err}
+		}()
+	}
+	close(start)
+	var consumed, lastDownloads int
+	for i := 0; i < downloads; i++ {
+		result := <-results
+		if result.err == storage.ErrEntryNotFound {
+			continue
+		} else if result.err != nil {
+			t.Fatalf("Could not consume download, %T: %v", result.err, result.err)
+		}
+		consumed++
+		if result.lastDownload {
+			lastDownloads++
+		}
+	}
+	if consumed != maximumDownloads || lastDownloads != 1 {
+		t.Fatalf("Expected %d downloads and a single last download, got %d downloads and %d last downloads",
+			maximumDownloads, consumed, lastDownloads)
+	}
+	if err = fileStorage.Delete(entry.DeleteReference); err != nil {
+		t.Fatalf("Could not delete entry with exhausted downloads, %T: %v", err, err)
+	}
+}
+
+// testDeduplication stores identical data twice and checks that the entries share a single blob which is deleted
+// together with the last entry. The blobs function returns the amount of stored blobs.
+func testDeduplication(t *testing.T, fileStorage storage.FileStorage, blobs func() int) {
+	initialBlobs := blobs()
+	var entries []*storage.Entry
+	for _, data := range []string{"duplicate data", "duplicate data", "unique data"} {
+		entry := &storage.Entry{Filename: "deduplicated.txt", UploadDate: time.Now()}
+		writer, err := fileStorage.Store(entry)
+		if err != nil {
+			t.Fatalf("Could not store entry, %T: %v", err, err)
+		}
+		if _, err = writer.Write([]byte(data)); err != nil {
+			t.Fatalf("Could not write entry data, %T: %v", err, err)
+		}
+		md5Checksum := md5.Sum([]byte(data))
+		entry.MD5 = hex.EncodeToString(md5Checksum[:])
+		if err = writer.Close(); err != nil {
+			t.Fatalf("Could not close entry writer, %T: %v", err, err)
+		}
+		if checksum := sha256.Sum256([]byte(data)); entry.SHA256 != hex.EncodeToString(checksum[:]) {
+			t.Fatalf("Invalid checksum %q of the data %q", entry.SHA256, data)
+		}
+		entries = append(entries, entry)
+	}
+	if storedBlobs := blobs() - initialBlobs; storedBlobs != 2 {
+		t.Fatalf("Expected 2 new blobs, got %d", storedBlobs)
+	}
+	// the shared blob must outlive the first entry
+	if err := fileStorage.Delete(entries[0].DeleteReference); err != nil {
+		t.Fatalf("Could not delete entry, %T: %v", err, err)
+	}
+	if err := fileStorage.Delete(entries[0].DeleteReference); err != storage.ErrEntryNotFound {
+		t.Fatalf("Deleted entry could be deleted again, err: %v", err)
+	}
+	entry, err := fileStorage.Request(entries[1].CallReference)
+	if err != nil {
+		t.Fatalf("Could not request deduplicated entry, %T: %v", err, err)
+	}
+	data, err := ioutil.ReadAll(entry.Reader)
+	entry.Reader.Close()
+	if err != nil {
+		t.Fatalf("Could not read deduplicated entry, %T: %v", err, err)
+	}
+	if string(data) != "duplicate data" || entry.SHA256 != entries[1].SHA256 || entry.MD5 != entries[1].MD5 {
+		t.Fatalf("Deduplicated entry returned %q with checksums %q and %q", data, entry.SHA256, entry.MD5)
+	}
+	for _, entry := range entries[1:] {
+		if err = fileStorage.Delete(entry.DeleteReference); err != nil {
+			t.Fatalf("Could not delete entry, %T: %v", err, err)
+		}
+	}
+	if remainingBlobs := blobs() - initialBlobs; remainingBlobs != 0 {
+		t.Fatalf("%d blobs of the deleted entries were not removed", remainingBlobs)
+	}
+}
+
+// testDerivedStorage stores derived data of an entry and checks that it is deleted together with the entry.
+func testDerivedStorage(t *testing.T, fileStorage storage.FileStorage) {
+	derivedStorage := fileStorage.(storage.DerivedStorage)
+	entry := &storage.Entry{Filename: "derived.png", UploadDate: time.Now()}
+	writer, err := fileStorage.Store(entry)
+	if err != nil {
+		t.Fatalf("Could not store entry, %T: %v", err, err)
+	}
+	if err = derivedStorage.StoreDerived(entry.CallReference, "thumb-128", []byte("early")); err != storage.ErrEntryNotFound {
+		t.Fatalf("Derived data of an incomplete entry was stored, err: %v", err)
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatalf("Could not close entry writer, %T: %v", err, err)
+	}
+	for _, data := range []string{"first", "replaced"} {
+		if err = derivedStorage.StoreDerived(entry.CallReference, "thumb-128", []byte(data)); err != nil {
+			t.Fatalf("Could not store derived data, %T: %v", err, err)
+		}
+	}
+	if data, err := derivedStorage.RequestDerived(entry.CallReference, "thumb-128"); err != nil ||
+		string(data) != "replaced" {
+		t.Fatalf("Requested derived data %q does not match the stored data, err: %v", data, err)
+	}
+	if _, err = derivedStorage.RequestDerived(entry.CallReference, "thumb-256"); err != storage.ErrEntryNotFound {
+		t.Fatalf("Missing derived data was not reported, err: %v", err)
+	}
+	if err = derivedStorage.StoreDerived(entry.CallReference, "../thumb", []byte("invalid")); err == nil {
+		t.Fatal("Derived data with an invalid name was stored")
+	}
+	if err = derivedStorage.StoreDerived("missing", "thumb-128", []byte("missing")); err != storage.ErrEntryNotFound {
+		t.Fatalf("Derived data of a missing entry was stored, err: %v", err)
+	}
+	if err = fileStorage.Delete(entry.DeleteReference); err != nil {
+		t.Fatalf("Could not delete entry, %T: %v", err, err)
+	}
+	if _, err = derivedStorage.RequestDerived(entry.CallReference, "thumb-128"); err != storage.ErrEntryNotFound {
+		t.Fatalf("Derived data of the deleted entry could still be requested, err: %v", err)
+	}
+}
+
+// testAbort aborts the writer of an entry and checks that the entry never becomes available.
+func testAbort(t *testing.T, fileStorage storage.FileStorage) {
+	author := storage.AuthorIdentifier("aborting author")
+	entry := &storage.Entry{CallReference: "aborted", Author: author, Filename: "aborted.txt", UploadDate: time.Now()}
+	writer, err := fileStorage.Store(entry)
+	if err != nil {
+		t.Fatalf("Could not store entry, %T: %v", err, err)
+	}
+	if _, err = writer.Write([]byte("aborted data")); err != nil {
+		t.Fatalf("Could not write entry data, %T: %v", err, err)
+	}
+	if err = writer.(storage.AbortableWriter).Abort(); err != nil {
+		t.Fatalf("Could not abort entry writer, %T: %v", err, err)
+	}
+	if _, err = fileStorage.Request(entry.CallReference); err != storage.ErrEntryNotFound {
+		t.Fatalf("Aborted entry could be requested, err: %v", err)
+	}
+	if err = fileStorage.Delete(entry.DeleteReference); err != storage.ErrEntryNotFound {
+		t.Fatalf("Aborted entry could be deleted, err: %v", err)
+	}
+	if usage, err := fileStorage.(storage.UsageStorage).Usage(author); err != nil || usage != 0 {
+		t.Fatalf("Expected the aborted entry not to be counted, got %d, err: %v", usage, err)
+	}
+	// the references of the aborted entry are released
+	if writer, err = fileStorage.Store(&storage.Entry{CallReference: entry.CallReference,
+		DeleteReference: entry.DeleteReference, Filename: "aborted.txt", UploadDate: time.Now()}); err != nil {
+		t.Fatalf("Could not reuse the references of the aborted entry, %T: %v", err, err)
+	}
+	if err = writer.(storage.AbortableWriter).Abort(); err != nil {
+		t.Fatalf("Could not abort entry writer, %T: %v", err, err)
+	}
+}
+
+// testUsage stores entries of different authors and checks that only the complete and available entries of an author
+// are counted. The given storage must not contain any entries of the author "usage author".
+func testUsage(t *testing.T, fileStorage storage.FileStorage) {
+	usageStorage := fileStorage.(storage.UsageStorage)
+	author := storage.AuthorIdentifier("usage author")
+	var deleteReferences []string
+	for _, entry := range []*storage.Entry{
+		{Author: author},
+		// deduplicated data is counted for every entry
+		{Author: author},
+		{Author: author, ExpirationDate: time.Now().Add(-time.Minute)},
+		{Author: storage.AuthorIdentifier("another usage author")},
+	} {
+		entry.Filename = "usage.txt"
+		entry.UploadDate = time.Now()
+		writer, err := fileStorage.Store(entry)
+		if err != nil {
+			t.Fatalf("Could not store entry, %T: %v", err, err)
+		}
+		if _, err = writer.Write([]byte("usage data")); err != nil {
+			t.Fatalf("Could not write entry data, %T: %v", err, err)
+		}
+		if err = writer.Close(); err != nil {
+			t.Fatalf("Could not close entry writer, %T: %v", err, err)
+		}
+		deleteReferences = append(deleteReferences, entry.DeleteReference)
+	}
+	// incomplete entries are not counted
+	incompleteEntry := &storage.Entry{Author: author, Filename: "incomplete.txt", UploadDate: time.Now()}
+	writer, err := fileStorage.Store(incompleteEntry)
+	if err != nil {
+		t.Fatalf("Could not store entry, %T: %v", err, err)
+	}
+	if _, err = writer.Write([]byte("incomplete data")); err != nil {
+		t.Fatalf("Could not write entry data, %T: %v", err, err)
+	}
+	// the expired entry is counted until it has been deleted
+	if usage, err := usageStorage.Usage(author); err != nil || usage != 30 {
+		t.Fatalf("Expected a usage of 30 bytes, got %d, err: %v", usage, err)
+	}
+	if _, err = fileStorage.DeleteExpired(time.Now()); err != nil {
+		t.Fatalf("Could not delete expired entries, %T: %v", err, err)
+	}
+	if usage, err := usageStorage.Usage(author); err != nil || usage != 20 {
+		t.Fatalf("Expected a usage of 20 bytes after deleting the expired entry, got %d, err: %v", usage, err)
+	}
+	if err = fileStorage.Delete(deleteReferences[0]); err != nil {
+		t.Fatalf("Could not delete entry, %T: %v", err, err)
+	}
+	if usage, err := usageStorage.Usage(author); err != nil || usage != 10 {
+		t.Fatalf("Expected a usage of 10 bytes after the deletion, got %d, err: %v", usage, err)
+	}
+	if usage, err := usageStorage.Usage(storage.AuthorIdentifier("unknown usage author")); err != nil || usage != 0 {
+		t.Fatalf("Expected no usage of an unknown author, got %d, err: %v", usage, err)
+	}
+	for _, deleteReference := range []string{deleteReferences[1], deleteReferences[3],
+		incompleteEntry.DeleteReference} {
+		if err = fileStorage.Delete(deleteReference); err != nil {
+			t.Fatalf("Could not delete entry, %T: %v", err, err)
+		}
+	}
+}
+
+// all storages persist the users alongside the entries
+var (
+	_ storage.UserStorage = &MongoStorage{}
+	_ storage.UserStorage = &FilesystemStorage{}
+	_ storage.UserStorage = &BoltStorage{}
+	_ storage.UserStorage = &SQLStorage{}
+	_ storage.UserStorage = &S3Storage{}
+)
+
+// testUserStorage creates users and tokens and checks the authentication of the tokens. The given storage must not
+// contain any users.
+func testUserStorage(t *testing.T, userStorage storage.UserStorage) {
+	if hasUsers, err := userStorage.HasUsers(); err != nil || hasUsers {
+		t.Fatalf("Empty user storage reported users, err: %v", err)
+	}
+	creationDate := time.Now().Round(time.Second)
+	for _, user := range []*storage.User{
+		{Name: "bob", Role: storage.RoleUser, CreationDate: creationDate},
+		{Name: "alice", Role: storage.RoleAdmin, CreationDate: creationDate},
+	} {
+		if err := userStorage.CreateUser(user); err != nil {
+			t.Fatalf("Could not create user, %T: %v", err, err)
+		}
+	}
+	if err := userStorage.CreateUser(&storage.User{Name: "alice"}); err != storage.ErrUserExists {
+		t.Fatalf("Existing user could be created again, err: %v", err)
+	}
+	if err := userStorage.CreateUser(&storage.User{Name: "../alice"}); err != storage.ErrInvalidUsername {
+		t.Fatalf("Invalid username was not rejected, err: %v", err)
+	}
+	if hasUsers, err := userStorage.HasUsers(); err != nil || !hasUsers {
+		t.Fatalf("User storage did not report the created users, err: %v", err)
+	}
+	users, err := userStorage.ListUsers()
+	if err != nil {
+		t.Fatalf("Could not list users, %T: %v", err, err)
+	}
+	if len(users) != 2 || users[0].Name != "alice" || !users[0].IsAdmin() || users[1].Name != "bob" ||
+		users[1].IsAdmin() || !users[1].CreationDate.Equal(creationDate) {
+		t.Fatalf("Listed users %+v do not match the created users", users)
+	}
+	token, secret, err := storage.NewToken("bob", "laptop")
+	if err != nil {
+		t.Fatalf("Could not create token, %T: %v", err, err)
+	}
+	if err = userStorage.StoreToken(token); err != nil {
+		t.Fatalf("Could not store token, %T: %v", err, err)
+	}
+	user, err := storage.Authenticate(userStorage, secret)
+	if err != nil {
+		t.Fatalf("Could not authenticate with the token, %T: %v", err, err)
+	}
+	if user.Name != "bob" {
+		t.Fatalf("Token resolved to the wrong user %+v", user)
+	}
+	if _, err = storage.Authenticate(userStorage, secret+"x"); err != storage.ErrTokenNotFound {
+		t.Fatalf("Invalid token was not rejected, err: %v", err)
+	}
+	tokens, err := userStorage.ListTokens("bob")
+	if err != nil {
+		t.Fatalf("Could not list tokens, %T: %v", err, err)
+	}
+	if len(tokens) != 1 || tokens[0].ID != token.ID || tokens[0].Description != "laptop" {
+		t.Fatalf("Listed tokens %+v do not match the stored token", tokens)
+	}
+	if err = userStorage.RevokeToken(token.ID); err != nil {
+		t.Fatalf("Could not revoke token, %T: %v", err, err)
+	}
+	if _, err = storage.Authenticate(userStorage, secret); err != storage.ErrTokenNotFound {
+		t.Fatalf("Revoked token could still be used, err: %v", err)
+	}
+	if err = userStorage.RevokeToken(token.ID); err != storage.ErrTokenNotFound {
+		t.Fatalf("Revoked token could be revoked again, err: %v", err)
+	}
+	// deleting a user deletes its tokens too
+	if token, secret, err = storage.NewToken("bob", ""); err != nil {
+		t.Fatalf("Could not create token, %T: %v", err, err)
+	}
+	if err = userStorage.StoreToken(token); err != nil {
+		t.Fatalf("Could not store token, %T: %v", err, err)
+	}
+	if err = userStorage.DeleteUser("bob"); err != nil {
+		t.Fatalf("Could not delete user, %T: %v", err, err)
+	}
+	if _, err = userStorage.RequestUser("bob"); err != storage.ErrUserNotFound {
+		t.Fatalf("Deleted user could still be requested, err: %v", err)
+	}
+	if _, err = userStorage.RequestToken(token.Hash); err != storage.ErrTokenNotFound {
+		t.Fatalf("Token of the deleted user could still be requested, err: %v", err)
+	}
+	if err = userStorage.DeleteUser("bob"); err != storage.ErrUserNotFound {
+		t.Fatalf("Deleted user could be deleted again, err: %v", err)
+	}
+}
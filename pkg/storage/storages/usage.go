@@ -0,0 +1,24 @@
+package storages
+
+import "github.com/mmichaelb/gosharexserver/pkg/storage"
+
+// all storages calculate the amount of stored data per author
+var (
+	_ storage.UsageStorage = &MongoStorage{}
+	_ storage.UsageStorage = &FilesystemStorage{}
+	_ storage.UsageStorage = &BoltStorage{}
+	_ storage.UsageStorage = &SQLStorage{}
+	_ storage.UsageStorage = &S3Storage{}
+	_ storage.UsageStorage = &EncryptedStorage{}
+)
+
+// usageCounters maps the authors to the amount of bytes their complete entries take up. Storages which can not sum up
+// the sizes with a query keep the counters up to date when entries are completed or deleted.
+type usageCounters map[string]int64
+
+// add adds the given amount of bytes to the counter of the author. Counters which drop to zero are removed.
+func (counters usageCounters) add(author string, size int64) {
+	if counters[author] += size; counters[author] <= 0 {
+		delete(counters, author)
+	}
+}
@@ -0,0 +1,10 @@
+package storage
+
+// UsageStorage is an interface which is the scheme to calculate the amount of stored data per author. FileStorage
+// implementations can implement it to enforce storage quotas.
+type UsageStorage interface {
+	// Usage returns the total size in bytes of the data of all complete entries of the given author. Entries which
+	// share their data with other entries are counted with their full size. Expired entries and entries whose downloads
+	// are exhausted are counted until they are deleted.
+	Usage(author AuthorIdentifier) (int64, error)
+}
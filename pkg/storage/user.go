@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// ErrUserNotFound is returned by the UserStorage methods if the user could not be found.
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrUserExists is returned by the UserStorage.CreateUser method if the name is already used by another user.
+var ErrUserExists = errors.New("user already exists")
+
+// ErrInvalidUsername is returned by the UserStorage.CreateUser method if the name contains invalid characters.
+var ErrInvalidUsername = errors.New("invalid username")
+
+// ErrTokenNotFound is returned by the UserStorage methods if the token could not be found.
+var ErrTokenNotFound = errors.New("token not found")
+
+const (
+	// token sizes in bytes
+	tokenIDSize     = 4
+	tokenSecretSize = 32
+	// maximumUsernameLength is the maximum length of a valid username.
+	maximumUsernameLength = 64
+)
+
+// Role determines the permissions of a user.
+type Role string
+
+const (
+	// RoleUser is allowed to upload files and to manage its own entries.
+	RoleUser Role = "user"
+	// RoleAdmin is additionally allowed to manage the entries of all users.
+	RoleAdmin Role = "admin"
+)
+
+// User represents a person who is allowed to upload files. The name is used as the AuthorIdentifier of its entries.
+type User struct {
+	// Name is the unique name of the user.
+	Name string `json:"name" bson:"name"`
+	// Role determines the permissions of the user.
+	Role Role `json:"role" bson:"role"`
+	// CreationDate is the time when the user was created.
+	CreationDate time.Time `json:"creation_date" bson:"creation_date"`
+}
+
+// Author returns the AuthorIdentifier which is stored in the entries uploaded by the user.
+func (user *User) Author() AuthorIdentifier {
+	return AuthorIdentifier(user.Name)
+}
+
+// IsAdmin returns whether the user has the admin role.
+func (user *User) IsAdmin() bool {
+	return user.Role == RoleAdmin
+}
+
+// Token is an API token which authorizes requests of a user. Only the hash of the secret is stored.
+type Token struct {
+	// ID is the public identifier of the token which is used to revoke it.
+	ID string `json:"id" bson:"id"`
+	// Hash is the hex encoded SHA-256 hash of the secret.
+	Hash string `json:"hash" bson:"hash"`
+	// Username is the name of the user the token belongs to.
+	Username string `json:"username" bson:"username"`
+	// Description helps to identify the token (e.g. the name of the device).
+	Description string `json:"description" bson:"description"`
+	// CreationDate is the time when the token was created.
+	CreationDate time.Time `json:"creation_date" bson:"creation_date"`
+}
+
+// UserStorage is an interface which is the scheme to store users and their tokens. FileStorage implementations can
+// implement it to persist the users alongside the entries.
+type UserStorage interface {
+	// CreateUser stores the new user. It returns ErrUserExists if the name is already used or ErrInvalidUsername if
+	// the name is not valid (see ValidUsername).
+	CreateUser(user *User) error
+	// RequestUser searches for the user with the given name. It returns ErrUserNotFound if there is no such user.
+	RequestUser(name string) (*User, error)
+	// ListUsers returns all users sorted by their name.
+	ListUsers() ([]*User, error)
+	// HasUsers returns whether at least one user exists without loading the users.
+	HasUsers() (bool, error)
+	// DeleteUser deletes the user with the given name and all of its tokens. It returns ErrUserNotFound if there is
+	// no such user.
+	DeleteUser(name string) error
+	// StoreToken stores the new token.
+	StoreToken(token *Token) error
+	// RequestToken searches for the token with the given hash. It returns ErrTokenNotFound if there is no such token.
+	RequestToken(hash string) (*Token, error)
+	// ListTokens returns all tokens of the user with the given name.
+	ListTokens(username string) ([]*Token, error)
+	// RevokeToken deletes the token with the given ID. It returns ErrTokenNotFound if there is no such token.
+	RevokeToken(id string) error
+}
+
+// ValidUsername returns whether the name only consists of ASCII letters, digits, underscores and dashes and is not
+// longer than 64 characters.
+func ValidUsername(name string) bool {
+	if name == "" || len(name) > maximumUsernameLength {
+		return false
+	}
+	for _, char := range name {
+		if !(char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z' || char >= '0' && char <= '9' ||
+			char == '_' || char == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// NewToken creates a new random token for the user with the given name. The returned secret is only available at this
+// point and has to be handed over to the user.
+func NewToken(username, description string) (token *Token, secret string, err error) {
+	id := make([]byte, tokenIDSize)
+	if _, err = rand.Read(id); err != nil {
+		return nil, "", err
+	}
+	secretBytes := make([]byte, tokenSecretSize)
+	if _, err = rand.Read(secretBytes); err != nil {
+		return nil, "", err
+	}
+	secret = base64.RawURLEncoding.EncodeToString(secretBytes)
+	return &Token{
+		ID:           hex.EncodeToString(id),
+		Hash:         HashToken(secret),
+		Username:     username,
+		Description:  description,
+		CreationDate: time.Now(),
+	}, secret, nil
+}
+
+// HashToken returns the hex encoded SHA-256 hash of the given token secret.
+func HashToken(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+// Authenticate resolves the user the given token secret belongs to. It returns ErrTokenNotFound if the secret is not
+// valid.
+func Authenticate(userStorage UserStorage, secret string) (*User, error) {
+	token, err := userStorage.RequestToken(HashToken(secret))
+	if err != nil {
+		return nil, err
+	}
+	user, err := userStorage.RequestUser(token.Username)
+	if err == ErrUserNotFound {
+		// the token belongs to a deleted user
+		return nil, ErrTokenNotFound
+	}
+	return user, err
+}
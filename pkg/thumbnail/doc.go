@@ -0,0 +1,3 @@
+// Package thumbnail contains the image processing which creates downscaled previews of uploaded images. It only uses
+// the pure Go decoders and encoders of the standard library.
+package thumbnail
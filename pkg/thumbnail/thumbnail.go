@@ -0,0 +1,145 @@
+package thumbnail
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+)
+
+const (
+	// MaximumPixels limits the size of the images which are decoded. Larger images are rejected before decoding them
+	// to protect against decompression bombs.
+	MaximumPixels = 16000000
+	// jpegQuality is the quality of the thumbnails of JPEG images.
+	jpegQuality = 85
+	// the image formats which are supported by the Generate function
+	formatPNG  = "png"
+	formatJPEG = "jpeg"
+	formatGIF  = "gif"
+)
+
+// ErrUnsupportedImage is returned by the Generate function if the data is not a supported image.
+var ErrUnsupportedImage = errors.New("unsupported image")
+
+// ErrImageTooLarge is returned by the Generate function if the image has more than MaximumPixels pixels.
+var ErrImageTooLarge = errors.New("image too large")
+
+// decoders maps the supported formats to their decoding functions.
+var decoders = map[string]func(io.Reader) (image.Image, error){
+	formatPNG:  png.Decode,
+	formatJPEG: jpeg.Decode,
+	formatGIF:  gif.Decode,
+}
+
+// Supported returns whether thumbnails can be generated for images with the given content type.
+func Supported(contentType string) bool {
+	switch strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0])) {
+	case "image/png", "image/jpeg", "image/jpg", "image/gif":
+		return true
+	}
+	return false
+}
+
+// Generate decodes the image and returns a thumbnail for every given width. The thumbnails keep the aspect ratio of
+// the image and images which are smaller than a width are not upscaled. Thumbnails of JPEG images are encoded as JPEG,
+// all other thumbnails are encoded as PNG in order to keep their transparency. Only the first frame of animated GIF
+// images is used.
+func Generate(reader io.ReadSeeker, widths []int) (map[int][]byte, error) {
+	config, format, err := image.DecodeConfig(reader)
+	if err != nil || decoders[format] == nil {
+		return nil, ErrUnsupportedImage
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, ErrUnsupportedImage
+	}
+	if int64(config.Width)*int64(config.Height) > MaximumPixels {
+		return nil, ErrImageTooLarge
+	}
+	if _, err = reader.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	source, err := decoders[format](reader)
+	if err != nil {
+		return nil, err
+	}
+	thumbnails := make(map[int][]byte, len(widths))
+	for _, width := range widths {
+		buffer := &bytes.Buffer{}
+		if format == formatJPEG {
+			err = jpeg.Encode(buffer, Resize(source, width), &jpeg.Options{Quality: jpegQuality})
+		} else {
+			err = png.Encode(buffer, Resize(source, width))
+		}
+		if err != nil {
+			return nil, err
+		}
+		thumbnails[width] = buffer.Bytes()
+	}
+	return thumbnails, nil
+}
+
+// Resize downscales the image to the given width while keeping its aspect ratio. Every pixel of the result is the
+// average of the source pixels it covers. Images which are not wider than the given width are returned unchanged.
+func Resize(source image.Image, width int) image.Image {
+	bounds := source.Bounds()
+	sourceWidth, sourceHeight := bounds.Dx(), bounds.Dy()
+	if width <= 0 || sourceWidth <= width {
+		return source
+	}
+	height := sourceHeight * width / sourceWidth
+	if height < 1 {
+		height = 1
+	}
+	// the premultiplied RGBA values can be averaged directly, the pixels of RGBA images are read without converting
+	// them
+	rgba, _ := source.(*image.RGBA)
+	result := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := span(y, height, sourceHeight)
+		for x := 0; x < width; x++ {
+			x0, x1 := span(x, width, sourceWidth)
+			var sums [4]int
+			for sourceY := bounds.Min.Y + y0; sourceY < bounds.Min.Y+y1; sourceY++ {
+				if rgba != nil {
+					offset := rgba.PixOffset(bounds.Min.X+x0, sourceY)
+					for sourceX := x0; sourceX < x1; sourceX++ {
+						for channel := 0; channel < 4; channel++ {
+							sums[channel] += int(rgba.Pix[offset+channel])
+						}
+						offset += 4
+					}
+					continue
+				}
+				for sourceX := bounds.Min.X + x0; sourceX < bounds.Min.X+x1; sourceX++ {
+					r, g, b, a := source.At(sourceX, sourceY).RGBA()
+					sums[0] += int(r >> 8)
+					sums[1] += int(g >> 8)
+					sums[2] += int(b >> 8)
+					sums[3] += int(a >> 8)
+				}
+			}
+			count := (x1 - x0) * (y1 - y0)
+			offset := result.PixOffset(x, y)
+			for channel := 0; channel < 4; channel++ {
+				result.Pix[offset+channel] = uint8(sums[channel] / count)
+			}
+		}
+	}
+	return result
+}
+
+// span returns the range of source coordinates which is covered by the target coordinate. The range contains at least
+// one coordinate.
+func span(target, targetSize, sourceSize int) (int, int) {
+	start := target * sourceSize / targetSize
+	end := (target + 1) * sourceSize / targetSize
+	if end <= start {
+		end = start + 1
+	}
+	return start, end
+}
@@ -0,0 +1,84 @@
+package thumbnail
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	// the left half of the image is red and the right half is blue
+	source := image.NewNRGBA(image.Rect(0, 0, 300, 150))
+	for y := 0; y < 150; y++ {
+		for x := 0; x < 300; x++ {
+			if x < 150 {
+				source.Set(x, y, color.NRGBA{R: 255, A: 255})
+			} else {
+				source.Set(x, y, color.NRGBA{B: 255, A: 255})
+			}
+		}
+	}
+	for _, test := range []struct {
+		encode func(*bytes.Buffer) error
+		format string
+	}{
+		{func(buffer *bytes.Buffer) error { return png.Encode(buffer, source) }, "png"},
+		{func(buffer *bytes.Buffer) error { return jpeg.Encode(buffer, source, nil) }, "jpeg"},
+	} {
+		buffer := &bytes.Buffer{}
+		if err := test.encode(buffer); err != nil {
+			t.Fatalf("Could not encode test image, %T: %v", err, err)
+		}
+		thumbnails, err := Generate(bytes.NewReader(buffer.Bytes()), []int{3, 100, 1000})
+		if err != nil {
+			t.Fatalf("Could not generate %s thumbnails, %T: %v", test.format, err, err)
+		}
+		for width, size := range map[int]image.Point{3: {3, 1}, 100: {100, 50}, 1000: {300, 150}} {
+			thumbnail, format, err := image.Decode(bytes.NewReader(thumbnails[width]))
+			if err != nil {
+				t.Fatalf("Could not decode %s thumbnail of width %d, %T: %v", test.format, width, err, err)
+			}
+			if format != test.format || thumbnail.Bounds().Size() != size {
+				t.Fatalf("Invalid %s thumbnail of width %d (%s, %v)", test.format, width, format,
+					thumbnail.Bounds().Size())
+			}
+		}
+	}
+	// the pixels of the thumbnail are the average of the covered pixels
+	thumbnail := Resize(source, 2).(*image.RGBA)
+	if left, right := thumbnail.RGBAAt(0, 0), thumbnail.RGBAAt(1, 0); left.R != 255 || left.B != 0 ||
+		right.R != 0 || right.B != 255 {
+		t.Fatalf("Invalid thumbnail colors %v and %v", left, right)
+	}
+	if averaged := Resize(source, 1).(*image.RGBA).RGBAAt(0, 0); averaged.R != 127 || averaged.B != 127 {
+		t.Fatalf("Invalid averaged color %v", averaged)
+	}
+	// the pixels of RGBA images are read directly which has to respect their bounds
+	rgba := image.NewRGBA(image.Rect(0, 0, 300, 150))
+	draw.Draw(rgba, rgba.Bounds(), source, image.ZP, draw.Src)
+	right := rgba.SubImage(image.Rect(150, 0, 300, 150))
+	if averaged := Resize(right, 1).(*image.RGBA).RGBAAt(0, 0); averaged.R != 0 || averaged.B != 255 {
+		t.Fatalf("Invalid averaged color %v of the sub image", averaged)
+	}
+}
+
+func TestGenerateInvalidImages(t *testing.T) {
+	if _, err := Generate(bytes.NewReader([]byte("no image")), []int{100}); err != ErrUnsupportedImage {
+		t.Fatalf("Invalid image was not rejected, err: %v", err)
+	}
+	// only the header of the huge image is encoded which must be enough to reject it
+	buffer := &bytes.Buffer{}
+	if err := png.Encode(buffer, image.NewGray(image.Rect(0, 0, 7000, 7000))); err != nil {
+		t.Fatalf("Could not encode test image, %T: %v", err, err)
+	}
+	if _, err := Generate(bytes.NewReader(buffer.Bytes()), []int{100}); err != ErrImageTooLarge {
+		t.Fatalf("Huge image was not rejected, err: %v", err)
+	}
+	if !Supported("image/png") || !Supported("IMAGE/JPEG; charset=binary") || Supported("image/svg+xml") {
+		t.Fatal("Invalid supported content types")
+	}
+}